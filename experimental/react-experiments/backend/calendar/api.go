@@ -8,7 +8,7 @@ import (
 	"strconv"
 	"time"
 
-	"github.com/davedolben/dev-tools/experimental/react-experiments/sqlutil"
+	"github.com/davedolben/dev-tools/go/sqlutil"
 	"github.com/gin-gonic/gin"
 	_ "github.com/glebarez/go-sqlite"
 )
@@ -3,7 +3,7 @@ package calendar
 import (
 	"database/sql"
 
-	"github.com/davedolben/dev-tools/experimental/react-experiments/sqlutil"
+	"github.com/davedolben/dev-tools/go/sqlutil"
 )
 
 var migrations = []sqlutil.Migration{
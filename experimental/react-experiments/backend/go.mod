@@ -3,6 +3,7 @@ module github.com/davedolben/dev-tools/experimental/react-experiments
 go 1.23.2
 
 require (
+	github.com/davedolben/dev-tools/go v0.0.0
 	github.com/gin-contrib/static v1.1.5
 	github.com/gin-gonic/gin v1.10.0
 	github.com/glebarez/go-sqlite v1.22.0
@@ -43,3 +44,5 @@ require (
 	modernc.org/memory v1.7.2 // indirect
 	modernc.org/sqlite v1.28.0 // indirect
 )
+
+replace github.com/davedolben/dev-tools/go => ../../../go
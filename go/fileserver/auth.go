@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+type AuthConfig struct {
+  // Username -> password for HTTP basic auth.
+  Users map[string]string `json:"users"`
+  // Accepted values for "Authorization: Bearer <token>".
+  Tokens []string `json:"tokens"`
+  // Accept any client certificate signed by -client_ca. If ClientCertNames is set, the certificate's
+  // common name must also be in that list.
+  ClientCert bool `json:"client_cert"`
+  ClientCertNames []string `json:"client_cert_names"`
+  Realm string `json:"realm"`
+}
+
+type ipList []netip.Prefix
+
+// Parse a list of CIDRs. Bare addresses are treated as a single-host prefix.
+func parseIPList(entries []string) (ipList, error) {
+  var l ipList
+  for _, e := range entries {
+    e = strings.TrimSpace(e)
+    if e == "" {
+      continue
+    }
+    if !strings.Contains(e, "/") {
+      addr, err := netip.ParseAddr(e)
+      if err != nil {
+        return nil, err
+      }
+      l = append(l, netip.PrefixFrom(addr, addr.BitLen()))
+      continue
+    }
+    prefix, err := netip.ParsePrefix(e)
+    if err != nil {
+      return nil, err
+    }
+    l = append(l, prefix.Masked())
+  }
+  return l, nil
+}
+
+func (l ipList) contains(addr netip.Addr) bool {
+  for _, prefix := range l {
+    if prefix.Contains(addr) {
+      return true
+    }
+  }
+  return false
+}
+
+// Deny always wins. An empty allow list allows everyone who isn't denied.
+func permitted(addr netip.Addr, allow ipList, deny ipList) bool {
+  if deny.contains(addr) {
+    return false
+  }
+  return len(allow) == 0 || allow.contains(addr)
+}
+
+type routeACL struct {
+  allow ipList
+  deny ipList
+}
+
+// AccessController checks the client address against allow/deny lists and enforces any per-route
+// authentication from the config before passing requests on.
+type AccessController struct {
+  h http.Handler
+  conf *ConfigFile
+  allow ipList
+  deny ipList
+  routes map[*RouteConfig]routeACL
+}
+
+func NewAccessController(h http.Handler, conf *ConfigFile, allow []string, deny []string) (*AccessController, error) {
+  ac := &AccessController{
+    h: h,
+    conf: conf,
+    routes: make(map[*RouteConfig]routeACL),
+  }
+  var err error
+  if ac.allow, err = parseIPList(allow); err != nil {
+    return nil, fmt.Errorf("bad allow list: %w", err)
+  }
+  if ac.deny, err = parseIPList(deny); err != nil {
+    return nil, fmt.Errorf("bad deny list: %w", err)
+  }
+  if conf != nil {
+    for _, route := range conf.Routes {
+      var acl routeACL
+      if acl.allow, err = parseIPList(route.Allow); err != nil {
+        return nil, fmt.Errorf("bad allow list for %s: %w", route.URLPrefix, err)
+      }
+      if acl.deny, err = parseIPList(route.Deny); err != nil {
+        return nil, fmt.Errorf("bad deny list for %s: %w", route.URLPrefix, err)
+      }
+      ac.routes[route] = acl
+    }
+  }
+  return ac, nil
+}
+
+// withAccessControl puts h behind the access checks and the config's redirect and rewrite rules.
+// Access is checked before the rules run, so they don't give away anything about a route the client
+// can't use, and again after a rewrite in case the new path belongs to a stricter route.
+func withAccessControl(h http.Handler, conf *ConfigFile, allow []string, deny []string) (http.Handler, error) {
+  ac, err := NewAccessController(h, conf, allow, deny)
+  if err != nil {
+    return nil, err
+  }
+  if conf == nil {
+    return ac, nil
+  }
+  outer, err := NewAccessController(&RuleHandler{h: ac, conf: conf}, conf, allow, deny)
+  if err != nil {
+    return nil, err
+  }
+  return outer, nil
+}
+
+func clientAddr(r *http.Request) (netip.Addr, error) {
+  addrPort, err := netip.ParseAddrPort(r.RemoteAddr)
+  if err != nil {
+    return netip.Addr{}, err
+  }
+  return addrPort.Addr().Unmap(), nil
+}
+
+func (ac *AccessController) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  addr, err := clientAddr(r)
+  if err != nil {
+    log.Printf("can't parse client address %q: %s", r.RemoteAddr, err.Error())
+    http.Error(w, "forbidden", http.StatusForbidden)
+    return
+  }
+  if !permitted(addr, ac.allow, ac.deny) {
+    http.Error(w, "forbidden", http.StatusForbidden)
+    return
+  }
+
+  if route := ac.conf.routeFor(r.URL.Path); route != nil {
+    acl := ac.routes[route]
+    if !permitted(addr, acl.allow, acl.deny) {
+      http.Error(w, "forbidden", http.StatusForbidden)
+      return
+    }
+    if route.Auth != nil && !route.Auth.authorized(r) {
+      if len(route.Auth.Users) > 0 {
+        realm := route.Auth.Realm
+        if realm == "" {
+          realm = "fileserver"
+        }
+        w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", realm))
+      }
+      http.Error(w, "unauthorized", http.StatusUnauthorized)
+      return
+    }
+  }
+
+  ac.h.ServeHTTP(w, r)
+}
+
+func secureEquals(a, b string) bool {
+  return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// A request is authorized if any one of the configured methods accepts it.
+func (auth *AuthConfig) authorized(r *http.Request) bool {
+  if user, pass, ok := r.BasicAuth(); ok {
+    if want, ok := auth.Users[user]; ok && secureEquals(pass, want) {
+      return true
+    }
+  }
+
+  if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+    for _, want := range auth.Tokens {
+      if secureEquals(token, want) {
+        return true
+      }
+    }
+  }
+
+  // The TLS layer has already checked that the certificate chains up to the client CA.
+  if auth.ClientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+    if len(auth.ClientCertNames) == 0 {
+      return true
+    }
+    name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+    for _, want := range auth.ClientCertNames {
+      if name == want {
+        return true
+      }
+    }
+  }
+
+  return false
+}
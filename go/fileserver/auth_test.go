@@ -0,0 +1,224 @@
+package main
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "net/netip"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestParseIPList(t *testing.T) {
+  tests := []struct {
+    entries []string
+    want []string
+    err bool
+  }{
+    {nil, nil, false},
+    {[]string{"10.0.0.0/8"}, []string{"10.0.0.0/8"}, false},
+    // Host bits are masked off.
+    {[]string{"192.168.1.77/24"}, []string{"192.168.1.0/24"}, false},
+    // Bare addresses are single hosts.
+    {[]string{"127.0.0.1", "::1"}, []string{"127.0.0.1/32", "::1/128"}, false},
+    {[]string{" 10.1.2.3 ", ""}, []string{"10.1.2.3/32"}, false},
+    {[]string{"fd00::/8"}, []string{"fd00::/8"}, false},
+    {[]string{"localhost"}, nil, true},
+    {[]string{"10.0.0.0/33"}, nil, true},
+  }
+  for _, tt := range tests {
+    l, err := parseIPList(tt.entries)
+    if (err != nil) != tt.err {
+      t.Errorf("%q: got error %v", tt.entries, err)
+      continue
+    }
+    var got []string
+    for _, prefix := range l {
+      got = append(got, prefix.String())
+    }
+    if len(got) != len(tt.want) {
+      t.Errorf("%q: got %v, want %v", tt.entries, got, tt.want)
+      continue
+    }
+    for i := range got {
+      if got[i] != tt.want[i] {
+        t.Errorf("%q: got %v, want %v", tt.entries, got, tt.want)
+        break
+      }
+    }
+  }
+}
+
+func mustIPList(t *testing.T, entries ...string) ipList {
+  t.Helper()
+  l, err := parseIPList(entries)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return l
+}
+
+func TestPermitted(t *testing.T) {
+  tests := []struct {
+    addr string
+    allow ipList
+    deny ipList
+    want bool
+  }{
+    {"1.2.3.4", nil, nil, true},
+    {"1.2.3.4", mustIPList(t, "1.2.3.0/24"), nil, true},
+    {"1.2.4.4", mustIPList(t, "1.2.3.0/24"), nil, false},
+    {"1.2.3.4", nil, mustIPList(t, "1.2.3.4"), false},
+    {"1.2.3.5", nil, mustIPList(t, "1.2.3.4"), true},
+    // Deny wins over allow.
+    {"1.2.3.4", mustIPList(t, "1.2.3.0/24"), mustIPList(t, "1.2.3.4"), false},
+    {"1.2.3.4", mustIPList(t, "1.2.3.4"), mustIPList(t, "0.0.0.0/0"), false},
+    {"::1", mustIPList(t, "127.0.0.1"), nil, false},
+  }
+  for _, tt := range tests {
+    if got := permitted(netip.MustParseAddr(tt.addr), tt.allow, tt.deny); got != tt.want {
+      t.Errorf("%s allow %v deny %v: got %v, want %v", tt.addr, tt.allow, tt.deny, got, tt.want)
+    }
+  }
+}
+
+func TestAccessController(t *testing.T) {
+  conf := &ConfigFile{
+    Routes: []*RouteConfig{
+      {URLPrefix: "/admin", Auth: &AuthConfig{Users: map[string]string{"root": "hunter2"}, Realm: "admins"}},
+      // More specific than /admin, so it replaces /admin's auth.
+      {URLPrefix: "/admin/public", Allow: []string{"10.0.0.0/8"}},
+      {URLPrefix: "/api/", Auth: &AuthConfig{Tokens: []string{"secret"}}},
+    },
+  }
+  ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+  ac, err := NewAccessController(ok, conf, nil, []string{"10.9.9.9"})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    path string
+    addr string
+    user, pass string
+    token string
+    want int
+  }{
+    {"/", "1.2.3.4", "", "", "", 200},
+    {"/", "10.9.9.9", "", "", "", 403},
+    {"/admin", "1.2.3.4", "", "", "", 401},
+    {"/admin/x", "1.2.3.4", "root", "hunter2", "", 200},
+    {"/admin/x", "1.2.3.4", "root", "wrong", "", 401},
+    {"/admin/x", "1.2.3.4", "nobody", "hunter2", "", 401},
+    // Routes match by plain prefix, the same way mappings do, so a route protects everything its
+    // target could serve.
+    {"/administrator", "1.2.3.4", "", "", "", 401},
+    {"/administrator", "1.2.3.4", "root", "hunter2", "", 200},
+    {"/admin/public/x", "10.1.1.1", "", "", "", 200},
+    {"/admin/public/x", "1.2.3.4", "root", "hunter2", "", 403},
+    // The global deny list applies to every route.
+    {"/admin/public/x", "10.9.9.9", "", "", "", 403},
+    {"/api/x", "1.2.3.4", "", "", "secret", 200},
+    {"/api/x", "1.2.3.4", "", "", "wrong", 401},
+    {"/api", "1.2.3.4", "", "", "", 200},
+  }
+  for _, tt := range tests {
+    r := httptest.NewRequest("GET", tt.path, nil)
+    r.RemoteAddr = tt.addr + ":1234"
+    if tt.user != "" {
+      r.SetBasicAuth(tt.user, tt.pass)
+    }
+    if tt.token != "" {
+      r.Header.Set("Authorization", "Bearer "+tt.token)
+    }
+    rec := httptest.NewRecorder()
+    ac.ServeHTTP(rec, r)
+    if rec.Code != tt.want {
+      t.Errorf("%s from %s as %q: got %d, want %d", tt.path, tt.addr, tt.user, rec.Code, tt.want)
+    }
+    if rec.Code == 401 && tt.path != "/api/x" && rec.Header().Get("WWW-Authenticate") != `Basic realm="admins"` {
+      t.Errorf("%s: got WWW-Authenticate %q", tt.path, rec.Header().Get("WWW-Authenticate"))
+    }
+  }
+}
+
+func TestAccessControllerBadConfig(t *testing.T) {
+  ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+  if _, err := NewAccessController(ok, nil, []string{"nope"}, nil); err == nil {
+    t.Error("accepted a bad allow list")
+  }
+  conf := &ConfigFile{Routes: []*RouteConfig{{URLPrefix: "/", Deny: []string{"1.2.3.4/99"}}}}
+  if _, err := NewAccessController(ok, conf, nil, nil); err == nil {
+    t.Error("accepted a bad route deny list")
+  }
+}
+
+// Redirects and rewrites only run for clients that may use the route.
+func TestRulesBehindAccessControl(t *testing.T) {
+  confFile := filepath.Join(t.TempDir(), "config.json")
+  err := os.WriteFile(confFile, []byte(`{
+    "routes": [
+      {
+        "url_prefix": "/",
+        "rewrites": [{ "from": "^/peek/(.*)$", "to": "/private/$1" }]
+      },
+      {
+        "url_prefix": "/private",
+        "auth": { "users": { "root": "hunter2" } },
+        "redirects": [{ "from": "^/old$", "to": "/secret-target" }]
+      },
+      {
+        "url_prefix": "/internal",
+        "allow": ["10.0.0.0/8"],
+        "redirects": [{ "from": "^/old$", "to": "/internal-target" }]
+      }
+    ]
+  }`), 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  conf, err := parseConfigFile(confFile)
+  if err != nil {
+    t.Fatal(err)
+  }
+  h, err := withAccessControl(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Write([]byte(r.URL.Path))
+  }), conf, nil, []string{"10.9.9.9"})
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  tests := []struct {
+    path string
+    addr string
+    user string
+    want int
+    wantLocation string
+    wantBody string
+  }{
+    {"/private/old", "1.2.3.4", "", 401, "", ""},
+    {"/private/old", "1.2.3.4", "root", 302, "/private/secret-target", ""},
+    {"/private/old", "10.9.9.9", "root", 403, "", ""},
+    {"/internal/old", "1.2.3.4", "", 403, "", ""},
+    {"/internal/old", "10.1.1.1", "", 302, "/internal/internal-target", ""},
+    // A rewrite into a protected route is checked against the route it lands on.
+    {"/peek/x", "1.2.3.4", "", 401, "", ""},
+    {"/peek/x", "1.2.3.4", "root", 200, "", "/private/x"},
+  }
+  for _, tt := range tests {
+    r := httptest.NewRequest("GET", tt.path, nil)
+    r.RemoteAddr = tt.addr + ":1234"
+    if tt.user != "" {
+      r.SetBasicAuth(tt.user, "hunter2")
+    }
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, r)
+    if rec.Code != tt.want || rec.Header().Get("Location") != tt.wantLocation {
+      t.Errorf("%s from %s as %q: got %d to %q, want %d to %q", tt.path, tt.addr, tt.user,
+          rec.Code, rec.Header().Get("Location"), tt.want, tt.wantLocation)
+    }
+    if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+      t.Errorf("%s: got body %q", tt.path, rec.Body.String())
+    }
+  }
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Per-route settings that don't fit on the command line. A route applies to every request whose path
+// starts with URLPrefix; when several match, the longest prefix wins.
+type RouteConfig struct {
+  URLPrefix string `json:"url_prefix"`
+  // Optional. Adds a mapping for the prefix, same as "<url_prefix>:<target>" on the command line.
+  Target string `json:"target"`
+
+  Auth *AuthConfig `json:"auth"`
+  // CIDRs (or bare IPs) that may access the route, in addition to the global -allow/-deny lists.
+  Allow []string `json:"allow"`
+  Deny []string `json:"deny"`
+
+  // Cache-Control header for files served by this route's target.
+  CacheControl string `json:"cache_control"`
+  // Single page app fallback for directory targets.
+  SPA *SPAConfig `json:"spa"`
+  // Status code -> template file, overriding the global error pages for this route's target.
+  ErrorPages map[string]string `json:"error_pages"`
+  // Applied before routing, to requests that would otherwise belong to this route. Redirects send
+  // the client elsewhere, rewrites change the path internally.
+  Redirects []*RewriteRule `json:"redirects"`
+  Rewrites []*RewriteRule `json:"rewrites"`
+
+  // Options for proxy targets.
+  Proxy *ProxyConfig `json:"proxy"`
+}
+
+type ConfigFile struct {
+  Routes []*RouteConfig `json:"routes"`
+
+  // Status code -> html/template file used for error responses from served files.
+  ErrorPages map[string]string `json:"error_pages"`
+}
+
+// A time.Duration that's written as a string like "1m30s" in the config.
+type Duration struct {
+  time.Duration
+}
+
+func (d *Duration) UnmarshalJSON(bs []byte) error {
+  var s string
+  if err := json.Unmarshal(bs, &s); err != nil {
+    return err
+  }
+  var err error
+  d.Duration, err = time.ParseDuration(s)
+  return err
+}
+
+func parseConfigFile(filename string) (*ConfigFile, error) {
+  bs, err := os.ReadFile(filename)
+  if err != nil {
+    return nil, err
+  }
+
+  conf := &ConfigFile{}
+  if err := json.Unmarshal(bs, conf); err != nil {
+    return nil, err
+  }
+
+  for _, route := range conf.Routes {
+    for _, rule := range append(route.Redirects, route.Rewrites...) {
+      if err := rule.compile(); err != nil {
+        return nil, fmt.Errorf("%s: %w", route.URLPrefix, err)
+      }
+    }
+  }
+
+  return conf, nil
+}
+
+// Find the route with the longest prefix matching urlPath, or nil if none match.
+func (conf *ConfigFile) routeFor(urlPath string) *RouteConfig {
+  if conf == nil {
+    return nil
+  }
+  var best *RouteConfig
+  for _, route := range conf.Routes {
+    if !strings.HasPrefix(urlPath, route.URLPrefix) {
+      continue
+    }
+    if best == nil || len(route.URLPrefix) > len(best.URLPrefix) {
+      best = route
+    }
+  }
+  return best
+}
+
+// The part of urlPath after the route's prefix, starting with a slash.
+func (route *RouteConfig) relativePath(urlPath string) string {
+  return "/" + strings.TrimPrefix(strings.TrimPrefix(urlPath, route.URLPrefix), "/")
+}
@@ -3,26 +3,18 @@ package main
 import (
 	"flag"
 	"fmt"
+	"html/template"
 	"log"
 	"net/http"
-	"net/http/httputil"
 	"net/url"
 	"os"
 	"strings"
 )
 
-type HTTPLogger struct {
-  h http.Handler
-}
-
-func (hl *HTTPLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-  log.Printf("%s", r.URL.String())
-  hl.h.ServeHTTP(w, r)
-}
-
 type DirMapping struct {
   URLPrefix string
   ActualDir string
+  Options *FileOptions
 }
 
 type ProxyMapping struct {
@@ -35,8 +27,14 @@ type MappedDirServer struct {
   Mappings []DirMapping
   ProxyMappings []ProxyMapping
   StaticServer http.Handler
+  // Render READMEs under directory listings.
+  RenderReadme bool
+  // Used for mappings that don't override them.
+  DefaultFileOptions FileOptions
 
-  ReverseProxies map[string]*httputil.ReverseProxy
+  ReverseProxies map[string]http.Handler
+  // Set with -live.
+  Live *LiveReloader
 }
 
 func copyHeader(dst, src http.Header) {
@@ -52,32 +50,134 @@ func (sv *MappedDirServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
   urlPath := r.URL.Path
   for _, m := range sv.Mappings {
     if strings.HasPrefix(urlPath, m.URLPrefix) {
-      f, err := os.Stat(m.ActualDir)
-      if err != nil {
-        http.Error(w, err.Error(), 400)
-        return
-      }
-      if f.IsDir() {
-        http.StripPrefix(m.URLPrefix, http.FileServer(http.Dir(m.ActualDir))).ServeHTTP(w, r)
-      } else {
-        http.ServeFile(w, r, m.ActualDir)
-      }
+      sv.withLive(sv.mappingHandler(m)).ServeHTTP(w, r)
       return
     }
   }
   for _, m := range sv.ProxyMappings {
     if strings.HasPrefix(urlPath, m.URLPrefix) {
-      r.Host = m.ProxyDestination.Host
       sv.ReverseProxies[m.URLPrefix].ServeHTTP(w, r)
       return
     }
   }
-  sv.StaticServer.ServeHTTP(w, r)
+  sv.withLive(sv.StaticServer).ServeHTTP(w, r)
+}
+
+func (sv *MappedDirServer) mappingHandler(m DirMapping) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    f, err := os.Stat(m.ActualDir)
+    if err != nil {
+      http.Error(w, err.Error(), 400)
+      return
+    }
+    if f.IsDir() {
+      dirServer := &FileListServer{
+        URLPrefix: m.URLPrefix,
+        Root: m.ActualDir,
+        RenderReadme: sv.RenderReadme,
+        Options: m.Options,
+      }
+      dirServer.ServeHTTP(w, r)
+    } else {
+      serveFile(withErrorPages(w, r, m.Options.ErrorPages), r, m.ActualDir, m.Options)
+    }
+  })
+}
+
+// Only files served from disk get the reload script, proxied responses are passed through untouched.
+func (sv *MappedDirServer) withLive(h http.Handler) http.Handler {
+  if sv.Live == nil {
+    return h
+  }
+  return sv.Live.Wrap(h)
+}
+
+// Name of the mapping that ServeHTTP would use for urlPath, or "static" for the default directory.
+func (sv *MappedDirServer) RouteFor(urlPath string) string {
+  for _, m := range sv.Mappings {
+    if strings.HasPrefix(urlPath, m.URLPrefix) {
+      return m.URLPrefix
+    }
+  }
+  for _, m := range sv.ProxyMappings {
+    if strings.HasPrefix(urlPath, m.URLPrefix) {
+      return m.URLPrefix
+    }
+  }
+  return "static"
+}
+
+// Add a mapping from a URL prefix to either a directory/file or, if the target starts with http, a
+// proxy destination. route holds extra options from the config file and may be nil.
+func (sv *MappedDirServer) AddMapping(urlPrefix string, target string, route *RouteConfig) error {
+  if route == nil {
+    route = &RouteConfig{}
+  }
+  if (strings.HasPrefix(target, "http")) {
+    remote, err := url.Parse(target)
+    if err != nil {
+      return err
+    }
+    sv.ProxyMappings = append(sv.ProxyMappings, ProxyMapping{
+      URLPrefix: urlPrefix,
+      ProxyDestination: remote,
+    })
+    sv.ReverseProxies[urlPrefix] = NewProxy(urlPrefix, remote, route.Proxy)
+    log.Printf("proxying %s -> %s", urlPrefix, target)
+  } else {
+    opts := sv.DefaultFileOptions
+    if route.CacheControl != "" {
+      opts.CacheControl = route.CacheControl
+    }
+    opts.SPA = route.SPA
+    if len(route.ErrorPages) > 0 {
+      pages, err := loadErrorPages(route.ErrorPages)
+      if err != nil {
+        return err
+      }
+      opts.ErrorPages = make(map[int]*template.Template)
+      for status, tmpl := range sv.DefaultFileOptions.ErrorPages {
+        opts.ErrorPages[status] = tmpl
+      }
+      for status, tmpl := range pages {
+        opts.ErrorPages[status] = tmpl
+      }
+    }
+    sv.Mappings = append(sv.Mappings, DirMapping{
+      URLPrefix: urlPrefix,
+      ActualDir: target,
+      Options: &opts,
+    })
+    log.Printf("mapping url %s to dir %s", urlPrefix, target)
+  }
+  return nil
+}
+
+func splitList(s string) []string {
+  if s == "" {
+    return nil
+  }
+  return strings.Split(s, ",")
 }
 
 func main() {
   fDir := flag.String("dir", ".", "Directory to serve")
+  fHost := flag.String("host", "", "Host/interface on which to serve (default all)")
   fPort := flag.Int("port", 8080, "Port on which to serve")
+  fReadme := flag.Bool("readme", false, "Render README files below directory listings")
+  fCacheControl := flag.String("cache_control", "", "Cache-Control header for served files, unless overridden per route")
+  fCompress := flag.Bool("compress", true, "Compress text files on the fly for clients that accept gzip or zstd")
+  fLive := flag.Bool("live", false, "Watch served directories and reload pages in the browser when files change")
+  fConfigFile := flag.String("config", "", "JSON file with per-route configuration")
+  fLogFormat := flag.String("log_format", "text", "Access log format: text, json or clf (Common Log Format)")
+  fStats := flag.Bool("stats", false, "Serve per-route request counters at "+statsPath)
+  fAllow := flag.String("allow", "", "Comma-separated CIDRs allowed to connect (default everyone)")
+  fDeny := flag.String("deny", "", "Comma-separated CIDRs that may not connect")
+  fTLSCert := flag.String("tls_cert", "", "TLS certificate file")
+  fTLSKey := flag.String("tls_key", "", "TLS key file")
+  fTLSSelfSigned := flag.Bool("tls_self_signed", false, "Serve TLS with a generated self-signed certificate")
+  fClientCA := flag.String("client_ca", "", "CA certificate used to verify client certificates (e.g. ssl/generated/ca_root.pem)")
+  fRequireClientCert := flag.Bool("require_client_cert", false, "Reject clients without a certificate signed by -client_ca")
 
   flag.Usage = func() {
     fmt.Fprintf(flag.CommandLine.Output(), "Usage of %s:\n", os.Args[0])
@@ -90,46 +190,121 @@ func main() {
 
   flag.Parse()
 
-  var mappings []DirMapping
-  var proxyMappings []ProxyMapping
-  reverseProxies := make(map[string]*httputil.ReverseProxy)
+  var conf *ConfigFile
+  if *fConfigFile != "" {
+    var err error
+    conf, err = parseConfigFile(*fConfigFile)
+    if err != nil {
+      log.Fatalf("error parsing config: %s", err.Error())
+    }
+  }
+
+  sv := &MappedDirServer{
+    RenderReadme: *fReadme,
+    DefaultFileOptions: FileOptions{
+      CacheControl: *fCacheControl,
+      Compress: *fCompress,
+    },
+    ReverseProxies: make(map[string]http.Handler),
+  }
+  if conf != nil && len(conf.ErrorPages) > 0 {
+    var err error
+    sv.DefaultFileOptions.ErrorPages, err = loadErrorPages(conf.ErrorPages)
+    if err != nil {
+      log.Fatalf("error loading error pages: %s", err.Error())
+    }
+  }
+  sv.StaticServer = &FileListServer{
+    Root: *fDir,
+    RenderReadme: *fReadme,
+    Options: &sv.DefaultFileOptions,
+  }
 
   for _, pair := range flag.Args() {
     s := strings.SplitN(pair, ":", 2)
     if len(s) != 2 {
       log.Fatalf("bad mapping: %q", pair)
     }
-    if (strings.HasPrefix(s[1], "http")) {
-      remote, err := url.Parse(s[1])
-      if err != nil {
+    if err := sv.AddMapping(s[0], s[1], nil); err != nil {
+      log.Fatal(err)
+    }
+  }
+  if conf != nil {
+    for _, route := range conf.Routes {
+      if route.Target == "" {
+        continue
+      }
+      if err := sv.AddMapping(route.URLPrefix, route.Target, route); err != nil {
         log.Fatal(err)
       }
-      proxyMappings = append(proxyMappings, ProxyMapping{
-        URLPrefix: s[0],
-        ProxyDestination: remote,
-      })
-      reverseProxies[s[0]] = httputil.NewSingleHostReverseProxy(remote)
-      log.Printf("proxying %s -> %s", s[0], s[1])
-    } else {
-      mappings = append(mappings, DirMapping{
-        URLPrefix: s[0],
-        ActualDir: s[1],
-      })
-      log.Printf("mapping url %s to dir %s", s[0], s[1])
     }
   }
 
-  sv := &MappedDirServer{
-    Mappings: mappings,
-    ProxyMappings: proxyMappings,
-    StaticServer: http.FileServer(http.Dir(*fDir)),
-    ReverseProxies: reverseProxies,
+  mux := http.NewServeMux()
+  if *fLive {
+    watched := []string{*fDir}
+    for _, m := range sv.Mappings {
+      watched = append(watched, m.ActualDir)
+    }
+    lr, err := NewLiveReloader(watched)
+    if err != nil {
+      log.Fatal(err)
+    }
+    mux.HandleFunc(liveEventsPath, lr.ServeEvents)
+    mux.HandleFunc(liveScriptPath, lr.ServeScript)
+    sv.Live = lr
+    log.Printf("live reload enabled for %v", watched)
   }
+  mux.Handle("/", sv)
 
-  http.Handle("/", &HTTPLogger{ h: sv })
+  var stats *RouteStats
+  if *fStats {
+    stats = NewRouteStats()
+    mux.Handle(statsPath, stats)
+  }
 
-  host := fmt.Sprintf(":%d", *fPort)
-  log.Printf("serving on %s", host)
-  log.Fatal(http.ListenAndServe(host, nil))
-}
+  handler, err := withAccessControl(mux, conf, splitList(*fAllow), splitList(*fDeny))
+  if err != nil {
+    log.Fatal(err)
+  }
 
+  server := &http.Server{
+    Addr: fmt.Sprintf("%s:%d", *fHost, *fPort),
+    Handler: &HTTPLogger{
+      h: handler,
+      Format: *fLogFormat,
+      RouteFor: func(urlPath string) string {
+        // Requests for the server's own endpoints are named after the endpoint.
+        if _, pattern := mux.Handler(&http.Request{Method: "GET", URL: &url.URL{Path: urlPath}}); pattern != "/" {
+          return pattern
+        }
+        return sv.RouteFor(urlPath)
+      },
+      Stats: stats,
+    },
+  }
+
+  tlsOpts := &TLSOptions{
+    CertFile: *fTLSCert,
+    KeyFile: *fTLSKey,
+    SelfSigned: *fTLSSelfSigned,
+    Hosts: []string{*fHost},
+    ClientCAFile: *fClientCA,
+    RequireClientCert: *fRequireClientCert,
+  }
+  if !tlsOpts.Enabled() {
+    if *fClientCA != "" {
+      log.Fatal("client certificates need TLS, use -tls_cert/-tls_key or -tls_self_signed")
+    }
+    log.Printf("serving on %s", server.Addr)
+    log.Fatal(server.ListenAndServe())
+  }
+
+  server.TLSConfig, err = tlsOpts.Config()
+  if err != nil {
+    log.Fatalf("error setting up TLS: %s", err.Error())
+  }
+  log.Printf("serving TLS on %s", server.Addr)
+  // The certificates are already loaded into the TLS config.
+  log.Fatal(server.ListenAndServeTLS("", ""))
+}
@@ -0,0 +1,413 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/yuin/goldmark"
+)
+
+// Stop walking the tree once a recursive search has found this many matches.
+const maxSearchResults = 1000
+
+// Files that get rendered under a directory listing, in order of preference.
+var readmeNames = []string{"README.md", "readme.md", "README", "README.txt", "readme.txt"}
+
+// FileListServer serves the files under Root like http.FileServer does, but renders its own
+// directory listings. Listings can be sorted, filtered by glob, searched recursively by filename and
+// requested as JSON.
+type FileListServer struct {
+  // Stripped from the request path before looking up files under Root.
+  URLPrefix string
+  Root string
+  // If set, a README in the listed directory is rendered below the listing.
+  RenderReadme bool
+  // How regular files are served. May be nil.
+  Options *FileOptions
+}
+
+type listingEntry struct {
+  Name string `json:"name"`
+  URL string `json:"url"`
+  IsDir bool `json:"is_dir"`
+  Size int64 `json:"size"`
+  ModTime time.Time `json:"mod_time"`
+}
+
+type breadcrumb struct {
+  Name string `json:"name"`
+  URL string `json:"url"`
+}
+
+type listingColumn struct {
+  Label string
+  URL string
+}
+
+type listing struct {
+  Path string `json:"path"`
+  Breadcrumbs []breadcrumb `json:"breadcrumbs"`
+  Sort string `json:"sort"`
+  Order string `json:"order"`
+  Glob string `json:"glob,omitempty"`
+  Search string `json:"q,omitempty"`
+  // True if a search stopped early because it hit maxSearchResults.
+  Truncated bool `json:"truncated,omitempty"`
+  Entries []listingEntry `json:"entries"`
+
+  Columns []listingColumn `json:"-"`
+  Readme template.HTML `json:"-"`
+}
+
+func (s *FileListServer) fileServer() http.Handler {
+  return http.StripPrefix(s.URLPrefix, http.FileServer(http.Dir(s.Root)))
+}
+
+func (s *FileListServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  relPath := path.Clean("/" + strings.TrimPrefix(r.URL.Path, s.URLPrefix))
+  if s.Options != nil {
+    w = withErrorPages(w, r, s.Options.ErrorPages)
+  }
+
+  // Missing files and other errors are handled exactly the way http.FileServer would, unless this is
+  // a single page app.
+  f, err := http.Dir(s.Root).Open(relPath)
+  if err != nil {
+    if s.Options != nil && s.Options.SPA != nil && errors.Is(err, fs.ErrNotExist) && s.Options.SPA.shouldFallback(r, relPath) {
+      serveFile(w, r, filepath.Join(s.Root, filepath.FromSlash(s.Options.SPA.index())), s.Options)
+      return
+    }
+    s.fileServer().ServeHTTP(w, r)
+    return
+  }
+  stat, err := f.Stat()
+  f.Close()
+  if err != nil {
+    s.fileServer().ServeHTTP(w, r)
+    return
+  }
+
+  filename := filepath.Join(s.Root, filepath.FromSlash(relPath))
+  if !stat.IsDir() {
+    serveFile(w, r, filename, s.Options)
+    return
+  }
+
+  // Make sure relative links in the listing (or index.html) resolve inside the directory.
+  if !strings.HasSuffix(r.URL.Path, "/") {
+    target := path.Base(r.URL.Path) + "/"
+    if r.URL.RawQuery != "" {
+      target += "?" + r.URL.RawQuery
+    }
+    http.Redirect(w, r, target, http.StatusMovedPermanently)
+    return
+  }
+
+  if index, err := os.Stat(filepath.Join(filename, "index.html")); err == nil && !index.IsDir() {
+    serveFile(w, r, filepath.Join(filename, "index.html"), s.Options)
+    return
+  }
+
+  l, err := s.buildListing(r, relPath)
+  if errors.Is(err, path.ErrBadPattern) {
+    http.Error(w, "bad glob: "+err.Error(), http.StatusBadRequest)
+    return
+  } else if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  if wantsJSON(r) {
+    w.Header().Set("Content-Type", "application/json")
+    if err := json.NewEncoder(w).Encode(l); err != nil {
+      log.Printf("error writing listing: %s", err.Error())
+    }
+    return
+  }
+
+  w.Header().Set("Content-Type", "text/html; charset=utf-8")
+  if err := listingTemplate.Execute(w, l); err != nil {
+    log.Printf("error writing listing: %s", err.Error())
+  }
+}
+
+func wantsJSON(r *http.Request) bool {
+  if r.URL.Query().Get("format") == "json" {
+    return true
+  }
+  return strings.Contains(r.Header.Get("Accept"), "application/json")
+}
+
+func (s *FileListServer) buildListing(r *http.Request, relPath string) (*listing, error) {
+  query := r.URL.Query()
+  l := &listing{
+    Path: r.URL.Path,
+    Breadcrumbs: makeBreadcrumbs(r.URL.Path),
+    Sort: query.Get("sort"),
+    Order: query.Get("order"),
+    Glob: query.Get("glob"),
+    Search: query.Get("q"),
+  }
+  if l.Sort != "size" && l.Sort != "mtime" {
+    l.Sort = "name"
+  }
+  if l.Order != "desc" {
+    l.Order = "asc"
+  }
+  if l.Glob != "" {
+    if _, err := path.Match(l.Glob, ""); err != nil {
+      return nil, err
+    }
+  }
+
+  dir := filepath.Join(s.Root, filepath.FromSlash(relPath))
+  var err error
+  if l.Search != "" {
+    l.Entries, l.Truncated, err = searchDir(dir, l.Search, l.Glob)
+  } else {
+    l.Entries, err = readDir(dir, l.Glob)
+  }
+  if err != nil {
+    return nil, err
+  }
+  sortEntries(l.Entries, l.Sort, l.Order == "desc")
+
+  for _, c := range []struct{ label, key string }{{"Name", "name"}, {"Size", "size"}, {"Modified", "mtime"}} {
+    // Clicking the current sort column flips the order.
+    order := "asc"
+    if c.key == l.Sort && l.Order == "asc" {
+      order = "desc"
+    }
+    q := url.Values{}
+    q.Set("sort", c.key)
+    q.Set("order", order)
+    if l.Glob != "" {
+      q.Set("glob", l.Glob)
+    }
+    if l.Search != "" {
+      q.Set("q", l.Search)
+    }
+    l.Columns = append(l.Columns, listingColumn{Label: c.label, URL: "?" + q.Encode()})
+  }
+
+  if s.RenderReadme && l.Search == "" {
+    l.Readme = renderReadme(dir)
+  }
+
+  return l, nil
+}
+
+func makeEntry(name string, info fs.FileInfo) listingEntry {
+  u := (&url.URL{Path: filepath.ToSlash(name)}).String()
+  if info.IsDir() {
+    u += "/"
+  }
+  return listingEntry{
+    Name: filepath.ToSlash(name),
+    URL: u,
+    IsDir: info.IsDir(),
+    Size: info.Size(),
+    ModTime: info.ModTime(),
+  }
+}
+
+func readDir(dir string, glob string) ([]listingEntry, error) {
+  dirEntries, err := os.ReadDir(dir)
+  if err != nil {
+    return nil, err
+  }
+  entries := []listingEntry{}
+  for _, de := range dirEntries {
+    if glob != "" && !de.IsDir() {
+      if ok, _ := path.Match(glob, de.Name()); !ok {
+        continue
+      }
+    }
+    info, err := de.Info()
+    if err != nil {
+      // The file probably went away between the readdir and the stat.
+      continue
+    }
+    entries = append(entries, makeEntry(de.Name(), info))
+  }
+  return entries, nil
+}
+
+// Walk everything under dir looking for files whose name contains the (case-insensitive) search
+// string. Entry names are relative to dir.
+func searchDir(dir string, search string, glob string) ([]listingEntry, bool, error) {
+  search = strings.ToLower(search)
+  entries := []listingEntry{}
+  truncated := false
+  err := filepath.WalkDir(dir, func(p string, de fs.DirEntry, err error) error {
+    if err != nil {
+      // Skip unreadable subtrees rather than failing the whole search.
+      if de != nil && de.IsDir() && p != dir {
+        return filepath.SkipDir
+      }
+      return err
+    }
+    if p == dir {
+      return nil
+    }
+    if !strings.Contains(strings.ToLower(de.Name()), search) {
+      return nil
+    }
+    if glob != "" {
+      if ok, _ := path.Match(glob, de.Name()); !ok {
+        return nil
+      }
+    }
+    info, err := de.Info()
+    if err != nil {
+      return nil
+    }
+    rel, err := filepath.Rel(dir, p)
+    if err != nil {
+      return err
+    }
+    entries = append(entries, makeEntry(rel, info))
+    if len(entries) >= maxSearchResults {
+      truncated = true
+      return filepath.SkipAll
+    }
+    return nil
+  })
+  return entries, truncated, err
+}
+
+func sortEntries(entries []listingEntry, by string, desc bool) {
+  less := func(a, b listingEntry) bool {
+    switch by {
+    case "size":
+      if a.Size != b.Size {
+        return a.Size < b.Size
+      }
+    case "mtime":
+      if !a.ModTime.Equal(b.ModTime) {
+        return a.ModTime.Before(b.ModTime)
+      }
+    default:
+      // Keep directories grouped together at the top when sorting by name.
+      if a.IsDir != b.IsDir {
+        return a.IsDir != desc
+      }
+    }
+    return strings.ToLower(a.Name) < strings.ToLower(b.Name)
+  }
+  sort.SliceStable(entries, func(i, j int) bool {
+    if desc {
+      return less(entries[j], entries[i])
+    }
+    return less(entries[i], entries[j])
+  })
+}
+
+func makeBreadcrumbs(urlPath string) []breadcrumb {
+  crumbs := []breadcrumb{{Name: "/", URL: "/"}}
+  current := "/"
+  for _, part := range strings.Split(strings.Trim(urlPath, "/"), "/") {
+    if part == "" {
+      continue
+    }
+    current += part + "/"
+    crumbs = append(crumbs, breadcrumb{Name: part, URL: current})
+  }
+  return crumbs
+}
+
+// Render the first README found in dir. Markdown files are converted to HTML (raw HTML in the
+// markdown is dropped), anything else is shown as preformatted text.
+func renderReadme(dir string) template.HTML {
+  for _, name := range readmeNames {
+    bs, err := os.ReadFile(filepath.Join(dir, name))
+    if err != nil {
+      continue
+    }
+    if strings.HasSuffix(strings.ToLower(name), ".md") {
+      var buf bytes.Buffer
+      if err := goldmark.Convert(bs, &buf); err != nil {
+        log.Printf("error rendering %s: %s", name, err.Error())
+        return ""
+      }
+      return template.HTML(buf.String())
+    }
+    return template.HTML("<pre>" + template.HTMLEscapeString(string(bs)) + "</pre>")
+  }
+  return ""
+}
+
+func formatSize(size int64) string {
+  units := []string{"B", "KB", "MB", "GB", "TB"}
+  f := float64(size)
+  i := 0
+  for f >= 1024 && i < len(units)-1 {
+    f /= 1024
+    i++
+  }
+  if i == 0 {
+    return fmt.Sprintf("%d B", size)
+  }
+  return fmt.Sprintf("%.1f %s", f, units[i])
+}
+
+var listingTemplate = template.Must(template.New("listing").Funcs(template.FuncMap{
+  "formatSize": formatSize,
+  "formatTime": func(t time.Time) string {
+    return t.Format("2006-01-02 15:04:05")
+  },
+}).Parse(listingTemplateString))
+
+var listingTemplateString = `<!DOCTYPE html>
+<html>
+<head>
+  <title>Index of {{.Path}}</title>
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+  <style>
+    body { font-family: sans-serif; margin: 1em 2em; }
+    table { border-collapse: collapse; min-width: 50%; }
+    th, td { text-align: left; padding: 2px 12px 2px 0; }
+    td.size { text-align: right; }
+    .crumbs a { text-decoration: none; }
+    .readme { border-top: solid 1px #ccc; margin-top: 1em; }
+  </style>
+</head>
+<body>
+<h3 class="crumbs">{{range $i, $c := .Breadcrumbs}}{{if $i}} / {{end}}<a href="{{$c.URL}}">{{$c.Name}}</a>{{end}}</h3>
+<form method="GET">
+  <input type="hidden" name="sort" value="{{.Sort}}" />
+  <input type="hidden" name="order" value="{{.Order}}" />
+  <input type="text" name="glob" placeholder="glob, e.g. *.js" value="{{.Glob}}" />
+  <input type="text" name="q" placeholder="search subdirectories" value="{{.Search}}" />
+  <button type="submit">Filter</button>
+  {{if or .Glob .Search}}<a href="?">clear</a>{{end}}
+</form>
+<table>
+  <tr>{{range .Columns}}<th><a href="{{.URL}}">{{.Label}}</a></th>{{end}}</tr>
+  {{if ne .Path "/"}}<tr><td><a href="../">../</a></td><td></td><td></td></tr>{{end}}
+  {{range .Entries}}
+  <tr>
+    <td><a href="{{.URL}}">{{.Name}}{{if .IsDir}}/{{end}}</a></td>
+    <td class="size">{{if not .IsDir}}{{formatSize .Size}}{{end}}</td>
+    <td>{{formatTime .ModTime}}</td>
+  </tr>
+  {{end}}
+</table>
+{{if .Truncated}}<p>Too many results, only showing the first {{len .Entries}}.</p>{{end}}
+{{if .Readme}}<div class="readme">{{.Readme}}</div>{{end}}
+</body>
+</html>
+`
@@ -0,0 +1,175 @@
+package main
+
+import (
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+)
+
+// Builds a small tree under a temp dir. Files get increasing sizes and mtimes in the order listed.
+func makeListingTree(t *testing.T) string {
+  t.Helper()
+  root := t.TempDir()
+  files := []string{"b.txt", "a.js", "C.md", "sub/deep/match.js", "sub/other.txt"}
+  base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+  for i, name := range files {
+    p := filepath.Join(root, filepath.FromSlash(name))
+    if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+      t.Fatal(err)
+    }
+    if err := os.WriteFile(p, []byte(strings.Repeat("x", (i+1)*10)), 0644); err != nil {
+      t.Fatal(err)
+    }
+    mtime := base.Add(time.Duration(i) * time.Hour)
+    if err := os.Chtimes(p, mtime, mtime); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return root
+}
+
+func getListing(t *testing.T, h http.Handler, target string) *listing {
+  t.Helper()
+  req := httptest.NewRequest("GET", target, nil)
+  req.Header.Set("Accept", "application/json")
+  rec := httptest.NewRecorder()
+  h.ServeHTTP(rec, req)
+  if rec.Code != 200 {
+    t.Fatalf("%s: got status %d: %s", target, rec.Code, rec.Body.String())
+  }
+  if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+    t.Fatalf("%s: got content type %q", target, ct)
+  }
+  l := &listing{}
+  if err := json.Unmarshal(rec.Body.Bytes(), l); err != nil {
+    t.Fatal(err)
+  }
+  return l
+}
+
+func entryNames(l *listing) []string {
+  names := []string{}
+  for _, e := range l.Entries {
+    names = append(names, e.Name)
+  }
+  return names
+}
+
+func TestListingSortAndFilter(t *testing.T) {
+  s := &FileListServer{Root: makeListingTree(t)}
+  tests := []struct {
+    query string
+    want []string
+  }{
+    // Directories stay at the top when sorting by name, either way round.
+    {"", []string{"sub", "a.js", "b.txt", "C.md"}},
+    {"?order=desc", []string{"sub", "C.md", "b.txt", "a.js"}},
+    // Unknown sort keys fall back to name.
+    {"?sort=bogus", []string{"sub", "a.js", "b.txt", "C.md"}},
+    // Globs only filter files, directories are always listed.
+    {"?glob=*.js", []string{"sub", "a.js"}},
+    {"?q=match", []string{"sub/deep/match.js"}},
+    {"?q=.txt", []string{"b.txt", "sub/other.txt"}},
+    {"?q=t&glob=*.js", []string{"sub/deep/match.js"}},
+  }
+  for _, tt := range tests {
+    if got := entryNames(getListing(t, s, "/"+tt.query)); !reflect.DeepEqual(got, tt.want) {
+      t.Errorf("%q: got %v, want %v", tt.query, got, tt.want)
+    }
+  }
+
+  // A directory's size and mtime depend on the filesystem, so only check the order of the files.
+  for query, want := range map[string][]string{
+    "?sort=size": {"b.txt", "a.js", "C.md"},
+    "?sort=size&order=desc": {"C.md", "a.js", "b.txt"},
+    "?sort=mtime&order=desc": {"C.md", "a.js", "b.txt"},
+  } {
+    l := getListing(t, s, "/"+query)
+    var got []string
+    for _, e := range l.Entries {
+      if !e.IsDir {
+        got = append(got, e.Name)
+      }
+    }
+    if !reflect.DeepEqual(got, want) {
+      t.Errorf("%q: got %v, want %v", query, got, want)
+    }
+  }
+}
+
+func TestListingBadGlob(t *testing.T) {
+  s := &FileListServer{Root: makeListingTree(t)}
+  rec := httptest.NewRecorder()
+  s.ServeHTTP(rec, httptest.NewRequest("GET", "/?glob=[", nil))
+  if rec.Code != http.StatusBadRequest {
+    t.Errorf("got status %d for a bad glob", rec.Code)
+  }
+}
+
+func TestListingJSON(t *testing.T) {
+  s := &FileListServer{URLPrefix: "/files", Root: makeListingTree(t)}
+  l := getListing(t, s, "/files/sub/?format=json")
+  if l.Path != "/files/sub/" || l.Sort != "name" || l.Order != "asc" {
+    t.Errorf("got %+v", l)
+  }
+  if len(l.Entries) != 2 {
+    t.Fatalf("got entries %+v", l.Entries)
+  }
+  deep, other := l.Entries[0], l.Entries[1]
+  if deep.Name != "deep" || !deep.IsDir || deep.URL != "deep/" {
+    t.Errorf("got dir entry %+v", deep)
+  }
+  if other.Name != "other.txt" || other.IsDir || other.URL != "other.txt" || other.Size != 50 || other.ModTime.IsZero() {
+    t.Errorf("got file entry %+v", other)
+  }
+}
+
+func TestListingBreadcrumbs(t *testing.T) {
+  tests := []struct {
+    path string
+    want []breadcrumb
+  }{
+    {"/", []breadcrumb{{"/", "/"}}},
+    {"/a/", []breadcrumb{{"/", "/"}, {"a", "/a/"}}},
+    {"/a/b/c/", []breadcrumb{{"/", "/"}, {"a", "/a/"}, {"b", "/a/b/"}, {"c", "/a/b/c/"}}},
+  }
+  for _, tt := range tests {
+    if got := makeBreadcrumbs(tt.path); !reflect.DeepEqual(got, tt.want) {
+      t.Errorf("%q: got %v, want %v", tt.path, got, tt.want)
+    }
+  }
+
+  s := &FileListServer{Root: makeListingTree(t)}
+  l := getListing(t, s, "/sub/deep/")
+  if want := []breadcrumb{{"/", "/"}, {"sub", "/sub/"}, {"deep", "/sub/deep/"}}; !reflect.DeepEqual(l.Breadcrumbs, want) {
+    t.Errorf("got %v, want %v", l.Breadcrumbs, want)
+  }
+}
+
+func TestListingHTML(t *testing.T) {
+  s := &FileListServer{Root: makeListingTree(t)}
+  rec := httptest.NewRecorder()
+  s.ServeHTTP(rec, httptest.NewRequest("GET", "/sub/", nil))
+  body := rec.Body.String()
+  if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/html") {
+    t.Errorf("got content type %q", rec.Header().Get("Content-Type"))
+  }
+  for _, want := range []string{`<a href="other.txt">other.txt</a>`, `<a href="deep/">deep/</a>`, `<a href="/sub/">sub</a>`} {
+    if !strings.Contains(body, want) {
+      t.Errorf("listing doesn't contain %s:\n%s", want, body)
+    }
+  }
+
+  // Directories without a trailing slash are redirected, keeping the query.
+  rec = httptest.NewRecorder()
+  s.ServeHTTP(rec, httptest.NewRequest("GET", "/sub?sort=size", nil))
+  if rec.Code != http.StatusMovedPermanently || rec.Header().Get("Location") != "/sub/?sort=size" {
+    t.Errorf("got %d to %q", rec.Code, rec.Header().Get("Location"))
+  }
+}
@@ -0,0 +1,295 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// How long to wait for more filesystem events before telling clients to reload. Editors tend to
+// write a file in several steps.
+const liveDebounce = 100 * time.Millisecond
+
+const liveScriptPath = "/_live/reload.js"
+const liveEventsPath = "/_live/events"
+
+var liveScriptTag = []byte(`<script src="` + liveScriptPath + `"></script>`)
+
+// Reloads the page when a change event comes in. If only stylesheets changed, swap them in place
+// instead so the page keeps its state.
+var liveScript = `(function() {
+  var source = new EventSource("` + liveEventsPath + `");
+  source.addEventListener("change", function(e) {
+    var change = JSON.parse(e.data);
+    if (!change.css_only) {
+      window.location.reload();
+      return;
+    }
+    var links = document.querySelectorAll('link[rel="stylesheet"]');
+    for (var i = 0; i < links.length; i++) {
+      var url = new URL(links[i].href);
+      url.searchParams.set("_live", Date.now());
+      links[i].href = url.toString();
+    }
+  });
+})();
+`
+
+type liveChange struct {
+  Files []string `json:"files"`
+  CSSOnly bool `json:"css_only"`
+}
+
+// LiveReloader watches a set of directories and pushes change events to connected browsers over
+// server-sent events.
+type LiveReloader struct {
+  watcher *fsnotify.Watcher
+
+  mu sync.Mutex
+  clients map[chan liveChange]struct{}
+}
+
+func NewLiveReloader(paths []string) (*LiveReloader, error) {
+  watcher, err := fsnotify.NewWatcher()
+  if err != nil {
+    return nil, err
+  }
+  lr := &LiveReloader{
+    watcher: watcher,
+    clients: make(map[chan liveChange]struct{}),
+  }
+  for _, p := range paths {
+    if err := lr.watchTree(p); err != nil {
+      watcher.Close()
+      return nil, err
+    }
+  }
+  go lr.run()
+  return lr, nil
+}
+
+func isIgnoredPath(p string) bool {
+  base := filepath.Base(p)
+  return base == ".git" || base == "node_modules" ||
+      strings.HasSuffix(base, ".swp") || strings.HasSuffix(base, "~")
+}
+
+// fsnotify doesn't watch recursively, so add every directory under root. Single files are watched
+// through their parent directory.
+func (lr *LiveReloader) watchTree(root string) error {
+  info, err := os.Stat(root)
+  if err != nil {
+    return err
+  }
+  if !info.IsDir() {
+    return lr.watcher.Add(filepath.Dir(root))
+  }
+  return filepath.WalkDir(root, func(p string, de fs.DirEntry, err error) error {
+    if err != nil {
+      return err
+    }
+    if !de.IsDir() {
+      return nil
+    }
+    if p != root && isIgnoredPath(p) {
+      return filepath.SkipDir
+    }
+    return lr.watcher.Add(p)
+  })
+}
+
+func (lr *LiveReloader) run() {
+  pending := map[string]struct{}{}
+  timer := time.NewTimer(liveDebounce)
+  timer.Stop()
+
+  for {
+    select {
+    case ev, ok := <-lr.watcher.Events:
+      if !ok {
+        return
+      }
+      if ev.Op == fsnotify.Chmod || isIgnoredPath(ev.Name) {
+        continue
+      }
+      if ev.Op&fsnotify.Create != 0 {
+        if info, err := os.Stat(ev.Name); err == nil && info.IsDir() {
+          if err := lr.watchTree(ev.Name); err != nil {
+            log.Printf("live: failed to watch %s: %s", ev.Name, err.Error())
+          }
+        }
+      }
+      pending[ev.Name] = struct{}{}
+      timer.Reset(liveDebounce)
+    case err, ok := <-lr.watcher.Errors:
+      if !ok {
+        return
+      }
+      log.Printf("live: watch error: %s", err.Error())
+    case <-timer.C:
+      change := liveChange{CSSOnly: true}
+      for p := range pending {
+        change.Files = append(change.Files, p)
+        if filepath.Ext(p) != ".css" {
+          change.CSSOnly = false
+        }
+      }
+      pending = map[string]struct{}{}
+      log.Printf("live: changed %v", change.Files)
+      lr.broadcast(change)
+    }
+  }
+}
+
+func (lr *LiveReloader) broadcast(change liveChange) {
+  lr.mu.Lock()
+  defer lr.mu.Unlock()
+  for ch := range lr.clients {
+    // Don't let one slow client hold up everyone else. It'll get the next change.
+    select {
+    case ch <- change:
+    default:
+    }
+  }
+}
+
+func (lr *LiveReloader) ServeEvents(w http.ResponseWriter, r *http.Request) {
+  flusher, ok := w.(http.Flusher)
+  if !ok {
+    http.Error(w, "streaming not supported", 500)
+    return
+  }
+
+  ch := make(chan liveChange, 1)
+  lr.mu.Lock()
+  lr.clients[ch] = struct{}{}
+  lr.mu.Unlock()
+  defer func() {
+    lr.mu.Lock()
+    delete(lr.clients, ch)
+    lr.mu.Unlock()
+  }()
+
+  w.Header().Set("Content-Type", "text/event-stream")
+  w.Header().Set("Cache-Control", "no-cache")
+  w.WriteHeader(http.StatusOK)
+  flusher.Flush()
+
+  for {
+    select {
+    case <-r.Context().Done():
+      return
+    case change := <-ch:
+      bs, err := json.Marshal(change)
+      if err != nil {
+        log.Printf("live: %s", err.Error())
+        continue
+      }
+      if _, err := fmt.Fprintf(w, "event: change\ndata: %s\n\n", bs); err != nil {
+        return
+      }
+      flusher.Flush()
+    }
+  }
+}
+
+func (lr *LiveReloader) ServeScript(w http.ResponseWriter, r *http.Request) {
+  w.Header().Set("Content-Type", "text/javascript")
+  fmt.Fprintf(w, "%s", liveScript)
+}
+
+// Wrap injects the reload script into every HTML response from h.
+func (lr *LiveReloader) Wrap(h http.Handler) http.Handler {
+  return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    // Leave websocket upgrades alone, they need the original (hijackable) ResponseWriter.
+    if r.Header.Get("Upgrade") != "" {
+      h.ServeHTTP(w, r)
+      return
+    }
+
+    // Always send full responses so there's something to inject the script into, and so the
+    // browser doesn't hang on to stale copies.
+    r.Header.Del("If-Modified-Since")
+    r.Header.Del("If-None-Match")
+    r.Header.Del("Range")
+    r.Header.Del("Accept-Encoding")
+    w.Header().Set("Cache-Control", "no-store")
+
+    iw := &injectingWriter{ResponseWriter: w}
+    h.ServeHTTP(iw, r)
+    iw.finish()
+  })
+}
+
+// Buffers HTML responses so the reload script can be added before they're sent. Everything else
+// is passed straight through.
+type injectingWriter struct {
+  http.ResponseWriter
+  status int
+  html bool
+  buf bytes.Buffer
+}
+
+func (iw *injectingWriter) WriteHeader(status int) {
+  if iw.status != 0 {
+    return
+  }
+  iw.status = status
+  // Compressed bodies (e.g. from a proxied server) can't be edited, so leave those alone.
+  iw.html = strings.HasPrefix(iw.Header().Get("Content-Type"), "text/html") &&
+      iw.Header().Get("Content-Encoding") == ""
+  if iw.html {
+    iw.Header().Del("Content-Length")
+    return
+  }
+  iw.ResponseWriter.WriteHeader(status)
+}
+
+func (iw *injectingWriter) Write(bs []byte) (int, error) {
+  if iw.status == 0 {
+    if iw.Header().Get("Content-Type") == "" {
+      iw.Header().Set("Content-Type", http.DetectContentType(bs))
+    }
+    iw.WriteHeader(http.StatusOK)
+  }
+  if iw.html {
+    return iw.buf.Write(bs)
+  }
+  return iw.ResponseWriter.Write(bs)
+}
+
+func (iw *injectingWriter) Flush() {
+  if iw.html {
+    return
+  }
+  if f, ok := iw.ResponseWriter.(http.Flusher); ok {
+    f.Flush()
+  }
+}
+
+func (iw *injectingWriter) finish() {
+  if !iw.html {
+    return
+  }
+  body := iw.buf.Bytes()
+  i := bytes.LastIndex(bytes.ToLower(body), []byte("</body>"))
+  if i < 0 {
+    i = len(body)
+  }
+  var out bytes.Buffer
+  out.Write(body[:i])
+  out.Write(liveScriptTag)
+  out.Write(body[i:])
+  iw.ResponseWriter.WriteHeader(iw.status)
+  iw.ResponseWriter.Write(out.Bytes())
+}
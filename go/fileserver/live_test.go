@@ -0,0 +1,77 @@
+package main
+
+import (
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestLiveInjectsHTMLOnly(t *testing.T) {
+  lr := &LiveReloader{}
+  tests := []struct {
+    contentType string
+    body string
+    want string
+  }{
+    {"text/html; charset=utf-8", "<html><body>hi</body></html>", "<html><body>hi" + string(liveScriptTag) + "</body></html>"},
+    // No </body>, so the script goes at the end.
+    {"text/html", "<p>hi", "<p>hi" + string(liveScriptTag)},
+    // Sniffed content types count too.
+    {"", "<!DOCTYPE html><body></body>", "<!DOCTYPE html><body>" + string(liveScriptTag) + "</body>"},
+    {"application/json", `{"body": "</body>"}`, `{"body": "</body>"}`},
+    {"text/css", "body {}", "body {}"},
+  }
+  for _, tt := range tests {
+    h := lr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      if tt.contentType != "" {
+        w.Header().Set("Content-Type", tt.contentType)
+      }
+      io.WriteString(w, tt.body)
+    }))
+    rec := httptest.NewRecorder()
+    h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+    if got := rec.Body.String(); got != tt.want {
+      t.Errorf("%q: got %q, want %q", tt.contentType, got, tt.want)
+    }
+  }
+}
+
+// Proxied routes are passed through as is, the reload script is only added to files served from disk.
+func TestLiveSkipsProxies(t *testing.T) {
+  backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/html")
+    io.WriteString(w, "<body>proxied</body>")
+  }))
+  defer backend.Close()
+
+  dir := t.TempDir()
+  if err := os.WriteFile(filepath.Join(dir, "index.html"), []byte("<body>static</body>"), 0644); err != nil {
+    t.Fatal(err)
+  }
+  sv := &MappedDirServer{
+    StaticServer: &FileListServer{Root: dir},
+    ReverseProxies: make(map[string]http.Handler),
+    Live: &LiveReloader{},
+  }
+  if err := sv.AddMapping("/api/", backend.URL, nil); err != nil {
+    t.Fatal(err)
+  }
+  if err := sv.AddMapping("/files/", dir, nil); err != nil {
+    t.Fatal(err)
+  }
+
+  for path, want := range map[string]string{
+    "/api/": "<body>proxied</body>",
+    "/": "<body>static" + string(liveScriptTag) + "</body>",
+    "/files/": "<body>static" + string(liveScriptTag) + "</body>",
+  } {
+    rec := httptest.NewRecorder()
+    sv.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+    if got := rec.Body.String(); got != want {
+      t.Errorf("%s: got %q, want %q", path, got, want)
+    }
+  }
+}
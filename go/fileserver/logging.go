@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const statsPath = "/_stats"
+
+// Upper bounds of the latency histogram buckets. Anything slower lands in a final overflow bucket.
+var latencyBuckets = []time.Duration{
+  time.Millisecond,
+  5 * time.Millisecond,
+  10 * time.Millisecond,
+  25 * time.Millisecond,
+  50 * time.Millisecond,
+  100 * time.Millisecond,
+  250 * time.Millisecond,
+  500 * time.Millisecond,
+  time.Second,
+  5 * time.Second,
+  30 * time.Second,
+}
+
+// Access logs in json and clf formats are written as bare lines, without the log package's timestamp.
+var accessLog = log.New(os.Stderr, "", 0)
+
+// Records what happened to a response so it can be logged afterwards.
+type responseRecorder struct {
+  http.ResponseWriter
+  status int
+  bytes int64
+}
+
+func (rr *responseRecorder) WriteHeader(status int) {
+  if rr.status == 0 {
+    rr.status = status
+  }
+  rr.ResponseWriter.WriteHeader(status)
+}
+
+func (rr *responseRecorder) Write(bs []byte) (int, error) {
+  if rr.status == 0 {
+    rr.status = http.StatusOK
+  }
+  n, err := rr.ResponseWriter.Write(bs)
+  rr.bytes += int64(n)
+  return n, err
+}
+
+// Streaming responses need to be able to flush through the recorder.
+func (rr *responseRecorder) Flush() {
+  if f, ok := rr.ResponseWriter.(http.Flusher); ok {
+    f.Flush()
+  }
+}
+
+func (rr *responseRecorder) Unwrap() http.ResponseWriter {
+  return rr.ResponseWriter
+}
+
+// Websocket upgrades through the proxies need to hijack the connection.
+func (rr *responseRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+  h, ok := rr.ResponseWriter.(http.Hijacker)
+  if !ok {
+    return nil, nil, errors.New("connection can't be hijacked")
+  }
+  if rr.status == 0 {
+    rr.status = http.StatusSwitchingProtocols
+  }
+  return h.Hijack()
+}
+
+type accessLogEntry struct {
+  Time time.Time `json:"time"`
+  Client string `json:"client"`
+  User string `json:"user,omitempty"`
+  Method string `json:"method"`
+  URL string `json:"url"`
+  Proto string `json:"proto"`
+  Route string `json:"route"`
+  Status int `json:"status"`
+  Bytes int64 `json:"bytes"`
+  LatencyMs float64 `json:"latency_ms"`
+  Referer string `json:"referer,omitempty"`
+  UserAgent string `json:"user_agent,omitempty"`
+}
+
+// Figure out who made the request, for the logs: the basic auth user or client certificate name.
+func requestUser(r *http.Request) string {
+  if user, _, ok := r.BasicAuth(); ok {
+    return user
+  }
+  if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+    return r.TLS.VerifiedChains[0][0].Subject.CommonName
+  }
+  return ""
+}
+
+func dashIfEmpty(s string) string {
+  if s == "" {
+    return "-"
+  }
+  return s
+}
+
+type routePathKey struct{}
+
+// Rewrites record the path they handed on here, so the request is counted against the route that
+// actually served it.
+func setRoutePath(r *http.Request, urlPath string) {
+  if p, ok := r.Context().Value(routePathKey{}).(*string); ok {
+    *p = urlPath
+  }
+}
+
+type HTTPLogger struct {
+  h http.Handler
+  // One of "text", "json" or "clf" (Common Log Format).
+  Format string
+  // Names the route a request belongs to, for the logs and stats.
+  RouteFor func(urlPath string) string
+  // Optional.
+  Stats *RouteStats
+}
+
+func (hl *HTTPLogger) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  start := time.Now()
+  rr := &responseRecorder{ResponseWriter: w}
+  routePath := r.URL.Path
+  r = r.WithContext(context.WithValue(r.Context(), routePathKey{}, &routePath))
+  hl.h.ServeHTTP(rr, r)
+  latency := time.Since(start)
+
+  if rr.status == 0 {
+    // The handler didn't write anything, which net/http turns into an empty 200.
+    rr.status = http.StatusOK
+  }
+
+  route := ""
+  if hl.RouteFor != nil {
+    route = hl.RouteFor(routePath)
+  }
+  if hl.Stats != nil {
+    hl.Stats.Record(route, rr.status, rr.bytes, latency)
+  }
+
+  client := r.RemoteAddr
+  if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+    client = host
+  }
+
+  switch hl.Format {
+  case "json":
+    bs, err := json.Marshal(&accessLogEntry{
+      Time: start,
+      Client: client,
+      User: requestUser(r),
+      Method: r.Method,
+      URL: r.URL.RequestURI(),
+      Proto: r.Proto,
+      Route: route,
+      Status: rr.status,
+      Bytes: rr.bytes,
+      LatencyMs: float64(latency.Microseconds()) / 1000,
+      Referer: r.Referer(),
+      UserAgent: r.UserAgent(),
+    })
+    if err != nil {
+      log.Printf("error writing access log: %s", err.Error())
+      return
+    }
+    accessLog.Print(string(bs))
+  case "clf":
+    accessLog.Printf("%s - %s [%s] %q %d %d",
+        client, dashIfEmpty(requestUser(r)), start.Format("02/Jan/2006:15:04:05 -0700"),
+        fmt.Sprintf("%s %s %s", r.Method, r.URL.RequestURI(), r.Proto), rr.status, rr.bytes)
+  default:
+    log.Printf("%s %s %d %dB %s [%s] %s", r.Method, r.URL.String(), rr.status, rr.bytes,
+        latency.Round(time.Microsecond), route, client)
+  }
+}
+
+type routeCounters struct {
+  Requests int64 `json:"requests"`
+  // Keyed by status class, e.g. "2xx".
+  Statuses map[string]int64 `json:"statuses"`
+  Bytes int64 `json:"bytes"`
+  TotalLatencyMs float64 `json:"total_latency_ms"`
+  // Counts per latency bucket, parallel to latencyBuckets plus one overflow bucket.
+  LatencyHistogram []int64 `json:"latency_histogram"`
+}
+
+// RouteStats keeps per-route request counters and latency histograms.
+type RouteStats struct {
+  mu sync.Mutex
+  started time.Time
+  routes map[string]*routeCounters
+}
+
+func NewRouteStats() *RouteStats {
+  return &RouteStats{
+    started: time.Now(),
+    routes: make(map[string]*routeCounters),
+  }
+}
+
+func (s *RouteStats) Record(route string, status int, bytes int64, latency time.Duration) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+
+  c, ok := s.routes[route]
+  if !ok {
+    c = &routeCounters{
+      Statuses: make(map[string]int64),
+      LatencyHistogram: make([]int64, len(latencyBuckets)+1),
+    }
+    s.routes[route] = c
+  }
+  c.Requests++
+  c.Statuses[fmt.Sprintf("%dxx", status/100)]++
+  c.Bytes += bytes
+  c.TotalLatencyMs += float64(latency.Microseconds()) / 1000
+  bucket := sort.Search(len(latencyBuckets), func(i int) bool {
+    return latency <= latencyBuckets[i]
+  })
+  c.LatencyHistogram[bucket]++
+}
+
+// Serves the current counters as JSON.
+func (s *RouteStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  bucketNames := []string{}
+  for _, b := range latencyBuckets {
+    bucketNames = append(bucketNames, "<="+b.String())
+  }
+  bucketNames = append(bucketNames, ">"+latencyBuckets[len(latencyBuckets)-1].String())
+
+  var buf bytes.Buffer
+  enc := json.NewEncoder(&buf)
+  // Keep the bucket names readable.
+  enc.SetEscapeHTML(false)
+  s.mu.Lock()
+  err := enc.Encode(struct {
+    Since time.Time `json:"since"`
+    LatencyBuckets []string `json:"latency_buckets"`
+    Routes map[string]*routeCounters `json:"routes"`
+  }{
+    Since: s.started,
+    LatencyBuckets: bucketNames,
+    Routes: s.routes,
+  })
+  s.mu.Unlock()
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  w.Header().Set("Content-Type", "application/json")
+  w.Write(buf.Bytes())
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+// Rewritten requests are counted against the route that served them.
+func TestStatsAfterRewrite(t *testing.T) {
+  dir := t.TempDir()
+  if err := os.WriteFile(filepath.Join(dir, "x.txt"), []byte("x"), 0644); err != nil {
+    t.Fatal(err)
+  }
+  sv := &MappedDirServer{
+    StaticServer: &FileListServer{Root: t.TempDir()},
+    ReverseProxies: make(map[string]http.Handler),
+  }
+  if err := sv.AddMapping("/app/", dir, nil); err != nil {
+    t.Fatal(err)
+  }
+  root := &RouteConfig{
+    URLPrefix: "/",
+    Rewrites: []*RewriteRule{{From: "^/old/(.*)$", To: "/app/$1"}},
+  }
+  if err := root.Rewrites[0].compile(); err != nil {
+    t.Fatal(err)
+  }
+
+  stats := NewRouteStats()
+  hl := &HTTPLogger{
+    h: &RuleHandler{
+      h: sv,
+      conf: &ConfigFile{Routes: []*RouteConfig{root}},
+    },
+    RouteFor: sv.RouteFor,
+    Stats: stats,
+  }
+  for _, path := range []string{"/old/x.txt", "/app/x.txt", "/missing"} {
+    rec := httptest.NewRecorder()
+    hl.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+  }
+
+  if c := stats.routes["/app/"]; c == nil || c.Requests != 2 || c.Statuses["2xx"] != 2 {
+    t.Errorf("got /app/ counters %+v", c)
+  }
+  if c := stats.routes["static"]; c == nil || c.Requests != 1 || c.Statuses["4xx"] != 1 {
+    t.Errorf("got static counters %+v", c)
+  }
+  if len(stats.routes) != 2 {
+    t.Errorf("got routes %v", stats.routes)
+  }
+}
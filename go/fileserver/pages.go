@@ -0,0 +1,208 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Serve an index page for paths that don't exist, so client-side routes in single page apps work
+// when loaded directly.
+type SPAConfig struct {
+  // Relative to the mapped directory. Defaults to index.html.
+  Index string `json:"index"`
+  // Path prefixes inside the mapping that should 404 as normal, e.g. "/api/" for an API that lives
+  // under the app.
+  Exclude []string `json:"exclude"`
+}
+
+// Only fall back for page loads. A missing script or image should still be a 404 rather than a copy
+// of index.html. relPath is the request path with the mapping's URL prefix removed.
+func (spa *SPAConfig) shouldFallback(r *http.Request, relPath string) bool {
+  if r.Method != http.MethodGet && r.Method != http.MethodHead {
+    return false
+  }
+  for _, prefix := range spa.Exclude {
+    if strings.HasPrefix(relPath, prefix) {
+      return false
+    }
+  }
+  return path.Ext(relPath) == "" || strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+func (spa *SPAConfig) index() string {
+  if spa.Index == "" {
+    return "index.html"
+  }
+  return spa.Index
+}
+
+// Redirects and rewrites are matched against the request path with the route's URL prefix removed.
+// To can refer to groups in From with $1, ${name}, etc. and is relative to the prefix too, unless it's
+// a full URL (only useful for redirects).
+type RewriteRule struct {
+  From string `json:"from"`
+  To string `json:"to"`
+  // For redirects only. Defaults to 302.
+  Status int `json:"status"`
+
+  re *regexp.Regexp
+}
+
+func (rule *RewriteRule) compile() error {
+  var err error
+  rule.re, err = regexp.Compile(rule.From)
+  if err != nil {
+    return fmt.Errorf("bad rule %q: %w", rule.From, err)
+  }
+  if rule.Status == 0 {
+    rule.Status = http.StatusFound
+  }
+  return nil
+}
+
+// Returns the target for urlPath, if the rule matches, with the route's prefix added back on.
+func (rule *RewriteRule) apply(route *RouteConfig, urlPath string) (string, bool) {
+  relPath := route.relativePath(urlPath)
+  m := rule.re.FindStringSubmatchIndex(relPath)
+  if m == nil {
+    return "", false
+  }
+  target := string(rule.re.ExpandString(nil, rule.To, relPath, m))
+  if strings.Contains(target, "://") {
+    return target, true
+  }
+  return strings.TrimSuffix(route.URLPrefix, "/") + "/" + strings.TrimPrefix(target, "/"), true
+}
+
+// RuleHandler applies the redirect and rewrite rules of the route a request belongs to before passing
+// it on. The first matching redirect wins, then the first matching rewrite.
+type RuleHandler struct {
+  h http.Handler
+  conf *ConfigFile
+}
+
+func (rh *RuleHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  route := rh.conf.routeFor(r.URL.Path)
+  if route == nil {
+    rh.h.ServeHTTP(w, r)
+    return
+  }
+  for _, rule := range route.Redirects {
+    if target, ok := rule.apply(route, r.URL.Path); ok {
+      // Keep the query string unless the rule sets its own.
+      if r.URL.RawQuery != "" && !strings.Contains(target, "?") {
+        target += "?" + r.URL.RawQuery
+      }
+      http.Redirect(w, r, target, rule.Status)
+      return
+    }
+  }
+  for _, rule := range route.Rewrites {
+    if target, ok := rule.apply(route, r.URL.Path); ok {
+      // Copy the request so the access logs still show what the client asked for.
+      r2 := r.Clone(r.Context())
+      r2.URL.Path = target
+      r2.URL.RawPath = ""
+      if p, q, found := strings.Cut(target, "?"); found {
+        r2.URL.Path = p
+        r2.URL.RawQuery = q
+      }
+      setRoutePath(r2, r2.URL.Path)
+      rh.h.ServeHTTP(w, r2)
+      return
+    }
+  }
+  rh.h.ServeHTTP(w, r)
+}
+
+// Load error page templates from a status code -> filename map.
+func loadErrorPages(files map[string]string) (map[int]*template.Template, error) {
+  pages := make(map[int]*template.Template)
+  for code, filename := range files {
+    status, err := strconv.Atoi(code)
+    if err != nil {
+      return nil, fmt.Errorf("bad status code %q for error page", code)
+    }
+    tmpl, err := template.ParseFiles(filename)
+    if err != nil {
+      return nil, err
+    }
+    pages[status] = tmpl
+  }
+  return pages, nil
+}
+
+type errorPageData struct {
+  Status int
+  StatusText string
+  Path string
+}
+
+// Replaces the body of error responses with a custom page when there's one for the status code.
+type errorPageWriter struct {
+  http.ResponseWriter
+  r *http.Request
+  pages map[int]*template.Template
+  wroteHeader bool
+  // Set when the handler's own body is being thrown away.
+  replaced bool
+}
+
+func (ew *errorPageWriter) WriteHeader(status int) {
+  if ew.wroteHeader {
+    return
+  }
+  ew.wroteHeader = true
+
+  tmpl, ok := ew.pages[status]
+  if !ok {
+    ew.ResponseWriter.WriteHeader(status)
+    return
+  }
+
+  ew.replaced = true
+  h := ew.Header()
+  h.Del("Content-Length")
+  h.Del("Content-Encoding")
+  h.Del("ETag")
+  h.Set("Content-Type", "text/html; charset=utf-8")
+  ew.ResponseWriter.WriteHeader(status)
+  if ew.r.Method == http.MethodHead {
+    return
+  }
+  err := tmpl.Execute(ew.ResponseWriter, &errorPageData{
+    Status: status,
+    StatusText: http.StatusText(status),
+    Path: ew.r.URL.Path,
+  })
+  if err != nil {
+    log.Printf("error rendering error page for %d: %s", status, err.Error())
+  }
+}
+
+func (ew *errorPageWriter) Write(bs []byte) (int, error) {
+  if !ew.wroteHeader {
+    ew.WriteHeader(http.StatusOK)
+  }
+  if ew.replaced {
+    return len(bs), nil
+  }
+  return ew.ResponseWriter.Write(bs)
+}
+
+func withErrorPages(w http.ResponseWriter, r *http.Request, pages map[int]*template.Template) http.ResponseWriter {
+  if len(pages) == 0 {
+    return w
+  }
+  return &errorPageWriter{
+    ResponseWriter: w,
+    r: r,
+    pages: pages,
+  }
+}
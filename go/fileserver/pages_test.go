@@ -0,0 +1,148 @@
+package main
+
+import (
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func TestParseExampleConfig(t *testing.T) {
+  conf, err := parseConfigFile("config.example.json")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if route := conf.routeFor("/old-docs/x"); route == nil || route.URLPrefix != "/" || route.Redirects[0].re == nil {
+    t.Errorf("got route %+v", route)
+  }
+}
+
+// Serves a single page app from /app with route-relative rules, the way main puts things together.
+func newRulesServer(t *testing.T) http.Handler {
+  t.Helper()
+  dir := t.TempDir()
+  for name, content := range map[string]string{
+    "index.html": "app",
+    "v2/page.txt": "v2 page",
+    "api/real.json": "{}",
+  } {
+    p := filepath.Join(dir, filepath.FromSlash(name))
+    if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+      t.Fatal(err)
+    }
+    if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  confFile := filepath.Join(t.TempDir(), "config.json")
+  err := os.WriteFile(confFile, []byte(`{
+    "routes": [
+      {
+        "url_prefix": "/",
+        "redirects": [{ "from": "^/old/(.*)$", "to": "/app/$1", "status": 301 }]
+      },
+      {
+        "url_prefix": "/app",
+        "target": "`+filepath.ToSlash(dir)+`",
+        "spa": { "exclude": ["/api/"] },
+        "redirects": [
+          { "from": "^/login$", "to": "/#/login" },
+          { "from": "^/elsewhere$", "to": "https://example.com/" }
+        ],
+        "rewrites": [{ "from": "^/v1/(.*)$", "to": "/v2/$1" }]
+      }
+    ]
+  }`), 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  conf, err := parseConfigFile(confFile)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  sv := &MappedDirServer{
+    StaticServer: &FileListServer{Root: t.TempDir()},
+    ReverseProxies: make(map[string]http.Handler),
+  }
+  for _, route := range conf.Routes {
+    if route.Target == "" {
+      continue
+    }
+    if err := sv.AddMapping(route.URLPrefix, route.Target, route); err != nil {
+      t.Fatal(err)
+    }
+  }
+  return &RuleHandler{h: sv, conf: conf}
+}
+
+func TestRouteRules(t *testing.T) {
+  h := newRulesServer(t)
+  tests := []struct {
+    path string
+    wantStatus int
+    wantLocation string
+    wantBody string
+  }{
+    {"/old/x?a=1", 301, "/app/x?a=1", ""},
+    {"/app/login", 302, "/app/#/login", ""},
+    {"/app/elsewhere", 302, "https://example.com/", ""},
+    // Rules only see the path inside their route.
+    {"/login", 404, "", ""},
+    {"/app/old/x", 200, "", "app"},
+    {"/app/v1/page.txt", 200, "", "v2 page"},
+    {"/v1/page.txt", 404, "", ""},
+  }
+  for _, tt := range tests {
+    rec := httptest.NewRecorder()
+    req := httptest.NewRequest("GET", tt.path, nil)
+    req.Header.Set("Accept", "text/html")
+    h.ServeHTTP(rec, req)
+    if rec.Code != tt.wantStatus {
+      t.Errorf("%s: got status %d, want %d", tt.path, rec.Code, tt.wantStatus)
+    }
+    if got := rec.Header().Get("Location"); got != tt.wantLocation {
+      t.Errorf("%s: got location %q, want %q", tt.path, got, tt.wantLocation)
+    }
+    if body, _ := io.ReadAll(rec.Body); tt.wantBody != "" && string(body) != tt.wantBody {
+      t.Errorf("%s: got %q, want %q", tt.path, body, tt.wantBody)
+    }
+  }
+}
+
+func TestSPAFallback(t *testing.T) {
+  h := newRulesServer(t)
+  tests := []struct {
+    path string
+    accept string
+    wantStatus int
+  }{
+    {"/app/some/route", "", 200},
+    {"/app/missing.js", "", 404},
+    {"/app/missing.js", "text/html", 200},
+    // Exclusions are relative to the route, so this is /api/ inside the app.
+    {"/app/api/missing", "text/html", 404},
+    {"/app/api/real.json", "", 200},
+    {"/app/apis", "text/html", 200},
+  }
+  for _, tt := range tests {
+    rec := httptest.NewRecorder()
+    req := httptest.NewRequest("GET", tt.path, nil)
+    if tt.accept != "" {
+      req.Header.Set("Accept", tt.accept)
+    }
+    h.ServeHTTP(rec, req)
+    if rec.Code != tt.wantStatus {
+      t.Errorf("%s (%q): got status %d, want %d", tt.path, tt.accept, rec.Code, tt.wantStatus)
+    }
+  }
+
+  rec := httptest.NewRecorder()
+  h.ServeHTTP(rec, httptest.NewRequest("POST", "/app/some/route", nil))
+  if rec.Code == 200 {
+    t.Error("fell back to index.html for a POST")
+  }
+}
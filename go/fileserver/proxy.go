@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Per-proxy options, set through the "proxy" section of a route in the config file.
+type ProxyConfig struct {
+  // Remove the route's URL prefix before forwarding, so /api/foo -> http://dest/foo.
+  StripPrefix bool `json:"strip_prefix"`
+  // By default the Host header is rewritten to the destination's host. PreserveHost forwards the
+  // client's Host header instead, and HostHeader sends a fixed value.
+  PreserveHost bool `json:"preserve_host"`
+  HostHeader string `json:"host_header"`
+
+  // Headers to set on the forwarded request and on the response. An empty value removes the header.
+  RequestHeaders map[string]string `json:"request_headers"`
+  ResponseHeaders map[string]string `json:"response_headers"`
+
+  // How long to wait to connect to the destination, and then for it to start responding. These don't
+  // limit how long a response can stream for, so websockets and event streams keep working.
+  DialTimeout Duration `json:"dial_timeout"`
+  ResponseHeaderTimeout Duration `json:"response_header_timeout"`
+
+  // If set, CORS headers from the destination are replaced with these.
+  CORS *CORSConfig `json:"cors"`
+}
+
+type CORSConfig struct {
+  // Origins allowed to make requests. "*" allows any origin.
+  AllowOrigins []string `json:"allow_origins"`
+  AllowMethods []string `json:"allow_methods"`
+  AllowHeaders []string `json:"allow_headers"`
+  AllowCredentials bool `json:"allow_credentials"`
+  MaxAge Duration `json:"max_age"`
+}
+
+var defaultCORSMethods = []string{"GET", "HEAD", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+
+func setHeaders(h http.Header, values map[string]string) {
+  for k, v := range values {
+    if v == "" {
+      h.Del(k)
+    } else {
+      h.Set(k, v)
+    }
+  }
+}
+
+// Remove prefix from the start of u's path. The escaped path is trimmed when it can be, so escapes
+// in the rest of the path (like %2F) are forwarded as they came in.
+func stripPathPrefix(u *url.URL, prefix string) {
+  escapedPrefix := (&url.URL{Path: prefix}).EscapedPath()
+  if rest, ok := strings.CutPrefix(u.EscapedPath(), escapedPrefix); ok {
+    rawPath := "/" + strings.TrimPrefix(rest, "/")
+    if p, err := url.PathUnescape(rawPath); err == nil {
+      u.Path = p
+      u.RawPath = rawPath
+      return
+    }
+  }
+  // The prefix was escaped differently in the request, so fall back to the decoded path.
+  u.Path = "/" + strings.TrimPrefix(strings.TrimPrefix(u.Path, prefix), "/")
+  u.RawPath = ""
+}
+
+func (c *CORSConfig) allowedOrigin(origin string) (string, bool) {
+  for _, o := range c.AllowOrigins {
+    if o == "*" {
+      // Browsers won't accept a wildcard origin on credentialed requests.
+      if c.AllowCredentials {
+        return origin, true
+      }
+      return "*", true
+    }
+    if strings.EqualFold(o, origin) {
+      return origin, true
+    }
+  }
+  return "", false
+}
+
+// Replace whatever CORS headers are in h with the ones for this config.
+func (c *CORSConfig) apply(h http.Header, origin string) {
+  for k := range h {
+    if strings.HasPrefix(k, "Access-Control-") {
+      h.Del(k)
+    }
+  }
+  h.Add("Vary", "Origin")
+  if origin == "" {
+    return
+  }
+  allowed, ok := c.allowedOrigin(origin)
+  if !ok {
+    return
+  }
+  h.Set("Access-Control-Allow-Origin", allowed)
+  if c.AllowCredentials {
+    h.Set("Access-Control-Allow-Credentials", "true")
+  }
+}
+
+// Answer a preflight request without bothering the destination.
+func (c *CORSConfig) servePreflight(w http.ResponseWriter, r *http.Request) {
+  origin := r.Header.Get("Origin")
+  c.apply(w.Header(), origin)
+  if _, ok := c.allowedOrigin(origin); ok {
+    methods := c.AllowMethods
+    if len(methods) == 0 {
+      methods = defaultCORSMethods
+    }
+    w.Header().Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+    if len(c.AllowHeaders) > 0 {
+      w.Header().Set("Access-Control-Allow-Headers", strings.Join(c.AllowHeaders, ", "))
+    } else if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+      w.Header().Set("Access-Control-Allow-Headers", reqHeaders)
+    }
+    if c.MaxAge.Duration > 0 {
+      w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(c.MaxAge.Seconds())))
+    }
+  }
+  w.WriteHeader(http.StatusNoContent)
+}
+
+func isPreflight(r *http.Request) bool {
+  return r.Method == http.MethodOptions &&
+      r.Header.Get("Origin") != "" &&
+      r.Header.Get("Access-Control-Request-Method") != ""
+}
+
+type proxyHandler struct {
+  conf *ProxyConfig
+  rp *httputil.ReverseProxy
+}
+
+func (ph *proxyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  if ph.conf.CORS != nil && isPreflight(r) {
+    ph.conf.CORS.servePreflight(w, r)
+    return
+  }
+  ph.rp.ServeHTTP(w, r)
+}
+
+// NewProxy builds the handler for a proxy mapping. conf may be nil for the defaults.
+func NewProxy(urlPrefix string, dest *url.URL, conf *ProxyConfig) http.Handler {
+  if conf == nil {
+    conf = &ProxyConfig{}
+  }
+
+  transport := http.DefaultTransport.(*http.Transport).Clone()
+  if conf.DialTimeout.Duration > 0 {
+    dialer := &net.Dialer{
+      Timeout: conf.DialTimeout.Duration,
+      KeepAlive: 30 * time.Second,
+    }
+    transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+      return dialer.DialContext(ctx, network, addr)
+    }
+  }
+  transport.ResponseHeaderTimeout = conf.ResponseHeaderTimeout.Duration
+
+  rp := &httputil.ReverseProxy{
+    Rewrite: func(pr *httputil.ProxyRequest) {
+      if conf.StripPrefix {
+        stripPathPrefix(pr.Out.URL, urlPrefix)
+      }
+      // SetURL also points the Host header at the destination.
+      pr.SetURL(dest)
+      pr.SetXForwarded()
+      if conf.PreserveHost {
+        pr.Out.Host = pr.In.Host
+      }
+      if conf.HostHeader != "" {
+        pr.Out.Host = conf.HostHeader
+      }
+      setHeaders(pr.Out.Header, conf.RequestHeaders)
+    },
+    ModifyResponse: func(rsp *http.Response) error {
+      setHeaders(rsp.Header, conf.ResponseHeaders)
+      if conf.CORS != nil {
+        conf.CORS.apply(rsp.Header, rsp.Request.Header.Get("Origin"))
+      }
+      return nil
+    },
+    Transport: transport,
+    // Flush as soon as anything is written so event streams and hot-reload sockets from dev servers
+    // aren't held up in a buffer. Websocket upgrades are handled by ReverseProxy itself, as long as
+    // the ResponseWriter can be hijacked.
+    FlushInterval: -1,
+  }
+
+  return &proxyHandler{
+    conf: conf,
+    rp: rp,
+  }
+}
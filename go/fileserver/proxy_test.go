@@ -0,0 +1,181 @@
+package main
+
+import (
+  "bufio"
+  "fmt"
+  "io"
+  "net"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "strings"
+  "testing"
+  "time"
+)
+
+func TestStripPathPrefix(t *testing.T) {
+  tests := []struct {
+    in string
+    prefix string
+    wantPath string
+    wantEscaped string
+  }{
+    {"/api/foo", "/api", "/foo", "/foo"},
+    {"/api/foo", "/api/", "/foo", "/foo"},
+    {"/api", "/api", "/", "/"},
+    // Escaped slashes and spaces after the prefix are kept as they were.
+    {"/api/a%2Fb", "/api/", "/a/b", "/a%2Fb"},
+    {"/api/a%20b/c", "/api/", "/a b/c", "/a%20b/c"},
+    // Prefixes that need escaping themselves.
+    {"/my%20files/a%2Fb", "/my files/", "/a/b", "/a%2Fb"},
+    // An unnecessarily escaped prefix still gets stripped, though the rest has to be re-escaped.
+    {"/%61pi/x", "/api/", "/x", "/x"},
+  }
+  for _, tt := range tests {
+    u, err := url.Parse(tt.in)
+    if err != nil {
+      t.Fatal(err)
+    }
+    stripPathPrefix(u, tt.prefix)
+    if u.Path != tt.wantPath || u.EscapedPath() != tt.wantEscaped {
+      t.Errorf("%s - %s: got %q (%q), want %q (%q)", tt.in, tt.prefix, u.Path, u.EscapedPath(), tt.wantPath, tt.wantEscaped)
+    }
+  }
+}
+
+// Starts a proxy to backend wrapped in the logger, the same as main does.
+func startProxy(t *testing.T, prefix string, backend *httptest.Server, conf *ProxyConfig) *httptest.Server {
+  t.Helper()
+  dest, err := url.Parse(backend.URL)
+  if err != nil {
+    t.Fatal(err)
+  }
+  sv := httptest.NewServer(&HTTPLogger{h: NewProxy(prefix, dest, conf)})
+  t.Cleanup(sv.Close)
+  return sv
+}
+
+func TestProxyStripPrefix(t *testing.T) {
+  backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    fmt.Fprintf(w, "%s?%s", r.URL.EscapedPath(), r.URL.RawQuery)
+  }))
+  defer backend.Close()
+
+  for _, tt := range []struct {
+    conf *ProxyConfig
+    path string
+    want string
+  }{
+    {nil, "/api/a%2Fb?x=1", "/api/a%2Fb?x=1"},
+    {&ProxyConfig{StripPrefix: true}, "/api/a%2Fb?x=1", "/a%2Fb?x=1"},
+    {&ProxyConfig{StripPrefix: true}, "/api/", "/?"},
+  } {
+    sv := startProxy(t, "/api/", backend, tt.conf)
+    rsp, err := http.Get(sv.URL + tt.path)
+    if err != nil {
+      t.Fatal(err)
+    }
+    body, _ := io.ReadAll(rsp.Body)
+    rsp.Body.Close()
+    if string(body) != tt.want {
+      t.Errorf("%+v %s: backend got %q, want %q", tt.conf, tt.path, body, tt.want)
+    }
+  }
+}
+
+// Each chunk the backend writes should reach the client straight away rather than when the response
+// ends.
+func TestProxyStreaming(t *testing.T) {
+  next := make(chan struct{})
+  backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    w.Header().Set("Content-Type", "text/event-stream")
+    for i := 0; i < 3; i++ {
+      fmt.Fprintf(w, "data: %d\n\n", i)
+      w.(http.Flusher).Flush()
+      select {
+      case <-next:
+      case <-r.Context().Done():
+        return
+      }
+    }
+  }))
+  defer backend.Close()
+  sv := startProxy(t, "/", backend, nil)
+
+  rsp, err := http.Get(sv.URL + "/events")
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer rsp.Body.Close()
+  lines := make(chan string)
+  go func() {
+    scanner := bufio.NewScanner(rsp.Body)
+    for scanner.Scan() {
+      if scanner.Text() != "" {
+        lines <- scanner.Text()
+      }
+    }
+    close(lines)
+  }()
+  for i := 0; i < 3; i++ {
+    select {
+    case line := <-lines:
+      if want := fmt.Sprintf("data: %d", i); line != want {
+        t.Fatalf("got %q, want %q", line, want)
+      }
+    case <-time.After(5 * time.Second):
+      t.Fatalf("event %d wasn't flushed through the proxy", i)
+    }
+    next <- struct{}{}
+  }
+}
+
+// A websocket-style upgrade has to get through the logger and proxy with the connection intact.
+func TestProxyUpgrade(t *testing.T) {
+  backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    if r.Header.Get("Upgrade") != "echo" {
+      http.Error(w, "expected an upgrade", 400)
+      return
+    }
+    conn, rw, err := w.(http.Hijacker).Hijack()
+    if err != nil {
+      t.Error(err)
+      return
+    }
+    defer conn.Close()
+    rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+    rw.Flush()
+    line, err := rw.ReadString('\n')
+    if err != nil {
+      return
+    }
+    rw.WriteString("echo: " + line)
+    rw.Flush()
+  }))
+  defer backend.Close()
+  sv := startProxy(t, "/ws/", backend, &ProxyConfig{StripPrefix: true})
+
+  conn, err := net.Dial("tcp", strings.TrimPrefix(sv.URL, "http://"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer conn.Close()
+  conn.SetDeadline(time.Now().Add(5 * time.Second))
+  fmt.Fprintf(conn, "GET /ws/socket HTTP/1.1\r\nHost: test\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+  br := bufio.NewReader(conn)
+  rsp, err := http.ReadResponse(br, nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if rsp.StatusCode != http.StatusSwitchingProtocols {
+    t.Fatalf("got status %d", rsp.StatusCode)
+  }
+  fmt.Fprintf(conn, "hello\n")
+  line, err := br.ReadString('\n')
+  if err != nil {
+    t.Fatal(err)
+  }
+  if line != "echo: hello\n" {
+    t.Errorf("got %q", line)
+  }
+}
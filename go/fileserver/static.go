@@ -0,0 +1,311 @@
+package main
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"html/template"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Files smaller than this aren't worth compressing on the fly.
+const minCompressSize = 1024
+
+// How files are served, set per mapping.
+type FileOptions struct {
+  // Sent as the Cache-Control header if set.
+  CacheControl string
+  // Compress compressible files on the fly when there's no precompressed sibling.
+  Compress bool
+  // Optional fallback for missing paths in single page apps.
+  SPA *SPAConfig
+  // Custom pages for error responses, by status code.
+  ErrorPages map[int]*template.Template
+}
+
+type precompressedEncoding struct {
+  encoding string
+  ext string
+}
+
+// Precompressed siblings we look for, in order of preference.
+var precompressedEncodings = []precompressedEncoding{
+  {"br", ".br"},
+  {"zstd", ".zst"},
+  {"gzip", ".gz"},
+}
+
+type etagEntry struct {
+  size int64
+  modTime time.Time
+  etag string
+}
+
+// Bounds etagCache. Past it an arbitrary entry is dropped for each new one.
+const maxETagEntries = 10000
+
+// Content hashes are only recomputed when a file's size or mtime changes. Entries are keyed by path
+// and replaced when they go stale.
+var etagCache = struct {
+  sync.Mutex
+  entries map[string]etagEntry
+}{
+  entries: make(map[string]etagEntry),
+}
+
+func fileETag(filename string, f *os.File, info os.FileInfo) (string, error) {
+  etagCache.Lock()
+  entry, ok := etagCache.entries[filename]
+  etagCache.Unlock()
+  if ok && entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+    return entry.etag, nil
+  }
+
+  h := sha256.New()
+  if _, err := io.Copy(h, f); err != nil {
+    return "", err
+  }
+  if _, err := f.Seek(0, io.SeekStart); err != nil {
+    return "", err
+  }
+  etag := hex.EncodeToString(h.Sum(nil)[:16])
+
+  etagCache.Lock()
+  if _, exists := etagCache.entries[filename]; !exists && len(etagCache.entries) >= maxETagEntries {
+    for k := range etagCache.entries {
+      delete(etagCache.entries, k)
+      break
+    }
+  }
+  etagCache.entries[filename] = etagEntry{
+    size: info.Size(),
+    modTime: info.ModTime(),
+    etag: etag,
+  }
+  etagCache.Unlock()
+  return etag, nil
+}
+
+func forgetETag(filename string) {
+  etagCache.Lock()
+  delete(etagCache.entries, filename)
+  etagCache.Unlock()
+}
+
+// Encoding -> q-value, from an Accept-Encoding header.
+type acceptedEncodings map[string]float64
+
+// Parse an Accept-Encoding header. Encodings without a q-value (or with a bad one) get 1.
+func parseAcceptEncoding(header string) acceptedEncodings {
+  accepted := make(acceptedEncodings)
+  for _, part := range strings.Split(header, ",") {
+    fields := strings.Split(part, ";")
+    name := strings.ToLower(strings.TrimSpace(fields[0]))
+    if name == "" {
+      continue
+    }
+    q := 1.0
+    for _, param := range fields[1:] {
+      if s, found := strings.CutPrefix(strings.TrimSpace(param), "q="); found {
+        if v, err := strconv.ParseFloat(s, 64); err == nil && v >= 0 && v <= 1 {
+          q = v
+        }
+      }
+    }
+    accepted[name] = q
+  }
+  return accepted
+}
+
+// The q-value for an encoding: its own if it's listed, otherwise the one for "*". 0 means it's not
+// acceptable.
+func (a acceptedEncodings) q(encoding string) float64 {
+  if q, ok := a[encoding]; ok {
+    return q
+  }
+  return a["*"]
+}
+
+func isCompressible(contentType string) bool {
+  contentType, _, _ = strings.Cut(contentType, ";")
+  if strings.HasPrefix(contentType, "text/") {
+    return true
+  }
+  switch contentType {
+  case "application/javascript", "application/json", "application/xml", "application/wasm",
+      "application/manifest+json", "image/svg+xml":
+    return true
+  }
+  return false
+}
+
+func contentTypeFor(filename string, f *os.File) (string, error) {
+  if ctype := mime.TypeByExtension(filepath.Ext(filename)); ctype != "" {
+    return ctype, nil
+  }
+  var buf [512]byte
+  n, _ := io.ReadFull(f, buf[:])
+  if _, err := f.Seek(0, io.SeekStart); err != nil {
+    return "", err
+  }
+  return http.DetectContentType(buf[:n]), nil
+}
+
+func etagMatches(header string, etag string) bool {
+  for _, candidate := range strings.Split(header, ",") {
+    candidate = strings.TrimSpace(candidate)
+    if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+      return true
+    }
+  }
+  return false
+}
+
+func notModifiedSince(r *http.Request, modTime time.Time) bool {
+  if r.Method != http.MethodGet && r.Method != http.MethodHead {
+    return false
+  }
+  t, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+  if err != nil {
+    return false
+  }
+  // The header only has second precision.
+  return !modTime.Truncate(time.Second).After(t)
+}
+
+// serveFile serves a single file with a content-hash ETag. If the client accepts it, a
+// precompressed sibling (foo.js.br, foo.js.gz, ...) is sent in its place, or failing that the file is
+// compressed on the fly. Range and conditional requests are handled by http.ServeContent.
+func serveFile(w http.ResponseWriter, r *http.Request, filename string, opts *FileOptions) {
+  f, err := os.Open(filename)
+  if err != nil {
+    if os.IsNotExist(err) {
+      forgetETag(filename)
+      http.NotFound(w, r)
+    } else if os.IsPermission(err) {
+      http.Error(w, "403 Forbidden", http.StatusForbidden)
+    } else {
+      http.Error(w, err.Error(), 500)
+    }
+    return
+  }
+  defer f.Close()
+
+  info, err := f.Stat()
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  ctype, err := contentTypeFor(filename, f)
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  etag, err := fileETag(filename, f, info)
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  w.Header().Set("Content-Type", ctype)
+  w.Header().Add("Vary", "Accept-Encoding")
+  if opts != nil && opts.CacheControl != "" {
+    w.Header().Set("Cache-Control", opts.CacheControl)
+  }
+
+  accepted := parseAcceptEncoding(r.Header.Get("Accept-Encoding"))
+
+  // The client's preference wins, then ours.
+  var candidates []precompressedEncoding
+  for _, pc := range precompressedEncodings {
+    if accepted.q(pc.encoding) > 0 {
+      candidates = append(candidates, pc)
+    }
+  }
+  sort.SliceStable(candidates, func(i, j int) bool {
+    return accepted.q(candidates[i].encoding) > accepted.q(candidates[j].encoding)
+  })
+  for _, pc := range candidates {
+    cf, err := os.Open(filename + pc.ext)
+    if err != nil {
+      continue
+    }
+    defer cf.Close()
+    cinfo, err := cf.Stat()
+    if err != nil || cinfo.IsDir() {
+      continue
+    }
+    w.Header().Set("Content-Encoding", pc.encoding)
+    w.Header().Set("ETag", `"`+etag+"-"+pc.encoding+`"`)
+    http.ServeContent(w, r, filename, info.ModTime(), cf)
+    return
+  }
+
+  // Compressing on the fly means the length isn't known up front, so leave range requests (which
+  // are mostly for big binary downloads anyway) uncompressed.
+  encoding := ""
+  if opts != nil && opts.Compress && r.Header.Get("Range") == "" &&
+      info.Size() >= minCompressSize && isCompressible(ctype) {
+    if zq, gq := accepted.q("zstd"), accepted.q("gzip"); zq > 0 && zq >= gq {
+      encoding = "zstd"
+    } else if gq > 0 {
+      encoding = "gzip"
+    }
+  }
+
+  if encoding == "" {
+    w.Header().Set("ETag", `"`+etag+`"`)
+    http.ServeContent(w, r, filename, info.ModTime(), f)
+    return
+  }
+
+  etag = `"` + etag + "-" + encoding + `"`
+  w.Header().Set("ETag", etag)
+  // The same conditional checks http.ServeContent does; If-Modified-Since only counts without an
+  // If-None-Match.
+  if inm := r.Header.Get("If-None-Match"); inm != "" {
+    if etagMatches(inm, etag) {
+      w.WriteHeader(http.StatusNotModified)
+      return
+    }
+  } else if notModifiedSince(r, info.ModTime()) {
+    w.WriteHeader(http.StatusNotModified)
+    return
+  }
+  w.Header().Set("Content-Encoding", encoding)
+  w.Header().Set("Last-Modified", info.ModTime().UTC().Format(http.TimeFormat))
+  w.Header().Del("Content-Length")
+  if r.Method == http.MethodHead {
+    return
+  }
+
+  var enc io.WriteCloser
+  if encoding == "zstd" {
+    enc, err = zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+  } else {
+    enc, err = gzip.NewWriterLevel(w, gzip.DefaultCompression)
+  }
+  if err != nil {
+    log.Printf("error creating %s writer: %s", encoding, err.Error())
+    return
+  }
+  if _, err := io.Copy(enc, f); err != nil {
+    log.Printf("error compressing %s: %s", filename, err.Error())
+  }
+  if err := enc.Close(); err != nil {
+    log.Printf("error compressing %s: %s", filename, err.Error())
+  }
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+  "compress/gzip"
+  "crypto/sha256"
+  "encoding/hex"
+  "fmt"
+  "io"
+  "net/http"
+  "net/http/httptest"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/klauspost/compress/zstd"
+)
+
+var staticModTime = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func writeStaticFile(t *testing.T, dir, name, content string) string {
+  t.Helper()
+  p := filepath.Join(dir, name)
+  if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+    t.Fatal(err)
+  }
+  if err := os.Chtimes(p, staticModTime, staticModTime); err != nil {
+    t.Fatal(err)
+  }
+  return p
+}
+
+func serveStatic(filename string, opts *FileOptions, header http.Header) *httptest.ResponseRecorder {
+  req := httptest.NewRequest("GET", "/"+filepath.Base(filename), nil)
+  for k, v := range header {
+    req.Header[k] = v
+  }
+  rec := httptest.NewRecorder()
+  serveFile(rec, req, filename, opts)
+  return rec
+}
+
+func acceptEncoding(value string) http.Header {
+  return http.Header{"Accept-Encoding": {value}}
+}
+
+func decodeBody(t *testing.T, rec *httptest.ResponseRecorder) string {
+  t.Helper()
+  var r io.Reader = rec.Body
+  switch rec.Header().Get("Content-Encoding") {
+  case "gzip":
+    gr, err := gzip.NewReader(rec.Body)
+    if err != nil {
+      t.Fatal(err)
+    }
+    r = gr
+  case "zstd":
+    zr, err := zstd.NewReader(rec.Body)
+    if err != nil {
+      t.Fatal(err)
+    }
+    defer zr.Close()
+    r = zr
+  }
+  bs, err := io.ReadAll(r)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return string(bs)
+}
+
+func TestParseAcceptEncoding(t *testing.T) {
+  tests := []struct {
+    header string
+    want map[string]float64
+  }{
+    {"", map[string]float64{"gzip": 0, "br": 0}},
+    {"gzip, br", map[string]float64{"gzip": 1, "br": 1, "zstd": 0}},
+    {"GZIP;q=0.5 , br;q=0", map[string]float64{"gzip": 0.5, "br": 0}},
+    {"*", map[string]float64{"gzip": 1, "zstd": 1}},
+    {"*;q=0.2, gzip", map[string]float64{"gzip": 1, "zstd": 0.2}},
+    {"*, br;q=0", map[string]float64{"br": 0, "zstd": 1}},
+    {"gzip;q=2, br;q=x", map[string]float64{"gzip": 1, "br": 1}},
+  }
+  for _, tt := range tests {
+    accepted := parseAcceptEncoding(tt.header)
+    for encoding, want := range tt.want {
+      if got := accepted.q(encoding); got != want {
+        t.Errorf("%q: got q=%v for %s, want %v", tt.header, got, encoding, want)
+      }
+    }
+  }
+}
+
+func TestFileETag(t *testing.T) {
+  dir := t.TempDir()
+  filename := writeStaticFile(t, dir, "a.txt", "hello")
+  sum := sha256.Sum256([]byte("hello"))
+  want := `"` + hex.EncodeToString(sum[:16]) + `"`
+
+  rec := serveStatic(filename, nil, nil)
+  if rec.Code != 200 || rec.Header().Get("ETag") != want || rec.Body.String() != "hello" {
+    t.Fatalf("got %d, etag %q, body %q", rec.Code, rec.Header().Get("ETag"), rec.Body.String())
+  }
+  for _, inm := range []string{want, "W/" + want, `"other", ` + want, "*"} {
+    if rec := serveStatic(filename, nil, http.Header{"If-None-Match": {inm}}); rec.Code != http.StatusNotModified {
+      t.Errorf("If-None-Match %s: got %d", inm, rec.Code)
+    }
+  }
+  if rec := serveStatic(filename, nil, http.Header{"If-None-Match": {`"other"`}}); rec.Code != 200 {
+    t.Errorf("stale If-None-Match: got %d", rec.Code)
+  }
+
+  // The cached hash is replaced when the file changes.
+  writeStaticFile(t, dir, "a.txt", "hello, world")
+  rec = serveStatic(filename, nil, http.Header{"If-None-Match": {want}})
+  if rec.Code != 200 || rec.Header().Get("ETag") == want {
+    t.Errorf("after a change: got %d, etag %q", rec.Code, rec.Header().Get("ETag"))
+  }
+}
+
+func TestETagCacheBounded(t *testing.T) {
+  etagCache.Lock()
+  saved := etagCache.entries
+  etagCache.entries = make(map[string]etagEntry)
+  for i := 0; i < maxETagEntries; i++ {
+    etagCache.entries[fmt.Sprintf("/nowhere/%d", i)] = etagEntry{}
+  }
+  etagCache.Unlock()
+  t.Cleanup(func() {
+    etagCache.Lock()
+    etagCache.entries = saved
+    etagCache.Unlock()
+  })
+
+  dir := t.TempDir()
+  filename := writeStaticFile(t, dir, "a.txt", "hello")
+  serveStatic(filename, nil, nil)
+  writeStaticFile(t, dir, "a.txt", "hello again")
+  serveStatic(filename, nil, nil)
+  etagCache.Lock()
+  n := len(etagCache.entries)
+  _, ok := etagCache.entries[filename]
+  etagCache.Unlock()
+  if n != maxETagEntries || !ok {
+    t.Errorf("got %d entries (cached: %v), want %d", n, ok, maxETagEntries)
+  }
+
+  // Deleted files are forgotten.
+  if err := os.Remove(filename); err != nil {
+    t.Fatal(err)
+  }
+  if rec := serveStatic(filename, nil, nil); rec.Code != http.StatusNotFound {
+    t.Errorf("got %d for a deleted file", rec.Code)
+  }
+  etagCache.Lock()
+  _, ok = etagCache.entries[filename]
+  etagCache.Unlock()
+  if ok {
+    t.Error("deleted file still cached")
+  }
+}
+
+func TestPrecompressedSiblings(t *testing.T) {
+  dir := t.TempDir()
+  filename := writeStaticFile(t, dir, "app.js", "plain")
+  writeStaticFile(t, dir, "app.js.br", "brotli")
+  writeStaticFile(t, dir, "app.js.zst", "zstd")
+  writeStaticFile(t, dir, "app.js.gz", "gzip")
+  opts := &FileOptions{Compress: true}
+  tests := []struct {
+    accept string
+    want string
+  }{
+    {"", "plain"},
+    {"identity", "plain"},
+    {"gzip", "gzip"},
+    {"gzip, br", "brotli"},
+    {"gzip, zstd", "zstd"},
+    {"br;q=0, gzip", "gzip"},
+    {"gzip;q=0.5, zstd", "zstd"},
+    {"br;q=0.1, gzip;q=0.9", "gzip"},
+    {"*", "brotli"},
+    {"*, br;q=0", "zstd"},
+    {"*;q=0", "plain"},
+    {"gzip;q=0", "plain"},
+  }
+  for _, tt := range tests {
+    rec := serveStatic(filename, opts, acceptEncoding(tt.accept))
+    if rec.Code != 200 || rec.Body.String() != tt.want {
+      t.Errorf("%q: got %d %q, want %q", tt.accept, rec.Code, rec.Body.String(), tt.want)
+      continue
+    }
+    encoding := rec.Header().Get("Content-Encoding")
+    etag := rec.Header().Get("ETag")
+    if tt.want == "plain" {
+      if encoding != "" || strings.Contains(etag, "-") {
+        t.Errorf("%q: got encoding %q, etag %q", tt.accept, encoding, etag)
+      }
+      continue
+    }
+    if !strings.HasSuffix(etag, "-"+encoding+`"`) {
+      t.Errorf("%q: got encoding %q, etag %q", tt.accept, encoding, etag)
+    }
+    // The type is the original file's, not the sibling's.
+    if ct := rec.Header().Get("Content-Type"); !strings.Contains(ct, "javascript") {
+      t.Errorf("%q: got content type %q", tt.accept, ct)
+    }
+  }
+
+  // Conditional requests go through http.ServeContent.
+  etag := serveStatic(filename, opts, acceptEncoding("gzip")).Header().Get("ETag")
+  if rec := serveStatic(filename, opts, http.Header{"Accept-Encoding": {"gzip"}, "If-None-Match": {etag}}); rec.Code != http.StatusNotModified {
+    t.Errorf("If-None-Match: got %d", rec.Code)
+  }
+  ims := staticModTime.Format(http.TimeFormat)
+  if rec := serveStatic(filename, opts, http.Header{"Accept-Encoding": {"gzip"}, "If-Modified-Since": {ims}}); rec.Code != http.StatusNotModified {
+    t.Errorf("If-Modified-Since: got %d", rec.Code)
+  }
+}
+
+func TestCompressOnTheFly(t *testing.T) {
+  dir := t.TempDir()
+  content := strings.Repeat("some compressible text\n", 100)
+  filename := writeStaticFile(t, dir, "big.txt", content)
+  small := writeStaticFile(t, dir, "small.txt", "tiny")
+  binary := writeStaticFile(t, dir, "big.png", content)
+  opts := &FileOptions{Compress: true}
+  tests := []struct {
+    name string
+    filename string
+    opts *FileOptions
+    header http.Header
+    wantEncoding string
+  }{
+    {"gzip", filename, opts, acceptEncoding("gzip"), "gzip"},
+    {"zstd preferred", filename, opts, acceptEncoding("gzip, zstd"), "zstd"},
+    {"by q-value", filename, opts, acceptEncoding("gzip, zstd;q=0.5"), "gzip"},
+    {"star", filename, opts, acceptEncoding("*"), "zstd"},
+    {"star without zstd", filename, opts, acceptEncoding("*, zstd;q=0"), "gzip"},
+    {"nothing acceptable", filename, opts, acceptEncoding("*;q=0"), ""},
+    {"no header", filename, opts, nil, ""},
+    {"off", filename, &FileOptions{}, acceptEncoding("gzip"), ""},
+    {"no options", filename, nil, acceptEncoding("gzip"), ""},
+    {"too small", small, opts, acceptEncoding("gzip"), ""},
+    {"not compressible", binary, opts, acceptEncoding("gzip"), ""},
+  }
+  for _, tt := range tests {
+    rec := serveStatic(tt.filename, tt.opts, tt.header)
+    if rec.Code != 200 {
+      t.Errorf("%s: got %d", tt.name, rec.Code)
+      continue
+    }
+    if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+      t.Errorf("%s: got encoding %q, want %q", tt.name, got, tt.wantEncoding)
+    }
+    want, err := os.ReadFile(tt.filename)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if got := decodeBody(t, rec); got != string(want) {
+      t.Errorf("%s: body doesn't match the file", tt.name)
+    }
+    if tt.wantEncoding != "" && (rec.Header().Get("Content-Length") != "" || rec.Header().Get("Last-Modified") == "") {
+      t.Errorf("%s: got headers %v", tt.name, rec.Header())
+    }
+  }
+
+  // Ranges are served from the file as is.
+  rec := serveStatic(filename, opts, http.Header{"Accept-Encoding": {"gzip"}, "Range": {"bytes=0-9"}})
+  if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != content[:10] {
+    t.Errorf("range: got %d, encoding %q, body %q", rec.Code, rec.Header().Get("Content-Encoding"), rec.Body.String())
+  }
+}
+
+func TestCompressedConditional(t *testing.T) {
+  dir := t.TempDir()
+  filename := writeStaticFile(t, dir, "big.txt", strings.Repeat("x", 2*minCompressSize))
+  opts := &FileOptions{Compress: true}
+  etag := serveStatic(filename, opts, acceptEncoding("gzip")).Header().Get("ETag")
+  if !strings.HasSuffix(etag, `-gzip"`) {
+    t.Fatalf("got etag %q", etag)
+  }
+  tests := []struct {
+    name string
+    header http.Header
+    want int
+  }{
+    {"etag", http.Header{"If-None-Match": {etag}}, http.StatusNotModified},
+    {"other etag", http.Header{"If-None-Match": {`"other"`}}, 200},
+    {"not modified", http.Header{"If-Modified-Since": {staticModTime.Format(http.TimeFormat)}}, http.StatusNotModified},
+    {"modified", http.Header{"If-Modified-Since": {staticModTime.Add(-time.Hour).Format(http.TimeFormat)}}, 200},
+    {"bad date", http.Header{"If-Modified-Since": {"yesterday"}}, 200},
+    // If-None-Match wins over If-Modified-Since.
+    {"both", http.Header{"If-None-Match": {`"other"`}, "If-Modified-Since": {staticModTime.Format(http.TimeFormat)}}, 200},
+  }
+  for _, tt := range tests {
+    tt.header.Set("Accept-Encoding", "gzip")
+    rec := serveStatic(filename, opts, tt.header)
+    if rec.Code != tt.want {
+      t.Errorf("%s: got %d, want %d", tt.name, rec.Code, tt.want)
+    }
+    if tt.want == http.StatusNotModified && rec.Body.Len() != 0 {
+      t.Errorf("%s: got a body with a 304", tt.name)
+    }
+  }
+}
+
+func TestCacheControlPerRoute(t *testing.T) {
+  dir := t.TempDir()
+  writeStaticFile(t, dir, "a.txt", "hello")
+  sv := &MappedDirServer{
+    ReverseProxies: make(map[string]http.Handler),
+    DefaultFileOptions: FileOptions{CacheControl: "no-cache"},
+  }
+  if err := sv.AddMapping("/assets/", dir, &RouteConfig{CacheControl: "max-age=3600"}); err != nil {
+    t.Fatal(err)
+  }
+  if err := sv.AddMapping("/plain/", dir, nil); err != nil {
+    t.Fatal(err)
+  }
+  if err := sv.AddMapping("/file", filepath.Join(dir, "a.txt"), &RouteConfig{CacheControl: "immutable"}); err != nil {
+    t.Fatal(err)
+  }
+  for target, want := range map[string]string{
+    "/assets/a.txt": "max-age=3600",
+    "/plain/a.txt": "no-cache",
+    "/file": "immutable",
+  } {
+    rec := httptest.NewRecorder()
+    sv.ServeHTTP(rec, httptest.NewRequest("GET", target, nil))
+    if rec.Code != 200 || rec.Body.String() != "hello" {
+      t.Errorf("%s: got %d %q", target, rec.Code, rec.Body.String())
+    }
+    if got := rec.Header().Get("Cache-Control"); got != want {
+      t.Errorf("%s: got Cache-Control %q, want %q", target, got, want)
+    }
+  }
+}
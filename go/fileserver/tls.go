@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"time"
+)
+
+type TLSOptions struct {
+  CertFile string
+  KeyFile string
+  // Generate a throwaway self-signed certificate instead of loading one.
+  SelfSigned bool
+  // Extra names to put in the self-signed certificate besides localhost.
+  Hosts []string
+
+  // PEM file with the CA that client certificates must be signed by, e.g. the ca_root.pem from
+  // ssl/create-ca.sh.
+  ClientCAFile string
+  // Reject connections without a valid client certificate. Otherwise certificates are only
+  // verified if the client sends one, and routes can require them through their auth config.
+  RequireClientCert bool
+}
+
+func (opts *TLSOptions) Enabled() bool {
+  return opts.SelfSigned || opts.CertFile != "" || opts.KeyFile != ""
+}
+
+func (opts *TLSOptions) Config() (*tls.Config, error) {
+  var cert tls.Certificate
+  var err error
+  if opts.SelfSigned {
+    cert, err = generateSelfSignedCert(opts.Hosts)
+  } else {
+    if opts.CertFile == "" || opts.KeyFile == "" {
+      return nil, errors.New("both a certificate and a key are required")
+    }
+    cert, err = tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+  }
+  if err != nil {
+    return nil, err
+  }
+
+  conf := &tls.Config{
+    Certificates: []tls.Certificate{cert},
+    MinVersion: tls.VersionTLS12,
+  }
+
+  if opts.ClientCAFile != "" {
+    caCert, err := os.ReadFile(opts.ClientCAFile)
+    if err != nil {
+      return nil, err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(caCert) {
+      return nil, fmt.Errorf("no certificates found in %s", opts.ClientCAFile)
+    }
+    conf.ClientCAs = pool
+    conf.ClientAuth = tls.VerifyClientCertIfGiven
+    if opts.RequireClientCert {
+      conf.ClientAuth = tls.RequireAndVerifyClientCert
+    }
+  } else if opts.RequireClientCert {
+    return nil, errors.New("requiring client certificates needs a client CA")
+  }
+
+  return conf, nil
+}
+
+func generateSelfSignedCert(hosts []string) (tls.Certificate, error) {
+  key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+
+  serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+
+  template := &x509.Certificate{
+    SerialNumber: serial,
+    Subject: pkix.Name{CommonName: "fileserver"},
+    NotBefore: time.Now().Add(-time.Hour),
+    NotAfter: time.Now().AddDate(1, 0, 0),
+    KeyUsage: x509.KeyUsageDigitalSignature,
+    ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    BasicConstraintsValid: true,
+    DNSNames: []string{"localhost"},
+    IPAddresses: []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+  }
+  for _, h := range hosts {
+    if h == "" {
+      continue
+    }
+    if ip := net.ParseIP(h); ip != nil {
+      template.IPAddresses = append(template.IPAddresses, ip)
+    } else {
+      template.DNSNames = append(template.DNSNames, h)
+    }
+  }
+
+  der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+  if err != nil {
+    return tls.Certificate{}, err
+  }
+
+  // Log the fingerprint so it can be checked against what the browser shows.
+  log.Printf("generated self-signed certificate, sha256 fingerprint %X", sha256.Sum256(der))
+
+  return tls.Certificate{
+    Certificate: [][]byte{der},
+    PrivateKey: key,
+  }, nil
+}
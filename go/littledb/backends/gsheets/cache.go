@@ -0,0 +1,291 @@
+package gsheets
+
+import (
+  "reflect"
+  "strconv"
+  "sync"
+  "time"
+
+  "google.golang.org/api/drive/v3"
+)
+
+// Queries read through a cache of range values. Entries are fresh for the range's TTL. With revision
+// checks turned on, a stale entry costs one cheap Drive call instead of a full fetch if the file
+// hasn't changed. Writes through the DB drop the entries they touch, and don't fill the cache
+// themselves.
+type rangeCache struct {
+  mu sync.Mutex
+  entries map[string]*cacheEntry
+  stats map[string]*RangeStats
+  revisionChecks int64
+}
+
+type cacheEntry struct {
+  values [][]interface{}
+  fetched time.Time
+  revision string
+}
+
+// RangeStats counts how queries for one range were answered.
+type RangeStats struct {
+  // Answered from the cache within the TTL.
+  Hits int64
+  // Answered from the cache after the Drive revision showed the file hadn't changed.
+  Revalidated int64
+  // Fetched from the Sheets API.
+  Misses int64
+}
+
+type CacheStats struct {
+  RangeStats
+  // Drive calls made to check the file revision.
+  RevisionChecks int64
+  Ranges map[string]RangeStats
+}
+
+func (c *rangeCache) statsFor(dataRange string) *RangeStats {
+  s, ok := c.stats[dataRange]
+  if !ok {
+    s = &RangeStats{}
+    c.stats[dataRange] = s
+  }
+  return s
+}
+
+// WithCacheTTL sets how long fetched values are reused for every range that doesn't have its own TTL.
+// Zero, the default, turns caching off.
+func (db *SheetsDB) WithCacheTTL(ttl time.Duration) *SheetsDB {
+  db.defaultTTL = ttl
+  return db
+}
+
+// SetCacheTTL sets the cache TTL for a range. Types registered to the same range share its entry, and
+// its TTL.
+func (db *SheetsDB) SetCacheTTL(dataRange string, ttl time.Duration) *SheetsDB {
+  db.ttls[dataRange] = ttl
+  return db
+}
+
+// WithRevisionCheck turns on revalidating stale cache entries against the spreadsheet's Drive
+// revision. The Drive service needs the drive.metadata.readonly scope or broader.
+func (db *SheetsDB) WithRevisionCheck(srv *drive.Service) *SheetsDB {
+  db.driveSrv = srv
+  return db
+}
+
+func (db *SheetsDB) cacheEnabled(dataRange string) bool {
+  return db.ttlFor(dataRange) > 0 || db.driveSrv != nil
+}
+
+func (db *SheetsDB) ttlFor(dataRange string) time.Duration {
+  if ttl, ok := db.ttls[dataRange]; ok {
+    return ttl
+  }
+  return db.defaultTTL
+}
+
+// The file's current revision. Google Sheets files don't have a head revision ID, so fall back to the
+// file version, which goes up on every change.
+func (db *SheetsDB) revision() (string, error) {
+  db.cache.mu.Lock()
+  db.cache.revisionChecks++
+  db.cache.mu.Unlock()
+
+  f, err := db.driveSrv.Files.Get(db.sheetId).Fields("headRevisionId", "version").Do()
+  if err != nil {
+    return "", err
+  }
+  if f.HeadRevisionId != "" {
+    return f.HeadRevisionId, nil
+  }
+  return strconv.FormatInt(f.Version, 10), nil
+}
+
+// Look up a range in the cache, revalidating it if it's stale and revision checks are on. The
+// revision is only fetched once per call, and returned so a following fetch can store it. Ranges that
+// aren't cached at all don't get a revision check; they're stored without one, and the first
+// revalidation refetches them.
+func (db *SheetsDB) cached(dataRange string, rev *string) ([][]interface{}, bool, error) {
+  if !db.cacheEnabled(dataRange) {
+    return nil, false, nil
+  }
+  ttl := db.ttlFor(dataRange)
+
+  db.cache.mu.Lock()
+  entry, ok := db.cache.entries[dataRange]
+  if ok && time.Since(entry.fetched) < ttl {
+    db.cache.statsFor(dataRange).Hits++
+    db.cache.mu.Unlock()
+    return entry.values, true, nil
+  }
+  db.cache.mu.Unlock()
+
+  if db.driveSrv == nil || !ok {
+    return nil, false, nil
+  }
+  if *rev == "" {
+    var err error
+    if *rev, err = db.revision(); err != nil {
+      return nil, false, err
+    }
+  }
+  if entry.revision != *rev {
+    return nil, false, nil
+  }
+
+  db.cache.mu.Lock()
+  defer db.cache.mu.Unlock()
+  entry.fetched = time.Now()
+  db.cache.statsFor(dataRange).Revalidated++
+  return entry.values, true, nil
+}
+
+func (db *SheetsDB) countMiss(dataRange string) {
+  db.cache.mu.Lock()
+  defer db.cache.mu.Unlock()
+  db.cache.statsFor(dataRange).Misses++
+}
+
+func (db *SheetsDB) store(dataRange string, values [][]interface{}, rev string) {
+  db.cache.mu.Lock()
+  defer db.cache.mu.Unlock()
+  if !db.cacheEnabled(dataRange) {
+    return
+  }
+  db.cache.entries[dataRange] = &cacheEntry{
+    values: values,
+    fetched: time.Now(),
+    revision: rev,
+  }
+}
+
+func (db *SheetsDB) invalidate(dataRange string) {
+  db.cache.mu.Lock()
+  defer db.cache.mu.Unlock()
+  delete(db.cache.entries, dataRange)
+}
+
+// Invalidate drops every cached range, e.g. after editing the sheet some other way.
+func (db *SheetsDB) Invalidate() {
+  db.cache.mu.Lock()
+  defer db.cache.mu.Unlock()
+  db.cache.entries = make(map[string]*cacheEntry)
+}
+
+func (db *SheetsDB) CacheStats() CacheStats {
+  db.cache.mu.Lock()
+  defer db.cache.mu.Unlock()
+  out := CacheStats{
+    RevisionChecks: db.cache.revisionChecks,
+    Ranges: make(map[string]RangeStats),
+  }
+  for r, s := range db.cache.stats {
+    out.Ranges[r] = *s
+    out.Hits += s.Hits
+    out.Revalidated += s.Revalidated
+    out.Misses += s.Misses
+  }
+  return out
+}
+
+// Get the values in a range, from the cache if possible. Writes skip the cache since they need the
+// current rows to find keys.
+func (db *SheetsDB) fetch(dataRange string, useCache bool) ([][]interface{}, error) {
+  rev := ""
+  if useCache {
+    values, ok, err := db.cached(dataRange, &rev)
+    if err != nil || ok {
+      return values, err
+    }
+    db.countMiss(dataRange)
+  }
+  call := db.srv.Spreadsheets.Values.Get(db.sheetId, dataRange)
+  if db.valueRenderOption != "" {
+    call = call.ValueRenderOption(db.valueRenderOption).DateTimeRenderOption("SERIAL_NUMBER")
+  }
+  rsp, err := call.Do()
+  if err != nil {
+    return nil, err
+  }
+  if useCache {
+    db.store(dataRange, rsp.Values, rev)
+  }
+  return rsp.Values, nil
+}
+
+// Prefetch loads the ranges for the given types (or every registered type if there are none) into
+// the cache with a single batchGet call, skipping ranges that are already cached. It's a no-op when
+// caching is off.
+func (db *SheetsDB) Prefetch(types ...interface{}) error {
+  dataRanges := []string{}
+  if len(types) == 0 {
+    for _, r := range db.ranges {
+      dataRanges = append(dataRanges, r)
+    }
+  }
+  for _, t := range types {
+    r, err := db.rangeFor(reflect.TypeOf(t))
+    if err != nil {
+      return err
+    }
+    dataRanges = append(dataRanges, r)
+  }
+
+  rev := ""
+  missing := []string{}
+  seen := make(map[string]bool)
+  for _, r := range dataRanges {
+    if seen[r] || !db.cacheEnabled(r) {
+      continue
+    }
+    seen[r] = true
+    _, ok, err := db.cached(r, &rev)
+    if err != nil {
+      return err
+    }
+    if !ok {
+      db.countMiss(r)
+      missing = append(missing, r)
+    }
+  }
+  if len(missing) == 0 {
+    return nil
+  }
+
+  call := db.srv.Spreadsheets.Values.BatchGet(db.sheetId).Ranges(missing...)
+  if db.valueRenderOption != "" {
+    call = call.ValueRenderOption(db.valueRenderOption).DateTimeRenderOption("SERIAL_NUMBER")
+  }
+  rsp, err := call.Do()
+  if err != nil {
+    return err
+  }
+  // Ranges come back in request order, but normalized (e.g. with quotes added), so match by index.
+  for i, vr := range rsp.ValueRanges {
+    if i < len(missing) {
+      db.store(missing[i], vr.Values, rev)
+    }
+  }
+  return nil
+}
+
+// QueryAll fills several slices, each a pointer to a slice of a registered type, fetching all of their
+// ranges in one batchGet call when caching is on.
+func (db *SheetsDB) QueryAll(data ...interface{}) error {
+  types := []interface{}{}
+  for _, d := range data {
+    v := reflect.ValueOf(d)
+    if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+      types = append(types, reflect.Zero(v.Elem().Type().Elem()).Interface())
+    }
+  }
+  if err := db.Prefetch(types...); err != nil {
+    return err
+  }
+  for _, d := range data {
+    if err := db.Query(d).Do(); err != nil {
+      return err
+    }
+  }
+  return nil
+}
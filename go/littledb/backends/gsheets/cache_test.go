@@ -0,0 +1,171 @@
+package gsheets
+
+import (
+  "testing"
+  "time"
+)
+
+// Makes every cached entry look older than its TTL.
+func expire(db *SheetsDB) {
+  db.cache.mu.Lock()
+  defer db.cache.mu.Unlock()
+  for _, e := range db.cache.entries {
+    e.fetched = time.Now().Add(-24 * time.Hour)
+  }
+}
+
+func TestCacheTTL(t *testing.T) {
+  fs := newFakeSheets(t)
+  db := newItemsDB(t, fs, false).WithCacheTTL(time.Hour)
+
+  if items := queryItems(t, db); len(items) != 2 {
+    t.Fatalf("got %+v", items)
+  }
+  queryItems(t, db)
+  if n := fs.count("get"); n != 1 {
+    t.Errorf("got %d fetches, want 1", n)
+  }
+
+  fs.edit("Items", [][]interface{}{{"ID", "Name"}, {1.0, "one"}})
+  if items := queryItems(t, db); len(items) != 2 {
+    t.Errorf("got %+v from the cache", items)
+  }
+  expire(db)
+  if items := queryItems(t, db); len(items) != 1 {
+    t.Errorf("got %+v after expiry", items)
+  }
+
+  stats := db.CacheStats()
+  if stats.Hits != 2 || stats.Misses != 2 || stats.Revalidated != 0 || stats.RevisionChecks != 0 {
+    t.Errorf("got stats %+v", stats)
+  }
+}
+
+func TestCacheOff(t *testing.T) {
+  fs := newFakeSheets(t)
+  db := newItemsDB(t, fs, false)
+  queryItems(t, db)
+  queryItems(t, db)
+  if n := fs.count("get"); n != 2 {
+    t.Errorf("got %d fetches, want 2", n)
+  }
+  if len(db.cache.entries) != 0 {
+    t.Errorf("cached %v with caching off", db.cache.entries)
+  }
+}
+
+// Writes read the current rows without touching the cache, and drop the cached range afterwards.
+func TestCacheInvalidateOnWrite(t *testing.T) {
+  fs := newFakeSheets(t)
+  db := newItemsDB(t, fs, false).WithCacheTTL(time.Hour)
+  queryItems(t, db)
+
+  if err := db.Insert(&item{ID: 3, Name: "three"}); err != nil {
+    t.Fatal(err)
+  }
+  if n := fs.count("get"); n != 2 {
+    t.Errorf("got %d fetches, want 2", n)
+  }
+  if _, ok := db.cache.entries["Items"]; ok {
+    t.Error("the write left the range cached")
+  }
+  if items := queryItems(t, db); len(items) != 3 {
+    t.Errorf("got %+v after insert", items)
+  }
+  if stats := db.CacheStats(); stats.Hits != 0 || stats.Misses != 2 {
+    t.Errorf("got stats %+v", stats)
+  }
+
+  if err := db.Insert(&item{ID: 3, Name: "again"}); err == nil {
+    t.Error("inserted a duplicate key")
+  }
+}
+
+func TestCacheRevisionCheck(t *testing.T) {
+  fs := newFakeSheets(t)
+  db := newItemsDB(t, fs, true).WithCacheTTL(time.Hour)
+
+  // Cold misses don't bother checking the revision.
+  queryItems(t, db)
+  if n := fs.count("revision"); n != 0 {
+    t.Errorf("got %d revision checks for a cold miss", n)
+  }
+
+  // The first revalidation has nothing to compare with, so it refetches and remembers the revision.
+  expire(db)
+  queryItems(t, db)
+  expire(db)
+  queryItems(t, db)
+  if fs.count("get") != 2 || fs.count("revision") != 2 {
+    t.Errorf("got %d fetches and %d revision checks, want 2 and 2", fs.count("get"), fs.count("revision"))
+  }
+
+  fs.edit("Items", [][]interface{}{{"ID", "Name"}, {1.0, "one"}})
+  expire(db)
+  if items := queryItems(t, db); len(items) != 1 {
+    t.Errorf("got %+v after an edit", items)
+  }
+  stats := db.CacheStats()
+  if stats.Misses != 3 || stats.Revalidated != 1 || stats.RevisionChecks != 3 {
+    t.Errorf("got stats %+v", stats)
+  }
+}
+
+func TestCacheTTLByRange(t *testing.T) {
+  fs := newFakeSheets(t)
+  db := newItemsDB(t, fs, false).SetCacheTTL("Items", time.Hour)
+  // A second type on the same range shares its TTL, whatever order the types were registered in.
+  db.Register(struct {
+    Name string `littledb:"Name"`
+  }{}, "Items")
+  for i := 0; i < 10; i++ {
+    if ttl := db.ttlFor("Items"); ttl != time.Hour {
+      t.Fatalf("got TTL %v", ttl)
+    }
+  }
+  if ttl := db.ttlFor("Others"); ttl != 0 {
+    t.Errorf("got TTL %v for a range without one", ttl)
+  }
+}
+
+func TestPrefetch(t *testing.T) {
+  fs := newFakeSheets(t)
+  db := newItemsDB(t, fs, false).WithCacheTTL(time.Hour)
+
+  items := []item{}
+  others := []otherItem{}
+  if err := db.QueryAll(&items, &others); err != nil {
+    t.Fatal(err)
+  }
+  if len(items) != 2 || len(others) != 1 {
+    t.Errorf("got %+v and %+v", items, others)
+  }
+  if fs.count("batchGet") != 1 || fs.count("get") != 0 {
+    t.Errorf("got %d batchGets and %d gets", fs.count("batchGet"), fs.count("get"))
+  }
+
+  // Everything's cached, so there's nothing left to fetch.
+  if err := db.Prefetch(); err != nil {
+    t.Fatal(err)
+  }
+  expire(db)
+  queryItems(t, db)
+  if err := db.Prefetch(item{}, otherItem{}); err != nil {
+    t.Fatal(err)
+  }
+  if fs.count("batchGet") != 2 || fs.count("get") != 1 {
+    t.Errorf("got %d batchGets and %d gets", fs.count("batchGet"), fs.count("get"))
+  }
+}
+
+// Prefetch is a no-op without a cache.
+func TestPrefetchCacheOff(t *testing.T) {
+  fs := newFakeSheets(t)
+  db := newItemsDB(t, fs, false)
+  if err := db.Prefetch(); err != nil {
+    t.Fatal(err)
+  }
+  if n := fs.count("batchGet"); n != 0 {
+    t.Errorf("got %d batchGets", n)
+  }
+}
@@ -0,0 +1,264 @@
+package gsheets
+
+import (
+  "encoding"
+  "fmt"
+  "math"
+  "reflect"
+  "strconv"
+  "strings"
+  "time"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// Unmarshaler is implemented by field types that parse their own cells. The cell is a string, or a
+// float64 or bool when values are read unformatted.
+type Unmarshaler interface {
+  UnmarshalCell(cell interface{}) error
+}
+
+var (
+  timeType = reflect.TypeOf(time.Time{})
+  durationType = reflect.TypeOf(time.Duration(0))
+  unmarshalerType = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+  textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// CellError says which cell couldn't be converted.
+type CellError struct {
+  // Row number in the sheet, as shown in the UI.
+  Row int
+  Column string
+  Value interface{}
+  Err error
+}
+
+func (e *CellError) Error() string {
+  return fmt.Sprintf("row %d, column %q: can't use %#v: %s", e.Row, e.Column, e.Value, e.Err.Error())
+}
+
+func (e *CellError) Unwrap() error {
+  return e.Err
+}
+
+func isEmptyCell(cell interface{}) bool {
+  s, ok := cell.(string)
+  return cell == nil || (ok && strings.TrimSpace(s) == "")
+}
+
+// setCell converts a cell to the type of v, which is the field f or what it points to. Empty cells
+// (including the missing ones at the end of short rows) leave pointers nil and everything else at its
+// zero value.
+func setCell(f *littledb.Field, v reflect.Value, cell interface{}) error {
+  if v.Kind() == reflect.Ptr {
+    if isEmptyCell(cell) {
+      v.Set(reflect.Zero(v.Type()))
+      return nil
+    }
+    p := reflect.New(v.Type().Elem())
+    if err := setCell(f, p.Elem(), cell); err != nil {
+      return err
+    }
+    v.Set(p)
+    return nil
+  }
+
+  if f.IsList() && v.Kind() == reflect.Slice {
+    if isEmptyCell(cell) {
+      v.Set(reflect.Zero(v.Type()))
+      return nil
+    }
+    return f.ParseValue(v, fmt.Sprint(cell))
+  }
+
+  // Custom types get to see empty cells too.
+  if v.Addr().Type().Implements(unmarshalerType) {
+    return v.Addr().Interface().(Unmarshaler).UnmarshalCell(cell)
+  }
+  if v.Type() != timeType && v.Addr().Type().Implements(textUnmarshalerType) {
+    return v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(fmt.Sprint(cell)))
+  }
+
+  if isEmptyCell(cell) {
+    v.Set(reflect.Zero(v.Type()))
+    return nil
+  }
+
+  switch v.Type() {
+  case timeType:
+    t, err := parseTime(f, cell)
+    if err != nil {
+      return err
+    }
+    v.Set(reflect.ValueOf(t))
+    return nil
+  case durationType:
+    d, err := parseDuration(cell)
+    if err != nil {
+      return err
+    }
+    v.SetInt(int64(d))
+    return nil
+  }
+
+  switch v.Kind() {
+  case reflect.String:
+    if s, ok := cell.(string); ok {
+      v.SetString(s)
+    } else {
+      v.SetString(fmt.Sprint(cell))
+    }
+    return nil
+  case reflect.Bool:
+    switch c := cell.(type) {
+    case bool:
+      v.SetBool(c)
+      return nil
+    case string:
+      b, err := strconv.ParseBool(strings.TrimSpace(c))
+      if err != nil {
+        return fmt.Errorf("not a bool")
+      }
+      v.SetBool(b)
+      return nil
+    }
+    return fmt.Errorf("not a bool")
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    n, err := parseNumber(cell)
+    if err != nil {
+      return err
+    }
+    if n != math.Trunc(n) {
+      return fmt.Errorf("not an integer")
+    }
+    if v.OverflowInt(int64(n)) {
+      return fmt.Errorf("out of range for %v", v.Type())
+    }
+    v.SetInt(int64(n))
+    return nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    n, err := parseNumber(cell)
+    if err != nil {
+      return err
+    }
+    if n != math.Trunc(n) || n < 0 {
+      return fmt.Errorf("not a positive integer")
+    }
+    if v.OverflowUint(uint64(n)) {
+      return fmt.Errorf("out of range for %v", v.Type())
+    }
+    v.SetUint(uint64(n))
+    return nil
+  case reflect.Float32, reflect.Float64:
+    n, err := parseNumber(cell)
+    if err != nil {
+      return err
+    }
+    v.SetFloat(n)
+    return nil
+  }
+  return fmt.Errorf("unsupported field type %v", v.Type())
+}
+
+// Numbers come back formatted the way the sheet shows them, e.g. "1,234.50", "-$12", "($12)" or
+// "15%".
+func parseNumber(cell interface{}) (float64, error) {
+  switch c := cell.(type) {
+  case float64:
+    return c, nil
+  case string:
+    s := strings.TrimSpace(c)
+    s = strings.ReplaceAll(s, ",", "")
+    // Accounting formats put negative numbers in parentheses.
+    neg := false
+    if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")") {
+      neg = true
+      s = strings.TrimSpace(s[1 : len(s)-1])
+    }
+    // The sign can come before or after the currency symbol.
+    sign := ""
+    if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+      sign, s = s[:1], s[1:]
+    }
+    s = sign + strings.TrimPrefix(s, "$")
+    scale := 1.0
+    if strings.HasSuffix(s, "%") {
+      s = strings.TrimSuffix(s, "%")
+      scale = 0.01
+    }
+    f, err := strconv.ParseFloat(s, 64)
+    if err != nil {
+      return 0, fmt.Errorf("not a number")
+    }
+    if neg {
+      f = -f
+    }
+    return f * scale, nil
+  }
+  return 0, fmt.Errorf("not a number")
+}
+
+// Dates are strings, or serial numbers when values are read unformatted.
+func parseTime(f *littledb.Field, cell interface{}) (time.Time, error) {
+  switch c := cell.(type) {
+  case float64:
+    return f.TimeFromSerial(c), nil
+  case string:
+    return f.ParseTime(strings.TrimSpace(c))
+  }
+  return time.Time{}, fmt.Errorf("not a date")
+}
+
+// Durations are formatted like "1:06:55" in sheets, or are a fraction of a day when unformatted. Go
+// duration strings ("1h30m") work too.
+func parseDuration(cell interface{}) (time.Duration, error) {
+  switch c := cell.(type) {
+  case float64:
+    return time.Duration(c * float64(24*time.Hour)), nil
+  case string:
+    s := strings.TrimSpace(c)
+    if d, err := time.ParseDuration(s); err == nil {
+      return d, nil
+    }
+    neg := strings.HasPrefix(s, "-")
+    parts := strings.Split(strings.TrimPrefix(s, "-"), ":")
+    if len(parts) < 2 || len(parts) > 3 {
+      return 0, fmt.Errorf("not a duration")
+    }
+    units := []time.Duration{time.Hour, time.Minute, time.Second}
+    var d time.Duration
+    for i, part := range parts {
+      if i == len(parts)-1 && len(parts) == 3 {
+        // Seconds can have a fraction.
+        f, err := strconv.ParseFloat(part, 64)
+        if err != nil {
+          return 0, fmt.Errorf("not a duration")
+        }
+        d += time.Duration(f * float64(time.Second))
+        continue
+      }
+      n, err := strconv.Atoi(part)
+      if err != nil {
+        return 0, fmt.Errorf("not a duration")
+      }
+      d += time.Duration(n) * units[i]
+    }
+    if neg {
+      d = -d
+    }
+    return d, nil
+  }
+  return 0, fmt.Errorf("not a duration")
+}
+
+// The inverse of parseDuration's "h:mm:ss" form.
+func formatDuration(d time.Duration) string {
+  sign := ""
+  if d < 0 {
+    sign = "-"
+    d = -d
+  }
+  d = d.Round(time.Second)
+  return fmt.Sprintf("%s%d:%02d:%02d", sign, int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60)
+}
@@ -0,0 +1,178 @@
+package gsheets
+
+import (
+  "errors"
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+type cellRow struct {
+  S string `littledb:"S"`
+  N int `littledb:"N"`
+  U uint8 `littledb:"U"`
+  F float64 `littledb:"F"`
+  B bool `littledb:"B"`
+  P *int `littledb:"P"`
+  T time.Time `littledb:"T"`
+  D time.Duration `littledb:"D"`
+  L []string `littledb:"L,sep=;"`
+}
+
+func schemaField(t *testing.T, row interface{}, name string) *littledb.Field {
+  t.Helper()
+  schema, err := littledb.SchemaOf(reflect.TypeOf(row))
+  if err != nil {
+    t.Fatal(err)
+  }
+  f, ok := schema.Field(name)
+  if !ok {
+    t.Fatalf("no field %q", name)
+  }
+  return f
+}
+
+func TestSetCell(t *testing.T) {
+  three := 3
+  tests := []struct {
+    field string
+    cell interface{}
+    want interface{}
+    // Part of the error message, if it should fail.
+    err string
+  }{
+    {"S", "hi", "hi", ""},
+    {"S", 1.5, "1.5", ""},
+    {"S", nil, "", ""},
+    {"N", "1,234", 1234, ""},
+    {"N", 12.0, 12, ""},
+    {"N", "(12)", -12, ""},
+    {"N", "", 0, ""},
+    {"N", "1.5", nil, "not an integer"},
+    {"N", true, nil, "not a number"},
+    {"U", 255.0, uint8(255), ""},
+    {"U", 256.0, nil, "out of range"},
+    {"U", "-1", nil, "not a positive integer"},
+    {"F", "15%", 0.15, ""},
+    {"F", "-$1,234.50", -1234.5, ""},
+    {"B", true, true, ""},
+    {"B", " TRUE ", true, ""},
+    {"B", "yes", nil, "not a bool"},
+    // Sheets gives numbers for checkboxes that were typed over, which isn't a bool either.
+    {"B", 1.0, nil, "not a bool"},
+    {"P", "3", &three, ""},
+    {"P", " ", (*int)(nil), ""},
+    {"T", "2024-03-04", time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC), ""},
+    {"T", 45355.5, time.Date(2024, 3, 4, 12, 0, 0, 0, time.UTC), ""},
+    {"T", "someday", nil, "failed to parse"},
+    {"T", true, nil, "not a date"},
+    {"D", "1:30:00", 90 * time.Minute, ""},
+    {"D", 0.5, 12 * time.Hour, ""},
+    {"L", "a;b", []string{"a", "b"}, ""},
+    {"L", "", []string(nil), ""},
+  }
+  for _, tt := range tests {
+    row := reflect.New(reflect.TypeOf(cellRow{})).Elem()
+    f := schemaField(t, cellRow{}, tt.field)
+    v := f.Value(row)
+    err := setCell(f, v, tt.cell)
+    if tt.err != "" {
+      if err == nil || !strings.Contains(err.Error(), tt.err) {
+        t.Errorf("%s = %#v: got error %v, want %q", tt.field, tt.cell, err, tt.err)
+      }
+      continue
+    }
+    if err != nil {
+      t.Errorf("%s = %#v: %v", tt.field, tt.cell, err)
+      continue
+    }
+    if got := v.Interface(); !reflect.DeepEqual(got, tt.want) {
+      t.Errorf("%s = %#v: got %#v, want %#v", tt.field, tt.cell, got, tt.want)
+    }
+  }
+}
+
+func TestParseNumber(t *testing.T) {
+  tests := []struct {
+    cell interface{}
+    want float64
+    ok bool
+  }{
+    {2.5, 2.5, true},
+    {"12", 12, true},
+    {" 1,234.50 ", 1234.5, true},
+    {"$12", 12, true},
+    {"-$12", -12, true},
+    {"$-12", -12, true},
+    {"+$12", 12, true},
+    {"($12)", -12, true},
+    {"(1,000)", -1000, true},
+    {"15%", 0.15, true},
+    {"-15%", -0.15, true},
+    {"1e3", 1000, true},
+    {"", 0, false},
+    {"$", 0, false},
+    {"12 apples", 0, false},
+    {"--12", 0, false},
+    {true, 0, false},
+    {nil, 0, false},
+  }
+  for _, tt := range tests {
+    got, err := parseNumber(tt.cell)
+    if (err == nil) != tt.ok {
+      t.Errorf("%#v: got error %v", tt.cell, err)
+      continue
+    }
+    if got != tt.want {
+      t.Errorf("%#v: got %v, want %v", tt.cell, got, tt.want)
+    }
+  }
+}
+
+func TestParseDuration(t *testing.T) {
+  tests := []struct {
+    cell interface{}
+    want time.Duration
+    ok bool
+  }{
+    {"1:06:55", time.Hour + 6*time.Minute + 55*time.Second, true},
+    {"0:30", 30 * time.Minute, true},
+    {"36:00:00", 36 * time.Hour, true},
+    {"-1:30:00", -90 * time.Minute, true},
+    {"0:00:01.5", 1500 * time.Millisecond, true},
+    {"1h30m", 90 * time.Minute, true},
+    {0.25, 6 * time.Hour, true},
+    {"1", 0, false},
+    {"1:2:3:4", 0, false},
+    {"a:b", 0, false},
+    {true, 0, false},
+  }
+  for _, tt := range tests {
+    got, err := parseDuration(tt.cell)
+    if (err == nil) != tt.ok {
+      t.Errorf("%#v: got error %v", tt.cell, err)
+      continue
+    }
+    if got != tt.want {
+      t.Errorf("%#v: got %v, want %v", tt.cell, got, tt.want)
+    }
+  }
+
+  // formatDuration writes what parseDuration reads.
+  for _, d := range []time.Duration{0, 90 * time.Minute, -(26*time.Hour + 5*time.Second)} {
+    if got, err := parseDuration(formatDuration(d)); err != nil || got != d {
+      t.Errorf("%v: round trip got %v, %v", d, got, err)
+    }
+  }
+}
+
+// Conversion errors from reading a sheet say where the bad cell is.
+func TestCellError(t *testing.T) {
+  err := error(&CellError{Row: 3, Column: "B", Value: 1.0, Err: errors.New("not a bool")})
+  if got := err.Error(); got != `row 3, column "B": can't use 1: not a bool` {
+    t.Errorf("got %q", got)
+  }
+}
@@ -0,0 +1,270 @@
+package gsheets
+
+import (
+  "context"
+  "encoding/json"
+  "net/http"
+  "net/http/httptest"
+  "sort"
+  "strconv"
+  "strings"
+  "sync"
+  "testing"
+
+  "google.golang.org/api/drive/v3"
+  "google.golang.org/api/option"
+  "google.golang.org/api/sheets/v4"
+)
+
+type item struct {
+  ID int `littledb:"ID,key"`
+  Name string `littledb:"Name"`
+}
+
+type otherItem struct {
+  ID int `littledb:"ID,key"`
+}
+
+// A fake of the Sheets values and spreadsheets APIs and the Drive files API, enough for reads,
+// writes and revision checks. Each sheet is registered by its name, so ranges are whole sheets, and
+// values are kept as they were sent rather than parsed the way USER_ENTERED input would be. Every
+// change bumps the file version.
+type fakeSheets struct {
+  t *testing.T
+  mu sync.Mutex
+  values map[string][][]interface{}
+  version int64
+  // Requests by kind: "get", "batchGet", "append", "update", "batchUpdate", "deleteRows",
+  // "properties" or "revision".
+  calls map[string]int
+  // Data rows (0-based, header excluded) of each deleteRows request, in the order they were sent.
+  deleted [][]int
+}
+
+func newFakeSheets(t *testing.T) *fakeSheets {
+  return &fakeSheets{
+    t: t,
+    values: map[string][][]interface{}{
+      "Items": {{"ID", "Name"}, {1.0, "one"}, {2.0, "two"}},
+      "Others": {{"ID"}, {7.0}},
+    },
+    version: 1,
+    calls: make(map[string]int),
+  }
+}
+
+func (fs *fakeSheets) count(kind string) int {
+  fs.mu.Lock()
+  defer fs.mu.Unlock()
+  return fs.calls[kind]
+}
+
+// Sheet IDs are handed out in name order.
+func (fs *fakeSheets) sheetNames() []string {
+  names := []string{}
+  for name := range fs.values {
+    names = append(names, name)
+  }
+  sort.Strings(names)
+  return names
+}
+
+// Like the API, leave off empty cells at the end of a row.
+func trimRow(row []interface{}) []interface{} {
+  for len(row) > 0 && (row[len(row)-1] == nil || row[len(row)-1] == "") {
+    row = row[:len(row)-1]
+  }
+  return row
+}
+
+func (fs *fakeSheets) decode(r *http.Request, body interface{}) {
+  if err := json.NewDecoder(r.Body).Decode(body); err != nil {
+    fs.t.Error(err)
+  }
+}
+
+func (fs *fakeSheets) checkInputOption(option string) {
+  if option != "USER_ENTERED" {
+    fs.t.Errorf("got value input option %q, want USER_ENTERED", option)
+  }
+}
+
+// Write values over the cells of a range like 'Items'!A3:B3. Nil cells are left alone.
+func (fs *fakeSheets) write(a1 string, values [][]interface{}) {
+  r, err := parseA1(a1)
+  if err != nil {
+    fs.t.Error(err)
+    return
+  }
+  sheet := fs.values[r.Sheet]
+  for i, row := range values {
+    n := r.StartRow - 1 + i
+    for len(sheet) <= n {
+      sheet = append(sheet, nil)
+    }
+    for j, cell := range row {
+      if cell == nil {
+        continue
+      }
+      col := r.StartCol + j
+      for len(sheet[n]) <= col {
+        sheet[n] = append(sheet[n], "")
+      }
+      sheet[n][col] = cell
+    }
+  }
+  fs.values[r.Sheet] = sheet
+}
+
+// Handles AddSheet and row DeleteDimension requests.
+func (fs *fakeSheets) updateSpreadsheet(requests []*sheets.Request) {
+  names := fs.sheetNames()
+  deleted := []int{}
+  for _, req := range requests {
+    if req.AddSheet != nil {
+      fs.values[req.AddSheet.Properties.Title] = [][]interface{}{}
+      continue
+    }
+    d := req.DeleteDimension
+    if d == nil || d.Range.Dimension != "ROWS" || d.Range.SheetId < 0 || int(d.Range.SheetId) >= len(names) {
+      fs.t.Errorf("unexpected request %+v", req)
+      continue
+    }
+    name := names[d.Range.SheetId]
+    sheet := fs.values[name]
+    start, end := int(d.Range.StartIndex), int(d.Range.EndIndex)
+    if start < 1 || end > len(sheet) || start >= end {
+      fs.t.Errorf("bad row range %d-%d in %s", start, end, name)
+      continue
+    }
+    fs.values[name] = append(sheet[:start:start], sheet[end:]...)
+    deleted = append(deleted, start-1)
+  }
+  if len(deleted) > 0 {
+    fs.calls["deleteRows"]++
+    fs.deleted = append(fs.deleted, deleted)
+  }
+}
+
+func (fs *fakeSheets) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+  fs.mu.Lock()
+  defer fs.mu.Unlock()
+  valueRange := func(name string) map[string]interface{} {
+    values := [][]interface{}{}
+    for _, row := range fs.values[name] {
+      values = append(values, trimRow(row))
+    }
+    return map[string]interface{}{"range": name, "values": values}
+  }
+  var rsp interface{} = map[string]interface{}{}
+  p := r.URL.Path
+  switch {
+  case strings.HasPrefix(p, "/drive/v3/files/"):
+    fs.calls["revision"]++
+    // int64s are strings in the JSON API.
+    rsp = map[string]interface{}{"version": strconv.FormatInt(fs.version, 10)}
+  case strings.HasSuffix(p, "/values:batchGet"):
+    fs.calls["batchGet"]++
+    ranges := []interface{}{}
+    for _, name := range r.URL.Query()["ranges"] {
+      ranges = append(ranges, valueRange(name))
+    }
+    rsp = map[string]interface{}{"valueRanges": ranges}
+  case strings.HasSuffix(p, "/values:batchUpdate"):
+    fs.calls["batchUpdate"]++
+    var body sheets.BatchUpdateValuesRequest
+    fs.decode(r, &body)
+    fs.checkInputOption(body.ValueInputOption)
+    for _, vr := range body.Data {
+      fs.write(vr.Range, vr.Values)
+    }
+    fs.version++
+  case strings.HasSuffix(p, ":append"):
+    fs.calls["append"]++
+    fs.checkInputOption(r.URL.Query().Get("valueInputOption"))
+    name := strings.TrimSuffix(p[strings.LastIndex(p, "/values/")+len("/values/"):], ":append")
+    var body sheets.ValueRange
+    fs.decode(r, &body)
+    fs.values[name] = append(fs.values[name], body.Values...)
+    fs.version++
+  case strings.HasSuffix(p, ":batchUpdate"):
+    var body sheets.BatchUpdateSpreadsheetRequest
+    fs.decode(r, &body)
+    fs.updateSpreadsheet(body.Requests)
+    fs.version++
+  case strings.Contains(p, "/values/") && r.Method == http.MethodPut:
+    fs.calls["update"]++
+    var body sheets.ValueRange
+    fs.decode(r, &body)
+    fs.write(p[strings.LastIndex(p, "/values/")+len("/values/"):], body.Values)
+    fs.version++
+  case strings.Contains(p, "/values/"):
+    fs.calls["get"]++
+    rsp = valueRange(p[strings.LastIndex(p, "/values/")+len("/values/"):])
+  case strings.HasPrefix(p, "/v4/spreadsheets/"):
+    fs.calls["properties"]++
+    props := []interface{}{}
+    for i, name := range fs.sheetNames() {
+      props = append(props, map[string]interface{}{"properties": map[string]interface{}{"sheetId": i, "title": name}})
+    }
+    rsp = map[string]interface{}{"sheets": props}
+  default:
+    fs.t.Errorf("unexpected request %s %s", r.Method, r.URL)
+    http.NotFound(w, r)
+    return
+  }
+  w.Header().Set("Content-Type", "application/json")
+  json.NewEncoder(w).Encode(rsp)
+}
+
+// Bump the version as if someone edited the sheet in the browser.
+func (fs *fakeSheets) edit(name string, values [][]interface{}) {
+  fs.mu.Lock()
+  defer fs.mu.Unlock()
+  fs.values[name] = values
+  fs.version++
+}
+
+func (fs *fakeSheets) sheet(name string) [][]interface{} {
+  fs.mu.Lock()
+  defer fs.mu.Unlock()
+  return fs.values[name]
+}
+
+func newFakeDB(t *testing.T, fs *fakeSheets, revisions bool) *SheetsDB {
+  t.Helper()
+  server := httptest.NewServer(fs)
+  t.Cleanup(server.Close)
+  ctx := context.Background()
+  srv, err := sheets.NewService(ctx, option.WithEndpoint(server.URL+"/"), option.WithoutAuthentication())
+  if err != nil {
+    t.Fatal(err)
+  }
+  db, err := NewSheetsDB(srv, "sheet")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if revisions {
+    driveSrv, err := drive.NewService(ctx, option.WithEndpoint(server.URL+"/drive/v3/"), option.WithoutAuthentication())
+    if err != nil {
+      t.Fatal(err)
+    }
+    db.WithRevisionCheck(driveSrv)
+  }
+  return db
+}
+
+// A DB with the Items and Others sheets registered.
+func newItemsDB(t *testing.T, fs *fakeSheets, revisions bool) *SheetsDB {
+  t.Helper()
+  return newFakeDB(t, fs, revisions).Register(item{}, "Items").Register(otherItem{}, "Others")
+}
+
+func queryItems(t *testing.T, db *SheetsDB) []item {
+  t.Helper()
+  items := []item{}
+  if err := db.Query(&items).Do(); err != nil {
+    t.Fatal(err)
+  }
+  return items
+}
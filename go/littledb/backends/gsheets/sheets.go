@@ -1,12 +1,14 @@
 package gsheets
 
 import (
-  "errors"
+  "context"
   "fmt"
-  "log"
+  "net/http"
   "reflect"
   "time"
 
+  "google.golang.org/api/drive/v3"
+  "google.golang.org/api/option"
   "google.golang.org/api/sheets/v4"
 
   "github.com/davedolben/dev-tools/go/littledb"
@@ -17,94 +19,55 @@ type sheetsExecutor struct {
   db *SheetsDB
 }
 
-type sheetColumn struct {
-  Name string
-  FieldIndex int
-  ColIndex int
-}
-
 func (ex *sheetsExecutor) Query(clauses *littledb.QueryClauses) error {
-  vPtr := reflect.ValueOf(ex.data)
-  if vPtr.Kind() != reflect.Ptr {
-    return errors.New("not a pointer")
-  }
-
-  vContainer := vPtr.Elem()
-  if vContainer.Kind() != reflect.Slice {
-    return errors.New("not a slice")
-  }
-
-  tElem := vContainer.Type().Elem()
-
-  cols := []sheetColumn{}
-
-  log.Printf("%+v", tElem)
-  for i := 0; i < tElem.NumField(); i++ {
-    f := tElem.Field(i)
-    log.Printf("%+v", f)
-    name, ok := f.Tag.Lookup("littledb")
-    if !ok || name == "-" {
-      continue
-    }
-    cols = append(cols, sheetColumn{
-      Name: name,
-      FieldIndex: i,
-      ColIndex: -1,
-    })
+  tElem, err := littledb.SliceElemType(ex.data)
+  if err != nil {
+    return err
   }
+  vContainer := reflect.ValueOf(ex.data).Elem()
 
-  dataRange, ok := ex.db.ranges[tElem]
-  if !ok {
-    return fmt.Errorf("no spreadsheet range registered for type: %+v", tElem)
-  }
-  rsp, err := ex.db.srv.Spreadsheets.Values.Get(ex.db.sheetId, dataRange).Do()
+  table, err := ex.db.loadTable(tElem, true)
   if err != nil {
     return err
   }
 
-  headers := rsp.Values[0]
-  for i, h := range headers {
-    for j := range cols {
-      if cols[j].Name == h {
-        cols[j].ColIndex = i
+  out := reflect.MakeSlice(vContainer.Type(), len(table.rows), len(table.rows))
+  for i, row := range table.rows {
+    vRow := out.Index(i)
+    for j, f := range table.schema.Fields {
+      var cell interface{}
+      // The API leaves off empty cells at the end of a row.
+      if col := table.cols[f.Name]; col < len(row) {
+        cell = row[col]
       }
-    }
-  }
-
-  log.Printf("%+v", cols)
-
-  containerLen := len(rsp.Values) - 1
-  vContainer.Set(reflect.MakeSlice(vContainer.Type(), containerLen, containerLen))
-  for i, row := range rsp.Values[1:] {
-    vRow := vContainer.Index(i)
-    for _, col := range cols {
-      vField := vRow.Field(col.FieldIndex)
-
-      // Special case handling for date strings
-      vRowData := reflect.ValueOf(row[col.ColIndex])
-      if vField.Type() == reflect.ValueOf(time.Time{}).Type() && vRowData.Kind() == reflect.String {
-        timestamp, err := stringToTime(vRowData.String())
-        if err != nil {
-          return err
+      if err := setCell(&table.schema.Fields[j], f.Value(vRow), cell); err != nil {
+        return &CellError{
+          Row: table.sheetRow(i),
+          Column: f.Name,
+          Value: cell,
+          Err: err,
         }
-        vField.Set(reflect.ValueOf(timestamp))
-        continue
       }
-
-      if vField.Type() != vRowData.Type() {
-        log.Fatalf("type mismatch %+v != %+v", vField.Type(), reflect.ValueOf(row[col.ColIndex]).Type())
-      }
-      vField.Set(reflect.ValueOf(row[col.ColIndex]))
     }
+    table.schema.CombineDates(vRow)
   }
+  vContainer.Set(out)
 
-  return nil
+  // The values API can't filter or sort, so do it here.
+  return littledb.ApplyClauses(ex.data, clauses)
 }
 
 type SheetsDB struct {
   srv *sheets.Service
   sheetId string
   ranges map[reflect.Type]string
+  valueRenderOption string
+
+  defaultTTL time.Duration
+  // By range.
+  ttls map[string]time.Duration
+  driveSrv *drive.Service
+  cache *rangeCache
 }
 
 func (db *SheetsDB) Register(t interface{}, dataRange string) *SheetsDB {
@@ -112,12 +75,30 @@ func (db *SheetsDB) Register(t interface{}, dataRange string) *SheetsDB {
   return db
 }
 
+// WithValueRenderOption sets how the API returns values: FORMATTED_VALUE (the default), UNFORMATTED_VALUE
+// or FORMULA. Unformatted values come back as numbers, so dates are read as serial numbers and don't
+// depend on the sheet's locale or display format.
+func (db *SheetsDB) WithValueRenderOption(opt string) *SheetsDB {
+  db.valueRenderOption = opt
+  return db
+}
+
+func (db *SheetsDB) rangeFor(t reflect.Type) (string, error) {
+  dataRange, ok := db.ranges[t]
+  if !ok {
+    return "", fmt.Errorf("no spreadsheet range registered for type: %+v", t)
+  }
+  return dataRange, nil
+}
+
 func (db *SheetsDB) Query(data interface{}) *littledb.PartialQuery {
   return &littledb.PartialQuery{
     Ex: &sheetsExecutor{
       data: data,
       db: db,
     },
+    Data: data,
+    DB: db,
   }
 }
 
@@ -126,6 +107,20 @@ func NewSheetsDB(srv *sheets.Service, sheetId string) (*SheetsDB, error) {
     srv: srv,
     sheetId: sheetId,
     ranges: make(map[reflect.Type]string),
+    ttls: make(map[string]time.Duration),
+    cache: &rangeCache{
+      entries: make(map[string]*cacheEntry),
+      stats: make(map[string]*RangeStats),
+    },
   }, nil
 }
 
+
+// Open makes a SheetsDB from an authorized client, e.g. one from the googleauth package.
+func Open(ctx context.Context, client *http.Client, sheetId string) (*SheetsDB, error) {
+  srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+  if err != nil {
+    return nil, fmt.Errorf("error creating sheets service: %w", err)
+  }
+  return NewSheetsDB(srv, sheetId)
+}
@@ -0,0 +1,94 @@
+package gsheets
+
+import (
+  "fmt"
+  "reflect"
+  "strconv"
+  "strings"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// The current contents of a registered range. The header row says which column each field is in,
+// and writes look up rows by key.
+type sheetTable struct {
+  dataRange string
+  a1 *a1Range
+  schema *littledb.Schema
+  // Column name -> index in the header row.
+  cols map[string]int
+  width int
+  rows [][]interface{}
+}
+
+func (db *SheetsDB) loadTable(t reflect.Type, useCache bool) (*sheetTable, error) {
+  dataRange, err := db.rangeFor(t)
+  if err != nil {
+    return nil, err
+  }
+  a1, err := parseA1(dataRange)
+  if err != nil {
+    return nil, err
+  }
+  schema, err := littledb.SchemaOf(t)
+  if err != nil {
+    return nil, err
+  }
+  values, err := db.fetch(dataRange, useCache)
+  if err != nil {
+    return nil, err
+  }
+  if len(values) == 0 {
+    return nil, fmt.Errorf("no header row in %s", dataRange)
+  }
+
+  table := &sheetTable{
+    dataRange: dataRange,
+    a1: a1,
+    schema: schema,
+    cols: make(map[string]int),
+    width: len(values[0]),
+    rows: values[1:],
+  }
+  for i, h := range values[0] {
+    table.cols[fmt.Sprint(h)] = i
+  }
+  missing := []string{}
+  for _, f := range schema.Fields {
+    if _, ok := table.cols[f.Name]; !ok {
+      missing = append(missing, strconv.Quote(f.Name))
+    }
+  }
+  if len(missing) > 0 {
+    return nil, fmt.Errorf("%s: header row is missing columns %s", dataRange, strings.Join(missing, ", "))
+  }
+  return table, nil
+}
+
+// The row number in the sheet of a data row.
+func (table *sheetTable) sheetRow(i int) int {
+  return table.a1.StartRow + 1 + i
+}
+
+// Find the data row with the given key, or -1. value is formatted with keyString. Cells are read
+// into the key's type and formatted the same way before comparing, so keys still match when the sheet
+// was read unformatted (e.g. dates as serial numbers).
+func (table *sheetTable) findRow(key *littledb.Field, value string) int {
+  col := table.cols[key.Name]
+  v := reflect.New(key.Type).Elem()
+  for i, row := range table.rows {
+    if col >= len(row) {
+      continue
+    }
+    if fmt.Sprint(row[col]) == value {
+      return i
+    }
+    if err := setCell(key, v, row[col]); err != nil {
+      continue
+    }
+    if keyString(key, v) == value {
+      return i
+    }
+  }
+  return -1
+}
@@ -0,0 +1,54 @@
+package gsheets
+
+import (
+  "reflect"
+  "testing"
+  "time"
+)
+
+type datedRow struct {
+  Day time.Time `littledb:"Day,key"`
+  Hours float64 `littledb:"Hours"`
+}
+
+type durationRow struct {
+  Length time.Duration `littledb:"Length,key"`
+}
+
+type countRow struct {
+  ID int `littledb:"ID,key"`
+}
+
+func TestFindRow(t *testing.T) {
+  day := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+  tests := []struct {
+    name string
+    key interface{}
+    field string
+    rows [][]interface{}
+    value interface{}
+    want int
+  }{
+    {"formatted date", datedRow{}, "Day", [][]interface{}{{"3/3/2024"}, {"3/4/2024"}}, day, 1},
+    // UNFORMATTED_VALUE with SERIAL_NUMBER dates.
+    {"serial date", datedRow{}, "Day", [][]interface{}{{45354.0}, {45355.0}}, day, 1},
+    {"date in another format", datedRow{}, "Day", [][]interface{}{{"2024-03-04"}}, day, 0},
+    {"missing date", datedRow{}, "Day", [][]interface{}{{45354.0}, {}}, day, -1},
+    {"formatted duration", durationRow{}, "Length", [][]interface{}{{"1:30:00"}}, 90 * time.Minute, 0},
+    {"serial duration", durationRow{}, "Length", [][]interface{}{{0.25}, {0.0625}}, 90 * time.Minute, 1},
+    {"formatted number", countRow{}, "ID", [][]interface{}{{"999"}, {"1,234"}}, 1234, 1},
+    {"unformatted number", countRow{}, "ID", [][]interface{}{{1234.0}}, 1234, 0},
+    {"bad cells are skipped", countRow{}, "ID", [][]interface{}{{"n/a"}, {"7"}}, 7, 1},
+  }
+  for _, tt := range tests {
+    key := schemaField(t, tt.key, tt.field)
+    table := &sheetTable{
+      cols: map[string]int{tt.field: 0},
+      rows: tt.rows,
+    }
+    value := keyString(key, reflect.ValueOf(tt.value))
+    if got := table.findRow(key, value); got != tt.want {
+      t.Errorf("%s: looking for %q got row %d, want %d", tt.name, value, got, tt.want)
+    }
+  }
+}
@@ -2,26 +2,70 @@ package gsheets
 
 import (
   "fmt"
-  "time"
+  "regexp"
+  "strconv"
+  "strings"
 )
 
-func stringToTime(str string) (time.Time, error) {
-  fmts := []string{
-    "1/2/2006",
-    "01/02/2006",
-    "03:04:05 PM",
-    "3:04:05 PM",
-    "03:04 PM",
-    "3:04 PM",
-    "15:04",
-    "15:04:05",
+var cellsRegex = regexp.MustCompile(`^[A-Za-z]{1,3}[0-9]*(:[A-Za-z]{1,3}[0-9]*)?$`)
+
+// A parsed A1 range like "Time Sheet!A1:E100". Columns are 0-based, rows are 1-based like in the UI.
+type a1Range struct {
+  Sheet string
+  StartCol int
+  StartRow int
+}
+
+func parseA1(r string) (*a1Range, error) {
+  out := &a1Range{StartRow: 1}
+  cells := r
+  if i := strings.LastIndex(r, "!"); i >= 0 {
+    out.Sheet = r[:i]
+    cells = r[i+1:]
+    if len(out.Sheet) >= 2 && strings.HasPrefix(out.Sheet, "'") && strings.HasSuffix(out.Sheet, "'") {
+      out.Sheet = strings.ReplaceAll(out.Sheet[1:len(out.Sheet)-1], "''", "'")
+    }
+  } else if !cellsRegex.MatchString(r) {
+    // Just a sheet name.
+    out.Sheet = r
+    return out, nil
+  }
+
+  start, _, _ := strings.Cut(cells, ":")
+  letters := strings.TrimRight(start, "0123456789")
+  if letters != "" {
+    col := 0
+    for _, c := range strings.ToUpper(letters) {
+      if c < 'A' || c > 'Z' {
+        return nil, fmt.Errorf("bad range: %q", r)
+      }
+      col = col*26 + int(c-'A'+1)
+    }
+    out.StartCol = col - 1
   }
-  for _, f := range fmts {
-    time, err := time.Parse(f, str)
-    if err == nil {
-      return time, nil
+  if digits := start[len(letters):]; digits != "" {
+    row, err := strconv.Atoi(digits)
+    if err != nil || row < 1 {
+      return nil, fmt.Errorf("bad range: %q", r)
     }
+    out.StartRow = row
+  }
+  return out, nil
+}
+
+func columnName(col int) string {
+  name := ""
+  for col++; col > 0; col = (col - 1) / 26 {
+    name = string(rune('A'+(col-1)%26)) + name
   }
-  return time.Time{}, fmt.Errorf("failed to parse string to date: %q", str)
+  return name
 }
 
+// The A1 range for one row of the table, 'width' columns wide.
+func (r *a1Range) rowRange(row, width int) string {
+  cells := fmt.Sprintf("%s%d:%s%d", columnName(r.StartCol), row, columnName(r.StartCol+width-1), row)
+  if r.Sheet == "" {
+    return cells
+  }
+  return "'" + strings.ReplaceAll(r.Sheet, "'", "''") + "'!" + cells
+}
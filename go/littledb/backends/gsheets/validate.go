@@ -0,0 +1,116 @@
+package gsheets
+
+import (
+  "fmt"
+  "reflect"
+  "sort"
+
+  "google.golang.org/api/sheets/v4"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// Validate compares the header row of every registered range with its type's tags.
+func (db *SheetsDB) Validate() ([]*littledb.Drift, error) {
+  props, err := db.sheetProperties()
+  if err != nil {
+    return nil, err
+  }
+  titles := make(map[string]bool)
+  for _, p := range props {
+    titles[p.Title] = true
+  }
+
+  types := []reflect.Type{}
+  for t := range db.ranges {
+    types = append(types, t)
+  }
+  sort.Slice(types, func(i, j int) bool { return db.ranges[types[i]] < db.ranges[types[j]] })
+
+  drifts := []*littledb.Drift{}
+  for _, t := range types {
+    dataRange := db.ranges[t]
+    schema, err := littledb.SchemaOf(t)
+    if err != nil {
+      return nil, err
+    }
+    a1, err := parseA1(dataRange)
+    if err != nil {
+      return nil, err
+    }
+
+    headers := []string{}
+    if a1.Sheet == "" || titles[a1.Sheet] {
+      values, err := db.fetch(dataRange, false)
+      if err != nil {
+        return nil, err
+      }
+      if len(values) > 0 {
+        for _, h := range values[0] {
+          headers = append(headers, fmt.Sprint(h))
+        }
+      }
+    }
+
+    d := littledb.CompareColumns(schema, headers)
+    d.Table = dataRange
+    d.NoTable = len(headers) == 0
+    drifts = append(drifts, d)
+  }
+  return drifts, nil
+}
+
+// CreateMissing adds a sheet and header row for every registered range that doesn't have a header
+// row yet. Columns are written in field order.
+func (db *SheetsDB) CreateMissing() error {
+  drifts, err := db.Validate()
+  if err != nil {
+    return err
+  }
+  props, err := db.sheetProperties()
+  if err != nil {
+    return err
+  }
+  titles := make(map[string]bool)
+  for _, p := range props {
+    titles[p.Title] = true
+  }
+
+  for _, d := range drifts {
+    if !d.NoTable {
+      continue
+    }
+    a1, err := parseA1(d.Table)
+    if err != nil {
+      return err
+    }
+    if a1.Sheet != "" && !titles[a1.Sheet] {
+      _, err := db.srv.Spreadsheets.BatchUpdate(db.sheetId, &sheets.BatchUpdateSpreadsheetRequest{
+        Requests: []*sheets.Request{
+          {AddSheet: &sheets.AddSheetRequest{Properties: &sheets.SheetProperties{Title: a1.Sheet}}},
+        },
+      }).Do()
+      if err != nil {
+        return fmt.Errorf("adding sheet %q: %w", a1.Sheet, err)
+      }
+      titles[a1.Sheet] = true
+    }
+
+    schema, err := littledb.SchemaOf(d.Type)
+    if err != nil {
+      return err
+    }
+    header := []interface{}{}
+    for _, f := range schema.Fields {
+      header = append(header, f.Name)
+    }
+    _, err = db.srv.Spreadsheets.Values.Update(db.sheetId, a1.rowRange(a1.StartRow, len(header)), &sheets.ValueRange{
+      Values: [][]interface{}{header},
+    }).ValueInputOption("RAW").Do()
+    if err != nil {
+      return fmt.Errorf("writing header row for %s: %w", d.Table, err)
+    }
+    db.invalidate(d.Table)
+  }
+  return nil
+}
@@ -0,0 +1,226 @@
+package gsheets
+
+import (
+  "encoding"
+  "fmt"
+  "reflect"
+  "sort"
+  "time"
+
+  "google.golang.org/api/googleapi"
+  "google.golang.org/api/sheets/v4"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// A full row of cells in header order. Columns that aren't mapped to a field are left as nil, which
+// the API skips, so formulas and hand-entered columns next to the data survive updates.
+func (table *sheetTable) rowValues(vRow reflect.Value) []interface{} {
+  out := make([]interface{}, table.width)
+  for i, f := range table.schema.Fields {
+    out[table.cols[f.Name]] = cellValue(&table.schema.Fields[i], f.Value(vRow))
+  }
+  return out
+}
+
+// Convert a field to something the Sheets API accepts. Times are written as text that USER_ENTERED
+// input turns back into dates.
+func cellValue(f *littledb.Field, v reflect.Value) interface{} {
+  for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+    if v.IsNil() {
+      return ""
+    }
+    v = v.Elem()
+  }
+  if f.IsList() && v.Kind() == reflect.Slice {
+    return f.FormatValue(v)
+  }
+  switch x := v.Interface().(type) {
+  case time.Time:
+    return f.FormatTime(x)
+  case time.Duration:
+    return formatDuration(x)
+  case encoding.TextMarshaler:
+    if text, err := x.MarshalText(); err == nil {
+      return string(text)
+    }
+  }
+  switch v.Kind() {
+  case reflect.String:
+    return v.String()
+  case reflect.Bool:
+    return v.Bool()
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return v.Int()
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return v.Uint()
+  case reflect.Float32, reflect.Float64:
+    return v.Float()
+  }
+  return fmt.Sprint(v.Interface())
+}
+
+// Key values are compared to the formatted cell values the API returns.
+func keyString(key *littledb.Field, v reflect.Value) string {
+  return fmt.Sprint(cellValue(key, v))
+}
+
+func (db *SheetsDB) Insert(data interface{}) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  if len(rows) == 0 {
+    return nil
+  }
+  table, err := db.loadTable(t, false)
+  if err != nil {
+    return err
+  }
+  key, keyErr := table.schema.Key()
+
+  values := [][]interface{}{}
+  added := make(map[string]bool)
+  for _, vRow := range rows {
+    // Keys are optional for inserts, but if there is one it has to stay unique.
+    if keyErr == nil {
+      k := keyString(key, key.Value(vRow))
+      if added[k] || table.findRow(key, k) >= 0 {
+        return fmt.Errorf("duplicate key: %s = %q", key.Name, k)
+      }
+      added[k] = true
+    }
+    values = append(values, table.rowValues(vRow))
+  }
+
+  _, err = db.srv.Spreadsheets.Values.Append(db.sheetId, table.dataRange, &sheets.ValueRange{Values: values}).
+    ValueInputOption("USER_ENTERED").
+    InsertDataOption("INSERT_ROWS").
+    Do()
+  db.invalidate(table.dataRange)
+  return err
+}
+
+func (db *SheetsDB) Update(data interface{}) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  if len(rows) == 0 {
+    return nil
+  }
+  table, err := db.loadTable(t, false)
+  if err != nil {
+    return err
+  }
+  key, err := table.schema.Key()
+  if err != nil {
+    return err
+  }
+
+  updates := []*sheets.ValueRange{}
+  for _, vRow := range rows {
+    k := keyString(key, key.Value(vRow))
+    i := table.findRow(key, k)
+    if i < 0 {
+      return fmt.Errorf("%w: %s = %q", littledb.ErrNotFound, key.Name, k)
+    }
+    updates = append(updates, &sheets.ValueRange{
+      Range: table.a1.rowRange(table.sheetRow(i), table.width),
+      Values: [][]interface{}{table.rowValues(vRow)},
+    })
+  }
+
+  _, err = db.srv.Spreadsheets.Values.BatchUpdate(db.sheetId, &sheets.BatchUpdateValuesRequest{
+    ValueInputOption: "USER_ENTERED",
+    Data: updates,
+  }).Do()
+  db.invalidate(table.dataRange)
+  return err
+}
+
+func (db *SheetsDB) Delete(data interface{}) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  if len(rows) == 0 {
+    return nil
+  }
+  table, err := db.loadTable(t, false)
+  if err != nil {
+    return err
+  }
+  key, err := table.schema.Key()
+  if err != nil {
+    return err
+  }
+
+  sheetRows := []int{}
+  seen := make(map[int]bool)
+  for _, vRow := range rows {
+    k := keyString(key, key.Value(vRow))
+    i := table.findRow(key, k)
+    if i < 0 {
+      return fmt.Errorf("%w: %s = %q", littledb.ErrNotFound, key.Name, k)
+    }
+    if !seen[i] {
+      seen[i] = true
+      sheetRows = append(sheetRows, table.sheetRow(i))
+    }
+  }
+  // Delete from the bottom up so the earlier row numbers stay valid.
+  sort.Sort(sort.Reverse(sort.IntSlice(sheetRows)))
+
+  gid, err := db.sheetGid(table.a1.Sheet)
+  if err != nil {
+    return err
+  }
+  requests := []*sheets.Request{}
+  for _, row := range sheetRows {
+    requests = append(requests, &sheets.Request{
+      DeleteDimension: &sheets.DeleteDimensionRequest{
+        Range: &sheets.DimensionRange{
+          SheetId: gid,
+          Dimension: "ROWS",
+          StartIndex: int64(row - 1),
+          EndIndex: int64(row),
+          // Zero is a valid sheet ID and row index.
+          ForceSendFields: []string{"SheetId", "StartIndex"},
+        },
+      },
+    })
+  }
+  _, err = db.srv.Spreadsheets.BatchUpdate(db.sheetId, &sheets.BatchUpdateSpreadsheetRequest{
+    Requests: requests,
+  }).Do()
+  db.invalidate(table.dataRange)
+  return err
+}
+
+// Row deletes go through the spreadsheet API, which wants the numeric sheet ID rather than its name.
+// A range without a sheet name refers to the first sheet.
+func (db *SheetsDB) sheetGid(name string) (int64, error) {
+  sheets, err := db.sheetProperties()
+  if err != nil {
+    return 0, err
+  }
+  for _, s := range sheets {
+    if name == "" || s.Title == name {
+      return s.SheetId, nil
+    }
+  }
+  return 0, fmt.Errorf("no sheet named %q", name)
+}
+
+func (db *SheetsDB) sheetProperties() ([]*sheets.SheetProperties, error) {
+  rsp, err := db.srv.Spreadsheets.Get(db.sheetId).Fields(googleapi.Field("sheets.properties(sheetId,title)")).Do()
+  if err != nil {
+    return nil, err
+  }
+  out := []*sheets.SheetProperties{}
+  for _, s := range rsp.Sheets {
+    out = append(out, s.Properties)
+  }
+  return out, nil
+}
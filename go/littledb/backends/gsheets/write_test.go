@@ -0,0 +1,117 @@
+package gsheets
+
+import (
+  "errors"
+  "fmt"
+  "testing"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+  "github.com/davedolben/dev-tools/go/littledb/dbtest"
+)
+
+func TestConformance(t *testing.T) {
+  dbtest.Run(t, func(t *testing.T) littledb.DB {
+    fs := newFakeSheets(t)
+    fs.values["rows"] = [][]interface{}{{"id", "name", "score", "done", "date", "note"}}
+    return newFakeDB(t, fs, false).Register(dbtest.Row{}, "rows")
+  })
+}
+
+func TestRelations(t *testing.T) {
+  dbtest.RunRelations(t, func(t *testing.T) littledb.DB {
+    fs := newFakeSheets(t)
+    fs.values["projects"] = [][]interface{}{{"id", "name"}}
+    fs.values["tasks"] = [][]interface{}{{"id", "title", "project", "tags", "points", "created_by"}}
+    return newFakeDB(t, fs, false).Register(dbtest.Project{}, "projects").Register(dbtest.Task{}, "tasks")
+  })
+}
+
+func TestValidate(t *testing.T) {
+  dbtest.RunValidate(t, func(t *testing.T) func(row interface{}) dbtest.ValidatingDB {
+    fs := newFakeSheets(t)
+    return func(row interface{}) dbtest.ValidatingDB {
+      return newFakeDB(t, fs, false).Register(row, "rows")
+    }
+  })
+}
+
+func TestInsert(t *testing.T) {
+  fs := newFakeSheets(t)
+  db := newItemsDB(t, fs, false)
+  if err := db.Insert([]item{{ID: 3, Name: "three"}, {ID: 4, Name: "four"}}); err != nil {
+    t.Fatal(err)
+  }
+  if got := fmt.Sprint(fs.sheet("Items")); got != "[[ID Name] [1 one] [2 two] [3 three] [4 four]]" {
+    t.Errorf("got %s", got)
+  }
+  if n := fs.count("append"); n != 1 {
+    t.Errorf("got %d appends, want 1", n)
+  }
+
+  // Duplicates are caught before anything is sent.
+  for _, rows := range [][]item{{{ID: 2}}, {{ID: 5}, {ID: 5}}} {
+    if err := db.Insert(rows); err == nil {
+      t.Errorf("inserted duplicate keys %+v", rows)
+    }
+  }
+  if n := fs.count("append"); n != 1 {
+    t.Errorf("got %d appends after failed inserts", n)
+  }
+}
+
+func TestUpdate(t *testing.T) {
+  fs := newFakeSheets(t)
+  // A hand-entered column between the mapped ones, and the data starting lower down.
+  fs.values["Items"] = [][]interface{}{{"ID", "Notes", "Name"}, {1.0, "keep", "one"}, {2.0, "me", "two"}, {3.0, "", "three"}}
+  db := newItemsDB(t, fs, false)
+  if err := db.Update([]item{{ID: 3, Name: "THREE"}, {ID: 1, Name: "ONE"}}); err != nil {
+    t.Fatal(err)
+  }
+  if got := fmt.Sprint(fs.sheet("Items")); got != "[[ID Notes Name] [1 keep ONE] [2 me two] [3  THREE]]" {
+    t.Errorf("got %s", got)
+  }
+  if n := fs.count("batchUpdate"); n != 1 {
+    t.Errorf("got %d batch updates, want 1", n)
+  }
+
+  if err := db.Update(&item{ID: 9}); !errors.Is(err, littledb.ErrNotFound) {
+    t.Errorf("got %v, want ErrNotFound", err)
+  }
+  if n := fs.count("batchUpdate"); n != 1 {
+    t.Errorf("got %d batch updates after a failed update", n)
+  }
+}
+
+func TestDelete(t *testing.T) {
+  fs := newFakeSheets(t)
+  fs.values["Items"] = [][]interface{}{{"ID", "Name"}, {1.0, "one"}, {2.0, "two"}, {3.0, "three"}, {4.0, "four"}}
+  db := newItemsDB(t, fs, false)
+  if err := db.Delete([]item{{ID: 1}, {ID: 3}, {ID: 1}}); err != nil {
+    t.Fatal(err)
+  }
+  if got := fmt.Sprint(fs.sheet("Items")); got != "[[ID Name] [2 two] [4 four]]" {
+    t.Errorf("got %s", got)
+  }
+  // One request, bottom up, each row once.
+  if got := fmt.Sprint(fs.deleted); got != "[[2 0]]" {
+    t.Errorf("deleted rows %s", got)
+  }
+  if items := queryItems(t, db); len(items) != 2 || items[0].ID != 2 || items[1].ID != 4 {
+    t.Errorf("got %+v", items)
+  }
+
+  if err := db.Delete(&item{ID: 1}); !errors.Is(err, littledb.ErrNotFound) {
+    t.Errorf("got %v, want ErrNotFound", err)
+  }
+  if n := fs.count("deleteRows"); n != 1 {
+    t.Errorf("got %d delete requests, want 1", n)
+  }
+
+  // Rows of the other sheet go through its own sheet ID.
+  if err := db.Delete(&otherItem{ID: 7}); err != nil {
+    t.Fatal(err)
+  }
+  if got := fmt.Sprint(fs.sheet("Others"), fs.sheet("Items")); got != "[[ID]] [[ID Name] [2 two] [4 four]]" {
+    t.Errorf("got %s", got)
+  }
+}
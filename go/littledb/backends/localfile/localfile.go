@@ -0,0 +1,470 @@
+package localfile
+
+import (
+  "bytes"
+  "encoding/csv"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+  "reflect"
+  "sort"
+  "strings"
+  "sync"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// FileDB stores each registered type in its own CSV or JSON file, picked by the file extension.
+//
+// CSV files have a header row and columns are matched to fields by name, like sheets. JSON files hold
+// an array of objects keyed by column name. Columns that aren't mapped to a field are kept as they
+// are when the file is rewritten. Files that don't exist yet read as empty and are created on the
+// first insert.
+type FileDB struct {
+  mu sync.Mutex
+  files map[reflect.Type]string
+}
+
+func NewFileDB() *FileDB {
+  return &FileDB{
+    files: make(map[reflect.Type]string),
+  }
+}
+
+func (db *FileDB) Register(t interface{}, filename string) *FileDB {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  db.files[reflect.TypeOf(t)] = filename
+  return db
+}
+
+// The contents of a file. Values are strings for CSV and decoded JSON values for JSON.
+type fileData struct {
+  columns []string
+  rows []map[string]interface{}
+}
+
+type format interface {
+  read(r io.Reader) (*fileData, error)
+  write(w io.Writer, d *fileData) error
+  // Set a field from a stored value.
+  get(f *littledb.Field, stored interface{}, v reflect.Value) error
+  // The value to store for a field.
+  put(f *littledb.Field, v reflect.Value) interface{}
+}
+
+type csvFormat struct{}
+
+func (csvFormat) read(r io.Reader) (*fileData, error) {
+  records, err := csv.NewReader(r).ReadAll()
+  if err != nil {
+    return nil, err
+  }
+  d := &fileData{}
+  if len(records) == 0 {
+    return d, nil
+  }
+  d.columns = records[0]
+  for _, record := range records[1:] {
+    row := make(map[string]interface{})
+    for i, col := range d.columns {
+      if i < len(record) {
+        row[col] = record[i]
+      }
+    }
+    d.rows = append(d.rows, row)
+  }
+  return d, nil
+}
+
+func (csvFormat) write(w io.Writer, d *fileData) error {
+  cw := csv.NewWriter(w)
+  if err := cw.Write(d.columns); err != nil {
+    return err
+  }
+  for _, row := range d.rows {
+    record := make([]string, len(d.columns))
+    for i, col := range d.columns {
+      if s, ok := row[col].(string); ok {
+        record[i] = s
+      }
+    }
+    if err := cw.Write(record); err != nil {
+      return err
+    }
+  }
+  cw.Flush()
+  return cw.Error()
+}
+
+func (csvFormat) get(f *littledb.Field, stored interface{}, v reflect.Value) error {
+  s, _ := stored.(string)
+  return f.ParseValue(v, s)
+}
+
+func (csvFormat) put(f *littledb.Field, v reflect.Value) interface{} {
+  return f.FormatValue(v)
+}
+
+type jsonFormat struct{}
+
+func (jsonFormat) read(r io.Reader) (*fileData, error) {
+  dec := json.NewDecoder(r)
+  // Keep big integers intact for fields we don't know about.
+  dec.UseNumber()
+  d := &fileData{}
+  if err := dec.Decode(&d.rows); err != nil && err != io.EOF {
+    return nil, err
+  }
+  // JSON objects don't keep their key order, so sort the columns to keep rewrites stable.
+  seen := make(map[string]bool)
+  for _, row := range d.rows {
+    for col := range row {
+      if !seen[col] {
+        seen[col] = true
+        d.columns = append(d.columns, col)
+      }
+    }
+  }
+  sort.Strings(d.columns)
+  return d, nil
+}
+
+func (jsonFormat) write(w io.Writer, d *fileData) error {
+  rows := d.rows
+  if rows == nil {
+    rows = []map[string]interface{}{}
+  }
+  bs, err := json.MarshalIndent(rows, "", "  ")
+  if err != nil {
+    return err
+  }
+  _, err = w.Write(append(bs, '\n'))
+  return err
+}
+
+// Times are RFC 3339 in JSON files, so field layouts and timezones don't apply.
+func (jsonFormat) get(f *littledb.Field, stored interface{}, v reflect.Value) error {
+  if stored == nil {
+    v.Set(reflect.Zero(v.Type()))
+    return nil
+  }
+  bs, err := json.Marshal(stored)
+  if err != nil {
+    return err
+  }
+  // Unmarshal into a fresh value so nothing from the old contents of v leaks through.
+  p := reflect.New(v.Type())
+  if err := json.Unmarshal(bs, p.Interface()); err != nil {
+    return err
+  }
+  v.Set(p.Elem())
+  return nil
+}
+
+func (jsonFormat) put(f *littledb.Field, v reflect.Value) interface{} {
+  return v.Interface()
+}
+
+func formatFor(filename string) (format, error) {
+  switch strings.ToLower(filepath.Ext(filename)) {
+  case ".csv":
+    return csvFormat{}, nil
+  case ".json":
+    return jsonFormat{}, nil
+  }
+  return nil, fmt.Errorf("unknown file type: %s", filename)
+}
+
+// A loaded file plus what's needed to map its rows to and from structs.
+type table struct {
+  filename string
+  format format
+  schema *littledb.Schema
+  data *fileData
+}
+
+// Load the file for a type. Must be called with mu held.
+func (db *FileDB) load(t reflect.Type) (*table, error) {
+  filename, ok := db.files[t]
+  if !ok {
+    return nil, fmt.Errorf("no file registered for type: %+v", t)
+  }
+  f, err := formatFor(filename)
+  if err != nil {
+    return nil, err
+  }
+  schema, err := littledb.SchemaOf(t)
+  if err != nil {
+    return nil, err
+  }
+
+  tbl := &table{
+    filename: filename,
+    format: f,
+    schema: schema,
+    data: &fileData{},
+  }
+  file, err := os.Open(filename)
+  if errors.Is(err, os.ErrNotExist) {
+    return tbl, nil
+  } else if err != nil {
+    return nil, err
+  }
+  defer file.Close()
+  if tbl.data, err = f.read(file); err != nil {
+    return nil, fmt.Errorf("%s: %w", filename, err)
+  }
+  return tbl, nil
+}
+
+// Write the file back out, through a temp file so a failed write doesn't lose the old contents.
+func (tbl *table) save() error {
+  // Add any columns the file didn't have yet, e.g. when it's new.
+  for _, f := range tbl.schema.Fields {
+    found := false
+    for _, col := range tbl.data.columns {
+      found = found || col == f.Name
+    }
+    if !found {
+      tbl.data.columns = append(tbl.data.columns, f.Name)
+    }
+  }
+
+  buf := &bytes.Buffer{}
+  if err := tbl.format.write(buf, tbl.data); err != nil {
+    return err
+  }
+  tmp := tbl.filename + ".tmp"
+  if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+    return err
+  }
+  return os.Rename(tmp, tbl.filename)
+}
+
+// Set the fields of vRow from row i.
+func (tbl *table) get(i int, vRow reflect.Value) error {
+  for j, f := range tbl.schema.Fields {
+    if err := tbl.format.get(&tbl.schema.Fields[j], tbl.data.rows[i][f.Name], f.Value(vRow)); err != nil {
+      return fmt.Errorf("%s: row %d, column %q: %w", tbl.filename, i+1, f.Name, err)
+    }
+  }
+  tbl.schema.CombineDates(vRow)
+  return nil
+}
+
+// Copy the mapped fields of vRow into a stored row.
+func (tbl *table) put(vRow reflect.Value, row map[string]interface{}) {
+  for i, f := range tbl.schema.Fields {
+    row[f.Name] = tbl.format.put(&tbl.schema.Fields[i], f.Value(vRow))
+  }
+}
+
+// Find the row with the same key as vRow, or -1. Keys are compared by their text form, after a trip
+// through the field type so e.g. 1 and 1.0 in a JSON file are the same.
+func (tbl *table) find(key *littledb.Field, vRow reflect.Value) (int, error) {
+  k := littledb.FormatValue(key.Value(vRow))
+  v := reflect.New(key.Type).Elem()
+  for i, row := range tbl.data.rows {
+    if err := tbl.format.get(key, row[key.Name], v); err != nil {
+      return -1, fmt.Errorf("%s: row %d, column %q: %w", tbl.filename, i+1, key.Name, err)
+    }
+    if littledb.FormatValue(v) == k {
+      return i, nil
+    }
+  }
+  return -1, nil
+}
+
+type fileExecutor struct {
+  data interface{}
+  db *FileDB
+}
+
+func (ex *fileExecutor) Query(clauses *littledb.QueryClauses) error {
+  tElem, err := littledb.SliceElemType(ex.data)
+  if err != nil {
+    return err
+  }
+  ex.db.mu.Lock()
+  tbl, err := ex.db.load(tElem)
+  ex.db.mu.Unlock()
+  if err != nil {
+    return err
+  }
+
+  vContainer := reflect.ValueOf(ex.data).Elem()
+  n := len(tbl.data.rows)
+  out := reflect.MakeSlice(vContainer.Type(), n, n)
+  for i := 0; i < n; i++ {
+    if err := tbl.get(i, out.Index(i)); err != nil {
+      return err
+    }
+  }
+  vContainer.Set(out)
+  return littledb.ApplyClauses(ex.data, clauses)
+}
+
+func (db *FileDB) Query(data interface{}) *littledb.PartialQuery {
+  return &littledb.PartialQuery{
+    Ex: &fileExecutor{
+      data: data,
+      db: db,
+    },
+    Data: data,
+    DB: db,
+  }
+}
+
+func (db *FileDB) Insert(data interface{}) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  tbl, err := db.load(t)
+  if err != nil {
+    return err
+  }
+  key, keyErr := tbl.schema.Key()
+
+  for _, vRow := range rows {
+    if keyErr == nil {
+      i, err := tbl.find(key, vRow)
+      if err != nil {
+        return err
+      }
+      if i >= 0 {
+        return fmt.Errorf("duplicate key: %s = %q", key.Name, littledb.FormatValue(key.Value(vRow)))
+      }
+    }
+    row := make(map[string]interface{})
+    tbl.put(vRow, row)
+    tbl.data.rows = append(tbl.data.rows, row)
+  }
+  return tbl.save()
+}
+
+func (db *FileDB) Update(data interface{}) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  tbl, err := db.load(t)
+  if err != nil {
+    return err
+  }
+  key, err := tbl.schema.Key()
+  if err != nil {
+    return err
+  }
+
+  for _, vRow := range rows {
+    i, err := tbl.find(key, vRow)
+    if err != nil {
+      return err
+    }
+    if i < 0 {
+      return fmt.Errorf("%w: %s = %q", littledb.ErrNotFound, key.Name, littledb.FormatValue(key.Value(vRow)))
+    }
+    tbl.put(vRow, tbl.data.rows[i])
+  }
+  return tbl.save()
+}
+
+func (db *FileDB) Delete(data interface{}) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  tbl, err := db.load(t)
+  if err != nil {
+    return err
+  }
+  key, err := tbl.schema.Key()
+  if err != nil {
+    return err
+  }
+
+  remove := make(map[int]bool)
+  for _, vRow := range rows {
+    i, err := tbl.find(key, vRow)
+    if err != nil {
+      return err
+    }
+    if i < 0 {
+      return fmt.Errorf("%w: %s = %q", littledb.ErrNotFound, key.Name, littledb.FormatValue(key.Value(vRow)))
+    }
+    remove[i] = true
+  }
+  kept := []map[string]interface{}{}
+  for i, row := range tbl.data.rows {
+    if !remove[i] {
+      kept = append(kept, row)
+    }
+  }
+  tbl.data.rows = kept
+  return tbl.save()
+}
+
+// Validate compares the columns in every registered file with its type's tags. JSON files are checked
+// against the keys used by any of their rows, so an empty JSON file never drifts.
+func (db *FileDB) Validate() ([]*littledb.Drift, error) {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  drifts := []*littledb.Drift{}
+  for t, filename := range db.files {
+    tbl, err := db.load(t)
+    if err != nil {
+      return nil, err
+    }
+    var d *littledb.Drift
+    _, isJSON := tbl.format.(jsonFormat)
+    if isJSON && len(tbl.data.rows) == 0 {
+      d = &littledb.Drift{Type: t}
+    } else {
+      d = littledb.CompareColumns(tbl.schema, tbl.data.columns)
+    }
+    d.Table = filename
+    if _, err := os.Stat(filename); errors.Is(err, os.ErrNotExist) {
+      d.NoTable = true
+    } else if !isJSON && len(tbl.data.columns) == 0 {
+      d.NoTable = true
+    }
+    drifts = append(drifts, d)
+  }
+  sort.Slice(drifts, func(i, j int) bool { return drifts[i].Table < drifts[j].Table })
+  return drifts, nil
+}
+
+// CreateMissing writes a header row (or an empty JSON array) for registered files that don't exist
+// or are empty.
+func (db *FileDB) CreateMissing() error {
+  drifts, err := db.Validate()
+  if err != nil {
+    return err
+  }
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  for _, d := range drifts {
+    if !d.NoTable {
+      continue
+    }
+    tbl, err := db.load(d.Type)
+    if err != nil {
+      return err
+    }
+    if err := tbl.save(); err != nil {
+      return err
+    }
+  }
+  return nil
+}
@@ -0,0 +1,147 @@
+package localfile
+
+import (
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+  "time"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+  "github.com/davedolben/dev-tools/go/littledb/dbtest"
+)
+
+func TestConformanceCSV(t *testing.T) {
+  dbtest.Run(t, func(t *testing.T) littledb.DB {
+    return NewFileDB().Register(dbtest.Row{}, filepath.Join(t.TempDir(), "rows.csv"))
+  })
+}
+
+func TestConformanceJSON(t *testing.T) {
+  dbtest.Run(t, func(t *testing.T) littledb.DB {
+    return NewFileDB().Register(dbtest.Row{}, filepath.Join(t.TempDir(), "rows.json"))
+  })
+}
+
+func TestValidate(t *testing.T) {
+  for _, ext := range []string{".csv", ".json"} {
+    t.Run(ext, func(t *testing.T) {
+      dbtest.RunValidate(t, func(t *testing.T) func(row interface{}) dbtest.ValidatingDB {
+        filename := filepath.Join(t.TempDir(), "rows" + ext)
+        return func(row interface{}) dbtest.ValidatingDB {
+          return NewFileDB().Register(row, filename)
+        }
+      })
+    })
+  }
+}
+
+func TestRelations(t *testing.T) {
+  for _, ext := range []string{".csv", ".json"} {
+    t.Run(ext, func(t *testing.T) {
+      dbtest.RunRelations(t, func(t *testing.T) littledb.DB {
+        dir := t.TempDir()
+        return NewFileDB().
+          Register(dbtest.Project{}, filepath.Join(dir, "projects" + ext)).
+          Register(dbtest.Task{}, filepath.Join(dir, "tasks" + ext))
+      })
+    })
+  }
+}
+
+type timeWindow struct {
+  What string `littledb:"What,key"`
+  Date string `littledb:"Date"`
+}
+
+// Columns the struct doesn't map have to survive a rewrite.
+func TestKeepsUnmappedColumns(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "times.csv")
+  err := os.WriteFile(filename, []byte("What,Date,Notes\nAdmin,7/21/2021,keep me\nMeeting,7/22/2021,\n"), 0644)
+  if err != nil {
+    t.Fatal(err)
+  }
+  db := NewFileDB().Register(timeWindow{}, filename)
+  if err := db.Update(&timeWindow{What: "Admin", Date: "7/23/2021"}); err != nil {
+    t.Fatal(err)
+  }
+  bs, err := os.ReadFile(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  want := "What,Date,Notes\nAdmin,7/23/2021,keep me\nMeeting,7/22/2021,\n"
+  if string(bs) != want {
+    t.Errorf("got:\n%s\nwant:\n%s", bs, want)
+  }
+}
+
+func TestUnknownExtension(t *testing.T) {
+  db := NewFileDB().Register(dbtest.Row{}, filepath.Join(t.TempDir(), "rows.txt"))
+  rows := []dbtest.Row{}
+  err := db.Query(&rows).Do()
+  if err == nil || !strings.Contains(err.Error(), "unknown file type") {
+    t.Errorf("got %v, want an unknown file type error", err)
+  }
+}
+
+type sampleTimeWindow struct {
+  What string `littledb:"What"`
+  Date time.Time `littledb:"Date"`
+  In time.Time `littledb:"In,date=Date"`
+  // Empty for a window that's still open.
+  Out *time.Time `littledb:"Out,date=Date"`
+  Category string `littledb:"Category"`
+  Project string `littledb:"Project"`
+  Notes string `littledb:"Notes"`
+}
+
+type ganttTask struct {
+  Task string `littledb:"task,key"`
+  Start time.Time `littledb:"start"`
+  End time.Time `littledb:"end"`
+}
+
+// The data files checked into the repo read as they are.
+func TestSampleData(t *testing.T) {
+  db := NewFileDB().
+    Register(sampleTimeWindow{}, filepath.Join("..", "..", "..", "..", "timesheet", "sample_data.csv")).
+    Register(ganttTask{}, filepath.Join("..", "..", "..", "..", "gantt", "test-data.csv"))
+
+  windows := []sampleTimeWindow{}
+  if err := db.Query(&windows).Do(); err != nil {
+    t.Fatal(err)
+  }
+  if len(windows) != 188 {
+    t.Fatalf("got %d time windows, want 188", len(windows))
+  }
+  first := windows[0]
+  if first.What != "Admin" || first.Category != "Other" || !first.In.Equal(time.Date(2021, 7, 21, 10, 8, 29, 0, time.UTC)) ||
+      first.Out == nil || !first.Out.Equal(time.Date(2021, 7, 21, 11, 15, 24, 0, time.UTC)) {
+    t.Errorf("got first window %+v", first)
+  }
+  if last := windows[len(windows)-1]; last.What != "Comms" || last.Out != nil || !last.In.Equal(time.Date(2021, 8, 18, 12, 40, 0, 0, time.UTC)) {
+    t.Errorf("got last window %+v", last)
+  }
+
+  if err := db.Query(&windows).Where("Date", littledb.Ge, time.Date(2021, 8, 18, 0, 0, 0, 0, time.UTC)).Do(); err != nil {
+    t.Fatal(err)
+  }
+  if len(windows) != 6 {
+    t.Errorf("got %d windows on the last day, want 6", len(windows))
+  }
+  if err := db.Query(&windows).Where("What", littledb.Eq, "Meeting").Do(); err != nil {
+    t.Fatal(err)
+  }
+  if len(windows) != 60 {
+    t.Errorf("got %d meetings, want 60", len(windows))
+  }
+
+  tasks := []ganttTask{}
+  if err := db.Query(&tasks).OrderByDesc("end").Do(); err != nil {
+    t.Fatal(err)
+  }
+  if len(tasks) != 3 || tasks[0].Task != "Task 3" || !tasks[0].Start.Equal(time.Date(2020, 4, 25, 0, 0, 0, 0, time.UTC)) ||
+      !tasks[0].End.Equal(time.Date(2020, 5, 5, 0, 0, 0, 0, time.UTC)) {
+    t.Errorf("got tasks %+v", tasks)
+  }
+}
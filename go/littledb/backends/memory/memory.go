@@ -0,0 +1,206 @@
+package memory
+
+import (
+  "fmt"
+  "reflect"
+  "sync"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// MemoryDB keeps rows in memory, mostly for testing code that uses littledb.DB. Rows are copied in and
+// out, but the copies are shallow: pointer fields still point at the caller's data.
+type MemoryDB struct {
+  mu sync.Mutex
+  tables map[reflect.Type]*table
+}
+
+type table struct {
+  schema *littledb.Schema
+  // A reflect.Value holding a []T.
+  rows reflect.Value
+}
+
+func NewMemoryDB() *MemoryDB {
+  return &MemoryDB{
+    tables: make(map[reflect.Type]*table),
+  }
+}
+
+// Register creates an empty table for the type of t.
+func (db *MemoryDB) Register(t interface{}) *MemoryDB {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  tType := reflect.TypeOf(t)
+  db.tables[tType] = &table{
+    rows: reflect.MakeSlice(reflect.SliceOf(tType), 0, 0),
+  }
+  return db
+}
+
+// Look up the table for a type. Must be called with mu held.
+func (db *MemoryDB) table(t reflect.Type) (*table, error) {
+  tbl, ok := db.tables[t]
+  if !ok {
+    return nil, fmt.Errorf("no table registered for type: %+v", t)
+  }
+  if tbl.schema == nil {
+    schema, err := littledb.SchemaOf(t)
+    if err != nil {
+      return nil, err
+    }
+    tbl.schema = schema
+  }
+  return tbl, nil
+}
+
+// Only mapped fields are stored, to behave like the backends that store columns.
+func (tbl *table) copyFields(dst, src reflect.Value) reflect.Value {
+  for _, f := range tbl.schema.Fields {
+    v := f.Value(src)
+    if f.IsList() && !v.IsNil() {
+      // Don't share the backing array with the caller.
+      v = reflect.AppendSlice(reflect.MakeSlice(v.Type(), 0, v.Len()), v)
+    }
+    f.Value(dst).Set(v)
+  }
+  return dst
+}
+
+// Find the row in a []T with the same key as vRow, or -1.
+func findKey(rows reflect.Value, key *littledb.Field, vRow reflect.Value) int {
+  k := littledb.FormatValue(key.Value(vRow))
+  for i := 0; i < rows.Len(); i++ {
+    if littledb.FormatValue(key.Value(rows.Index(i))) == k {
+      return i
+    }
+  }
+  return -1
+}
+
+type memoryExecutor struct {
+  data interface{}
+  db *MemoryDB
+}
+
+func (ex *memoryExecutor) Query(clauses *littledb.QueryClauses) error {
+  tElem, err := littledb.SliceElemType(ex.data)
+  if err != nil {
+    return err
+  }
+
+  ex.db.mu.Lock()
+  tbl, err := ex.db.table(tElem)
+  if err != nil {
+    ex.db.mu.Unlock()
+    return err
+  }
+  out := reflect.MakeSlice(tbl.rows.Type(), tbl.rows.Len(), tbl.rows.Len())
+  reflect.Copy(out, tbl.rows)
+  ex.db.mu.Unlock()
+
+  reflect.ValueOf(ex.data).Elem().Set(out)
+  return littledb.ApplyClauses(ex.data, clauses)
+}
+
+func (db *MemoryDB) Query(data interface{}) *littledb.PartialQuery {
+  return &littledb.PartialQuery{
+    Ex: &memoryExecutor{
+      data: data,
+      db: db,
+    },
+    Data: data,
+    DB: db,
+  }
+}
+
+func (db *MemoryDB) Insert(data interface{}) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  tbl, err := db.table(t)
+  if err != nil {
+    return err
+  }
+  key, keyErr := tbl.schema.Key()
+
+  // Build the new slice first so a duplicate key part way through doesn't leave half the rows in.
+  newRows := reflect.AppendSlice(reflect.MakeSlice(tbl.rows.Type(), 0, tbl.rows.Len()+len(rows)), tbl.rows)
+  for _, vRow := range rows {
+    if keyErr == nil {
+      if findKey(newRows, key, vRow) >= 0 {
+        return fmt.Errorf("duplicate key: %s = %q", key.Name, littledb.FormatValue(key.Value(vRow)))
+      }
+    }
+    newRows = reflect.Append(newRows, tbl.copyFields(reflect.New(tbl.schema.Type).Elem(), vRow))
+  }
+  tbl.rows = newRows
+  return nil
+}
+
+func (db *MemoryDB) Update(data interface{}) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  tbl, err := db.table(t)
+  if err != nil {
+    return err
+  }
+  key, err := tbl.schema.Key()
+  if err != nil {
+    return err
+  }
+
+  indexes := []int{}
+  for _, vRow := range rows {
+    i := findKey(tbl.rows, key, vRow)
+    if i < 0 {
+      return fmt.Errorf("%w: %s = %q", littledb.ErrNotFound, key.Name, littledb.FormatValue(key.Value(vRow)))
+    }
+    indexes = append(indexes, i)
+  }
+  for j, i := range indexes {
+    tbl.copyFields(tbl.rows.Index(i), rows[j])
+  }
+  return nil
+}
+
+func (db *MemoryDB) Delete(data interface{}) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  tbl, err := db.table(t)
+  if err != nil {
+    return err
+  }
+  key, err := tbl.schema.Key()
+  if err != nil {
+    return err
+  }
+
+  remove := make(map[int]bool)
+  for _, vRow := range rows {
+    i := findKey(tbl.rows, key, vRow)
+    if i < 0 {
+      return fmt.Errorf("%w: %s = %q", littledb.ErrNotFound, key.Name, littledb.FormatValue(key.Value(vRow)))
+    }
+    remove[i] = true
+  }
+  kept := reflect.MakeSlice(tbl.rows.Type(), 0, tbl.rows.Len()-len(remove))
+  for i := 0; i < tbl.rows.Len(); i++ {
+    if !remove[i] {
+      kept = reflect.Append(kept, tbl.rows.Index(i))
+    }
+  }
+  tbl.rows = kept
+  return nil
+}
@@ -0,0 +1,20 @@
+package memory
+
+import (
+  "testing"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+  "github.com/davedolben/dev-tools/go/littledb/dbtest"
+)
+
+func TestConformance(t *testing.T) {
+  dbtest.Run(t, func(t *testing.T) littledb.DB {
+    return NewMemoryDB().Register(dbtest.Row{})
+  })
+}
+
+func TestRelations(t *testing.T) {
+  dbtest.RunRelations(t, func(t *testing.T) littledb.DB {
+    return NewMemoryDB().Register(dbtest.Project{}).Register(dbtest.Task{})
+  })
+}
@@ -0,0 +1,700 @@
+package sqlite
+
+import (
+  "database/sql"
+  "database/sql/driver"
+  "fmt"
+  "reflect"
+  "sort"
+  "strings"
+  "sync"
+  "time"
+
+  _ "github.com/glebarez/go-sqlite"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+  "github.com/davedolben/dev-tools/go/sqlutil"
+)
+
+// Times are stored as fixed width UTC strings so they sort and compare correctly as text.
+const timeLayout = "2006-01-02T15:04:05.000000000Z"
+
+var (
+  timeType = reflect.TypeOf(time.Time{})
+  scannerType = reflect.TypeOf((*sql.Scanner)(nil)).Elem()
+  valuerType = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+)
+
+// SQLiteDB stores each registered type in its own table. Columns come from the littledb tags, and
+// the key field becomes the primary key. Call Migrate after registering types to create or extend
+// the tables.
+type SQLiteDB struct {
+  db *sql.DB
+  mu sync.Mutex
+  tables map[reflect.Type]string
+}
+
+func NewSQLiteDB(db *sql.DB) *SQLiteDB {
+  return &SQLiteDB{
+    db: db,
+    tables: make(map[reflect.Type]string),
+  }
+}
+
+// Open a database file with the glebarez/go-sqlite driver.
+func Open(filename string) (*SQLiteDB, error) {
+  db, err := sql.Open("sqlite", filename)
+  if err != nil {
+    return nil, err
+  }
+  return NewSQLiteDB(db), nil
+}
+
+func (db *SQLiteDB) DB() *sql.DB {
+  return db.db
+}
+
+func (db *SQLiteDB) Close() error {
+  return db.db.Close()
+}
+
+func (db *SQLiteDB) Register(t interface{}, table string) *SQLiteDB {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  db.tables[reflect.TypeOf(t)] = table
+  return db
+}
+
+func (db *SQLiteDB) tableFor(t reflect.Type) (string, *littledb.Schema, error) {
+  db.mu.Lock()
+  table, ok := db.tables[t]
+  db.mu.Unlock()
+  if !ok {
+    return "", nil, fmt.Errorf("no table registered for type: %+v", t)
+  }
+  schema, err := littledb.SchemaOf(t)
+  if err != nil {
+    return "", nil, err
+  }
+  return table, schema, nil
+}
+
+func quote(name string) string {
+  return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func columnType(f *littledb.Field) (string, error) {
+  if f.IsList() {
+    return "TEXT", nil
+  }
+  t := f.Type
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  switch {
+  case t == timeType:
+    return "TEXT", nil
+  case reflect.PointerTo(t).Implements(scannerType) || t.Implements(valuerType):
+    // Let the type decide what it stores.
+    return "", nil
+  }
+  switch t.Kind() {
+  case reflect.String:
+    return "TEXT", nil
+  case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+    reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return "INTEGER", nil
+  case reflect.Float32, reflect.Float64:
+    return "REAL", nil
+  }
+  return "", fmt.Errorf("field %q: unsupported type %v", f.Name, f.Type)
+}
+
+func columnDef(f *littledb.Field) (string, error) {
+  typ, err := columnType(f)
+  if err != nil {
+    return "", err
+  }
+  def := quote(f.Name)
+  if typ != "" {
+    def += " " + typ
+  }
+  if f.Key {
+    def += " PRIMARY KEY NOT NULL"
+  }
+  return def, nil
+}
+
+// The columns of a table, in order. Empty if the table doesn't exist.
+func (db *SQLiteDB) columns(table string) ([]string, error) {
+  rows, err := db.db.Query(`SELECT name FROM pragma_table_info(?) ORDER BY cid`, table)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+  cols := []string{}
+  for rows.Next() {
+    var name string
+    if err := rows.Scan(&name); err != nil {
+      return nil, err
+    }
+    cols = append(cols, name)
+  }
+  return cols, rows.Err()
+}
+
+// The statements needed to bring a table in line with its schema. Columns are only ever added:
+// dropping or renaming them is left to hand-written migrations.
+func (db *SQLiteDB) tableChanges(table string, schema *littledb.Schema) ([]string, error) {
+  cols, err := db.columns(table)
+  if err != nil {
+    return nil, err
+  }
+  existing := make(map[string]bool)
+  for _, c := range cols {
+    existing[c] = true
+  }
+
+  if len(existing) == 0 {
+    defs := []string{}
+    for i := range schema.Fields {
+      def, err := columnDef(&schema.Fields[i])
+      if err != nil {
+        return nil, err
+      }
+      defs = append(defs, def)
+    }
+    return []string{fmt.Sprintf("CREATE TABLE %s (\n  %s\n)", quote(table), strings.Join(defs, ",\n  "))}, nil
+  }
+
+  stmts := []string{}
+  for i := range schema.Fields {
+    f := &schema.Fields[i]
+    if existing[f.Name] {
+      continue
+    }
+    if f.Key {
+      return nil, fmt.Errorf("table %s: can't add key column %q to an existing table", table, f.Name)
+    }
+    def, err := columnDef(f)
+    if err != nil {
+      return nil, err
+    }
+    stmts = append(stmts, fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", quote(table), def))
+  }
+  return stmts, nil
+}
+
+// Migrate creates missing tables and adds missing columns for every registered type. The statements
+// are applied as one sqlutil migration, numbered after the highest version already in the
+// migrations table. Hand-written migrations passed in run first, so they can rename or drop columns
+// before the tables are compared with the types; on a new database they run before any table
+// exists. They share version numbers with the generated steps, and MigrateDB skips anything at or
+// below the highest applied version, so number a new one above every version in the databases it
+// has to reach.
+func (db *SQLiteDB) Migrate(migrations ...sqlutil.Migration) error {
+  if len(migrations) > 0 {
+    if err := sqlutil.MigrateDB(db.db, migrations); err != nil {
+      return err
+    }
+  }
+
+  db.mu.Lock()
+  types := []reflect.Type{}
+  for t := range db.tables {
+    types = append(types, t)
+  }
+  db.mu.Unlock()
+  // Keep the statements in a stable order.
+  sort.Slice(types, func(i, j int) bool { return types[i].String() < types[j].String() })
+
+  stmts := []string{}
+  for _, t := range types {
+    table, schema, err := db.tableFor(t)
+    if err != nil {
+      return err
+    }
+    changes, err := db.tableChanges(table, schema)
+    if err != nil {
+      return err
+    }
+    stmts = append(stmts, changes...)
+  }
+  if len(stmts) == 0 {
+    return nil
+  }
+
+  version, err := sqlutil.AppliedVersion(db.db)
+  if err != nil {
+    return err
+  }
+  return sqlutil.MigrateDB(db.db, []sqlutil.Migration{{
+    Version: version + 1,
+    Up: func(sqlDB *sql.DB) error {
+      tx, err := sqlDB.Begin()
+      if err != nil {
+        return err
+      }
+      defer tx.Rollback()
+      for _, stmt := range stmts {
+        if _, err := tx.Exec(stmt); err != nil {
+          return fmt.Errorf("%s: %w", stmt, err)
+        }
+      }
+      return tx.Commit()
+    },
+  }})
+}
+
+// Validate compares every registered table's columns with its type's tags.
+func (db *SQLiteDB) Validate() ([]*littledb.Drift, error) {
+  db.mu.Lock()
+  types := []reflect.Type{}
+  for t := range db.tables {
+    types = append(types, t)
+  }
+  db.mu.Unlock()
+
+  drifts := []*littledb.Drift{}
+  for _, t := range types {
+    table, schema, err := db.tableFor(t)
+    if err != nil {
+      return nil, err
+    }
+    cols, err := db.columns(table)
+    if err != nil {
+      return nil, err
+    }
+    d := littledb.CompareColumns(schema, cols)
+    d.Table = table
+    d.NoTable = len(cols) == 0
+    drifts = append(drifts, d)
+  }
+  sort.Slice(drifts, func(i, j int) bool { return drifts[i].Table < drifts[j].Table })
+  return drifts, nil
+}
+
+// CreateMissing is the same as Migrate, which also adds missing columns to existing tables.
+func (db *SQLiteDB) CreateMissing() error {
+  return db.Migrate()
+}
+
+// Convert a field or clause value to a query parameter.
+func toSQL(v reflect.Value) (interface{}, error) {
+  for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+    if v.IsNil() {
+      return nil, nil
+    }
+    if v.Type().Implements(valuerType) {
+      return v.Interface(), nil
+    }
+    v = v.Elem()
+  }
+  if !v.IsValid() {
+    return nil, nil
+  }
+  if v.Type() == timeType {
+    return v.Interface().(time.Time).UTC().Format(timeLayout), nil
+  }
+  if v.Type().Implements(valuerType) {
+    return v.Interface(), nil
+  }
+  if reflect.PointerTo(v.Type()).Implements(valuerType) {
+    // Value has a pointer receiver. Rows passed by value aren't addressable.
+    p := reflect.New(v.Type())
+    p.Elem().Set(v)
+    return p.Interface(), nil
+  }
+  switch v.Kind() {
+  case reflect.String:
+    return v.String(), nil
+  case reflect.Bool:
+    return v.Bool(), nil
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return v.Int(), nil
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return int64(v.Uint()), nil
+  case reflect.Float32, reflect.Float64:
+    return v.Float(), nil
+  }
+  return nil, fmt.Errorf("unsupported type %v", v.Type())
+}
+
+// Set a field from a scanned column.
+func fromSQL(v reflect.Value, src interface{}) error {
+  if src == nil {
+    v.Set(reflect.Zero(v.Type()))
+    return nil
+  }
+  if v.Kind() == reflect.Ptr {
+    p := reflect.New(v.Type().Elem())
+    if err := fromSQL(p.Elem(), src); err != nil {
+      return err
+    }
+    v.Set(p)
+    return nil
+  }
+  if v.Addr().Type().Implements(scannerType) {
+    return v.Addr().Interface().(sql.Scanner).Scan(src)
+  }
+
+  if b, ok := src.([]byte); ok {
+    src = string(b)
+  }
+  if v.Type() == timeType {
+    switch s := src.(type) {
+    case time.Time:
+      v.Set(reflect.ValueOf(s))
+      return nil
+    case string:
+      t, err := time.Parse(timeLayout, s)
+      if err != nil {
+        return err
+      }
+      v.Set(reflect.ValueOf(t))
+      return nil
+    }
+    return fmt.Errorf("can't scan %T into a time", src)
+  }
+
+  switch s := src.(type) {
+  case int64:
+    switch v.Kind() {
+    case reflect.Bool:
+      v.SetBool(s != 0)
+      return nil
+    case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+      v.SetInt(s)
+      return nil
+    case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+      v.SetUint(uint64(s))
+      return nil
+    case reflect.Float32, reflect.Float64:
+      v.SetFloat(float64(s))
+      return nil
+    }
+  case float64:
+    if v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64 {
+      v.SetFloat(s)
+      return nil
+    }
+  case bool:
+    if v.Kind() == reflect.Bool {
+      v.SetBool(s)
+      return nil
+    }
+  case string:
+    return littledb.ParseValue(v, s)
+  }
+  return fmt.Errorf("can't scan %T into %v", src, v.Type())
+}
+
+// Lists are stored as delimited text, and empty lists as NULL.
+func fieldToSQL(f *littledb.Field, v reflect.Value) (interface{}, error) {
+  if !f.IsList() {
+    return toSQL(v)
+  }
+  if v.Len() == 0 {
+    return nil, nil
+  }
+  return f.FormatValue(v), nil
+}
+
+func fieldFromSQL(f *littledb.Field, v reflect.Value, src interface{}) error {
+  if !f.IsList() {
+    return fromSQL(v, src)
+  }
+  switch s := src.(type) {
+  case nil:
+    v.Set(reflect.Zero(v.Type()))
+    return nil
+  case []byte:
+    return f.ParseValue(v, string(s))
+  case string:
+    return f.ParseValue(v, s)
+  }
+  return fmt.Errorf("can't scan %T into %v", src, v.Type())
+}
+
+// Translate a clause tree into a WHERE expression and its parameters.
+func whereSQL(schema *littledb.Schema, c *littledb.Clause, args []interface{}) (string, []interface{}, error) {
+  if !c.IsLeaf() {
+    if len(c.Children) == 0 {
+      // Same as the in-memory evaluation: an empty AND matches everything, an empty OR nothing.
+      if c.Conj == littledb.AndConj {
+        return "1", args, nil
+      }
+      return "0", args, nil
+    }
+    joiner := " AND "
+    if c.Conj == littledb.OrConj {
+      joiner = " OR "
+    }
+    parts := []string{}
+    for _, child := range c.Children {
+      part, newArgs, err := whereSQL(schema, child, args)
+      if err != nil {
+        return "", nil, err
+      }
+      args = newArgs
+      parts = append(parts, part)
+    }
+    return "(" + strings.Join(parts, joiner) + ")", args, nil
+  }
+
+  value, err := toSQL(reflect.ValueOf(c.Value))
+  if err != nil {
+    return "", nil, fmt.Errorf("field %q: %w", c.Field, err)
+  }
+  col := quote(c.Field)
+  if f, _ := schema.Field(c.Field); f.IsList() {
+    if c.Op != littledb.Contains {
+      return "", nil, fmt.Errorf("field %q: only contains works on lists", c.Field)
+    }
+    // Lists are stored joined with the separator, so wrap both sides in it to match whole items.
+    item := f.Separator + f.FormatValue(reflect.ValueOf(c.Value)) + f.Separator
+    return fmt.Sprintf("instr(? || %s || ?, ?) > 0", col), append(args, f.Separator, f.Separator, item), nil
+  }
+  switch c.Op {
+  case littledb.Contains:
+    // instr rather than LIKE, which is case-insensitive and treats % and _ specially.
+    return fmt.Sprintf("instr(%s, ?) > 0", col), append(args, fmt.Sprint(c.Value)), nil
+  case littledb.Eq, littledb.Ne:
+    if value == nil {
+      if c.Op == littledb.Eq {
+        return col + " IS NULL", args, nil
+      }
+      return col + " IS NOT NULL", args, nil
+    }
+  }
+  if value == nil {
+    // Everything sorts after NULL.
+    switch c.Op {
+    case littledb.Lt:
+      return "0", args, nil
+    case littledb.Le:
+      return col + " IS NULL", args, nil
+    case littledb.Gt:
+      return col + " IS NOT NULL", args, nil
+    case littledb.Ge:
+      return "1", args, nil
+    }
+  }
+  switch c.Op {
+  case littledb.Eq, littledb.Ne, littledb.Lt, littledb.Le, littledb.Gt, littledb.Ge:
+    // SQL comparisons with NULL are never true, but in the in-memory evaluation NULL sorts before
+    // everything. Spell that out so both give the same results.
+    nullResult := "0"
+    if c.Op == littledb.Ne || c.Op == littledb.Lt || c.Op == littledb.Le {
+      nullResult = "1"
+    }
+    expr := fmt.Sprintf("CASE WHEN %s IS NULL THEN %s ELSE %s %s ? END", col, nullResult, col, c.Op)
+    return expr, append(args, value), nil
+  }
+  return "", nil, fmt.Errorf("unknown operator %q", c.Op)
+}
+
+type sqliteExecutor struct {
+  data interface{}
+  db *SQLiteDB
+}
+
+func (ex *sqliteExecutor) Query(clauses *littledb.QueryClauses) error {
+  tElem, err := littledb.SliceElemType(ex.data)
+  if err != nil {
+    return err
+  }
+  table, schema, err := ex.db.tableFor(tElem)
+  if err != nil {
+    return err
+  }
+  if err := clauses.Validate(schema); err != nil {
+    return err
+  }
+
+  cols := []string{}
+  for _, f := range schema.Fields {
+    cols = append(cols, quote(f.Name))
+  }
+  query := fmt.Sprintf("SELECT %s FROM %s", strings.Join(cols, ", "), quote(table))
+  args := []interface{}{}
+  if clauses != nil {
+    if clauses.Where != nil {
+      where, whereArgs, err := whereSQL(schema, clauses.Where, args)
+      if err != nil {
+        return err
+      }
+      query += " WHERE " + where
+      args = whereArgs
+    }
+    orders := []string{}
+    for _, o := range clauses.OrderBy {
+      dir := "ASC"
+      if o.Desc {
+        dir = "DESC"
+      }
+      orders = append(orders, quote(o.Field)+" "+dir)
+    }
+    if len(orders) > 0 {
+      // rowid last so ties keep insertion order, like a stable sort.
+      query += " ORDER BY " + strings.Join(orders, ", ") + ", rowid"
+    }
+    if clauses.Limit > 0 || clauses.Offset > 0 {
+      limit := clauses.Limit
+      if limit == 0 {
+        limit = -1
+      }
+      query += " LIMIT ? OFFSET ?"
+      args = append(args, limit, clauses.Offset)
+    }
+  }
+
+  rows, err := ex.db.db.Query(query, args...)
+  if err != nil {
+    return err
+  }
+  defer rows.Close()
+
+  vContainer := reflect.ValueOf(ex.data).Elem()
+  out := reflect.MakeSlice(vContainer.Type(), 0, 0)
+  raw := make([]interface{}, len(cols))
+  dest := make([]interface{}, len(cols))
+  for i := range raw {
+    dest[i] = &raw[i]
+  }
+  for rows.Next() {
+    if err := rows.Scan(dest...); err != nil {
+      return err
+    }
+    vRow := reflect.New(tElem).Elem()
+    for i, f := range schema.Fields {
+      if err := fieldFromSQL(&schema.Fields[i], f.Value(vRow), raw[i]); err != nil {
+        return fmt.Errorf("%s: column %q: %w", table, f.Name, err)
+      }
+    }
+    out = reflect.Append(out, vRow)
+  }
+  if err := rows.Err(); err != nil {
+    return err
+  }
+  vContainer.Set(out)
+  return nil
+}
+
+func (db *SQLiteDB) Query(data interface{}) *littledb.PartialQuery {
+  return &littledb.PartialQuery{
+    Ex: &sqliteExecutor{
+      data: data,
+      db: db,
+    },
+    Data: data,
+    DB: db,
+  }
+}
+
+// The values of the mapped fields of a row, in schema order.
+func rowArgs(schema *littledb.Schema, vRow reflect.Value) ([]interface{}, error) {
+  args := []interface{}{}
+  for i, f := range schema.Fields {
+    arg, err := fieldToSQL(&schema.Fields[i], f.Value(vRow))
+    if err != nil {
+      return nil, fmt.Errorf("field %q: %w", f.Name, err)
+    }
+    args = append(args, arg)
+  }
+  return args, nil
+}
+
+// Run fn for every row in one transaction, so a failure part way through changes nothing.
+func (db *SQLiteDB) eachRow(data interface{}, fn func(tx *sql.Tx, table string, schema *littledb.Schema, vRow reflect.Value) error) error {
+  t, rows, err := littledb.Rows(data)
+  if err != nil {
+    return err
+  }
+  table, schema, err := db.tableFor(t)
+  if err != nil {
+    return err
+  }
+  tx, err := db.db.Begin()
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+  for _, vRow := range rows {
+    if err := fn(tx, table, schema, vRow); err != nil {
+      return err
+    }
+  }
+  return tx.Commit()
+}
+
+func (db *SQLiteDB) Insert(data interface{}) error {
+  return db.eachRow(data, func(tx *sql.Tx, table string, schema *littledb.Schema, vRow reflect.Value) error {
+    cols := []string{}
+    for _, f := range schema.Fields {
+      cols = append(cols, quote(f.Name))
+    }
+    args, err := rowArgs(schema, vRow)
+    if err != nil {
+      return err
+    }
+    placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(cols)), ", ")
+    _, err = tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quote(table), strings.Join(cols, ", "), placeholders), args...)
+    return err
+  })
+}
+
+func (db *SQLiteDB) Update(data interface{}) error {
+  return db.eachRow(data, func(tx *sql.Tx, table string, schema *littledb.Schema, vRow reflect.Value) error {
+    key, err := schema.Key()
+    if err != nil {
+      return err
+    }
+    sets := []string{}
+    for _, f := range schema.Fields {
+      sets = append(sets, quote(f.Name)+" = ?")
+    }
+    args, err := rowArgs(schema, vRow)
+    if err != nil {
+      return err
+    }
+    keyArg, err := toSQL(key.Value(vRow))
+    if err != nil {
+      return err
+    }
+    res, err := tx.Exec(fmt.Sprintf("UPDATE %s SET %s WHERE %s = ?", quote(table), strings.Join(sets, ", "), quote(key.Name)), append(args, keyArg)...)
+    if err != nil {
+      return err
+    }
+    return checkFound(res, key, vRow)
+  })
+}
+
+func (db *SQLiteDB) Delete(data interface{}) error {
+  return db.eachRow(data, func(tx *sql.Tx, table string, schema *littledb.Schema, vRow reflect.Value) error {
+    key, err := schema.Key()
+    if err != nil {
+      return err
+    }
+    keyArg, err := toSQL(key.Value(vRow))
+    if err != nil {
+      return err
+    }
+    res, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE %s = ?", quote(table), quote(key.Name)), keyArg)
+    if err != nil {
+      return err
+    }
+    return checkFound(res, key, vRow)
+  })
+}
+
+func checkFound(res sql.Result, key *littledb.Field, vRow reflect.Value) error {
+  n, err := res.RowsAffected()
+  if err != nil {
+    return err
+  }
+  if n == 0 {
+    return fmt.Errorf("%w: %s = %q", littledb.ErrNotFound, key.Name, littledb.FormatValue(key.Value(vRow)))
+  }
+  return nil
+}
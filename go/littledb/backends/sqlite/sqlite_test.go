@@ -0,0 +1,211 @@
+package sqlite
+
+import (
+  "database/sql"
+  "fmt"
+  "path/filepath"
+  "strings"
+  "testing"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+  "github.com/davedolben/dev-tools/go/littledb/dbtest"
+  "github.com/davedolben/dev-tools/go/sqlutil"
+)
+
+func TestConformance(t *testing.T) {
+  dbtest.Run(t, func(t *testing.T) littledb.DB {
+    db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+    if err != nil {
+      t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+    db.Register(dbtest.Row{}, "rows")
+    if err := db.Migrate(); err != nil {
+      t.Fatal(err)
+    }
+    return db
+  })
+}
+
+func TestRelations(t *testing.T) {
+  dbtest.RunRelations(t, func(t *testing.T) littledb.DB {
+    db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+    if err != nil {
+      t.Fatal(err)
+    }
+    t.Cleanup(func() { db.Close() })
+    db.Register(dbtest.Project{}, "projects").Register(dbtest.Task{}, "tasks")
+    if err := db.Migrate(); err != nil {
+      t.Fatal(err)
+    }
+    return db
+  })
+}
+
+func TestValidate(t *testing.T) {
+  dbtest.RunValidate(t, func(t *testing.T) func(row interface{}) dbtest.ValidatingDB {
+    filename := filepath.Join(t.TempDir(), "test.db")
+    return func(row interface{}) dbtest.ValidatingDB {
+      db, err := Open(filename)
+      if err != nil {
+        t.Fatal(err)
+      }
+      t.Cleanup(func() { db.Close() })
+      return db.Register(row, "rows")
+    }
+  })
+}
+
+type taskV1 struct {
+  ID int `littledb:"id,key"`
+  Name string `littledb:"name"`
+}
+
+type taskV2 struct {
+  ID int `littledb:"id,key"`
+  Name string `littledb:"name"`
+  Owner *string `littledb:"owner"`
+}
+
+// Adding a field to the struct adds a column on the next Migrate, and keeps the existing rows.
+func TestMigrateAddsColumns(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "test.db")
+  db, err := Open(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  db.Register(taskV1{}, "tasks")
+  if err := db.Migrate(); err != nil {
+    t.Fatal(err)
+  }
+  if err := db.Insert(&taskV1{ID: 1, Name: "first"}); err != nil {
+    t.Fatal(err)
+  }
+  db.Close()
+
+  db, err = Open(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer db.Close()
+  db.Register(taskV2{}, "tasks")
+  for i := 0; i < 2; i++ {
+    if err := db.Migrate(); err != nil {
+      t.Fatal(err)
+    }
+  }
+  // Creating the table and adding the column, as two sqlutil migrations.
+  if version, err := sqlutil.AppliedVersion(db.DB()); err != nil || version != 2 {
+    t.Errorf("got version %d (%v), want 2", version, err)
+  }
+
+  owner := "dave"
+  if err := db.Insert(&taskV2{ID: 2, Name: "second", Owner: &owner}); err != nil {
+    t.Fatal(err)
+  }
+  rows := []taskV2{}
+  if err := db.Query(&rows).OrderBy("id").Do(); err != nil {
+    t.Fatal(err)
+  }
+  if len(rows) != 2 || rows[0].Name != "first" || rows[0].Owner != nil || rows[1].Owner == nil || *rows[1].Owner != "dave" {
+    t.Errorf("got %+v", rows)
+  }
+}
+
+func appliedVersions(t *testing.T, db *SQLiteDB) string {
+  t.Helper()
+  rows, err := db.DB().Query(`SELECT version FROM migrations ORDER BY version`)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer rows.Close()
+  versions := []string{}
+  for rows.Next() {
+    var v int
+    if err := rows.Scan(&v); err != nil {
+      t.Fatal(err)
+    }
+    versions = append(versions, fmt.Sprint(v))
+  }
+  return strings.Join(versions, " ")
+}
+
+// Hand-written migrations run before the generated steps, and both are numbered in sqlutil's
+// migrations table.
+func TestMigrateWithHandWritten(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "test.db")
+  db, err := Open(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  ran := map[int]int{}
+  migration := func(version int, stmt string) sqlutil.Migration {
+    return sqlutil.Migration{
+      Version: version,
+      Up: func(sqlDB *sql.DB) error {
+        ran[version]++
+        _, err := sqlDB.Exec(stmt)
+        return err
+      },
+    }
+  }
+  settings := migration(1, `CREATE TABLE settings (name TEXT PRIMARY KEY, value TEXT)`)
+
+  db.Register(taskV1{}, "tasks")
+  if err := db.Migrate(settings); err != nil {
+    t.Fatal(err)
+  }
+  if got := appliedVersions(t, db); got != "1 2" {
+    t.Errorf("got versions %q, want 1 2", got)
+  }
+  db.Close()
+
+  db, err = Open(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer db.Close()
+  db.Register(taskV2{}, "tasks")
+  index := migration(3, `CREATE INDEX tasks_name ON tasks (name)`)
+  for i := 0; i < 2; i++ {
+    if err := db.Migrate(settings, index); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if ran[1] != 1 || ran[3] != 1 {
+    t.Errorf("hand-written migrations ran %v times", ran)
+  }
+  if got := appliedVersions(t, db); got != "1 2 3 4" {
+    t.Errorf("got versions %q, want 1 2 3 4", got)
+  }
+  drift, err := db.Validate()
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(drift) != 1 || !drift[0].OK() || len(drift[0].Extra) != 0 {
+    t.Errorf("got drift %v", drift)
+  }
+}
+
+// A failed step is rolled back and not recorded, so the next Migrate tries again.
+func TestMigrateFailure(t *testing.T) {
+  db, err := Open(filepath.Join(t.TempDir(), "test.db"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer db.Close()
+  // Columns can't be added to a view, so the projects table created before it is rolled back too.
+  if _, err := db.DB().Exec(`CREATE VIEW tasks AS SELECT 1 AS id`); err != nil {
+    t.Fatal(err)
+  }
+  db.Register(dbtest.Project{}, "projects").Register(taskV1{}, "tasks")
+  if err := db.Migrate(); err == nil {
+    t.Fatal("migrated")
+  }
+  if got := appliedVersions(t, db); got != "" {
+    t.Errorf("got versions %q", got)
+  }
+  if cols, err := db.columns("projects"); err != nil || len(cols) != 0 {
+    t.Errorf("got projects columns %v (%v)", cols, err)
+  }
+}
@@ -0,0 +1,270 @@
+package littledb
+
+import (
+  "fmt"
+  "math"
+  "reflect"
+  "strconv"
+  "strings"
+  "time"
+)
+
+// Layouts ParseTime tries, in order. These cover what Sheets and spreadsheet exports produce.
+var TimeLayouts = []string{
+  time.RFC3339Nano,
+  "2006-01-02 15:04:05",
+  "2006-01-02",
+  "1/2/2006 15:04:05",
+  "1/2/2006 3:04:05 PM",
+  "1/2/2006",
+  "01/02/2006",
+  "03:04:05 PM",
+  "3:04:05 PM",
+  "03:04 PM",
+  "3:04 PM",
+  "15:04",
+  "15:04:05",
+}
+
+func ParseTime(s string) (time.Time, error) {
+  return (*Field)(nil).ParseTime(s)
+}
+
+// FormatTime writes a time in a form ParseTime (and Sheets) reads back. Dates without a clock and
+// clock times without a date (year 0, as parsed from "3:04 PM") are written without the missing part.
+func FormatTime(t time.Time) string {
+  switch {
+  case t.IsZero():
+    return ""
+  case t.Year() == 0:
+    return t.Format("15:04:05")
+  case t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0:
+    return t.Format("1/2/2006")
+  }
+  return t.Format("1/2/2006 15:04:05")
+}
+
+func (f *Field) location() *time.Location {
+  if f == nil || f.Location == nil {
+    return time.UTC
+  }
+  return f.Location
+}
+
+// ParseTime parses a time with the field's layouts, or TimeLayouts, in the field's timezone. f can
+// be nil for the defaults.
+func (f *Field) ParseTime(s string) (time.Time, error) {
+  layouts := TimeLayouts
+  if f != nil && len(f.Layouts) > 0 {
+    layouts = f.Layouts
+  }
+  for _, layout := range layouts {
+    t, err := time.ParseInLocation(layout, s, f.location())
+    if err == nil {
+      return t, nil
+    }
+  }
+  return time.Time{}, fmt.Errorf("failed to parse string to date: %q", s)
+}
+
+// FormatTime formats a time with the field's first layout, in the field's timezone. Fields that take
+// their date from another field only write the time of day.
+func (f *Field) FormatTime(t time.Time) string {
+  if t.IsZero() {
+    return ""
+  }
+  t = t.In(f.location())
+  switch {
+  case f != nil && len(f.Layouts) > 0:
+    return t.Format(f.Layouts[0])
+  case f != nil && f.DateField != "":
+    return t.Format("15:04:05")
+  }
+  return FormatTime(t)
+}
+
+// TimeFromSerial converts a spreadsheet serial number (days since 1899-12-30, with the time of day as
+// the fraction) in the field's timezone. Values under 1 are times of day, and come back with year 0
+// like a parsed "3:04 PM".
+func (f *Field) TimeFromSerial(serial float64) time.Time {
+  days := math.Floor(serial)
+  secs := int(math.Round((serial - days) * 24 * 60 * 60))
+  if serial >= 0 && serial < 1 {
+    return time.Date(0, 1, 1, 0, 0, secs, 0, f.location())
+  }
+  // time.Date normalizes the overflowing day and second counts.
+  return time.Date(1899, 12, 30+int(days), 0, 0, secs, 0, f.location())
+}
+
+// CombineDates fills in the date of time-only fields from the field named by their "date" option.
+// Fields that already have a date are left alone.
+func (s *Schema) CombineDates(row reflect.Value) {
+  for _, f := range s.Fields {
+    if f.DateField == "" {
+      continue
+    }
+    df, _ := s.Field(f.DateField)
+    vClock := f.Value(row)
+    clock, ok := indirect(vClock)
+    date, dateOk := indirect(df.Value(row))
+    if !ok || !dateOk {
+      continue
+    }
+    c := clock.Interface().(time.Time)
+    d := date.Interface().(time.Time)
+    if c.IsZero() || c.Year() != 0 || d.IsZero() {
+      continue
+    }
+    combined := time.Date(d.Year(), d.Month(), d.Day(), c.Hour(), c.Minute(), c.Second(), c.Nanosecond(), f.location())
+    if vClock.Kind() == reflect.Ptr {
+      vClock.Set(reflect.ValueOf(&combined))
+    } else {
+      vClock.Set(reflect.ValueOf(combined))
+    }
+  }
+}
+
+// ParseValue sets a field from its text form, e.g. a CSV cell. Empty strings leave pointers nil and
+// everything else at its zero value.
+func ParseValue(v reflect.Value, s string) error {
+  if v.Kind() == reflect.Ptr {
+    if s == "" {
+      v.Set(reflect.Zero(v.Type()))
+      return nil
+    }
+    p := reflect.New(v.Type().Elem())
+    if err := ParseValue(p.Elem(), s); err != nil {
+      return err
+    }
+    v.Set(p)
+    return nil
+  }
+
+  if v.Type() == timeType {
+    t := time.Time{}
+    if s != "" {
+      var err error
+      if t, err = ParseTime(s); err != nil {
+        return err
+      }
+    }
+    v.Set(reflect.ValueOf(t))
+    return nil
+  }
+
+  if v.Kind() == reflect.String {
+    v.SetString(s)
+    return nil
+  }
+  s = strings.TrimSpace(s)
+  if s == "" {
+    v.Set(reflect.Zero(v.Type()))
+    return nil
+  }
+  switch {
+  case v.Kind() == reflect.Bool:
+    b, err := strconv.ParseBool(s)
+    if err != nil {
+      return err
+    }
+    v.SetBool(b)
+  case isInt(v):
+    n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+    if err != nil {
+      return err
+    }
+    v.SetInt(n)
+  case isUint(v):
+    n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+    if err != nil {
+      return err
+    }
+    v.SetUint(n)
+  case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+    f, err := strconv.ParseFloat(s, v.Type().Bits())
+    if err != nil {
+      return err
+    }
+    v.SetFloat(f)
+  default:
+    return fmt.Errorf("unsupported field type %v", v.Type())
+  }
+  return nil
+}
+
+// FormatValue is the inverse of ParseValue.
+func FormatValue(v reflect.Value) string {
+  v, ok := indirect(v)
+  if !ok {
+    return ""
+  }
+  if v.Type() == timeType {
+    return FormatTime(v.Interface().(time.Time))
+  }
+  switch {
+  case v.Kind() == reflect.String:
+    return v.String()
+  case v.Kind() == reflect.Bool:
+    return strconv.FormatBool(v.Bool())
+  case isInt(v):
+    return strconv.FormatInt(v.Int(), 10)
+  case isUint(v):
+    return strconv.FormatUint(v.Uint(), 10)
+  case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+    return strconv.FormatFloat(v.Float(), 'g', -1, v.Type().Bits())
+  }
+  return fmt.Sprint(v.Interface())
+}
+
+// ParseValue is like the package's ParseValue, but uses the field's layouts and timezone for times,
+// and splits lists on the field's separator.
+func (f *Field) ParseValue(v reflect.Value, s string) error {
+  if f.IsList() && v.Kind() == reflect.Slice {
+    return f.parseList(v, s)
+  }
+  if !isTimeType(v.Type()) || s == "" {
+    return ParseValue(v, s)
+  }
+  t, err := f.ParseTime(s)
+  if err != nil {
+    return err
+  }
+  if v.Kind() == reflect.Ptr {
+    v.Set(reflect.ValueOf(&t))
+  } else {
+    v.Set(reflect.ValueOf(t))
+  }
+  return nil
+}
+
+// FormatValue is the inverse of the field's ParseValue.
+func (f *Field) FormatValue(v reflect.Value) string {
+  if f.IsList() && v.Kind() == reflect.Slice {
+    parts := make([]string, v.Len())
+    for i := range parts {
+      parts[i] = f.FormatValue(v.Index(i))
+    }
+    return strings.Join(parts, f.Separator)
+  }
+  if t, ok := indirect(v); ok && t.Type() == timeType {
+    return f.FormatTime(t.Interface().(time.Time))
+  }
+  return FormatValue(v)
+}
+
+// Items are trimmed, so "a, b" and "a,b" are the same list. An empty string is a nil slice.
+func (f *Field) parseList(v reflect.Value, s string) error {
+  if strings.TrimSpace(s) == "" {
+    v.Set(reflect.Zero(v.Type()))
+    return nil
+  }
+  parts := strings.Split(s, f.Separator)
+  out := reflect.MakeSlice(v.Type(), len(parts), len(parts))
+  for i, part := range parts {
+    if err := f.ParseValue(out.Index(i), strings.TrimSpace(part)); err != nil {
+      return fmt.Errorf("item %d: %w", i, err)
+    }
+  }
+  v.Set(out)
+  return nil
+}
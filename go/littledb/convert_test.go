@@ -0,0 +1,168 @@
+package littledb
+
+import (
+  "reflect"
+  "strings"
+  "testing"
+  "time"
+  // Don't depend on the system's zoneinfo for the tz tests.
+  _ "time/tzdata"
+)
+
+func TestTimeFromSerial(t *testing.T) {
+  la, err := time.LoadLocation("America/Los_Angeles")
+  if err != nil {
+    t.Fatal(err)
+  }
+  tests := []struct {
+    serial float64
+    loc *time.Location
+    want time.Time
+  }{
+    {1, nil, time.Date(1899, 12, 31, 0, 0, 0, 0, time.UTC)},
+    {45355, nil, time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)},
+    {45355.75, nil, time.Date(2024, 3, 4, 18, 0, 0, 0, time.UTC)},
+    // Rounded to the nearest second.
+    {45355 + 1.0/86400 - 1e-9, nil, time.Date(2024, 3, 4, 0, 0, 1, 0, time.UTC)},
+    {45355.5, la, time.Date(2024, 3, 4, 12, 0, 0, 0, la)},
+    // Times of day without a date.
+    {0, nil, time.Date(0, 1, 1, 0, 0, 0, 0, time.UTC)},
+    {0.5, nil, time.Date(0, 1, 1, 12, 0, 0, 0, time.UTC)},
+    {0.25, la, time.Date(0, 1, 1, 6, 0, 0, 0, la)},
+    // Before the epoch.
+    {-1, nil, time.Date(1899, 12, 29, 0, 0, 0, 0, time.UTC)},
+  }
+  for _, tt := range tests {
+    var f *Field
+    if tt.loc != nil {
+      f = &Field{Location: tt.loc}
+    }
+    if got := f.TimeFromSerial(tt.serial); !got.Equal(tt.want) || got.Location() != tt.want.Location() {
+      t.Errorf("%v in %v: got %v, want %v", tt.serial, tt.loc, got, tt.want)
+    }
+  }
+}
+
+type shift struct {
+  Date time.Time `littledb:"Date"`
+  In time.Time `littledb:"In,date=Date,tz=America/Los_Angeles"`
+  Out *time.Time `littledb:"Out,date=Date"`
+  Logged time.Time `littledb:"Logged" littledb_layout:"2006-01-02 15:04|Jan 2, 2006"`
+}
+
+func TestCombineDates(t *testing.T) {
+  schema, err := SchemaOf(reflect.TypeOf(shift{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  la, _ := time.LoadLocation("America/Los_Angeles")
+  date := time.Date(2024, 3, 4, 0, 0, 0, 0, time.UTC)
+  out := time.Date(0, 1, 1, 17, 30, 0, 0, time.UTC)
+  dated := time.Date(2024, 1, 1, 9, 0, 0, 0, la)
+
+  tests := []struct {
+    name string
+    row shift
+    wantIn time.Time
+    wantOut *time.Time
+  }{
+    {
+      "clock times get the date",
+      shift{Date: date, In: time.Date(0, 1, 1, 9, 0, 0, 0, la), Out: &out},
+      time.Date(2024, 3, 4, 9, 0, 0, 0, la),
+      timePtr(time.Date(2024, 3, 4, 17, 30, 0, 0, time.UTC)),
+    },
+    {
+      "times that already have a date are left alone",
+      shift{Date: date, In: dated},
+      dated,
+      nil,
+    },
+    {
+      "no date to combine with",
+      shift{In: time.Date(0, 1, 1, 9, 0, 0, 0, la), Out: &out},
+      time.Date(0, 1, 1, 9, 0, 0, 0, la),
+      &out,
+    },
+  }
+  for _, tt := range tests {
+    row := tt.row
+    if row.Out != nil {
+      o := *row.Out
+      row.Out = &o
+    }
+    schema.CombineDates(reflect.ValueOf(&row).Elem())
+    if !row.In.Equal(tt.wantIn) {
+      t.Errorf("%s: got In %v, want %v", tt.name, row.In, tt.wantIn)
+    }
+    if (row.Out == nil) != (tt.wantOut == nil) || (row.Out != nil && !row.Out.Equal(*tt.wantOut)) {
+      t.Errorf("%s: got Out %v, want %v", tt.name, row.Out, tt.wantOut)
+    }
+  }
+}
+
+func timePtr(t time.Time) *time.Time {
+  return &t
+}
+
+func TestTimeTags(t *testing.T) {
+  schema, err := SchemaOf(reflect.TypeOf(shift{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  in, _ := schema.Field("In")
+  if in.Location == nil || in.Location.String() != "America/Los_Angeles" || in.DateField != "Date" {
+    t.Errorf("got In field %+v", in)
+  }
+  // Parsed in the field's timezone, and written back without the date.
+  parsed, err := in.ParseTime("9:15 AM")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if parsed.Location() != in.Location || parsed.Hour() != 9 || parsed.Minute() != 15 {
+    t.Errorf("got %v", parsed)
+  }
+  if got := in.FormatTime(time.Date(2024, 3, 4, 17, 15, 0, 0, time.UTC)); got != "09:15:00" {
+    t.Errorf("got %q", got)
+  }
+
+  logged, _ := schema.Field("Logged")
+  if !reflect.DeepEqual(logged.Layouts, []string{"2006-01-02 15:04", "Jan 2, 2006"}) || logged.Location != nil {
+    t.Errorf("got Logged field %+v", logged)
+  }
+  for _, s := range []string{"2024-03-04 10:00", "Mar 4, 2024"} {
+    if _, err := logged.ParseTime(s); err != nil {
+      t.Errorf("%q: %v", s, err)
+    }
+  }
+  // Only the field's own layouts are used.
+  if _, err := logged.ParseTime("3/4/2024"); err == nil {
+    t.Error("parsed a layout the field doesn't list")
+  }
+  if got := logged.FormatTime(time.Date(2024, 3, 4, 10, 0, 0, 0, time.UTC)); got != "2024-03-04 10:00" {
+    t.Errorf("got %q", got)
+  }
+
+  bad := []struct {
+    row interface{}
+    err string
+  }{
+    {struct {
+      T time.Time `littledb:"T,tz=Nowhere/Special"`
+    }{}, "unknown time zone"},
+    {struct {
+      N int `littledb:"N,tz=UTC"`
+    }{}, "only work on time.Time fields"},
+    {struct {
+      S string `littledb:"S" littledb_layout:"2006"`
+    }{}, "only work on time.Time fields"},
+    {struct {
+      T time.Time `littledb:"T,date=D"`
+    }{}, "isn't a time field"},
+  }
+  for _, tt := range bad {
+    if _, err := SchemaOf(reflect.TypeOf(tt.row)); err == nil || !strings.Contains(err.Error(), tt.err) {
+      t.Errorf("%T: got error %v, want %q", tt.row, err, tt.err)
+    }
+  }
+}
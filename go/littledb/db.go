@@ -1,9 +1,137 @@
 package littledb
 
+import (
+  "errors"
+  "fmt"
+)
+
+// Returned (wrapped) by Update and Delete when no row has the given key.
+var ErrNotFound = errors.New("littledb: row not found")
+
+type Op string
+
+const (
+  Eq Op = "="
+  Ne Op = "!="
+  Lt Op = "<"
+  Le Op = "<="
+  Gt Op = ">"
+  Ge Op = ">="
+  // Substring match, for string fields.
+  Contains Op = "contains"
+)
+
+func (op Op) valid() bool {
+  switch op {
+  case Eq, Ne, Lt, Le, Gt, Ge, Contains:
+    return true
+  }
+  return false
+}
+
+type Conjunction string
+
+const (
+  AndConj Conjunction = "and"
+  OrConj Conjunction = "or"
+)
+
+// Clause is a node in the clause tree. Leaves compare a field to a value; inner nodes combine their
+// children with Conj.
+type Clause struct {
+  Field string
+  Op Op
+  Value interface{}
+
+  Conj Conjunction
+  Children []*Clause
+}
+
+func (c *Clause) IsLeaf() bool {
+  return c.Conj == ""
+}
+
+// Cond builds a leaf clause comparing a field (by its littledb column name) to a value.
+func Cond(field string, op Op, value interface{}) *Clause {
+  return &Clause{
+    Field: field,
+    Op: op,
+    Value: value,
+  }
+}
+
+func And(clauses ...*Clause) *Clause {
+  return &Clause{Conj: AndConj, Children: clauses}
+}
+
+func Or(clauses ...*Clause) *Clause {
+  return &Clause{Conj: OrConj, Children: clauses}
+}
+
+// Visit calls fn for every leaf under c.
+func (c *Clause) Visit(fn func(leaf *Clause) error) error {
+  if c == nil {
+    return nil
+  }
+  if c.IsLeaf() {
+    return fn(c)
+  }
+  for _, child := range c.Children {
+    if err := child.Visit(fn); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+type Order struct {
+  Field string
+  Desc bool
+}
+
 type QueryClauses struct {
-  // TODO: clause tree goes here
+  // nil matches everything.
+  Where *Clause
+  OrderBy []Order
+  // 0 means no limit.
+  Limit int
+  Offset int
+}
+
+// Validate checks that every field the clauses refer to is in the schema.
+func (qc *QueryClauses) Validate(schema *Schema) error {
+  if qc == nil {
+    return nil
+  }
+  err := qc.Where.Visit(func(leaf *Clause) error {
+    if !leaf.Op.valid() {
+      return fmt.Errorf("unknown operator %q", leaf.Op)
+    }
+    if _, ok := schema.Field(leaf.Field); !ok {
+      return fmt.Errorf("%v has no littledb field %q", schema.Type, leaf.Field)
+    }
+    return nil
+  })
+  if err != nil {
+    return err
+  }
+  for _, o := range qc.OrderBy {
+    f, ok := schema.Field(o.Field)
+    if !ok {
+      return fmt.Errorf("%v has no littledb field %q", schema.Type, o.Field)
+    }
+    if f.IsList() {
+      return fmt.Errorf("can't order by list field %q", o.Field)
+    }
+  }
+  if qc.Limit < 0 || qc.Offset < 0 {
+    return fmt.Errorf("bad limit/offset: %d/%d", qc.Limit, qc.Offset)
+  }
+  return nil
 }
 
+// Executors run a query for a backend. They can push the clauses down to the backend or fetch
+// everything and call ApplyClauses.
 type Executor interface {
   Query(*QueryClauses) error
 }
@@ -11,15 +139,118 @@ type Executor interface {
 type PartialQuery struct {
   Ex Executor
   Clauses *QueryClauses
+  // The pointer to a slice that was passed to DB.Query. Used to validate field names.
+  Data interface{}
+  // The DB the query came from, for loading relations.
+  DB DB
+  with []string
+}
+
+func (pq *PartialQuery) clauses() *QueryClauses {
+  if pq.Clauses == nil {
+    pq.Clauses = &QueryClauses{}
+  }
+  return pq.Clauses
+}
+
+func (pq *PartialQuery) addClause(conj Conjunction, c *Clause) *PartialQuery {
+  qc := pq.clauses()
+  switch {
+  case qc.Where == nil:
+    qc.Where = c
+  case qc.Where.Conj == conj:
+    // Copy rather than append in place, in case the caller still holds on to the old tree.
+    children := append(append([]*Clause{}, qc.Where.Children...), c)
+    qc.Where = &Clause{Conj: conj, Children: children}
+  default:
+    qc.Where = &Clause{Conj: conj, Children: []*Clause{qc.Where, c}}
+  }
+  return pq
+}
+
+// Where adds a condition, ANDed with any existing ones.
+func (pq *PartialQuery) Where(field string, op Op, value interface{}) *PartialQuery {
+  return pq.addClause(AndConj, Cond(field, op, value))
+}
+
+// WhereClause adds a clause tree built with Cond/And/Or, ANDed with any existing conditions.
+func (pq *PartialQuery) WhereClause(c *Clause) *PartialQuery {
+  return pq.addClause(AndConj, c)
+}
+
+// And is the same as Where.
+func (pq *PartialQuery) And(field string, op Op, value interface{}) *PartialQuery {
+  return pq.Where(field, op, value)
+}
+
+// Or combines everything so far with a new condition: Where(a).And(b).Or(c) is (a AND b) OR c.
+func (pq *PartialQuery) Or(field string, op Op, value interface{}) *PartialQuery {
+  return pq.addClause(OrConj, Cond(field, op, value))
+}
+
+func (pq *PartialQuery) OrderBy(field string) *PartialQuery {
+  pq.clauses().OrderBy = append(pq.clauses().OrderBy, Order{Field: field})
+  return pq
+}
+
+func (pq *PartialQuery) OrderByDesc(field string) *PartialQuery {
+  pq.clauses().OrderBy = append(pq.clauses().OrderBy, Order{Field: field, Desc: true})
+  return pq
+}
+
+func (pq *PartialQuery) Limit(n int) *PartialQuery {
+  pq.clauses().Limit = n
+  return pq
+}
+
+func (pq *PartialQuery) Offset(n int) *PartialQuery {
+  pq.clauses().Offset = n
+  return pq
+}
+
+// With loads the named relations (Go field names tagged littledb_ref) after the query, or every
+// relation if none are named. See LoadRelations.
+func (pq *PartialQuery) With(relations ...string) *PartialQuery {
+  pq.with = append(append([]string{}, pq.with...), relations...)
+  return pq
 }
 
 func (pq *PartialQuery) Do() error {
-  return pq.Ex.Query(pq.Clauses)
+  if pq.Data != nil && pq.Clauses != nil {
+    t, err := SliceElemType(pq.Data)
+    if err != nil {
+      return err
+    }
+    schema, err := SchemaOf(t)
+    if err != nil {
+      return err
+    }
+    if err := pq.Clauses.Validate(schema); err != nil {
+      return err
+    }
+  }
+  if err := pq.Ex.Query(pq.Clauses); err != nil {
+    return err
+  }
+  if pq.with == nil {
+    return nil
+  }
+  if pq.DB == nil {
+    return errors.New("can't load relations, the query has no DB")
+  }
+  return LoadRelations(pq.DB, pq.Data, pq.with...)
 }
 
 type DB interface {
   // Query the table/sheet/etc. registered for a given type and populate the fields in the data structure.
   // 'data' must be a pointer to a slice
   Query(data interface{}) *PartialQuery
-}
 
+  // Insert, Update and Delete take a struct, a pointer to one, or a slice (or pointer to a slice) of
+  // either. Update and Delete find rows by the field tagged `littledb:",key"`.
+  Insert(data interface{}) error
+  // Update overwrites every mapped column of the rows with matching keys.
+  Update(data interface{}) error
+  // Delete removes the rows with matching keys. Only the key field needs to be set.
+  Delete(data interface{}) error
+}
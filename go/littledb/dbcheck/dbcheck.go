@@ -0,0 +1,73 @@
+// Package dbcheck reports schema drift for programs that use littledb. Register types with a backend
+// as usual, then hand the backend to Main, e.g. from a -check flag:
+//
+//   if *check {
+//     dbcheck.Main(*create, db)
+//   }
+package dbcheck
+
+import (
+  "fmt"
+  "io"
+  "log"
+  "os"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// Run prints the drift for every registered type and returns whether they all match. With create set,
+// header rows or tables are created first for types that don't have one.
+func Run(w io.Writer, v littledb.Validator, create bool) (bool, error) {
+  if create {
+    if err := v.CreateMissing(); err != nil {
+      return false, err
+    }
+  }
+  drifts, err := v.Validate()
+  if err != nil {
+    return false, err
+  }
+  ok := true
+  for _, d := range drifts {
+    status := "ok"
+    if !d.OK() {
+      status = "DRIFT"
+      ok = false
+    } else if len(d.Extra) > 0 {
+      status = "ok (extra columns)"
+    }
+    fmt.Fprintf(w, "%-20s %s\n", status, d.String())
+  }
+  return ok, nil
+}
+
+// Check runs every backend in turn, stopping at the first error.
+func Check(w io.Writer, create bool, dbs ...littledb.Validator) (bool, error) {
+  allOk := true
+  for _, db := range dbs {
+    ok, err := Run(w, db, create)
+    if err != nil {
+      return false, err
+    }
+    allOk = allOk && ok
+  }
+  return allOk, nil
+}
+
+// Main checks the backends, printing to stdout, and exits. The exit status is 0 if everything
+// matches, 1 if anything drifted, and 2 if a backend couldn't be checked.
+func Main(create bool, dbs ...littledb.Validator) {
+  if len(dbs) == 0 {
+    log.Print("dbcheck: nothing to check")
+    os.Exit(2)
+  }
+  ok, err := Check(os.Stdout, create, dbs...)
+  if err != nil {
+    log.Print(err)
+    os.Exit(2)
+  }
+  if !ok {
+    os.Exit(1)
+  }
+  os.Exit(0)
+}
@@ -0,0 +1,64 @@
+package dbcheck
+
+import (
+  "bytes"
+  "os"
+  "path/filepath"
+  "strings"
+  "testing"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+  "github.com/davedolben/dev-tools/go/littledb/backends/localfile"
+)
+
+type entry struct {
+  What string `littledb:"What"`
+  Hours float64 `littledb:"Hours"`
+}
+
+func TestCheck(t *testing.T) {
+  dir := t.TempDir()
+  good := filepath.Join(dir, "good.csv")
+  drifted := filepath.Join(dir, "drifted.csv")
+  extra := filepath.Join(dir, "extra.csv")
+  for filename, header := range map[string]string{good: "What,Hours\n", drifted: "what,Minutes\n", extra: "What,Hours,Notes\n"} {
+    if err := os.WriteFile(filename, []byte(header), 0644); err != nil {
+      t.Fatal(err)
+    }
+  }
+  missing := filepath.Join(dir, "missing.csv")
+
+  tests := []struct {
+    files []string
+    create bool
+    ok bool
+    want []string
+  }{
+    {[]string{good}, false, true, []string{"ok  ", "good.csv): ok"}},
+    {[]string{extra}, false, true, []string{"ok (extra columns)", `extra "Notes"`}},
+    {[]string{good, drifted}, false, false, []string{"good.csv): ok", "DRIFT", `renamed "what" -> "What"`, `missing "Hours"`, `extra "Minutes"`}},
+    {[]string{missing}, false, false, []string{"DRIFT", "no header row or table"}},
+    // Creating the missing file fixes it.
+    {[]string{missing}, true, true, []string{"missing.csv): ok"}},
+  }
+  for _, tt := range tests {
+    // One backend per file, since a FileDB holds one file per type.
+    dbs := []littledb.Validator{}
+    for _, f := range tt.files {
+      dbs = append(dbs, localfile.NewFileDB().Register(entry{}, f))
+    }
+    var buf bytes.Buffer
+    ok, err := Check(&buf, tt.create, dbs...)
+    if err != nil {
+      t.Fatal(err)
+    }
+    if ok != tt.ok {
+      t.Errorf("%v: got ok %v\n%s", tt.files, ok, buf.String())
+    }
+    for _, want := range tt.want {
+      if !strings.Contains(buf.String(), want) {
+        t.Errorf("%v: output doesn't contain %q:\n%s", tt.files, want, buf.String())
+      }
+    }
+  }
+}
@@ -0,0 +1,271 @@
+// Package dbtest is a conformance suite for littledb backends.
+package dbtest
+
+import (
+  "errors"
+  "testing"
+  "time"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// Row is the type the suite stores. Backends need to register it however they register types.
+type Row struct {
+  ID int `littledb:"id,key"`
+  Name string `littledb:"name"`
+  Score float64 `littledb:"score"`
+  Done bool `littledb:"done"`
+  Date time.Time `littledb:"date"`
+  Note *string `littledb:"note"`
+  // Not stored.
+  Scratch string
+}
+
+// Rows the suite inserts, in key order.
+func Fixtures() []Row {
+  note := "remember the milk"
+  return []Row{
+    {ID: 1, Name: "alpha", Score: 1.5, Done: true, Date: day(2021, 7, 21), Note: &note},
+    {ID: 2, Name: "beta", Score: 3, Date: day(2021, 7, 22)},
+    {ID: 3, Name: "gamma", Score: -2.25, Done: true, Date: day(2020, 4, 12)},
+    {ID: 4, Name: "alphabet", Score: 3, Date: day(2022, 1, 1)},
+  }
+}
+
+func day(y int, m time.Month, d int) time.Time {
+  return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// Run runs the suite. newDB is called once per subtest and must return an empty DB with Row
+// registered.
+func Run(t *testing.T, newDB func(t *testing.T) littledb.DB) {
+  seeded := func(t *testing.T) littledb.DB {
+    db := newDB(t)
+    if err := db.Insert(Fixtures()); err != nil {
+      t.Fatalf("insert: %v", err)
+    }
+    return db
+  }
+
+  t.Run("Empty", func(t *testing.T) {
+    db := newDB(t)
+    rows := []Row{{ID: 99}}
+    if err := db.Query(&rows).Do(); err != nil {
+      t.Fatal(err)
+    }
+    if len(rows) != 0 {
+      t.Errorf("got %d rows from an empty table", len(rows))
+    }
+  })
+
+  t.Run("RoundTrip", func(t *testing.T) {
+    db := seeded(t)
+    rows := []Row{}
+    if err := db.Query(&rows).OrderBy("id").Do(); err != nil {
+      t.Fatal(err)
+    }
+    want := Fixtures()
+    if len(rows) != len(want) {
+      t.Fatalf("got %d rows, want %d", len(rows), len(want))
+    }
+    for i := range want {
+      checkRow(t, rows[i], want[i])
+    }
+  })
+
+  t.Run("InsertOrder", func(t *testing.T) {
+    db := seeded(t)
+    rows := []Row{}
+    if err := db.Query(&rows).Do(); err != nil {
+      t.Fatal(err)
+    }
+    checkIDs(t, rows, 1, 2, 3, 4)
+  })
+
+  t.Run("UnstoredField", func(t *testing.T) {
+    db := newDB(t)
+    if err := db.Insert(&Row{ID: 1, Scratch: "not stored"}); err != nil {
+      t.Fatal(err)
+    }
+    rows := []Row{}
+    if err := db.Query(&rows).Do(); err != nil {
+      t.Fatal(err)
+    }
+    if len(rows) != 1 || rows[0].Scratch != "" {
+      t.Errorf("got %+v, want one row without Scratch", rows)
+    }
+  })
+
+  t.Run("Where", func(t *testing.T) {
+    db := seeded(t)
+    tests := []struct {
+      name string
+      q func(pq *littledb.PartialQuery) *littledb.PartialQuery
+      want []int
+    }{
+      {"eq", func(pq *littledb.PartialQuery) *littledb.PartialQuery { return pq.Where("name", littledb.Eq, "beta") }, []int{2}},
+      {"ne", func(pq *littledb.PartialQuery) *littledb.PartialQuery { return pq.Where("score", littledb.Ne, 3) }, []int{1, 3}},
+      {"lt", func(pq *littledb.PartialQuery) *littledb.PartialQuery { return pq.Where("score", littledb.Lt, 1.5) }, []int{3}},
+      {"le", func(pq *littledb.PartialQuery) *littledb.PartialQuery { return pq.Where("score", littledb.Le, 1.5) }, []int{1, 3}},
+      {"gt date", func(pq *littledb.PartialQuery) *littledb.PartialQuery { return pq.Where("date", littledb.Gt, day(2021, 7, 21)) }, []int{2, 4}},
+      {"ge", func(pq *littledb.PartialQuery) *littledb.PartialQuery { return pq.Where("id", littledb.Ge, 3) }, []int{3, 4}},
+      {"bool", func(pq *littledb.PartialQuery) *littledb.PartialQuery { return pq.Where("done", littledb.Eq, true) }, []int{1, 3}},
+      {"contains", func(pq *littledb.PartialQuery) *littledb.PartialQuery { return pq.Where("name", littledb.Contains, "alpha") }, []int{1, 4}},
+      {"nil pointer", func(pq *littledb.PartialQuery) *littledb.PartialQuery { return pq.Where("note", littledb.Eq, nil) }, []int{2, 3, 4}},
+      {"and", func(pq *littledb.PartialQuery) *littledb.PartialQuery {
+        return pq.Where("score", littledb.Eq, 3).And("name", littledb.Contains, "alpha")
+      }, []int{4}},
+      {"or", func(pq *littledb.PartialQuery) *littledb.PartialQuery {
+        return pq.Where("id", littledb.Eq, 1).Or("id", littledb.Eq, 3)
+      }, []int{1, 3}},
+      {"nested", func(pq *littledb.PartialQuery) *littledb.PartialQuery {
+        return pq.WhereClause(littledb.Or(
+          littledb.And(littledb.Cond("done", littledb.Eq, true), littledb.Cond("score", littledb.Lt, 0)),
+          littledb.Cond("name", littledb.Eq, "beta"),
+        ))
+      }, []int{2, 3}},
+    }
+    for _, tt := range tests {
+      t.Run(tt.name, func(t *testing.T) {
+        rows := []Row{}
+        if err := tt.q(db.Query(&rows)).OrderBy("id").Do(); err != nil {
+          t.Fatal(err)
+        }
+        checkIDs(t, rows, tt.want...)
+      })
+    }
+  })
+
+  t.Run("OrderAndLimit", func(t *testing.T) {
+    db := seeded(t)
+    rows := []Row{}
+    if err := db.Query(&rows).OrderByDesc("score").OrderBy("name").Do(); err != nil {
+      t.Fatal(err)
+    }
+    checkIDs(t, rows, 4, 2, 1, 3)
+
+    if err := db.Query(&rows).OrderBy("date").Offset(1).Limit(2).Do(); err != nil {
+      t.Fatal(err)
+    }
+    checkIDs(t, rows, 1, 2)
+
+    if err := db.Query(&rows).OrderBy("id").Offset(10).Do(); err != nil {
+      t.Fatal(err)
+    }
+    checkIDs(t, rows)
+  })
+
+  t.Run("UnknownField", func(t *testing.T) {
+    db := seeded(t)
+    rows := []Row{}
+    if err := db.Query(&rows).Where("nope", littledb.Eq, 1).Do(); err == nil {
+      t.Error("expected an error for an unknown field")
+    }
+  })
+
+  t.Run("DuplicateKey", func(t *testing.T) {
+    db := seeded(t)
+    if err := db.Insert(&Row{ID: 2, Name: "again"}); err == nil {
+      t.Error("expected an error inserting a duplicate key")
+    }
+    rows := []Row{}
+    if err := db.Query(&rows).Where("id", littledb.Eq, 2).Do(); err != nil {
+      t.Fatal(err)
+    }
+    if len(rows) != 1 || rows[0].Name != "beta" {
+      t.Errorf("got %+v after a failed insert", rows)
+    }
+  })
+
+  t.Run("Update", func(t *testing.T) {
+    db := seeded(t)
+    updated := Fixtures()[1]
+    note := "updated"
+    updated.Name = "BETA"
+    updated.Done = true
+    updated.Note = &note
+    if err := db.Update(updated); err != nil {
+      t.Fatal(err)
+    }
+    first := Fixtures()[0]
+    first.Note = nil
+    if err := db.Update([]*Row{&first}); err != nil {
+      t.Fatal(err)
+    }
+
+    rows := []Row{}
+    if err := db.Query(&rows).OrderBy("id").Do(); err != nil {
+      t.Fatal(err)
+    }
+    want := Fixtures()
+    want[0] = first
+    want[1] = updated
+    if len(rows) != len(want) {
+      t.Fatalf("got %d rows, want %d", len(rows), len(want))
+    }
+    for i := range want {
+      checkRow(t, rows[i], want[i])
+    }
+  })
+
+  t.Run("UpdateMissing", func(t *testing.T) {
+    db := seeded(t)
+    err := db.Update(&Row{ID: 42})
+    if !errors.Is(err, littledb.ErrNotFound) {
+      t.Errorf("got %v, want ErrNotFound", err)
+    }
+  })
+
+  t.Run("Delete", func(t *testing.T) {
+    db := seeded(t)
+    if err := db.Delete([]Row{{ID: 1}, {ID: 3}}); err != nil {
+      t.Fatal(err)
+    }
+    rows := []Row{}
+    if err := db.Query(&rows).OrderBy("id").Do(); err != nil {
+      t.Fatal(err)
+    }
+    checkIDs(t, rows, 2, 4)
+
+    if err := db.Delete(&Row{ID: 1}); !errors.Is(err, littledb.ErrNotFound) {
+      t.Errorf("got %v deleting a deleted row, want ErrNotFound", err)
+    }
+    // Deleted keys can be reused.
+    if err := db.Insert(&Row{ID: 1, Name: "new"}); err != nil {
+      t.Fatal(err)
+    }
+  })
+}
+
+func checkIDs(t *testing.T, rows []Row, want ...int) {
+  t.Helper()
+  got := []int{}
+  for _, r := range rows {
+    got = append(got, r.ID)
+  }
+  if len(got) != len(want) {
+    t.Errorf("got ids %v, want %v", got, want)
+    return
+  }
+  for i := range want {
+    if got[i] != want[i] {
+      t.Errorf("got ids %v, want %v", got, want)
+      return
+    }
+  }
+}
+
+func checkRow(t *testing.T, got, want Row) {
+  t.Helper()
+  if got.ID != want.ID || got.Name != want.Name || got.Score != want.Score || got.Done != want.Done {
+    t.Errorf("got %+v, want %+v", got, want)
+  }
+  if !got.Date.Equal(want.Date) {
+    t.Errorf("row %d: got date %v, want %v", want.ID, got.Date, want.Date)
+  }
+  switch {
+  case got.Note == nil && want.Note == nil:
+  case got.Note == nil || want.Note == nil || *got.Note != *want.Note:
+    t.Errorf("row %d: got note %v, want %v", want.ID, got.Note, want.Note)
+  }
+}
@@ -0,0 +1,215 @@
+package dbtest
+
+import (
+  "fmt"
+  "reflect"
+  "strings"
+  "testing"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// Audit is embedded in Task, so its columns are Task's columns.
+type Audit struct {
+  CreatedBy string `littledb:"created_by"`
+}
+
+type Project struct {
+  ID string `littledb:"id,key"`
+  Name string `littledb:"name"`
+  Tasks []Task `littledb_ref:"project"`
+}
+
+type Task struct {
+  ID int `littledb:"id,key"`
+  Title string `littledb:"title"`
+  ProjectID string `littledb:"project"`
+  Tags []string `littledb:"tags,sep=;"`
+  Points []int `littledb:"points"`
+  Audit
+  Project *Project `littledb_ref:"project"`
+}
+
+func Projects() []Project {
+  return []Project{
+    {ID: "home", Name: "Home"},
+    {ID: "work", Name: "Work"},
+    {ID: "side", Name: "Side project"},
+    {ID: "idle", Name: "Nothing to do"},
+  }
+}
+
+// Tasks the relations suite inserts, in key order. Task 5's project doesn't exist.
+func Tasks() []Task {
+  return []Task{
+    {ID: 1, Title: "dishes", ProjectID: "home", Tags: []string{"chore", "daily"}, Points: []int{1}, Audit: Audit{"dave"}},
+    {ID: 2, Title: "report", ProjectID: "work", Tags: []string{"writing"}, Points: []int{3, 5}, Audit: Audit{"dave"}},
+    {ID: 3, Title: "laundry", ProjectID: "home", Tags: []string{"chore"}, Audit: Audit{"sam"}},
+    {ID: 4, Title: "prototype", ProjectID: "side", Points: []int{8, 13, 21}},
+    {ID: 5, Title: "orphan", ProjectID: "gone"},
+  }
+}
+
+// A DB that counts queries, to check that relations are loaded in batches.
+type countingDB struct {
+  littledb.DB
+  queries int
+}
+
+func (db *countingDB) Query(data interface{}) *littledb.PartialQuery {
+  db.queries++
+  return db.DB.Query(data)
+}
+
+// RunRelations checks embedded structs, list fields and relations. newDB is called once per subtest
+// and must return an empty DB with Project and Task registered.
+func RunRelations(t *testing.T, newDB func(t *testing.T) littledb.DB) {
+  seeded := func(t *testing.T) littledb.DB {
+    db := newDB(t)
+    if err := db.Insert(Projects()); err != nil {
+      t.Fatalf("insert projects: %v", err)
+    }
+    if err := db.Insert(Tasks()); err != nil {
+      t.Fatalf("insert tasks: %v", err)
+    }
+    return db
+  }
+
+  t.Run("RoundTrip", func(t *testing.T) {
+    db := seeded(t)
+    tasks := []Task{}
+    if err := db.Query(&tasks).OrderBy("id").Do(); err != nil {
+      t.Fatal(err)
+    }
+    want := Tasks()
+    if len(tasks) != len(want) {
+      t.Fatalf("got %d tasks, want %d", len(tasks), len(want))
+    }
+    for i := range want {
+      got := tasks[i]
+      if got.Title != want[i].Title || got.ProjectID != want[i].ProjectID || got.CreatedBy != want[i].CreatedBy {
+        t.Errorf("got %+v, want %+v", got, want[i])
+      }
+      if !sameItems(got.Tags, want[i].Tags) || !sameItems(got.Points, want[i].Points) {
+        t.Errorf("task %d: got lists %v %v, want %v %v", want[i].ID, got.Tags, got.Points, want[i].Tags, want[i].Points)
+      }
+      if got.Project != nil {
+        t.Errorf("task %d: relation loaded without With", want[i].ID)
+      }
+    }
+  })
+
+  t.Run("ListContains", func(t *testing.T) {
+    db := seeded(t)
+    tasks := []Task{}
+    if err := db.Query(&tasks).Where("tags", littledb.Contains, "chore").OrderBy("id").Do(); err != nil {
+      t.Fatal(err)
+    }
+    checkTaskIDs(t, tasks, 1, 3)
+    // Whole items only.
+    if err := db.Query(&tasks).Where("tags", littledb.Contains, "chor").Do(); err != nil {
+      t.Fatal(err)
+    }
+    checkTaskIDs(t, tasks)
+    if err := db.Query(&tasks).Where("points", littledb.Contains, 13).Do(); err != nil {
+      t.Fatal(err)
+    }
+    checkTaskIDs(t, tasks, 4)
+    // Nothing but contains works on a list.
+    if err := db.Query(&tasks).Where("tags", littledb.Eq, "chore").Do(); err == nil || !strings.Contains(err.Error(), "only contains works on lists") {
+      t.Errorf("got %v, want an only contains error", err)
+    }
+  })
+
+  t.Run("EmbeddedWhere", func(t *testing.T) {
+    db := seeded(t)
+    tasks := []Task{}
+    if err := db.Query(&tasks).Where("created_by", littledb.Eq, "dave").OrderBy("id").Do(); err != nil {
+      t.Fatal(err)
+    }
+    checkTaskIDs(t, tasks, 1, 2)
+  })
+
+  t.Run("BelongsTo", func(t *testing.T) {
+    db := seeded(t)
+    tasks := []Task{}
+    if err := db.Query(&tasks).OrderBy("id").With("Project").Do(); err != nil {
+      t.Fatal(err)
+    }
+    want := map[int]string{1: "Home", 2: "Work", 3: "Home", 4: "Side project"}
+    for _, task := range tasks {
+      got := ""
+      if task.Project != nil {
+        got = task.Project.Name
+      }
+      if got != want[task.ID] {
+        t.Errorf("task %d: got project %q, want %q", task.ID, got, want[task.ID])
+      }
+    }
+  })
+
+  t.Run("HasMany", func(t *testing.T) {
+    db := seeded(t)
+    projects := []Project{}
+    if err := db.Query(&projects).With().Do(); err != nil {
+      t.Fatal(err)
+    }
+    want := map[string][]int{"home": {1, 3}, "work": {2}, "side": {4}, "idle": nil}
+    for _, p := range projects {
+      got := []int{}
+      for _, task := range p.Tasks {
+        got = append(got, task.ID)
+      }
+      if fmt.Sprint(got) != fmt.Sprint(append([]int{}, want[p.ID]...)) {
+        t.Errorf("project %q: got tasks %v, want %v", p.ID, got, want[p.ID])
+      }
+    }
+  })
+
+  t.Run("Batches", func(t *testing.T) {
+    db := &countingDB{DB: seeded(t)}
+    tasks := []Task{}
+    if err := db.Query(&tasks).Do(); err != nil {
+      t.Fatal(err)
+    }
+
+    defer func(n int) { littledb.RelationBatchSize = n }(littledb.RelationBatchSize)
+    littledb.RelationBatchSize = 2
+    db.queries = 0
+    if err := littledb.LoadRelations(db, &tasks, "Project"); err != nil {
+      t.Fatal(err)
+    }
+    // Four distinct projects, two at a time.
+    if db.queries != 2 {
+      t.Errorf("made %d queries for 5 tasks, want 2", db.queries)
+    }
+  })
+
+  t.Run("UnknownRelation", func(t *testing.T) {
+    db := seeded(t)
+    tasks := []Task{}
+    if err := db.Query(&tasks).With("Nope").Do(); err == nil {
+      t.Error("no error for an unknown relation")
+    }
+  })
+}
+
+func checkTaskIDs(t *testing.T, tasks []Task, want ...int) {
+  t.Helper()
+  got := []int{}
+  for _, task := range tasks {
+    got = append(got, task.ID)
+  }
+  if fmt.Sprint(got) != fmt.Sprint(append([]int{}, want...)) {
+    t.Errorf("got ids %v, want %v", got, want)
+  }
+}
+
+// Lists compare equal whether empty lists come back nil or not.
+func sameItems(a, b interface{}) bool {
+  va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+  if va.Len() == 0 && vb.Len() == 0 {
+    return true
+  }
+  return reflect.DeepEqual(a, b)
+}
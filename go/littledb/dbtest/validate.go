@@ -0,0 +1,94 @@
+package dbtest
+
+import (
+  "reflect"
+  "sort"
+  "testing"
+  "time"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+)
+
+// DriftRow is stored where Row was to check Validate. Against Row's columns, "id" and "note" look
+// renamed, "owner" is missing and "done" is extra.
+type DriftRow struct {
+  ID int `littledb:"ID,key"`
+  Name string `littledb:"name"`
+  Score float64 `littledb:"score"`
+  Date time.Time `littledb:"date"`
+  Notes *string `littledb:"notes"`
+  Owner string `littledb:"owner"`
+}
+
+// ValidatingDB is a backend that can check its registered types.
+type ValidatingDB interface {
+  littledb.DB
+  littledb.Validator
+}
+
+// RunValidate checks Validate and CreateMissing. newStore is called once per subtest and returns a
+// function that opens the same storage (a file, table or range that doesn't exist yet) with the
+// given type registered. It's called several times with different types.
+func RunValidate(t *testing.T, newStore func(t *testing.T) func(row interface{}) ValidatingDB) {
+  validate := func(t *testing.T, db ValidatingDB) *littledb.Drift {
+    t.Helper()
+    drifts, err := db.Validate()
+    if err != nil {
+      t.Fatalf("validate: %v", err)
+    }
+    if len(drifts) != 1 {
+      t.Fatalf("got %d drifts, want 1: %v", len(drifts), drifts)
+    }
+    return drifts[0]
+  }
+
+  t.Run("NoTable", func(t *testing.T) {
+    d := validate(t, newStore(t)(Row{}))
+    if !d.NoTable || d.OK() || d.Type != reflect.TypeOf(Row{}) || d.Table == "" {
+      t.Errorf("got %+v", d)
+    }
+  })
+
+  t.Run("CreateMissing", func(t *testing.T) {
+    open := newStore(t)
+    db := open(Row{})
+    // Twice, since the second time there's nothing to create.
+    for i := 0; i < 2; i++ {
+      if err := db.CreateMissing(); err != nil {
+        t.Fatalf("create: %v", err)
+      }
+    }
+    if d := validate(t, db); !d.OK() || d.NoTable || len(d.Extra) > 0 {
+      t.Errorf("got %v", d)
+    }
+    if err := db.Insert(Fixtures()); err != nil {
+      t.Fatalf("insert: %v", err)
+    }
+    rows := []Row{}
+    if err := open(Row{}).Query(&rows).Do(); err != nil {
+      t.Fatal(err)
+    }
+    if len(rows) != len(Fixtures()) {
+      t.Errorf("got %d rows", len(rows))
+    }
+  })
+
+  t.Run("Drift", func(t *testing.T) {
+    open := newStore(t)
+    db := open(Row{})
+    if err := db.CreateMissing(); err != nil {
+      t.Fatalf("create: %v", err)
+    }
+    if err := db.Insert(Fixtures()); err != nil {
+      t.Fatalf("insert: %v", err)
+    }
+
+    d := validate(t, open(DriftRow{}))
+    sort.Slice(d.Renamed, func(i, j int) bool { return d.Renamed[i].To < d.Renamed[j].To })
+    wantRenamed := []littledb.Rename{{From: "id", To: "ID"}, {From: "note", To: "notes"}}
+    if d.OK() || d.NoTable || !reflect.DeepEqual(d.Renamed, wantRenamed) ||
+        !reflect.DeepEqual(d.Missing, []string{"owner"}) || !reflect.DeepEqual(d.Extra, []string{"done"}) {
+      t.Errorf("got %v", d)
+    }
+  })
+}
@@ -0,0 +1,249 @@
+package littledb
+
+import (
+  "fmt"
+  "reflect"
+  "sort"
+  "strings"
+  "time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ApplyClauses filters, sorts and trims the slice that data points to, for backends that can't push
+// the clauses down to where the data lives.
+func ApplyClauses(data interface{}, qc *QueryClauses) error {
+  if qc == nil {
+    return nil
+  }
+  t, err := SliceElemType(data)
+  if err != nil {
+    return err
+  }
+  schema, err := SchemaOf(t)
+  if err != nil {
+    return err
+  }
+  if err := qc.Validate(schema); err != nil {
+    return err
+  }
+
+  vSlice := reflect.ValueOf(data).Elem()
+  out := reflect.MakeSlice(vSlice.Type(), 0, vSlice.Len())
+  for i := 0; i < vSlice.Len(); i++ {
+    ok, err := Matches(schema, qc.Where, vSlice.Index(i))
+    if err != nil {
+      return err
+    }
+    if ok {
+      out = reflect.Append(out, vSlice.Index(i))
+    }
+  }
+
+  if len(qc.OrderBy) > 0 {
+    var sortErr error
+    sort.SliceStable(out.Interface(), func(i, j int) bool {
+      for _, o := range qc.OrderBy {
+        f, _ := schema.Field(o.Field)
+        cmp, err := compareValues(f.Value(out.Index(i)), f.Value(out.Index(j)))
+        if err != nil {
+          sortErr = err
+          return false
+        }
+        if cmp != 0 {
+          return (cmp < 0) != o.Desc
+        }
+      }
+      return false
+    })
+    if sortErr != nil {
+      return sortErr
+    }
+  }
+
+  start := qc.Offset
+  if start > out.Len() {
+    start = out.Len()
+  }
+  end := out.Len()
+  if qc.Limit > 0 && start+qc.Limit < end {
+    end = start + qc.Limit
+  }
+  vSlice.Set(out.Slice(start, end))
+  return nil
+}
+
+// Matches evaluates a clause tree against one row (a struct value of the schema's type).
+func Matches(schema *Schema, c *Clause, row reflect.Value) (bool, error) {
+  if c == nil {
+    return true, nil
+  }
+  if !c.IsLeaf() {
+    for _, child := range c.Children {
+      ok, err := Matches(schema, child, row)
+      if err != nil {
+        return false, err
+      }
+      if c.Conj == OrConj && ok {
+        return true, nil
+      }
+      if c.Conj == AndConj && !ok {
+        return false, nil
+      }
+    }
+    // An empty OR matches nothing, an empty AND matches everything.
+    return c.Conj == AndConj, nil
+  }
+
+  f, ok := schema.Field(c.Field)
+  if !ok {
+    return false, fmt.Errorf("%v has no littledb field %q", schema.Type, c.Field)
+  }
+  vField := f.Value(row)
+
+  if f.IsList() {
+    // Lists match if any item equals the value.
+    if c.Op != Contains {
+      return false, fmt.Errorf("field %q: only contains works on lists", c.Field)
+    }
+    for i := 0; i < vField.Len(); i++ {
+      cmp, err := compareValues(vField.Index(i), reflect.ValueOf(c.Value))
+      if err != nil {
+        return false, fmt.Errorf("field %q: %w", c.Field, err)
+      }
+      if cmp == 0 {
+        return true, nil
+      }
+    }
+    return false, nil
+  }
+
+  if c.Op == Contains {
+    s, ok := indirect(vField)
+    if !ok || s.Kind() != reflect.String {
+      return false, fmt.Errorf("field %q: contains only works on strings", c.Field)
+    }
+    return strings.Contains(s.String(), fmt.Sprint(c.Value)), nil
+  }
+
+  cmp, err := compareValues(vField, reflect.ValueOf(c.Value))
+  if err != nil {
+    return false, fmt.Errorf("field %q: %w", c.Field, err)
+  }
+  switch c.Op {
+  case Eq:
+    return cmp == 0, nil
+  case Ne:
+    return cmp != 0, nil
+  case Lt:
+    return cmp < 0, nil
+  case Le:
+    return cmp <= 0, nil
+  case Gt:
+    return cmp > 0, nil
+  case Ge:
+    return cmp >= 0, nil
+  }
+  return false, fmt.Errorf("unknown operator %q", c.Op)
+}
+
+// Follow pointers and interfaces. Returns false for nil.
+func indirect(v reflect.Value) (reflect.Value, bool) {
+  for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+    if v.IsNil() {
+      return reflect.Value{}, false
+    }
+    v = v.Elem()
+  }
+  return v, v.IsValid()
+}
+
+// Compare two values of compatible kinds, e.g. a field and the value in a clause. nil (including nil
+// pointers for empty cells) sorts before everything else.
+func compareValues(a, b reflect.Value) (int, error) {
+  a, aOk := indirect(a)
+  b, bOk := indirect(b)
+  if !aOk || !bOk {
+    switch {
+    case !aOk && !bOk:
+      return 0, nil
+    case !aOk:
+      return -1, nil
+    default:
+      return 1, nil
+    }
+  }
+
+  if a.Type() == timeType && b.Type() == timeType {
+    ta := a.Interface().(time.Time)
+    tb := b.Interface().(time.Time)
+    return ta.Compare(tb), nil
+  }
+
+  switch {
+  case isInt(a) && isInt(b):
+    return compareOrdered(a.Int(), b.Int()), nil
+  case isUint(a) && isUint(b):
+    return compareOrdered(a.Uint(), b.Uint()), nil
+  case isNumber(a) && isNumber(b):
+    return compareOrdered(toFloat(a), toFloat(b)), nil
+  case a.Kind() == reflect.String && b.Kind() == reflect.String:
+    return strings.Compare(a.String(), b.String()), nil
+  case a.Kind() == reflect.Bool && b.Kind() == reflect.Bool:
+    if a.Bool() == b.Bool() {
+      return 0, nil
+    }
+    if !a.Bool() {
+      return -1, nil
+    }
+    return 1, nil
+  case a.Type() == timeType || b.Type() == timeType:
+    return 0, fmt.Errorf("can't compare %v to %v", a.Type(), b.Type())
+  case a.Type() == b.Type():
+    // Structs, byte slices and the like have no order.
+    return 0, fmt.Errorf("incomparable field type %v", a.Type())
+  case a.Type().ConvertibleTo(b.Type()) && a.Kind() == b.Kind():
+    return compareValues(a.Convert(b.Type()), b)
+  }
+  return 0, fmt.Errorf("can't compare %v to %v", a.Type(), b.Type())
+}
+
+func isInt(v reflect.Value) bool {
+  switch v.Kind() {
+  case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+    return true
+  }
+  return false
+}
+
+func isUint(v reflect.Value) bool {
+  switch v.Kind() {
+  case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+    return true
+  }
+  return false
+}
+
+func isNumber(v reflect.Value) bool {
+  return isInt(v) || isUint(v) || v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64
+}
+
+func toFloat(v reflect.Value) float64 {
+  switch {
+  case isInt(v):
+    return float64(v.Int())
+  case isUint(v):
+    return float64(v.Uint())
+  }
+  return v.Float()
+}
+
+func compareOrdered[T int64 | uint64 | float64](a, b T) int {
+  switch {
+  case a < b:
+    return -1
+  case a > b:
+    return 1
+  }
+  return 0
+}
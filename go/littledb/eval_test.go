@@ -0,0 +1,197 @@
+package littledb
+
+import (
+  "fmt"
+  "reflect"
+  "strings"
+  "testing"
+)
+
+type evalPoint struct {
+  X, Y int
+}
+
+type evalRow struct {
+  ID int `littledb:"ID,key"`
+  Name string `littledb:"Name"`
+  Score float64 `littledb:"Score"`
+  Tags []string `littledb:"Tags"`
+  P evalPoint `littledb:"P"`
+  Raw []byte `littledb:"Raw"`
+}
+
+func evalRows() []evalRow {
+  return []evalRow{
+    {ID: 1, Name: "alice", Score: 3, Tags: []string{"a", "b"}, P: evalPoint{1, 2}},
+    {ID: 2, Name: "bob", Score: 1, Tags: []string{"b"}},
+    {ID: 3, Name: "carol", Score: 3},
+    {ID: 4, Name: "dave", Score: 2, Tags: []string{"c"}},
+    {ID: 5, Name: "erin", Score: 5, Tags: []string{"a"}},
+  }
+}
+
+func rowIds(rows []evalRow) string {
+  var ids []string
+  for _, r := range rows {
+    ids = append(ids, fmt.Sprint(r.ID))
+  }
+  return strings.Join(ids, " ")
+}
+
+func clauseString(c *Clause) string {
+  if c == nil {
+    return ""
+  }
+  if c.IsLeaf() {
+    return fmt.Sprintf("%s %s %v", c.Field, c.Op, c.Value)
+  }
+  var parts []string
+  for _, child := range c.Children {
+    if child.IsLeaf() {
+      parts = append(parts, clauseString(child))
+    } else {
+      parts = append(parts, "("+clauseString(child)+")")
+    }
+  }
+  return strings.Join(parts, " "+string(c.Conj)+" ")
+}
+
+type nopExecutor struct{}
+
+func (nopExecutor) Query(*QueryClauses) error {
+  return nil
+}
+
+func TestClauseTree(t *testing.T) {
+  newQuery := func() *PartialQuery {
+    return &PartialQuery{Ex: nopExecutor{}, Data: &[]evalRow{}}
+  }
+  tests := []struct {
+    q *PartialQuery
+    want string
+  }{
+    {newQuery().Where("ID", Eq, 1), "ID = 1"},
+    {newQuery().Where("ID", Eq, 1).And("Name", Eq, "x").And("Score", Gt, 2), "ID = 1 and Name = x and Score > 2"},
+    {newQuery().Where("ID", Eq, 1).And("Name", Eq, "x").Or("ID", Eq, 3).And("Score", Lt, 4),
+        "((ID = 1 and Name = x) or ID = 3) and Score < 4"},
+    {newQuery().Where("ID", Eq, 1).WhereClause(Or(Cond("Name", Eq, "x"), And(Cond("Score", Ge, 1), Cond("Score", Le, 2)))),
+        "ID = 1 and (Name = x or (Score >= 1 and Score <= 2))"},
+  }
+  for _, tt := range tests {
+    if got := clauseString(tt.q.Clauses.Where); got != tt.want {
+      t.Errorf("got %q, want %q", got, tt.want)
+    }
+    if err := tt.q.Do(); err != nil {
+      t.Errorf("%s: %v", tt.want, err)
+    }
+  }
+
+  // Adding to a query doesn't change a tree the caller already took.
+  q := newQuery().Where("ID", Eq, 1).And("ID", Eq, 2)
+  before := q.Clauses.Where
+  q.And("ID", Eq, 3)
+  if got := clauseString(before); got != "ID = 1 and ID = 2" {
+    t.Errorf("old tree changed to %q", got)
+  }
+}
+
+func TestApplyClauses(t *testing.T) {
+  tests := []struct {
+    name string
+    qc *QueryClauses
+    want string
+  }{
+    {"nil", nil, "1 2 3 4 5"},
+    {"everything", &QueryClauses{}, "1 2 3 4 5"},
+    {"eq", &QueryClauses{Where: Cond("Score", Eq, 3)}, "1 3"},
+    {"int against float", &QueryClauses{Where: Cond("Score", Ge, 3)}, "1 3 5"},
+    {"ne", &QueryClauses{Where: Cond("Name", Ne, "bob")}, "1 3 4 5"},
+    {"string contains", &QueryClauses{Where: Cond("Name", Contains, "a")}, "1 3 4"},
+    {"list contains", &QueryClauses{Where: Cond("Tags", Contains, "b")}, "1 2"},
+    {"nested", &QueryClauses{Where: Or(
+        And(Cond("Score", Gt, 1), Cond("Score", Lt, 3)),
+        And(Cond("Name", Contains, "e"), Or(Cond("ID", Eq, 5), Cond("ID", Eq, 2))))}, "4 5"},
+    {"empty and", &QueryClauses{Where: And()}, "1 2 3 4 5"},
+    {"empty or", &QueryClauses{Where: Or()}, ""},
+    {"order by", &QueryClauses{OrderBy: []Order{{Field: "Score"}}}, "2 4 1 3 5"},
+    {"order by desc is stable", &QueryClauses{OrderBy: []Order{{Field: "Score", Desc: true}}}, "5 1 3 4 2"},
+    {"order by two fields", &QueryClauses{OrderBy: []Order{{Field: "Score", Desc: true}, {Field: "Name", Desc: true}}}, "5 3 1 4 2"},
+    {"limit", &QueryClauses{Limit: 2}, "1 2"},
+    {"zero limit", &QueryClauses{Limit: 0, Offset: 3}, "4 5"},
+    {"limit past the end", &QueryClauses{Limit: 10, Offset: 3}, "4 5"},
+    {"offset", &QueryClauses{OrderBy: []Order{{Field: "Score"}}, Limit: 2, Offset: 1}, "4 1"},
+    {"offset at the end", &QueryClauses{Offset: 5}, ""},
+    {"offset past the end", &QueryClauses{Offset: 9, Limit: 1}, ""},
+    {"filter, order and limit", &QueryClauses{Where: Cond("Score", Gt, 1), OrderBy: []Order{{Field: "Name", Desc: true}}, Limit: 2}, "5 4"},
+  }
+  for _, tt := range tests {
+    rows := evalRows()
+    if err := ApplyClauses(&rows, tt.qc); err != nil {
+      t.Errorf("%s: %v", tt.name, err)
+      continue
+    }
+    if got := rowIds(rows); got != tt.want {
+      t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+    }
+  }
+}
+
+func TestValidate(t *testing.T) {
+  schema, err := SchemaOf(reflect.TypeOf(evalRow{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  var nilClauses *QueryClauses
+  if err := nilClauses.Validate(schema); err != nil {
+    t.Errorf("nil clauses: %v", err)
+  }
+  tests := []struct {
+    qc *QueryClauses
+    want string
+  }{
+    {&QueryClauses{Where: Cond("ID", "~", 1)}, "unknown operator"},
+    {&QueryClauses{Where: Cond("Nope", Eq, 1)}, `no littledb field "Nope"`},
+    // Found anywhere in the tree.
+    {&QueryClauses{Where: And(Cond("ID", Eq, 1), Or(Cond("Name", Eq, "x"), Cond("Nope", Eq, 1)))}, `no littledb field "Nope"`},
+    {&QueryClauses{OrderBy: []Order{{Field: "Nope"}}}, `no littledb field "Nope"`},
+    {&QueryClauses{OrderBy: []Order{{Field: "Tags"}}}, "can't order by list field"},
+    {&QueryClauses{Limit: -1}, "bad limit/offset"},
+    {&QueryClauses{Offset: -1}, "bad limit/offset"},
+  }
+  for _, tt := range tests {
+    err := tt.qc.Validate(schema)
+    if err == nil || !strings.Contains(err.Error(), tt.want) {
+      t.Errorf("%+v: got %v, want %q", tt.qc, err, tt.want)
+    }
+    // ApplyClauses checks them too.
+    rows := evalRows()
+    if err := ApplyClauses(&rows, tt.qc); err == nil {
+      t.Errorf("%+v: applied", tt.qc)
+    }
+  }
+}
+
+func TestApplyClausesErrors(t *testing.T) {
+  type otherPoint evalPoint
+  tests := []struct {
+    qc *QueryClauses
+    want string
+  }{
+    // Struct and []byte fields used to recurse until the stack overflowed.
+    {&QueryClauses{Where: Cond("P", Eq, evalPoint{1, 2})}, "incomparable field type"},
+    {&QueryClauses{Where: Cond("P", Eq, otherPoint{1, 2})}, "incomparable field type"},
+    {&QueryClauses{OrderBy: []Order{{Field: "P"}}}, "incomparable field type"},
+    {&QueryClauses{Where: Cond("Raw", Eq, []byte("x"))}, "incomparable field type"},
+    {&QueryClauses{OrderBy: []Order{{Field: "Raw"}}}, "incomparable field type"},
+    {&QueryClauses{Where: Cond("Name", Lt, 3)}, "can't compare"},
+    {&QueryClauses{Where: Cond("Tags", Eq, "a")}, "only contains works on lists"},
+    {&QueryClauses{Where: Cond("Score", Contains, "1")}, "contains only works on strings"},
+  }
+  for _, tt := range tests {
+    rows := evalRows()
+    err := ApplyClauses(&rows, tt.qc)
+    if err == nil || !strings.Contains(err.Error(), tt.want) {
+      t.Errorf("%+v: got %v, want %q", tt.qc, err, tt.want)
+    }
+  }
+}
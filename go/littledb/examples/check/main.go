@@ -0,0 +1,105 @@
+// Checks local timesheet files against the TimeWindow struct, e.g.
+//
+//   go run ./littledb/examples/check -csv ../timesheet/sample_data.csv
+//   go run ./littledb/examples/check -sqlite /tmp/times.db -create
+//
+// or a spreadsheet, without a browser session if there's a service account key:
+//
+//   go run ./littledb/examples/check -sheet <id> -service-account key.json
+//   go run ./littledb/examples/check -sheet <id> -client-secrets desktop_client.json
+package main
+
+import (
+  "context"
+  "flag"
+  "fmt"
+  "log"
+  "os"
+  "time"
+
+  "github.com/davedolben/dev-tools/go/littledb"
+  "github.com/davedolben/dev-tools/go/littledb/backends/gsheets"
+  "github.com/davedolben/dev-tools/go/littledb/backends/localfile"
+  "github.com/davedolben/dev-tools/go/littledb/backends/sqlite"
+  "github.com/davedolben/dev-tools/go/littledb/dbcheck"
+  "github.com/davedolben/dev-tools/go/littledb/googleauth"
+)
+
+type TimeWindow struct {
+  What string `littledb:"What"`
+  Date time.Time `littledb:"Date"`
+  In time.Time `littledb:"In,date=Date"`
+  Out time.Time `littledb:"Out,date=Date"`
+  Category string `littledb:"Category"`
+  Project string `littledb:"Project"`
+  Notes string `littledb:"Notes"`
+}
+
+var csvFile = flag.String("csv", "", "CSV or JSON file to check")
+var sqliteFile = flag.String("sqlite", "", "SQLite database to check")
+var sheetId = flag.String("sheet", "", "ID of a spreadsheet to check")
+var sheetRange = flag.String("range", "Time Sheet!A1:G", "range the rows are in")
+var serviceAccount = flag.String("service-account", "", "service account key for -sheet")
+var clientSecrets = flag.String("client-secrets", "", "desktop app OAuth client for -sheet, if there's no service account")
+var create = flag.Bool("create", false, "create header rows and tables that don't exist yet")
+
+func main() {
+  flag.Parse()
+
+  backends := []littledb.Validator{}
+  if *csvFile != "" {
+    backends = append(backends, localfile.NewFileDB().Register(TimeWindow{}, *csvFile))
+  }
+  if *sqliteFile != "" {
+    db, err := sqlite.Open(*sqliteFile)
+    if err != nil {
+      log.Fatal(err)
+    }
+    defer db.Close()
+    backends = append(backends, db.Register(TimeWindow{}, "time_windows"))
+  }
+  if *sheetId != "" {
+    db, err := openSheet(context.Background())
+    if err != nil {
+      log.Fatal(err)
+    }
+    backends = append(backends, db.Register(TimeWindow{}, *sheetRange))
+  }
+  if len(backends) == 0 {
+    log.Fatal("nothing to check, pass -csv, -sqlite or -sheet")
+  }
+
+  dbcheck.Main(*create, backends...)
+}
+
+func openSheet(ctx context.Context) (*gsheets.SheetsDB, error) {
+  scope := googleauth.SheetsReadonlyScope
+  if *create {
+    scope = googleauth.SheetsScope
+  }
+  if *serviceAccount != "" {
+    client, err := googleauth.ServiceAccount(ctx, *serviceAccount, scope)
+    if err != nil {
+      return nil, err
+    }
+    return gsheets.Open(ctx, client, *sheetId)
+  }
+  if *clientSecrets == "" {
+    return nil, fmt.Errorf("-sheet needs -service-account or -client-secrets")
+  }
+
+  conf, err := googleauth.LoadConfig(*clientSecrets, scope)
+  if err != nil {
+    return nil, err
+  }
+  path, err := googleauth.DefaultPath("check")
+  if err != nil {
+    return nil, err
+  }
+  // Tokens for different scopes are kept apart so -create asks for write access once.
+  client, err := googleauth.Installed(ctx, conf, googleauth.NewFileStore(path), scope, os.Stderr)
+  if err != nil {
+    return nil, err
+  }
+  return gsheets.Open(ctx, client, *sheetId)
+}
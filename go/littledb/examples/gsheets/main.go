@@ -1,37 +1,25 @@
 package main
 
 import (
-  "context"
-  "crypto/rand"
-  "encoding/base64"
-  "encoding/gob"
   "encoding/json"
-  "io/ioutil"
+  "errors"
+  "flag"
   "fmt"
   "log"
   "net/http"
+  "os"
+  "strings"
   "time"
 
   "github.com/gorilla/mux"
+  "github.com/gorilla/securecookie"
   "github.com/gorilla/sessions"
-  "golang.org/x/oauth2"
-  "golang.org/x/oauth2/google"
-  "google.golang.org/api/option"
-  "google.golang.org/api/sheets/v4"
 
   "github.com/davedolben/dev-tools/go/littledb/backends/gsheets"
+  "github.com/davedolben/dev-tools/go/littledb/dbcheck"
+  "github.com/davedolben/dev-tools/go/littledb/googleauth"
 )
 
-// Credentials which stores google ids.
-type Credentials struct {
-    Cid     string `json:"client_id"`
-    Csecret string `json:"client_secret"`
-}
-
-type CredentialsFile struct {
-  Creds Credentials `json:"web"`
-}
-
 // User is a retrieved and authentiacted user.
 type User struct {
     Sub string `json:"sub"`
@@ -45,87 +33,111 @@ type User struct {
     Gender string `json:"gender"`
 }
 
-var cred CredentialsFile
-var conf *oauth2.Config
-var state string
-// TODO: in a prod environment this should be randomized
-var store = sessions.NewCookieStore([]byte("session_key_12345"))
+var credentialsFile = flag.String("credentials", "./credentials.json", "OAuth client secrets for a web app")
+var tokenFile = flag.String("tokens", "", "where to keep refresh tokens (defaults to the user config dir)")
+var baseURL = flag.String("url", "http://localhost:8000", "URL the demo is reachable at, used for the OAuth redirect")
+var host = flag.String("host", ":8000", "address to listen on")
 
-func randToken() string {
-	b := make([]byte, 32)
-	rand.Read(b)
-	return base64.StdEncoding.EncodeToString(b)
-}
-
-func init() {
-  gob.Register(&oauth2.Token{})
-
-    file, err := ioutil.ReadFile("./credentials.json")
-    if err != nil {
-        log.Fatalf("File error: %v\n", err)
-    }
-    json.Unmarshal(file, &cred)
+var flow *googleauth.WebFlow
+var store *sessions.CookieStore
 
-    if cred.Creds.Cid == "" || cred.Creds.Csecret == "" {
-      panic("bad credentials")
-    }
+// Set SESSION_KEY to keep sessions across restarts. Otherwise they're signed with a random key.
+func newSessionStore() *sessions.CookieStore {
+  key := []byte(os.Getenv("SESSION_KEY"))
+  if len(key) == 0 {
+    key = securecookie.GenerateRandomKey(32)
+  }
+  s := sessions.NewCookieStore(key)
+  s.Options.HttpOnly = true
+  s.Options.SameSite = http.SameSiteLaxMode
+  return s
+}
 
-    conf = &oauth2.Config{
-        ClientID:     cred.Creds.Cid,
-        ClientSecret: cred.Creds.Csecret,
-        RedirectURL:  "http://localhost:8000/auth",
-        Scopes: []string{
-            "https://www.googleapis.com/auth/spreadsheets",
-        },
-        Endpoint: google.Endpoint,
-    }
+// Tokens live on disk keyed by a random ID kept in the session cookie, rather than in the cookie.
+func sessionID(w http.ResponseWriter, r *http.Request) (string, error) {
+  session, _ := store.Get(r, "gsheets-demo")
+  if id, ok := session.Values["id"].(string); ok {
+    return id, nil
+  }
+  id, err := googleauth.RandomState()
+  if err != nil {
+    return "", err
+  }
+  session.Values["id"] = id
+  return id, session.Save(r, w)
 }
 
 func indexHandler(w http.ResponseWriter, r *http.Request) {
-  fmt.Fprintf(w, `<a href="/login">Login</a> <br/> <a href="/sheets">Sheets</a>`)
+  fmt.Fprintf(w, `<a href="/login">Login</a> <br/> <a href="/sheets">Sheets</a>
+<form method="POST" action="/logout"><button type="submit">Logout</button></form>`)
 }
 
+// In and Out are time-only columns, so take their date from the Date column. Project is loaded from
+// the Projects sheet by the name in the Project column.
 type TimeWindow struct {
   What string `littledb:"What" json:"what"`
-  Date time.Time `littledb:"Date" json:"date"`
-  In time.Time `littledb:"In" json:"in"`
-  Out time.Time `littledb:"Out" json:"out"`
+  Date time.Time `littledb:"Date,tz=Local" json:"date"`
+  In time.Time `littledb:"In,date=Date,tz=Local" json:"in"`
+  Out time.Time `littledb:"Out,date=Date,tz=Local" json:"out"`
+  ProjectName string `littledb:"Project" json:"-"`
+  Project *Project `littledb_ref:"Project" json:"project,omitempty"`
+}
+
+type Project struct {
+  Name string `littledb:"Name,key" json:"name"`
+  Client string `littledb:"Client" json:"client"`
+  Tags []string `littledb:"Tags" json:"tags"`
 }
 
 type Out struct {
   TimeWindows []TimeWindow `json:"time_windows"`
 }
 
-func sheetsHandler(w http.ResponseWriter, r *http.Request) {
-  sheetId := r.FormValue("sheet")
+func sheetsDB(w http.ResponseWriter, r *http.Request) (*gsheets.SheetsDB, error) {
+  id, err := sessionID(w, r)
+  if err != nil {
+    return nil, err
+  }
+  client, err := flow.Client(r.Context(), id)
+  if errors.Is(err, googleauth.ErrNoToken) {
+    return nil, fmt.Errorf("not logged in")
+  } else if err != nil {
+    return nil, err
+  }
 
-  session, _ := store.Get(r, "gsheets-demo")
-  tokenGob := session.Values["oauth2_token"]
-  log.Printf("%v", tokenGob)
-  tok, ok := tokenGob.(*oauth2.Token)
-  if !ok {
-    fmt.Fprintf(w, "error unmarshaling token")
-    return
+  db, err := gsheets.Open(r.Context(), client, r.FormValue("sheet"))
+  if err != nil {
+    return nil, err
   }
 
-  ctx := context.Background()
-  client := conf.Client(ctx, tok)
-  srv, err := sheets.NewService(ctx, option.WithHTTPClient(client))
+  db.Register(TimeWindow{}, "Time Sheet!A1:G100")
+  db.Register(Project{}, "Projects!A1:C100")
+  return db, nil
+}
+
+// Reports whether the sheet's headers still match the structs. Pass create=1 to add missing sheets
+// and header rows.
+func checkHandler(w http.ResponseWriter, r *http.Request) {
+  db, err := sheetsDB(w, r)
   if err != nil {
-    fmt.Fprintf(w, "error creating service: %s", err.Error())
+    fmt.Fprintf(w, "%s", err.Error())
     return
   }
+  w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+  if _, err := dbcheck.Run(w, db, r.FormValue("create") == "1"); err != nil {
+    fmt.Fprintf(w, "error checking sheet: %s", err.Error())
+  }
+}
 
-  db, err := gsheets.NewSheetsDB(srv, sheetId)
+func sheetsHandler(w http.ResponseWriter, r *http.Request) {
+  db, err := sheetsDB(w, r)
   if err != nil {
-    fmt.Fprintf(w, "error building client: %s", err.Error())
+    fmt.Fprintf(w, "%s", err.Error())
     return
   }
 
-  db.Register(TimeWindow{}, "Time Sheet!A1:E100")
-
   out := &Out{}
-  if err := db.Query(&out.TimeWindows).Do(); err != nil {
+  if err := db.Query(&out.TimeWindows).With("Project").Do(); err != nil {
     fmt.Fprintf(w, "error querying spreadsheet: %s", err.Error())
     return
   }
@@ -143,52 +155,78 @@ func sheetsHandler(w http.ResponseWriter, r *http.Request) {
   fmt.Fprintf(w, `%s`, string(bs))
 }
 
-func getLoginURL(state string) string {
-    return conf.AuthCodeURL(state)
-}
-
 func authHandler(w http.ResponseWriter, r *http.Request) {
-    // Handle the exchange code to initiate a transport.
-    qState := r.FormValue("state")
-    if qState != "state" {
-      panic("bad state: " + qState)
-    }
+  id, err := sessionID(w, r)
+  if err != nil {
+    fmt.Fprintf(w, "error saving session: %s", err.Error())
+    return
+  }
+  if _, err := flow.Callback(w, r, id); err != nil {
+    fmt.Fprintf(w, "login failed: %s", err.Error())
+    return
+  }
+  http.Redirect(w, r, "/", http.StatusFound)
+}
 
-	tok, err := conf.Exchange(oauth2.NoContext, r.FormValue("code"))
-	if err != nil {
-    fmt.Fprintf(w, "failed to exchange token: %s", err.Error())
-        return
-	}
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+  // Make sure the session exists before leaving the site.
+  if _, err := sessionID(w, r); err != nil {
+    fmt.Fprintf(w, "error saving session: %s", err.Error())
+    return
+  }
+  flow.Login(w, r)
+}
 
+// Forgets the session's refresh token and the session itself. POST only, so other sites can't log
+// people out with a link.
+func logoutHandler(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
   session, _ := store.Get(r, "gsheets-demo")
-  session.Values["oauth2_token"] = tok
+  if id, ok := session.Values["id"].(string); ok {
+    if err := flow.Logout(id); err != nil {
+      fmt.Fprintf(w, "error logging out: %s", err.Error())
+      return
+    }
+  }
+  session.Options.MaxAge = -1
   if err := session.Save(r, w); err != nil {
     fmt.Fprintf(w, "error saving session: %s", err.Error())
     return
   }
-
-  log.Printf("%+v\n", tok)
   http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func loginHandler(w http.ResponseWriter, r *http.Request) {
-    state = "state"
-    redir := getLoginURL(state)
-    log.Printf("%s\n", redir)
-    fmt.Fprintf(w, "%s", `<html><title>Golang Google</title> <body> <a href="` + redir + `">Login with Google!</a> </body></html>`)
-}
-
 func main() {
-    router := mux.NewRouter()
+  flag.Parse()
 
-    router.HandleFunc("/login", loginHandler)
-    router.HandleFunc("/auth", authHandler)
-    router.HandleFunc("/sheets", sheetsHandler)
-    router.HandleFunc("/", indexHandler)
+  conf, err := googleauth.LoadConfig(*credentialsFile, googleauth.SheetsScope)
+  if err != nil {
+    log.Fatal(err)
+  }
+  conf.RedirectURL = strings.TrimSuffix(*baseURL, "/") + "/auth"
 
-    http.Handle("/", router)
-    host := ":8000"
-    log.Printf("serving on %s", host)
-    http.ListenAndServe(":8000", nil)
-}
+  path := *tokenFile
+  if path == "" {
+    if path, err = googleauth.DefaultPath("gsheets-demo"); err != nil {
+      log.Fatal(err)
+    }
+  }
+  flow = googleauth.NewWebFlow(conf, googleauth.NewFileStore(path))
+  store = newSessionStore()
 
+  router := mux.NewRouter()
+
+  router.HandleFunc("/login", loginHandler)
+  router.HandleFunc("/auth", authHandler)
+  router.HandleFunc("/logout", logoutHandler)
+  router.HandleFunc("/sheets", sheetsHandler)
+  router.HandleFunc("/check", checkHandler)
+  router.HandleFunc("/", indexHandler)
+
+  http.Handle("/", router)
+  log.Printf("serving on %s", *host)
+  log.Fatal(http.ListenAndServe(*host, nil))
+}
@@ -0,0 +1,167 @@
+package googleauth
+
+import (
+  "context"
+  "crypto/subtle"
+  "errors"
+  "fmt"
+  "io"
+  "net"
+  "net/http"
+  "time"
+
+  "golang.org/x/oauth2"
+)
+
+// Installed returns a client for the token saved under key, or runs the installed-app flow to get
+// one: it prints a URL to open, catches the redirect on a loopback port and saves the token. The
+// client secrets need to be for a desktop app.
+func Installed(ctx context.Context, conf *oauth2.Config, store TokenStore, key string, prompt io.Writer) (*http.Client, error) {
+  client, err := Client(ctx, conf, store, key)
+  if !errors.Is(err, ErrNoToken) {
+    return client, err
+  }
+
+  tok, err := installedToken(ctx, conf, prompt)
+  if err != nil {
+    return nil, err
+  }
+  if err := store.Save(key, tok); err != nil {
+    return nil, err
+  }
+  return oauth2.NewClient(ctx, TokenSource(ctx, conf, store, key, tok)), nil
+}
+
+func installedToken(ctx context.Context, conf *oauth2.Config, prompt io.Writer) (*oauth2.Token, error) {
+  l, err := net.Listen("tcp", "127.0.0.1:0")
+  if err != nil {
+    return nil, err
+  }
+  defer l.Close()
+
+  // Copy the config so the shared one keeps its redirect URL.
+  c := *conf
+  c.RedirectURL = fmt.Sprintf("http://%s/", l.Addr().String())
+  state, err := RandomState()
+  if err != nil {
+    return nil, err
+  }
+
+  type result struct {
+    code string
+    err error
+  }
+  results := make(chan result, 1)
+  srv := &http.Server{
+    Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+      if r.URL.Path != "/" {
+        http.NotFound(w, r)
+        return
+      }
+      res := result{code: r.FormValue("code")}
+      switch {
+      case subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(state)) != 1:
+        res.err = fmt.Errorf("bad state in redirect")
+      case r.FormValue("error") != "":
+        res.err = fmt.Errorf("authorization failed: %s", r.FormValue("error"))
+      case res.code == "":
+        res.err = fmt.Errorf("no code in redirect")
+      }
+      if res.err != nil {
+        http.Error(w, res.err.Error(), http.StatusBadRequest)
+      } else {
+        fmt.Fprintf(w, "Authorized, you can close this tab.")
+      }
+      select {
+      case results <- res:
+      default:
+      }
+    }),
+    ReadHeaderTimeout: 10 * time.Second,
+  }
+  go srv.Serve(l)
+  defer srv.Close()
+
+  url := c.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+  fmt.Fprintf(prompt, "Open this URL to authorize access:\n\n  %s\n\n", url)
+
+  select {
+  case <-ctx.Done():
+    return nil, ctx.Err()
+  case res := <-results:
+    if res.err != nil {
+      return nil, res.err
+    }
+    return c.Exchange(ctx, res.code)
+  }
+}
+
+// WebFlow runs the OAuth flow for web apps. The config's RedirectURL has to point at a handler that
+// calls Callback.
+type WebFlow struct {
+  conf *oauth2.Config
+  store TokenStore
+}
+
+func NewWebFlow(conf *oauth2.Config, store TokenStore) *WebFlow {
+  return &WebFlow{conf: conf, store: store}
+}
+
+const stateCookie = "googleauth_state"
+
+// Login redirects to Google's consent page. The state is random and kept in a short-lived cookie for
+// Callback to check.
+func (f *WebFlow) Login(w http.ResponseWriter, r *http.Request) {
+  state, err := RandomState()
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusInternalServerError)
+    return
+  }
+  http.SetCookie(w, &http.Cookie{
+    Name: stateCookie,
+    Value: state,
+    Path: "/",
+    MaxAge: 600,
+    HttpOnly: true,
+    Secure: r.TLS != nil,
+    SameSite: http.SameSiteLaxMode,
+  })
+  // Force the consent prompt so Google sends a refresh token even if the user has logged in before.
+  url := f.conf.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.ApprovalForce)
+  http.Redirect(w, r, url, http.StatusFound)
+}
+
+// Callback checks the state, exchanges the code and saves the token under key, which the app should
+// tie to the user's session.
+func (f *WebFlow) Callback(w http.ResponseWriter, r *http.Request, key string) (*oauth2.Token, error) {
+  cookie, err := r.Cookie(stateCookie)
+  if err != nil {
+    return nil, fmt.Errorf("no login in progress")
+  }
+  http.SetCookie(w, &http.Cookie{Name: stateCookie, Path: "/", MaxAge: -1})
+  if subtle.ConstantTimeCompare([]byte(r.FormValue("state")), []byte(cookie.Value)) != 1 {
+    return nil, fmt.Errorf("bad state")
+  }
+  if e := r.FormValue("error"); e != "" {
+    return nil, fmt.Errorf("authorization failed: %s", e)
+  }
+
+  tok, err := f.conf.Exchange(r.Context(), r.FormValue("code"))
+  if err != nil {
+    return nil, fmt.Errorf("failed to exchange token: %w", err)
+  }
+  if err := f.store.Save(key, tok); err != nil {
+    return nil, err
+  }
+  return tok, nil
+}
+
+// Client returns a client for the token saved under key, or ErrNoToken if the user needs to log in.
+func (f *WebFlow) Client(ctx context.Context, key string) (*http.Client, error) {
+  return Client(ctx, f.conf, f.store, key)
+}
+
+// Logout forgets the token saved under key.
+func (f *WebFlow) Logout(key string) error {
+  return f.store.Delete(key)
+}
@@ -0,0 +1,108 @@
+// Package googleauth gets authorized HTTP clients for Google APIs, e.g. to open a gsheets.SheetsDB.
+//
+// Browser flows (LoadConfig with Installed or WebFlow) keep refresh tokens in a TokenStore on disk and
+// save refreshed tokens back as they're used. Service accounts (ServiceAccount) need no browser at all,
+// which suits CLIs and daemons; share the spreadsheet with the account's email address.
+package googleauth
+
+import (
+  "context"
+  "crypto/rand"
+  "encoding/base64"
+  "fmt"
+  "io/ioutil"
+  "net/http"
+  "sync"
+
+  "golang.org/x/oauth2"
+  "golang.org/x/oauth2/google"
+)
+
+const (
+  SheetsScope = "https://www.googleapis.com/auth/spreadsheets"
+  SheetsReadonlyScope = "https://www.googleapis.com/auth/spreadsheets.readonly"
+  // Enough for gsheets revision checks.
+  DriveMetadataReadonlyScope = "https://www.googleapis.com/auth/drive.metadata.readonly"
+)
+
+// LoadConfig reads an OAuth client secrets file downloaded from the Cloud console. Both "installed"
+// (desktop) and "web" clients work.
+func LoadConfig(filename string, scopes ...string) (*oauth2.Config, error) {
+  bs, err := ioutil.ReadFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  conf, err := google.ConfigFromJSON(bs, scopes...)
+  if err != nil {
+    return nil, fmt.Errorf("%s: %w", filename, err)
+  }
+  return conf, nil
+}
+
+// ServiceAccount returns a client authorized as the service account in a JSON key file.
+func ServiceAccount(ctx context.Context, filename string, scopes ...string) (*http.Client, error) {
+  bs, err := ioutil.ReadFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  conf, err := google.JWTConfigFromJSON(bs, scopes...)
+  if err != nil {
+    return nil, fmt.Errorf("%s: %w", filename, err)
+  }
+  return conf.Client(ctx), nil
+}
+
+// RandomState returns an unguessable value for the OAuth state parameter.
+func RandomState() (string, error) {
+  b := make([]byte, 32)
+  if _, err := rand.Read(b); err != nil {
+    return "", err
+  }
+  return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Client returns a client using the token saved under key, refreshing it as needed and saving the new
+// token back to the store. It returns ErrNoToken if there's nothing saved yet.
+func Client(ctx context.Context, conf *oauth2.Config, store TokenStore, key string) (*http.Client, error) {
+  tok, err := store.Load(key)
+  if err != nil {
+    return nil, err
+  }
+  return oauth2.NewClient(ctx, TokenSource(ctx, conf, store, key, tok)), nil
+}
+
+// TokenSource is like conf.TokenSource, but saves refreshed tokens under key.
+func TokenSource(ctx context.Context, conf *oauth2.Config, store TokenStore, key string, tok *oauth2.Token) oauth2.TokenSource {
+  return oauth2.ReuseTokenSource(tok, &savingSource{
+    src: conf.TokenSource(ctx, tok),
+    store: store,
+    key: key,
+    last: tok.AccessToken,
+  })
+}
+
+type savingSource struct {
+  src oauth2.TokenSource
+  store TokenStore
+  key string
+
+  mu sync.Mutex
+  last string
+}
+
+func (s *savingSource) Token() (*oauth2.Token, error) {
+  tok, err := s.src.Token()
+  if err != nil {
+    return nil, err
+  }
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  if tok.AccessToken != s.last {
+    // Google doesn't send the refresh token again, but the oauth2 package carries it over.
+    if err := s.store.Save(s.key, tok); err != nil {
+      return nil, fmt.Errorf("saving refreshed token: %w", err)
+    }
+    s.last = tok.AccessToken
+  }
+  return tok, nil
+}
@@ -0,0 +1,229 @@
+package googleauth
+
+import (
+  "context"
+  "encoding/json"
+  "errors"
+  "net/http"
+  "net/http/httptest"
+  "net/url"
+  "os"
+  "path/filepath"
+  "strconv"
+  "strings"
+  "sync/atomic"
+  "testing"
+  "time"
+
+  "golang.org/x/oauth2"
+)
+
+// A token endpoint that hands out numbered access tokens for any code or refresh token.
+func tokenServer(t *testing.T) (*httptest.Server, *int32) {
+  var issued int32
+  srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+    r.ParseForm()
+    if r.Form.Get("grant_type") == "authorization_code" && r.Form.Get("code") != "the-code" {
+      http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+      return
+    }
+    n := atomic.AddInt32(&issued, 1)
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+      "access_token": "access-" + strconv.Itoa(int(n)),
+      "refresh_token": "refresh",
+      "token_type": "Bearer",
+      "expires_in": 3600,
+    })
+  }))
+  t.Cleanup(srv.Close)
+  return srv, &issued
+}
+
+func testConfig(srv *httptest.Server) *oauth2.Config {
+  return &oauth2.Config{
+    ClientID: "id",
+    ClientSecret: "secret",
+    RedirectURL: "http://example.com/auth",
+    Endpoint: oauth2.Endpoint{
+      AuthURL: srv.URL + "/auth",
+      TokenURL: srv.URL + "/token",
+      AuthStyle: oauth2.AuthStyleInParams,
+    },
+  }
+}
+
+func TestFileStore(t *testing.T) {
+  path := filepath.Join(t.TempDir(), "sub", "tokens.json")
+  s := NewFileStore(path)
+
+  if _, err := s.Load("a"); !errors.Is(err, ErrNoToken) {
+    t.Fatalf("got %v, want ErrNoToken", err)
+  }
+  if err := s.Save("a", &oauth2.Token{AccessToken: "x", RefreshToken: "r"}); err != nil {
+    t.Fatal(err)
+  }
+  if err := s.Save("b", &oauth2.Token{AccessToken: "y"}); err != nil {
+    t.Fatal(err)
+  }
+
+  tok, err := NewFileStore(path).Load("a")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if tok.AccessToken != "x" || tok.RefreshToken != "r" {
+    t.Errorf("got %+v", tok)
+  }
+  info, err := os.Stat(path)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if info.Mode().Perm() != 0600 {
+    t.Errorf("got mode %v, want 0600", info.Mode().Perm())
+  }
+
+  if err := s.Delete("a"); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := s.Load("a"); !errors.Is(err, ErrNoToken) {
+    t.Errorf("got %v after delete, want ErrNoToken", err)
+  }
+  if _, err := s.Load("b"); err != nil {
+    t.Errorf("lost the other token: %v", err)
+  }
+}
+
+func TestClientSavesRefreshedToken(t *testing.T) {
+  srv, issued := tokenServer(t)
+  conf := testConfig(srv)
+  store := NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+  expired := &oauth2.Token{AccessToken: "old", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Hour)}
+  if err := store.Save("me", expired); err != nil {
+    t.Fatal(err)
+  }
+
+  ctx := context.Background()
+  src := TokenSource(ctx, conf, store, "me", expired)
+  for i := 0; i < 3; i++ {
+    tok, err := src.Token()
+    if err != nil {
+      t.Fatal(err)
+    }
+    if tok.AccessToken != "access-1" {
+      t.Errorf("got %q, want the refreshed token", tok.AccessToken)
+    }
+  }
+  if atomic.LoadInt32(issued) != 1 {
+    t.Errorf("refreshed %d times, want 1", atomic.LoadInt32(issued))
+  }
+  saved, err := store.Load("me")
+  if err != nil {
+    t.Fatal(err)
+  }
+  if saved.AccessToken != "access-1" || saved.RefreshToken != "refresh" {
+    t.Errorf("saved %+v", saved)
+  }
+}
+
+func TestWebFlow(t *testing.T) {
+  srv, _ := tokenServer(t)
+  store := NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+  flow := NewWebFlow(testConfig(srv), store)
+
+  if _, err := flow.Client(context.Background(), "session"); !errors.Is(err, ErrNoToken) {
+    t.Fatalf("got %v, want ErrNoToken before login", err)
+  }
+
+  w := httptest.NewRecorder()
+  flow.Login(w, httptest.NewRequest("GET", "/login", nil))
+  loc, err := url.Parse(w.Header().Get("Location"))
+  if err != nil {
+    t.Fatal(err)
+  }
+  state := loc.Query().Get("state")
+  if len(state) < 40 || state == "state" {
+    t.Fatalf("state %q doesn't look random", state)
+  }
+  if loc.Query().Get("access_type") != "offline" {
+    t.Errorf("login doesn't ask for a refresh token: %s", loc)
+  }
+  cookies := w.Result().Cookies()
+
+  callback := func(state string) (*oauth2.Token, error) {
+    r := httptest.NewRequest("GET", "/auth?code=the-code&state=" + url.QueryEscape(state), nil)
+    for _, c := range cookies {
+      r.AddCookie(c)
+    }
+    return flow.Callback(httptest.NewRecorder(), r, "session")
+  }
+  if _, err := callback("forged"); err == nil || !strings.Contains(err.Error(), "bad state") {
+    t.Errorf("got %v for a forged state", err)
+  }
+  if _, err := callback(state); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := flow.Client(context.Background(), "session"); err != nil {
+    t.Errorf("no client after login: %v", err)
+  }
+
+  if err := flow.Logout("session"); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := flow.Client(context.Background(), "session"); !errors.Is(err, ErrNoToken) {
+    t.Errorf("got %v after logout, want ErrNoToken", err)
+  }
+}
+
+// Plays the browser: follows the printed URL's redirect straight back to the loopback listener.
+type fakeBrowser struct {
+  t *testing.T
+}
+
+func (b fakeBrowser) Write(p []byte) (int, error) {
+  for _, field := range strings.Fields(string(p)) {
+    u, err := url.Parse(field)
+    if err != nil || u.Scheme != "http" {
+      continue
+    }
+    redirect, _ := url.Parse(u.Query().Get("redirect_uri"))
+    q := redirect.Query()
+    q.Set("code", "the-code")
+    q.Set("state", u.Query().Get("state"))
+    redirect.RawQuery = q.Encode()
+    go func() {
+      rsp, err := http.Get(redirect.String())
+      if err != nil {
+        b.t.Error(err)
+        return
+      }
+      rsp.Body.Close()
+    }()
+  }
+  return len(p), nil
+}
+
+func TestInstalled(t *testing.T) {
+  srv, issued := tokenServer(t)
+  conf := testConfig(srv)
+  store := NewFileStore(filepath.Join(t.TempDir(), "tokens.json"))
+  ctx, cancel := context.WithTimeout(context.Background(), 10 * time.Second)
+  defer cancel()
+
+  if _, err := Installed(ctx, conf, store, "cli", fakeBrowser{t}); err != nil {
+    t.Fatal(err)
+  }
+  if conf.RedirectURL != "http://example.com/auth" {
+    t.Errorf("changed the shared config's redirect URL to %q", conf.RedirectURL)
+  }
+  if _, err := store.Load("cli"); err != nil {
+    t.Fatal(err)
+  }
+
+  // The saved token is used without going through the browser again.
+  if _, err := Installed(ctx, conf, store, "cli", nil); err != nil {
+    t.Fatal(err)
+  }
+  if atomic.LoadInt32(issued) != 1 {
+    t.Errorf("issued %d tokens, want 1", atomic.LoadInt32(issued))
+  }
+}
@@ -0,0 +1,120 @@
+package googleauth
+
+import (
+  "encoding/json"
+  "errors"
+  "fmt"
+  "io/ioutil"
+  "os"
+  "path/filepath"
+  "sync"
+
+  "golang.org/x/oauth2"
+)
+
+var ErrNoToken = errors.New("googleauth: no saved token")
+
+// TokenStore keeps tokens by key, e.g. a user or session ID for web apps, or a fixed name for CLIs.
+type TokenStore interface {
+  // Load returns ErrNoToken if there isn't one for key.
+  Load(key string) (*oauth2.Token, error)
+  Save(key string, tok *oauth2.Token) error
+  Delete(key string) error
+}
+
+// FileStore keeps every token in one JSON file, readable only by the owner.
+type FileStore struct {
+  path string
+  mu sync.Mutex
+}
+
+func NewFileStore(path string) *FileStore {
+  return &FileStore{path: path}
+}
+
+// DefaultPath is a token file under the user's config directory, e.g. ~/.config/littledb/<name>.json.
+func DefaultPath(name string) (string, error) {
+  dir, err := os.UserConfigDir()
+  if err != nil {
+    return "", err
+  }
+  return filepath.Join(dir, "littledb", name + ".json"), nil
+}
+
+func (s *FileStore) read() (map[string]*oauth2.Token, error) {
+  tokens := make(map[string]*oauth2.Token)
+  bs, err := ioutil.ReadFile(s.path)
+  if os.IsNotExist(err) {
+    return tokens, nil
+  }
+  if err != nil {
+    return nil, err
+  }
+  if err := json.Unmarshal(bs, &tokens); err != nil {
+    return nil, fmt.Errorf("%s: %w", s.path, err)
+  }
+  return tokens, nil
+}
+
+func (s *FileStore) write(tokens map[string]*oauth2.Token) error {
+  bs, err := json.MarshalIndent(tokens, "", "  ")
+  if err != nil {
+    return err
+  }
+  if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+    return err
+  }
+  tmp, err := ioutil.TempFile(filepath.Dir(s.path), filepath.Base(s.path) + ".*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(tmp.Name())
+  // TempFile already creates the file as 0600.
+  if _, err := tmp.Write(bs); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Close(); err != nil {
+    return err
+  }
+  return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *FileStore) Load(key string) (*oauth2.Token, error) {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  tokens, err := s.read()
+  if err != nil {
+    return nil, err
+  }
+  tok, ok := tokens[key]
+  if !ok {
+    return nil, fmt.Errorf("%w for %q", ErrNoToken, key)
+  }
+  return tok, nil
+}
+
+func (s *FileStore) Save(key string, tok *oauth2.Token) error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  tokens, err := s.read()
+  if err != nil {
+    return err
+  }
+  tokens[key] = tok
+  return s.write(tokens)
+}
+
+func (s *FileStore) Delete(key string) error {
+  s.mu.Lock()
+  defer s.mu.Unlock()
+  tokens, err := s.read()
+  if err != nil {
+    return err
+  }
+  if _, ok := tokens[key]; !ok {
+    return nil
+  }
+  delete(tokens, key)
+  return s.write(tokens)
+}
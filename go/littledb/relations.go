@@ -0,0 +1,199 @@
+package littledb
+
+import (
+  "fmt"
+  "reflect"
+)
+
+// How many keys go into one query for related rows.
+var RelationBatchSize = 100
+
+// LoadRelations fills in the named relations (or all of them) for the rows in data, a pointer to a
+// slice as passed to DB.Query. Related rows are fetched from db with one query per relation per
+// RelationBatchSize distinct keys, rather than one per row. Rows with no related row get nil.
+func LoadRelations(db DB, data interface{}, names ...string) error {
+  t, err := SliceElemType(data)
+  if err != nil {
+    return err
+  }
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  schema, err := SchemaOf(t)
+  if err != nil {
+    return err
+  }
+  rels := []*Relation{}
+  if len(names) == 0 {
+    for i := range schema.Relations {
+      rels = append(rels, &schema.Relations[i])
+    }
+  }
+  for _, name := range names {
+    r, ok := schema.Relation(name)
+    if !ok {
+      return fmt.Errorf("%v has no littledb relation %q", t, name)
+    }
+    rels = append(rels, r)
+  }
+
+  vSlice := reflect.ValueOf(data).Elem()
+  rows := make([]reflect.Value, 0, vSlice.Len())
+  for i := 0; i < vSlice.Len(); i++ {
+    if row, ok := indirect(vSlice.Index(i)); ok {
+      rows = append(rows, row)
+    }
+  }
+  if len(rows) == 0 {
+    return nil
+  }
+
+  for _, r := range rels {
+    var err error
+    if r.Many {
+      err = loadHasMany(db, schema, r, rows)
+    } else {
+      err = loadBelongsTo(db, schema, r, rows)
+    }
+    if err != nil {
+      return fmt.Errorf("%v: relation %q: %w", t, r.Name, err)
+    }
+  }
+  return nil
+}
+
+// The distinct values of a column in rows, converted to the type of the column they'll be compared
+// to, in order of first appearance.
+func relationKeys(from *Field, rows []reflect.Value, to *Field) ([]interface{}, error) {
+  seen := make(map[string]bool)
+  keys := []interface{}{}
+  for _, row := range rows {
+    v, ok := indirect(from.Value(row))
+    if !ok {
+      continue
+    }
+    s := FormatValue(v)
+    if s == "" || seen[s] {
+      continue
+    }
+    seen[s] = true
+    k := reflect.New(to.Type).Elem()
+    if err := to.ParseValue(k, s); err != nil {
+      return nil, fmt.Errorf("key %q: %w", s, err)
+    }
+    keys = append(keys, k.Interface())
+  }
+  return keys, nil
+}
+
+// Query rows of type t whose field matches one of values, a batch at a time.
+func queryIn(db DB, t reflect.Type, field string, values []interface{}) (reflect.Value, error) {
+  all := reflect.MakeSlice(reflect.SliceOf(t), 0, len(values))
+  for start := 0; start < len(values); start += RelationBatchSize {
+    end := min(start+RelationBatchSize, len(values))
+    conds := make([]*Clause, 0, end-start)
+    for _, v := range values[start:end] {
+      conds = append(conds, Cond(field, Eq, v))
+    }
+    batch := reflect.New(reflect.SliceOf(t))
+    if err := db.Query(batch.Interface()).WhereClause(Or(conds...)).Do(); err != nil {
+      return reflect.Value{}, err
+    }
+    all = reflect.AppendSlice(all, batch.Elem())
+  }
+  return all, nil
+}
+
+// Set a relation field to a related row, which is addressable.
+func setRelated(field reflect.Value, related reflect.Value) {
+  if field.Kind() == reflect.Ptr {
+    field.Set(related.Addr())
+  } else {
+    field.Set(related)
+  }
+}
+
+func loadBelongsTo(db DB, schema *Schema, r *Relation, rows []reflect.Value) error {
+  col, _ := schema.Field(r.Column)
+  target, err := SchemaOf(r.Target)
+  if err != nil {
+    return err
+  }
+  key, err := target.Key()
+  if err != nil {
+    return err
+  }
+  keys, err := relationKeys(col, rows, key)
+  if err != nil {
+    return err
+  }
+  related, err := queryIn(db, r.Target, key.Name, keys)
+  if err != nil {
+    return err
+  }
+
+  byKey := make(map[string]reflect.Value)
+  for i := 0; i < related.Len(); i++ {
+    byKey[FormatValue(key.Value(related.Index(i)))] = related.Index(i)
+  }
+  for _, row := range rows {
+    field, ok := fieldByIndex(row, r.Index)
+    if !ok {
+      continue
+    }
+    match, found := byKey[FormatValue(col.Value(row))]
+    if !found {
+      field.Set(reflect.Zero(r.Type))
+      continue
+    }
+    setRelated(field, match)
+  }
+  return nil
+}
+
+func loadHasMany(db DB, schema *Schema, r *Relation, rows []reflect.Value) error {
+  key, err := schema.Key()
+  if err != nil {
+    return err
+  }
+  target, err := SchemaOf(r.Target)
+  if err != nil {
+    return err
+  }
+  col, ok := target.Field(r.Column)
+  if !ok {
+    return fmt.Errorf("%v has no littledb field %q", r.Target, r.Column)
+  }
+  keys, err := relationKeys(key, rows, col)
+  if err != nil {
+    return err
+  }
+  related, err := queryIn(db, r.Target, col.Name, keys)
+  if err != nil {
+    return err
+  }
+
+  groups := make(map[string]reflect.Value)
+  for i := 0; i < related.Len(); i++ {
+    k := FormatValue(col.Value(related.Index(i)))
+    g, ok := groups[k]
+    if !ok {
+      g = reflect.MakeSlice(r.Type, 0, 1)
+    }
+    item := reflect.New(r.Type.Elem()).Elem()
+    setRelated(item, related.Index(i))
+    groups[k] = reflect.Append(g, item)
+  }
+  for _, row := range rows {
+    field, ok := fieldByIndex(row, r.Index)
+    if !ok {
+      continue
+    }
+    g, found := groups[FormatValue(key.Value(row))]
+    if !found {
+      g = reflect.Zero(r.Type)
+    }
+    field.Set(g)
+  }
+  return nil
+}
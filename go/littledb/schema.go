@@ -0,0 +1,338 @@
+package littledb
+
+import (
+  "errors"
+  "fmt"
+  "reflect"
+  "strings"
+  "time"
+)
+
+// Field is a struct field mapped to a column through its `littledb:"..."` tag.
+type Field struct {
+  // Column name from the tag.
+  Name string
+  // Index path of the struct field, which is longer than one for fields of embedded structs. Use
+  // Value to get at the field.
+  Index []int
+  Type reflect.Type
+  // Set by the "key" tag option, e.g. `littledb:"ID,key"`. Update and Delete find rows by this field.
+  Key bool
+  // Everything after the name in the tag, for backend-specific options.
+  Options []string
+
+  // For time fields. Layouts come from a separate `littledb_layout:"..."` tag since they can contain
+  // commas; several can be given separated by "|". The timezone is the "tz" option, e.g.
+  // `littledb:"In,tz=America/Los_Angeles"`, and defaults to UTC.
+  Layouts []string
+  Location *time.Location
+  // The "date" option names another time field to take the date from, for time-only columns like
+  // `littledb:"In,date=Date"`.
+  DateField string
+
+  // For slice fields, which are stored as one delimited cell. The "sep" option sets the separator,
+  // e.g. `littledb:"Tags,sep=;"`, and defaults to a comma.
+  Separator string
+}
+
+// Relation is a field filled in from another registered type by With or LoadRelations rather than
+// stored in a column. It's tagged `littledb_ref:"Column"`:
+//
+//   // Belongs to: Project holds the key of a Project.
+//   Project *Project `littledb_ref:"Project"`
+//   // Has many: the TimeWindow "Project" column holds this row's key.
+//   Windows []TimeWindow `littledb_ref:"Project"`
+type Relation struct {
+  // The Go field name, which is what With takes.
+  Name string
+  Index []int
+  Type reflect.Type
+  // The related struct type.
+  Target reflect.Type
+  // For struct and pointer fields, the column in this type holding the target's key. For slice
+  // fields, the column in the target holding this type's key.
+  Column string
+  Many bool
+}
+
+// Schema describes how a struct type maps to columns.
+type Schema struct {
+  Type reflect.Type
+  Fields []Field
+  Relations []Relation
+}
+
+// SchemaOf reads the littledb tags on a struct type. Fields without a tag, or tagged "-", are skipped.
+// The fields of embedded structs without a tag are read as if they were in t.
+func SchemaOf(t reflect.Type) (*Schema, error) {
+  if t.Kind() != reflect.Struct {
+    return nil, fmt.Errorf("not a struct: %v", t)
+  }
+  s := &Schema{Type: t}
+  if err := s.addFields(t, nil); err != nil {
+    return nil, err
+  }
+
+  for _, f := range s.Fields {
+    if f.DateField == "" {
+      continue
+    }
+    if df, ok := s.Field(f.DateField); !ok || !isTimeType(df.Type) {
+      return nil, fmt.Errorf("%v: field %q: date field %q isn't a time field", t, f.Name, f.DateField)
+    }
+  }
+  for _, r := range s.Relations {
+    if _, ok := s.Field(r.Column); !r.Many && !ok {
+      return nil, fmt.Errorf("%v: relation %q: no column %q", t, r.Name, r.Column)
+    }
+  }
+  return s, nil
+}
+
+func (s *Schema) addFields(t reflect.Type, index []int) error {
+  for i := 0; i < t.NumField(); i++ {
+    f := t.Field(i)
+    fIndex := append(append([]int{}, index...), i)
+    tag, ok := f.Tag.Lookup("littledb")
+    if tag == "-" {
+      continue
+    }
+    if ref, isRef := f.Tag.Lookup("littledb_ref"); isRef {
+      if err := s.addRelation(f, fIndex, ref); err != nil {
+        return err
+      }
+      continue
+    }
+    if !ok {
+      if err := s.addEmbedded(f, fIndex); err != nil {
+        return err
+      }
+      continue
+    }
+    if err := s.addField(f, fIndex, tag); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func (s *Schema) addEmbedded(f reflect.StructField, index []int) error {
+  if !f.Anonymous {
+    return nil
+  }
+  et := f.Type
+  if et.Kind() == reflect.Ptr {
+    if !f.IsExported() {
+      // Can't allocate it through reflection.
+      return fmt.Errorf("%v: embedded %v has to be exported to be used through a pointer", s.Type, et)
+    }
+    et = et.Elem()
+  }
+  if et.Kind() != reflect.Struct || et == timeType {
+    return nil
+  }
+  return s.addFields(et, index)
+}
+
+func (s *Schema) addField(f reflect.StructField, index []int, tag string) error {
+  t := s.Type
+  name, opts, _ := strings.Cut(tag, ",")
+  if name == "" {
+    name = f.Name
+  }
+  if _, exists := s.Field(name); exists {
+    return fmt.Errorf("%v: duplicate column %q", t, name)
+  }
+  field := Field{
+    Name: name,
+    Index: index,
+    Type: f.Type,
+  }
+  if opts != "" {
+    field.Options = strings.Split(opts, ",")
+  }
+  for _, opt := range field.Options {
+    k, v, _ := strings.Cut(opt, "=")
+    switch k {
+    case "key":
+      field.Key = true
+    case "tz":
+      loc, err := time.LoadLocation(v)
+      if err != nil {
+        return fmt.Errorf("%v: field %q: %w", t, name, err)
+      }
+      field.Location = loc
+    case "date":
+      field.DateField = v
+    case "sep":
+      field.Separator = v
+    }
+  }
+  if layouts, ok := f.Tag.Lookup("littledb_layout"); ok && layouts != "" {
+    field.Layouts = strings.Split(layouts, "|")
+  }
+  if field.Location != nil || field.Layouts != nil || field.DateField != "" {
+    if !isTimeType(f.Type) {
+      return fmt.Errorf("%v: field %q: layouts, tz and date only work on time.Time fields", t, name)
+    }
+  }
+  if isListType(f.Type) {
+    if field.Separator == "" {
+      field.Separator = ","
+    }
+    if field.Key {
+      return fmt.Errorf("%v: field %q: a list can't be the key", t, name)
+    }
+  } else if field.Separator != "" {
+    return fmt.Errorf("%v: field %q: sep only works on slice fields", t, name)
+  }
+  if field.Key {
+    if k, err := s.Key(); err == nil {
+      return fmt.Errorf("%v: more than one key field (%q and %q)", t, k.Name, name)
+    }
+  }
+  s.Fields = append(s.Fields, field)
+  return nil
+}
+
+func (s *Schema) addRelation(f reflect.StructField, index []int, column string) error {
+  r := Relation{
+    Name: f.Name,
+    Index: index,
+    Type: f.Type,
+    Column: column,
+  }
+  target := f.Type
+  if target.Kind() == reflect.Slice {
+    r.Many = true
+    target = target.Elem()
+  }
+  if target.Kind() == reflect.Ptr {
+    target = target.Elem()
+  }
+  if target.Kind() != reflect.Struct || column == "" {
+    return fmt.Errorf("%v: relation %q needs a column and a struct, pointer or slice of structs", s.Type, f.Name)
+  }
+  r.Target = target
+  s.Relations = append(s.Relations, r)
+  return nil
+}
+
+// Slices other than []byte are stored as delimited lists.
+func isListType(t reflect.Type) bool {
+  return t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8
+}
+
+func isTimeType(t reflect.Type) bool {
+  return t == timeType || (t.Kind() == reflect.Ptr && t.Elem() == timeType)
+}
+
+// Key returns the field tagged with the "key" option.
+func (s *Schema) Key() (*Field, error) {
+  for i := range s.Fields {
+    if s.Fields[i].Key {
+      return &s.Fields[i], nil
+    }
+  }
+  return nil, fmt.Errorf("%v has no littledb key field", s.Type)
+}
+
+// IsList reports whether the field is a slice stored as a delimited list. f can be nil.
+func (f *Field) IsList() bool {
+  return f != nil && f.Separator != ""
+}
+
+// Value returns the field in row, a struct value of the schema's type. Nil embedded pointers are
+// allocated on the way if row is settable, and read as the zero value otherwise.
+func (f *Field) Value(row reflect.Value) reflect.Value {
+  v, ok := fieldByIndex(row, f.Index)
+  if !ok {
+    return reflect.Zero(f.Type)
+  }
+  return v
+}
+
+func fieldByIndex(v reflect.Value, index []int) (reflect.Value, bool) {
+  for i, x := range index {
+    if i > 0 && v.Kind() == reflect.Ptr {
+      if v.IsNil() {
+        if !v.CanSet() {
+          return reflect.Value{}, false
+        }
+        v.Set(reflect.New(v.Type().Elem()))
+      }
+      v = v.Elem()
+    }
+    v = v.Field(x)
+  }
+  return v, true
+}
+
+// Relation looks up a relation by its Go field name.
+func (s *Schema) Relation(name string) (*Relation, bool) {
+  for i := range s.Relations {
+    if s.Relations[i].Name == name {
+      return &s.Relations[i], true
+    }
+  }
+  return nil, false
+}
+
+// Field looks up a field by column name.
+func (s *Schema) Field(name string) (*Field, bool) {
+  for i := range s.Fields {
+    if s.Fields[i].Name == name {
+      return &s.Fields[i], true
+    }
+  }
+  return nil, false
+}
+
+// SliceElemType returns the element type of the slice that data points to. This is the form
+// DB.Query expects its argument in.
+func SliceElemType(data interface{}) (reflect.Type, error) {
+  t := reflect.TypeOf(data)
+  if t == nil || t.Kind() != reflect.Ptr {
+    return nil, errors.New("not a pointer")
+  }
+  if t.Elem().Kind() != reflect.Slice {
+    return nil, errors.New("not a slice")
+  }
+  return t.Elem().Elem(), nil
+}
+
+// Rows unpacks the argument to Insert, Update or Delete: a struct, a pointer to one, or a slice (or
+// pointer to a slice) of either. Returns the struct type and the rows as struct values.
+func Rows(data interface{}) (reflect.Type, []reflect.Value, error) {
+  v := reflect.ValueOf(data)
+  if !v.IsValid() {
+    return nil, nil, errors.New("nil data")
+  }
+  if v.Kind() == reflect.Ptr && v.Elem().Kind() == reflect.Slice {
+    v = v.Elem()
+  }
+  if v.Kind() != reflect.Slice {
+    row, ok := indirect(v)
+    if !ok || row.Kind() != reflect.Struct {
+      return nil, nil, fmt.Errorf("not a struct or slice of structs: %v", v.Type())
+    }
+    return row.Type(), []reflect.Value{row}, nil
+  }
+
+  t := v.Type().Elem()
+  if t.Kind() == reflect.Ptr {
+    t = t.Elem()
+  }
+  if t.Kind() != reflect.Struct {
+    return nil, nil, fmt.Errorf("not a slice of structs: %v", v.Type())
+  }
+  rows := make([]reflect.Value, 0, v.Len())
+  for i := 0; i < v.Len(); i++ {
+    row, ok := indirect(v.Index(i))
+    if !ok {
+      return nil, nil, fmt.Errorf("nil row at index %d", i)
+    }
+    rows = append(rows, row)
+  }
+  return t, rows, nil
+}
@@ -0,0 +1,148 @@
+package littledb
+
+import (
+  "fmt"
+  "reflect"
+  "strings"
+  "unicode"
+)
+
+// Drift is how the columns a backend has for a registered type differ from the type's tags.
+type Drift struct {
+  Type reflect.Type
+  // The range, table or file the type is registered to.
+  Table string
+  // The header row, table or file doesn't exist yet.
+  NoTable bool
+  // Tagged in the struct but not in the backend.
+  Missing []string
+  // In the backend but not in the struct.
+  Extra []string
+  // Missing columns that look like an extra column under a different name.
+  Renamed []Rename
+}
+
+type Rename struct {
+  // The backend's name.
+  From string
+  // The struct's name.
+  To string
+}
+
+func (d *Drift) OK() bool {
+  return !d.NoTable && len(d.Missing) == 0 && len(d.Renamed) == 0
+}
+
+func (d *Drift) String() string {
+  if d.NoTable {
+    return fmt.Sprintf("%v (%s): no header row or table", d.Type, d.Table)
+  }
+  parts := []string{}
+  for _, r := range d.Renamed {
+    parts = append(parts, fmt.Sprintf("renamed %q -> %q", r.From, r.To))
+  }
+  for _, m := range d.Missing {
+    parts = append(parts, fmt.Sprintf("missing %q", m))
+  }
+  for _, e := range d.Extra {
+    parts = append(parts, fmt.Sprintf("extra %q", e))
+  }
+  if len(parts) == 0 {
+    return fmt.Sprintf("%v (%s): ok", d.Type, d.Table)
+  }
+  return fmt.Sprintf("%v (%s): %s", d.Type, d.Table, strings.Join(parts, ", "))
+}
+
+// Validator is implemented by backends that can check registered types against what they store.
+type Validator interface {
+  // Validate returns the drift for every registered type, in no particular order.
+  Validate() ([]*Drift, error)
+  // CreateMissing creates the header rows, tables or files for registered types that don't have one.
+  CreateMissing() error
+}
+
+// CompareColumns works out the drift between a schema and a backend's column names. Extra columns
+// are fine for reading, but are reported so renames can be spotted.
+func CompareColumns(schema *Schema, columns []string) *Drift {
+  d := &Drift{Type: schema.Type}
+  have := make(map[string]bool)
+  for _, c := range columns {
+    have[c] = true
+  }
+  want := make(map[string]bool)
+  for _, f := range schema.Fields {
+    want[f.Name] = true
+    if !have[f.Name] {
+      d.Missing = append(d.Missing, f.Name)
+    }
+  }
+  for _, c := range columns {
+    if !want[c] && c != "" {
+      d.Extra = append(d.Extra, c)
+    }
+  }
+
+  // Pair up missing and extra columns that only differ by case, spacing or a typo or two.
+  missing := []string{}
+  for _, m := range d.Missing {
+    found := -1
+    for i, e := range d.Extra {
+      if similarNames(m, e) {
+        found = i
+        break
+      }
+    }
+    if found < 0 {
+      missing = append(missing, m)
+      continue
+    }
+    d.Renamed = append(d.Renamed, Rename{From: d.Extra[found], To: m})
+    d.Extra = append(d.Extra[:found:found], d.Extra[found+1:]...)
+  }
+  d.Missing = missing
+  return d
+}
+
+func normalizeName(s string) string {
+  out := []rune{}
+  for _, r := range strings.ToLower(s) {
+    if unicode.IsLetter(r) || unicode.IsDigit(r) {
+      out = append(out, r)
+    }
+  }
+  return string(out)
+}
+
+func similarNames(a, b string) bool {
+  na, nb := normalizeName(a), normalizeName(b)
+  if na == nb {
+    return true
+  }
+  // Allow about one edit per four characters.
+  limit := len(na) / 4
+  if len(nb) < len(na) {
+    limit = len(nb) / 4
+  }
+  return limit > 0 && editDistance(na, nb) <= limit
+}
+
+func editDistance(a, b string) int {
+  ra, rb := []rune(a), []rune(b)
+  prev := make([]int, len(rb)+1)
+  cur := make([]int, len(rb)+1)
+  for j := range prev {
+    prev[j] = j
+  }
+  for i := 1; i <= len(ra); i++ {
+    cur[0] = i
+    for j := 1; j <= len(rb); j++ {
+      cost := 1
+      if ra[i-1] == rb[j-1] {
+        cost = 0
+      }
+      cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+    }
+    prev, cur = cur, prev
+  }
+  return prev[len(rb)]
+}
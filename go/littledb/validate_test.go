@@ -0,0 +1,111 @@
+package littledb
+
+import (
+  "fmt"
+  "reflect"
+  "testing"
+)
+
+func TestEditDistance(t *testing.T) {
+  tests := []struct {
+    a, b string
+    want int
+  }{
+    {"", "", 0},
+    {"abc", "", 3},
+    {"", "abc", 3},
+    {"kitten", "sitting", 3},
+    {"category", "catagory", 1},
+    {"project", "projects", 1},
+    {"notes", "note", 1},
+    {"ab", "ba", 2},
+    // Runes, not bytes.
+    {"café", "cafe", 1},
+  }
+  for _, tt := range tests {
+    if got := editDistance(tt.a, tt.b); got != tt.want {
+      t.Errorf("editDistance(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+    }
+    if got := editDistance(tt.b, tt.a); got != tt.want {
+      t.Errorf("editDistance(%q, %q) = %d, want %d", tt.b, tt.a, got, tt.want)
+    }
+  }
+}
+
+func TestSimilarNames(t *testing.T) {
+  tests := []struct {
+    a, b string
+    want bool
+  }{
+    {"Category", "category", true},
+    {"Start Time", "start_time", true},
+    {"StartTime", "start-time ", true},
+    {"Category", "Catagory", true},
+    {"Project", "Projects", true},
+    // Short names need to match exactly once they're normalized.
+    {"In", "On", false},
+    {"Out", "Cut", false},
+    {"ID", "id", true},
+    {"Notes", "Date", false},
+    {"Description", "Desc", false},
+    {"Category", "Project", false},
+  }
+  for _, tt := range tests {
+    if got := similarNames(tt.a, tt.b); got != tt.want {
+      t.Errorf("similarNames(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+    }
+  }
+}
+
+type compareRow struct {
+  What string `littledb:"What"`
+  Category string `littledb:"Category"`
+  Project string `littledb:"Project"`
+  Notes string `littledb:"Notes"`
+}
+
+func TestCompareColumns(t *testing.T) {
+  schema, err := SchemaOf(reflect.TypeOf(compareRow{}))
+  if err != nil {
+    t.Fatal(err)
+  }
+  tests := []struct {
+    columns []string
+    want Drift
+    ok bool
+  }{
+    {[]string{"What", "Category", "Project", "Notes"}, Drift{}, true},
+    // Order doesn't matter, and blank header cells are ignored.
+    {[]string{"Notes", "", "Project", "What", "Category"}, Drift{}, true},
+    {[]string{"What", "Category", "Project", "Notes", "Hours"}, Drift{Extra: []string{"Hours"}}, true},
+    {[]string{"What", "Category"}, Drift{Missing: []string{"Project", "Notes"}}, false},
+    {
+      []string{"what", "Catagory", "Project", "Hours"},
+      Drift{
+        Missing: []string{"Notes"},
+        Extra: []string{"Hours"},
+        Renamed: []Rename{{From: "what", To: "What"}, {From: "Catagory", To: "Category"}},
+      },
+      false,
+    },
+    // Renames are found for several columns at once.
+    {
+      []string{"project", "Category", "What", "Note"},
+      Drift{Renamed: []Rename{{From: "project", To: "Project"}, {From: "Note", To: "Notes"}}},
+      false,
+    },
+    {nil, Drift{Missing: []string{"What", "Category", "Project", "Notes"}}, false},
+  }
+  for _, tt := range tests {
+    d := CompareColumns(schema, tt.columns)
+    // Empty and nil lists are the same thing here.
+    got := fmt.Sprint(d.Missing, d.Extra, d.Renamed)
+    want := fmt.Sprint(tt.want.Missing, tt.want.Extra, tt.want.Renamed)
+    if d.Type != schema.Type || got != want {
+      t.Errorf("%q: got %+v, want %+v", tt.columns, *d, tt.want)
+    }
+    if d.OK() != tt.ok {
+      t.Errorf("%q: got OK %v", tt.columns, d.OK())
+    }
+  }
+}
@@ -2,12 +2,14 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"path/filepath"
 	"strconv"
+	"time"
 
 	"github.com/davedolben/dev-tools/go/notes/data"
 
@@ -16,11 +18,12 @@ import (
 )
 
 var gDb data.NotesDatabase
+var gNotesDb data.NotesDatabase
 var gBlobStore data.BlobStore
 
-func InitDatabase(root string) {
+func InitDatabase(storage, root string) {
   var err error
-  gDb, err = data.NewTextDatabase(filepath.Join(root, "bookmarks.json"))
+  gDb, err = data.OpenDatabase(storage, root, "bookmarks")
   if err != nil {
     panic(err)
   }
@@ -38,28 +41,34 @@ func UseDatabase(db data.NotesDatabase) {
 	gDb = db
 }
 
+// UseNotesDatabase serves the notes trash and revisions under /notes/.
+func UseNotesDatabase(db data.NotesDatabase) {
+	gNotesDb = db
+}
+
 // TODO: use chi stuff
 func queryFromValues(values url.Values) *data.NotesQuery {
   q := &data.NotesQuery{}
   if len(values["tag"]) > 0 {
     q.Tags = values["tag"]
   }
+  q.Text = values.Get("q")
   return q
 }
 
 func getBookmarks(w http.ResponseWriter, r *http.Request) {
-  notes, err := gDb.Query(queryFromValues(r.URL.Query()))
-  if err != nil {
+  q := queryFromValues(r.URL.Query())
+  notes, err := gDb.Query(q)
+  if errors.Is(err, data.ErrBadQuery) {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  } else if err != nil {
     fmt.Printf("Error serving list: %s\n", err)
     http.Error(w, "Error serving list", 500)
     return
   }
 
-  // Reverse
-  var sortedNotes []data.Note
-  for i := len(notes)-1; i >= 0; i-- {
-    sortedNotes = append(sortedNotes, notes[i])
-  }
+  sortedNotes := data.ForDisplay(notes, q)
 
   data := struct {
     Bookmarks []data.Note `json:"bookmarks"`
@@ -166,6 +175,144 @@ func handleDelete(w http.ResponseWriter, r *http.Request) {
   fmt.Fprintf(w, "{}")
 }
 
+// The trash handlers take a pointer to the database so they can be registered for gDb and gNotesDb
+// before those are set.
+
+func getTrash(db *data.NotesDatabase) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    trash, err := (*db).ListTrash()
+    if err != nil {
+      fmt.Printf("Error serving trash: %s\n", err)
+      http.Error(w, "Error serving trash", 500)
+      return
+    }
+    // Most recently deleted first.
+    for i, j := 0, len(trash)-1; i < j; i, j = i+1, j-1 {
+      trash[i], trash[j] = trash[j], trash[i]
+    }
+
+    bs, err := json.Marshal(&struct {
+      Trash []data.Note `json:"trash"`
+    }{
+      Trash: trash,
+    })
+    if err != nil {
+      http.Error(w, err.Error(), 500)
+      return
+    }
+    fmt.Fprintf(w, "%s", string(bs))
+  }
+}
+
+func handleRestore(db *data.NotesDatabase) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    itemId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+    if err != nil {
+      http.Error(w, err.Error(), 500)
+      return
+    }
+
+    if err := (*db).Restore(itemId); errors.Is(err, data.ErrNotFound) {
+      http.Error(w, err.Error(), http.StatusNotFound)
+      return
+    } else if err != nil {
+      http.Error(w, err.Error(), 500)
+      return
+    }
+
+    if err := (*db).Flush(); err != nil {
+      http.Error(w, err.Error(), 500)
+      return
+    }
+
+    fmt.Fprintf(w, "{}")
+  }
+}
+
+// Permanently delete the items in the trash that were deleted more than ?older_than ago (e.g.
+// "720h"), or all of them.
+func handlePurge(db *data.NotesDatabase) http.HandlerFunc {
+  return func(w http.ResponseWriter, r *http.Request) {
+    var olderThan time.Duration
+    if s := r.URL.Query().Get("older_than"); s != "" {
+      var err error
+      if olderThan, err = time.ParseDuration(s); err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+      }
+    }
+
+    n, err := (*db).Purge(olderThan)
+    if err != nil {
+      http.Error(w, err.Error(), 500)
+      return
+    }
+
+    if err := (*db).Flush(); err != nil {
+      http.Error(w, err.Error(), 500)
+      return
+    }
+
+    fmt.Fprintf(w, "{\"purged\": %d}", n)
+  }
+}
+
+func getRevisions(w http.ResponseWriter, r *http.Request) {
+  itemId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  revisions, err := gNotesDb.Revisions(itemId)
+  if errors.Is(err, data.ErrNotFound) {
+    http.Error(w, err.Error(), http.StatusNotFound)
+    return
+  } else if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  bs, err := json.Marshal(&struct {
+    Revisions []data.Revision `json:"revisions"`
+  }{
+    Revisions: revisions,
+  })
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  fmt.Fprintf(w, "%s", string(bs))
+}
+
+func handleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+  itemId, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  number, err := strconv.Atoi(chi.URLParam(r, "number"))
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  if err := gNotesDb.RestoreRevision(itemId, number); errors.Is(err, data.ErrNotFound) || errors.Is(err, data.ErrNoRevision) {
+    http.Error(w, err.Error(), http.StatusNotFound)
+    return
+  } else if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  if err := gNotesDb.Flush(); err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  fmt.Fprintf(w, "{}")
+}
+
 func handleGetBlob(w http.ResponseWriter, r *http.Request) {
   id := chi.URLParam(r, "id")
   value, err := gBlobStore.Get(id)
@@ -206,6 +353,16 @@ func RegisterHandlers(s *http.ServeMux) {
 	r.Post("/bookmarks/{id}", handleUpdate)
 	r.Delete("/bookmarks/{id}", handleDelete)
 
+	r.Get("/bookmarks/trash/", getTrash(&gDb))
+	r.Post("/bookmarks/trash/{id}/restore", handleRestore(&gDb))
+	r.Delete("/bookmarks/trash/", handlePurge(&gDb))
+
+	r.Get("/notes/trash/", getTrash(&gNotesDb))
+	r.Post("/notes/trash/{id}/restore", handleRestore(&gNotesDb))
+	r.Delete("/notes/trash/", handlePurge(&gNotesDb))
+	r.Get("/notes/{id}/revisions", getRevisions)
+	r.Post("/notes/{id}/revisions/{number}/restore", handleRestoreRevision)
+
 	r.Get("/kv/{id}", handleGetBlob)
 	r.Post("/kv/{id}", handleSetBlob)
 
@@ -1,18 +1,19 @@
 package bookmarks
 
 import (
+  "errors"
   "fmt"
   "html/template"
   "log"
   "net/http"
   "net/url"
-  "path"
   "regexp"
   "strconv"
   "strings"
   "time"
 
   "github.com/davedolben/dev-tools/go/notes/data"
+  "github.com/davedolben/dev-tools/go/notes/render"
 )
 
 var tagsSplitRegex = regexp.MustCompile(`[\w-_:/]+`)
@@ -35,6 +36,10 @@ var listTemplateString = `
   <a href="/bookmarks">Bookmarks</a>
 </div>
 <hr />
+<form action="{{.Action}}" method="GET" class="flex-cols-container bottom-padded">
+  <input class="flex-fill padded-input" type="search" name="q" value="{{.Query}}" placeholder="Search: words, wor*, &quot;a phrase&quot;, -word, tag:x, -tag:x, after:2024-01, before:2024-02-15" />
+  <button type="submit" class="button">Search</button>
+</form>
 <form id="add-form" action="/api/bookmarks/add" method="POST">
   <div class="flex-rows-container app-width">
     <div class="flex-cols-container bottom-padded">
@@ -152,7 +157,7 @@ var listTemplateString = `
         <span>{{range .Tags}}<span class="tag"><a href="/bookmarks?tag={{.}}">{{.}}</a></span>{{end}}</span>
       </div>
       {{if .Body}}
-        <div class="note-body">{{htmlify .Body}}</div>
+        <div class="note-body markdown">{{markdown .Body}}</div>
       {{end}}
     </span>
   </div>
@@ -176,6 +181,7 @@ func queryFromValues(values url.Values) *data.NotesQuery {
   if len(values["tag"]) > 0 {
     q.Tags = values["tag"]
   }
+  q.Text = values.Get("q")
   return q
 }
 
@@ -184,18 +190,18 @@ func handleUnimplemented(w http.ResponseWriter, r *http.Request) {
 }
 
 func serveList(w http.ResponseWriter, r *http.Request) {
-  notes, err := gDb.Query(queryFromValues(r.URL.Query()))
-  if err != nil {
+  q := queryFromValues(r.URL.Query())
+  notes, err := gDb.Query(q)
+  if errors.Is(err, data.ErrBadQuery) {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  } else if err != nil {
     fmt.Printf("Error serving list: %s\n", err)
     http.Error(w, "Error serving list", 500)
     return
   }
 
-  // Reverse
-  var sortedNotes []data.Note
-  for i := len(notes)-1; i >= 0; i-- {
-    sortedNotes = append(sortedNotes, notes[i])
-  }
+  sortedNotes := data.ForDisplay(notes, q)
 
   r.ParseForm()
   fillTags := r.Form["fill_tags"]
@@ -214,6 +220,8 @@ func serveList(w http.ResponseWriter, r *http.Request) {
     FillTags []string
     Redirect string
     AutoSubmit bool
+    Action string
+    Query string
   }{
     Bookmarks: sortedNotes,
     FillTitle: r.FormValue("fill_title"),
@@ -221,6 +229,8 @@ func serveList(w http.ResponseWriter, r *http.Request) {
     FillTags: fillTags,
     Redirect: redirect,
     AutoSubmit: autoSubmit,
+    Action: "/bookmarks",
+    Query: q.Text,
   }
 
   if err := listTemplate.Execute(w, &data); err != nil {
@@ -303,9 +313,9 @@ func serveGoLink(w http.ResponseWriter, r *http.Request) {
   fmt.Fprintf(w, "failed to find golink %q", golink)
 }
 
-func InitDatabase(root string) data.NotesDatabase {
+func InitDatabase(storage, root string) data.NotesDatabase {
   var err error
-  gDb, err = data.NewTextDatabase(path.Join(root, "bookmarks.json"))
+  gDb, err = data.OpenDatabase(storage, root, "bookmarks")
   if err != nil {
     panic(err)
   }
@@ -314,9 +324,7 @@ func InitDatabase(root string) data.NotesDatabase {
 
 func setupTemplates() {
   tmplFuncs := template.FuncMap{
-    "htmlify": func(s string) template.HTML {
-      return template.HTML(strings.Replace(s, "\n", "<br/>", -1))
-    },
+    "markdown": render.Markdown,
     "formatTime": func(t time.Time) string {
       return t.Format(time.ANSIC)
     },
@@ -5,21 +5,41 @@ import (
 	"errors"
 	"io"
 	"os"
+	"sync"
 )
 
 type BlobStore interface {
 	Get(key string) (string, error)
 	Set(key string, data string) error
+	Close() error
 }
 
+// FileBlobStore is safe for concurrent use, and holds a lock on its file while it's open.
 type FileBlobStore struct {
 	filename string
+	lock *fileLock
+
+	mu sync.RWMutex
 	blobs map[string]string
 }
 
 var KeyNotFoundError = errors.New("key not found")
 
 func NewFileBlobStore(filename string) (BlobStore, error) {
+  lock, err := lockFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  bs, err := openFileBlobStore(filename)
+  if err != nil {
+    lock.unlock()
+    return nil, err
+  }
+  bs.lock = lock
+  return bs, nil
+}
+
+func openFileBlobStore(filename string) (*FileBlobStore, error) {
   f, err := os.Open(filename)
   if err != nil && !os.IsNotExist(err) {
     return nil, err
@@ -51,6 +71,8 @@ func NewFileBlobStore(filename string) (BlobStore, error) {
 }
 
 func (bs *FileBlobStore) Get(key string) (string, error) {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
 	data, ok := bs.blobs[key]
 	if !ok {
 		return "", KeyNotFoundError
@@ -59,11 +81,19 @@ func (bs *FileBlobStore) Get(key string) (string, error) {
 }
 
 func (bs *FileBlobStore) Set(key string, data string) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
 	bs.blobs[key] = data
-	return bs.Flush()
+	return bs.flush()
 }
 
 func (bs *FileBlobStore) Flush() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.flush()
+}
+
+func (bs *FileBlobStore) flush() error {
 	data := struct{
 		Blobs map[string]string
 	}{
@@ -73,8 +103,11 @@ func (bs *FileBlobStore) Flush() error {
   if err != nil {
     return err
   }
-  if err := os.WriteFile(bs.filename, byts, 0644); err != nil {
-    return err
-  }
-  return nil
+  return writeFileAtomic(bs.filename, byts, Backups)
+}
+
+func (bs *FileBlobStore) Close() error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.lock.unlock()
 }
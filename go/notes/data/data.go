@@ -2,14 +2,23 @@ package data
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"sort"
+	"sync"
 	"time"
 )
 
 type Note struct {
   Created time.Time `json:"created"`
+  // Zero until the note is first edited.
+  Updated time.Time `json:"updated,omitempty"`
+  // When the note was moved to the trash. Zero for notes that aren't in it, and for ones trashed
+  // before this was recorded.
+  Deleted time.Time `json:"deleted,omitempty"`
   ID int64 `json:"id"`
   Title string `json:"title"`
   Body string `json:"body"`
@@ -19,34 +28,108 @@ type Note struct {
 
 type NotesQuery struct {
   Tags []string
+  // Full-text search, see SearchQuery for the syntax.
+  Text string
 }
 
+// Ranked reports whether the results come back best match first rather than in the order the notes
+// were added.
+func (q *NotesQuery) Ranked() bool {
+  if q == nil || q.Text == "" {
+    return false
+  }
+  sq, err := ParseSearch(q.Text)
+  return err == nil && sq.Ranked()
+}
+
+var ErrNotFound = errors.New("id not found")
+
 type NotesDatabase interface {
   Add(note *Note) error
+  Get(id int64) (*Note, error)
+  // Update replaces the editable fields (title, body, URL and tags) of a note. The ID and created
+  // time are kept, and the updated time is set.
   Update(id int64, note *Note) error
+  // Delete moves a note to the trash.
   Delete(id int64) error
   Query(query *NotesQuery) ([]Note, error)
+  // ListTrash returns the notes in the trash, in the order they were deleted.
+  ListTrash() ([]Note, error)
+  // Restore moves a note out of the trash. It keeps its ID.
+  Restore(id int64) error
+  // Purge permanently removes the notes that were deleted more than olderThan ago, and returns how
+  // many it removed. Notes without a deletion time count as deleted long ago. Their IDs aren't reused.
+  Purge(olderThan time.Duration) (int, error)
+  // Revisions returns the log of a note's content, oldest first. Notes that haven't been edited since
+  // revisions were kept have just one, for their current content. Only the last maxRevisions are kept,
+  // and an update that doesn't change anything doesn't add one.
+  Revisions(id int64) ([]Revision, error)
+  // RestoreRevision updates a note to the content of an old revision, adding a new revision unless
+  // it's the latest one.
+  RestoreRevision(id int64, number int) error
   Flush() error
+  // Close releases the database's files. Flush first to keep changes.
+  Close() error
 }
 
+// TextNotesDatabase keeps notes in memory and saves them to a JSON file. It's safe for concurrent
+// use, and holds a lock on the file while it's open.
 type TextNotesDatabase struct {
   filename string
+  lock *fileLock
+
+  mu sync.RWMutex
   notes []Note
   trash []Note
   nextId int64
+  revisions map[int64][]Revision
+  index *Index
 }
 
 type NotesDB struct {
   Notes []Note `json:"notes"`
   Trash []Note `json:"trash"`
+  // The next ID to hand out. IDs are never reused, even after the note with the highest one is
+  // deleted. Files from before this was saved don't have it.
+  NextID int64 `json:"next_id,omitempty"`
+  // Note ID -> its revisions, including for notes in the trash.
+  Revisions map[int64][]Revision `json:"revisions,omitempty"`
 }
 
-func initIds(db *TextNotesDatabase) {
-  // For now, just blanket adjust IDs to be sequential, because it's easy and guaranteed to be de-duped.
-  for i := range db.notes {
-    db.notes[i].ID = int64(i+1)
+// Make sure every note (including the ones in the trash) has a unique ID, and that nextId is past all
+// of them. Notes that already have a unique ID keep it, since it may be in links. Returns whether
+// anything changed.
+func initIds(db *TextNotesDatabase) bool {
+  changed := false
+  maxId := int64(0)
+  seen := make(map[int64]bool)
+  var needIds []*Note
+  visit := func(notes []Note) {
+    for i := range notes {
+      id := notes[i].ID
+      if id <= 0 || seen[id] {
+        needIds = append(needIds, &notes[i])
+        continue
+      }
+      seen[id] = true
+      if id > maxId {
+        maxId = id
+      }
+    }
+  }
+  visit(db.notes)
+  visit(db.trash)
+
+  if db.nextId <= maxId {
+    db.nextId = maxId + 1
+    changed = true
   }
-  db.nextId = int64(len(db.notes)+1)
+  for _, note := range needIds {
+    note.ID = db.nextId
+    db.nextId++
+    changed = true
+  }
+  return changed
 }
 
 func fixMissingData(db *TextNotesDatabase) {
@@ -59,6 +142,38 @@ func fixMissingData(db *TextNotesDatabase) {
 }
 
 func NewTextDatabase(filename string) (NotesDatabase, error) {
+  lock, err := lockFile(filename)
+  if err != nil {
+    return nil, err
+  }
+  db, err := openTextDatabase(filename)
+  if err != nil {
+    lock.unlock()
+    return nil, err
+  }
+  db.lock = lock
+  return db, nil
+}
+
+// Storage backends for OpenDatabase.
+const (
+  JSONStorage = "json"
+  SQLiteStorage = "sqlite"
+)
+
+// OpenDatabase opens the database called name in root: name.json for JSON storage, name.db for
+// SQLite.
+func OpenDatabase(storage, root, name string) (NotesDatabase, error) {
+  switch storage {
+  case JSONStorage:
+    return NewTextDatabase(filepath.Join(root, name + ".json"))
+  case SQLiteStorage:
+    return NewSQLiteDatabase(filepath.Join(root, name + ".db"))
+  }
+  return nil, fmt.Errorf("unknown storage %q, want %s or %s", storage, JSONStorage, SQLiteStorage)
+}
+
+func openTextDatabase(filename string) (*TextNotesDatabase, error) {
   f, err := os.Open(filename)
   if err != nil && !os.IsNotExist(err) {
     return nil, err
@@ -66,6 +181,7 @@ func NewTextDatabase(filename string) (NotesDatabase, error) {
 
   db := &TextNotesDatabase{
     filename: filename,
+    revisions: make(map[int64][]Revision),
   }
 
   if err == nil {
@@ -76,19 +192,38 @@ func NewTextDatabase(filename string) (NotesDatabase, error) {
     }
     var data NotesDB
     if parseErr := json.Unmarshal(bytes, &data); parseErr != nil {
-      return nil, parseErr
+      return nil, fmt.Errorf("%s: %w (backups are in %s.1 to .%d)", filename, parseErr, filename, Backups)
     }
     db.notes = data.Notes
     db.trash = data.Trash
+    db.nextId = data.NextID
+    if data.Revisions != nil {
+      db.revisions = data.Revisions
+    }
   }
 
   fixMissingData(db)
-  initIds(db)
+  idsChanged := initIds(db)
+
+  db.index = loadIndex(indexFilename(filename))
+  db.index.sync(db.notes)
+
+  // Older files don't have next_id, and older versions renumbered notes on every load. Save the IDs
+  // once so they stay put from now on.
+  if idsChanged && err == nil {
+    if err := db.flush(); err != nil {
+      return nil, err
+    }
+  } else if err := db.index.save(indexFilename(filename)); err != nil {
+    return nil, err
+  }
 
   return db, nil
 }
 
 func (db *TextNotesDatabase) Add(note *Note) error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
   db.notes = append(db.notes, Note{
     ID: db.nextId,
     Title: note.Title,
@@ -97,29 +232,164 @@ func (db *TextNotesDatabase) Add(note *Note) error {
     Tags: note.Tags,
     Created: time.Now(),
   })
+  added := &db.notes[len(db.notes)-1]
+  db.index.add(added)
+  db.revisions[added.ID] = []Revision{revisionOf(added, 1)}
   db.nextId++
   return nil
 }
 
 func (db *TextNotesDatabase) Delete(id int64) error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
   for i, note := range db.notes {
     if note.ID == id {
+      note.Deleted = time.Now()
       db.trash = append(db.trash, note)
       db.notes = append(db.notes[:i], db.notes[i+1:]...)
+      db.index.remove(id)
       return nil
     }
   }
-  return fmt.Errorf("id not found")
+  return ErrNotFound
 }
 
-func (db *TextNotesDatabase) Update(id int64, newNote *Note) error {
-  for i, note := range db.notes {
+func (db *TextNotesDatabase) ListTrash() ([]Note, error) {
+  db.mu.RLock()
+  defer db.mu.RUnlock()
+  return append([]Note(nil), db.trash...), nil
+}
+
+func (db *TextNotesDatabase) Restore(id int64) error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  for i, note := range db.trash {
     if note.ID == id {
-      db.notes[i] = *newNote
+      note.Deleted = time.Time{}
+      db.trash = append(db.trash[:i], db.trash[i+1:]...)
+      // Notes are kept in the order they were added, which is ID order.
+      j := sort.Search(len(db.notes), func(j int) bool { return db.notes[j].ID > id })
+      db.notes = append(db.notes, Note{})
+      copy(db.notes[j+1:], db.notes[j:])
+      db.notes[j] = note
+      db.index.add(&db.notes[j])
       return nil
     }
   }
-  return fmt.Errorf("id not found")
+  return ErrNotFound
+}
+
+func (db *TextNotesDatabase) Purge(olderThan time.Duration) (int, error) {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  cutoff := time.Now().Add(-olderThan)
+  var kept []Note
+  for _, note := range db.trash {
+    if note.Deleted.After(cutoff) {
+      kept = append(kept, note)
+    } else {
+      delete(db.revisions, note.ID)
+    }
+  }
+  purged := len(db.trash) - len(kept)
+  db.trash = kept
+  return purged, nil
+}
+
+func (db *TextNotesDatabase) Get(id int64) (*Note, error) {
+  db.mu.RLock()
+  defer db.mu.RUnlock()
+  return db.get(id)
+}
+
+func (db *TextNotesDatabase) get(id int64) (*Note, error) {
+  for i := range db.notes {
+    if db.notes[i].ID == id {
+      note := db.notes[i]
+      return &note, nil
+    }
+  }
+  return nil, ErrNotFound
+}
+
+func (db *TextNotesDatabase) Update(id int64, newNote *Note) error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  return db.update(id, newNote)
+}
+
+func (db *TextNotesDatabase) update(id int64, newNote *Note) error {
+  for i := range db.notes {
+    if db.notes[i].ID == id {
+      note := &db.notes[i]
+      revisions := db.revisions[id]
+      if len(revisions) == 0 {
+        revisions = []Revision{revisionOf(note, 1)}
+      }
+      last := &revisions[len(revisions)-1]
+      if last.sameContent(newNote) {
+        return nil
+      }
+      note.Title = newNote.Title
+      note.Body = newNote.Body
+      note.URL = newNote.URL
+      note.Tags = newNote.Tags
+      note.Updated = time.Now()
+      db.index.add(note)
+      revisions = append(revisions, revisionOf(note, last.Number+1))
+      if len(revisions) > maxRevisions {
+        revisions = append([]Revision(nil), revisions[len(revisions)-maxRevisions:]...)
+      }
+      db.revisions[id] = revisions
+      return nil
+    }
+  }
+  return ErrNotFound
+}
+
+func (db *TextNotesDatabase) Revisions(id int64) ([]Revision, error) {
+  db.mu.RLock()
+  defer db.mu.RUnlock()
+  note, err := db.get(id)
+  if err != nil {
+    return nil, err
+  }
+  if revisions := db.revisions[id]; len(revisions) > 0 {
+    return append([]Revision(nil), revisions...), nil
+  }
+  return []Revision{revisionOf(note, 1)}, nil
+}
+
+func (db *TextNotesDatabase) RestoreRevision(id int64, number int) error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  note, err := db.get(id)
+  if err != nil {
+    return err
+  }
+  revisions := db.revisions[id]
+  if len(revisions) == 0 {
+    revisions = []Revision{revisionOf(note, 1)}
+  }
+  for _, rev := range revisions {
+    if rev.Number == number {
+      return db.update(id, rev.Note())
+    }
+  }
+  return ErrNoRevision
+}
+
+// ForDisplay puts query results in the order the list pages show them: newest first, or best match
+// first for ranked searches.
+func ForDisplay(notes []Note, q *NotesQuery) []Note {
+  if q.Ranked() {
+    return notes
+  }
+  var out []Note
+  for i := len(notes)-1; i >= 0; i-- {
+    out = append(out, notes[i])
+  }
+  return out
 }
 
 func filterForTags(notes []Note, tags []string) []Note {
@@ -146,30 +416,54 @@ func filterForTags(notes []Note, tags []string) []Note {
 }
 
 func (db *TextNotesDatabase) Query(query *NotesQuery) ([]Note, error) {
+  db.mu.RLock()
+  defer db.mu.RUnlock()
+
+  // Copy, since deletes shift notes around in place.
+  notes := append([]Note(nil), db.notes...)
   if query == nil {
-    return db.notes, nil
+    return notes, nil
   }
 
-  notes := db.notes
-
   if len(query.Tags) > 0 {
     notes = filterForTags(notes, query.Tags)
   }
+  if query.Text != "" {
+    sq, err := ParseSearch(query.Text)
+    if err != nil {
+      return nil, err
+    }
+    notes = db.index.Search(notes, sq)
+  }
 
   return notes, nil
 }
 
 func (db *TextNotesDatabase) Flush() error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  return db.flush()
+}
+
+func (db *TextNotesDatabase) flush() error {
   data := NotesDB{
     Notes: db.notes,
     Trash: db.trash,
+    NextID: db.nextId,
+    Revisions: db.revisions,
   }
   bs, err := json.Marshal(&data)
   if err != nil {
     return err
   }
-  if err := ioutil.WriteFile(db.filename, bs, 0644); err != nil {
+  if err := writeFileAtomic(db.filename, bs, Backups); err != nil {
     return err
   }
-  return nil
+  return db.index.save(indexFilename(db.filename))
+}
+
+func (db *TextNotesDatabase) Close() error {
+  db.mu.Lock()
+  defer db.mu.Unlock()
+  return db.lock.unlock()
 }
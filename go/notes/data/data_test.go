@@ -0,0 +1,180 @@
+package data
+
+import (
+  "encoding/json"
+  "os"
+  "path/filepath"
+  "testing"
+)
+
+func openTestDB(t *testing.T, filename string) NotesDatabase {
+  t.Helper()
+  db, err := NewTextDatabase(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { db.Close() })
+  return db
+}
+
+// Close db and open the file again, like a restart.
+func reopen(t *testing.T, db NotesDatabase, filename string) NotesDatabase {
+  t.Helper()
+  if err := db.Close(); err != nil {
+    t.Fatal(err)
+  }
+  return openTestDB(t, filename)
+}
+
+func addNotes(t *testing.T, db NotesDatabase, titles ...string) {
+  t.Helper()
+  for _, title := range titles {
+    if err := db.Add(&Note{Title: title}); err != nil {
+      t.Fatal(err)
+    }
+  }
+}
+
+// The IDs of the notes, by title.
+func idsByTitle(t *testing.T, db NotesDatabase) map[string]int64 {
+  t.Helper()
+  notes, err := db.Query(nil)
+  if err != nil {
+    t.Fatal(err)
+  }
+  ids := make(map[string]int64)
+  for _, n := range notes {
+    ids[n.Title] = n.ID
+  }
+  return ids
+}
+
+func checkIds(t *testing.T, db NotesDatabase, want map[string]int64) {
+  t.Helper()
+  got := idsByTitle(t, db)
+  if len(got) != len(want) {
+    t.Errorf("got ids %v, want %v", got, want)
+    return
+  }
+  for title, id := range want {
+    if got[title] != id {
+      t.Errorf("got ids %v, want %v", got, want)
+      return
+    }
+  }
+}
+
+func flush(t *testing.T, db NotesDatabase) {
+  t.Helper()
+  if err := db.Flush(); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func TestIdsSurviveRestartAfterDelete(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  db := openTestDB(t, filename)
+  addNotes(t, db, "a", "b", "c", "d")
+  if err := db.Delete(2); err != nil {
+    t.Fatal(err)
+  }
+  flush(t, db)
+
+  db = reopen(t, db, filename)
+  checkIds(t, db, map[string]int64{"a": 1, "c": 3, "d": 4})
+  if note, err := db.Get(3); err != nil || note.Title != "c" {
+    t.Errorf("got %+v, %v for id 3", note, err)
+  }
+}
+
+// Deleting the newest note mustn't free its ID for the next one.
+func TestIdsNotReusedAfterRestart(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  db := openTestDB(t, filename)
+  addNotes(t, db, "a", "b", "c")
+  if err := db.Delete(3); err != nil {
+    t.Fatal(err)
+  }
+  flush(t, db)
+
+  db = reopen(t, db, filename)
+  addNotes(t, db, "d")
+  flush(t, db)
+  checkIds(t, db, map[string]int64{"a": 1, "b": 2, "d": 4})
+
+  db = reopen(t, db, filename)
+  checkIds(t, db, map[string]int64{"a": 1, "b": 2, "d": 4})
+}
+
+func writeFile(t *testing.T, filename string, v interface{}) {
+  t.Helper()
+  bs, err := json.Marshal(v)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := os.WriteFile(filename, bs, 0644); err != nil {
+    t.Fatal(err)
+  }
+}
+
+func TestMigrateOldFile(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  // No next_id, a gap, a note without an ID, a duplicate, and a higher ID in the trash.
+  writeFile(t, filename, map[string]interface{}{
+    "notes": []map[string]interface{}{
+      {"id": 1, "title": "a"},
+      {"id": 4, "title": "b"},
+      {"title": "c"},
+      {"id": 4, "title": "d"},
+    },
+    "trash": []map[string]interface{}{
+      {"id": 7, "title": "deleted"},
+    },
+  })
+
+  db := openTestDB(t, filename)
+  want := map[string]int64{"a": 1, "b": 4, "c": 8, "d": 9}
+  checkIds(t, db, want)
+
+  // The migration is saved right away, without waiting for a write.
+  var saved NotesDB
+  bs, err := os.ReadFile(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := json.Unmarshal(bs, &saved); err != nil {
+    t.Fatal(err)
+  }
+  if saved.NextID != 10 {
+    t.Errorf("saved next_id %d, want 10", saved.NextID)
+  }
+
+  db = reopen(t, db, filename)
+  checkIds(t, db, want)
+  addNotes(t, db, "e")
+  want["e"] = 10
+  checkIds(t, db, want)
+}
+
+func TestUpdateKeepsIdAndCreated(t *testing.T) {
+  db := openTestDB(t, filepath.Join(t.TempDir(), "notes.json"))
+  addNotes(t, db, "a")
+  before, err := db.Get(1)
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if err := db.Update(1, &Note{ID: 99, Title: "edited", Tags: []string{"x"}}); err != nil {
+    t.Fatal(err)
+  }
+  after, err := db.Get(1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if after.ID != 1 || after.Title != "edited" || !after.Created.Equal(before.Created) || after.Updated.IsZero() {
+    t.Errorf("got %+v after update of %+v", after, before)
+  }
+  if _, err := db.Get(99); err == nil {
+    t.Error("update changed the id")
+  }
+}
@@ -0,0 +1,134 @@
+package data
+
+import (
+  "fmt"
+  "io"
+  "os"
+  "path/filepath"
+)
+
+// How many old copies of each data file to keep, as <file>.1 (newest) to <file>.N. Set before opening
+// databases.
+var Backups = 3
+
+// writeFileAtomic replaces filename with data so that a crash leaves either the old or the new
+// contents, never a mix. The old contents are rotated into backups first.
+func writeFileAtomic(filename string, data []byte, backups int) error {
+  dir := filepath.Dir(filename)
+  tmp, err := os.CreateTemp(dir, "." + filepath.Base(filename) + ".tmp*")
+  if err != nil {
+    return err
+  }
+  defer os.Remove(tmp.Name())
+  if _, err := tmp.Write(data); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Chmod(0644); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Sync(); err != nil {
+    tmp.Close()
+    return err
+  }
+  if err := tmp.Close(); err != nil {
+    return err
+  }
+
+  if err := rotateBackups(filename, backups); err != nil {
+    return fmt.Errorf("rotating backups of %s: %w", filename, err)
+  }
+  if err := os.Rename(tmp.Name(), filename); err != nil {
+    return err
+  }
+  return syncDir(dir)
+}
+
+func backupName(filename string, n int) string {
+  return fmt.Sprintf("%s.%d", filename, n)
+}
+
+// Shift <file>.1..N-1 up by one and copy the current file to <file>.1. The current file stays in
+// place until the new one is renamed over it.
+func rotateBackups(filename string, backups int) error {
+  if backups <= 0 {
+    return nil
+  }
+  if _, err := os.Stat(filename); os.IsNotExist(err) {
+    return nil
+  }
+  for n := backups - 1; n >= 1; n-- {
+    err := os.Rename(backupName(filename, n), backupName(filename, n+1))
+    if err != nil && !os.IsNotExist(err) {
+      return err
+    }
+  }
+  first := backupName(filename, 1)
+  os.Remove(first)
+  // A hard link is instant and the current file is never written in place, so it's as good as a copy.
+  if err := os.Link(filename, first); err == nil {
+    return nil
+  }
+  return copyFile(filename, first)
+}
+
+func copyFile(from, to string) error {
+  in, err := os.Open(from)
+  if err != nil {
+    return err
+  }
+  defer in.Close()
+  out, err := os.OpenFile(to, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+  if err != nil {
+    return err
+  }
+  if _, err := io.Copy(out, in); err != nil {
+    out.Close()
+    return err
+  }
+  if err := out.Sync(); err != nil {
+    out.Close()
+    return err
+  }
+  return out.Close()
+}
+
+// Make a rename durable. Not every platform can sync a directory, so errors are ignored.
+func syncDir(dir string) error {
+  d, err := os.Open(dir)
+  if err != nil {
+    return nil
+  }
+  defer d.Close()
+  d.Sync()
+  return nil
+}
+
+// fileLock is an advisory lock on <file>.lock, held while a database is open so two processes can't
+// both write the same data file.
+type fileLock struct {
+  f *os.File
+}
+
+func lockFile(filename string) (*fileLock, error) {
+  f, err := os.OpenFile(filename + ".lock", os.O_RDWR|os.O_CREATE, 0644)
+  if err != nil {
+    return nil, err
+  }
+  if err := flock(f); err != nil {
+    f.Close()
+    return nil, fmt.Errorf("%s is in use by another process: %w", filename, err)
+  }
+  return &fileLock{f: f}, nil
+}
+
+func (l *fileLock) unlock() error {
+  if l == nil || l.f == nil {
+    return nil
+  }
+  funlock(l.f)
+  err := l.f.Close()
+  l.f = nil
+  return err
+}
@@ -0,0 +1,178 @@
+package data
+
+import (
+  "fmt"
+  "os"
+  "path/filepath"
+  "strings"
+  "sync"
+  "testing"
+)
+
+func readFile(t *testing.T, filename string) string {
+  t.Helper()
+  bs, err := os.ReadFile(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  return string(bs)
+}
+
+func TestWriteFileAtomicBackups(t *testing.T) {
+  dir := t.TempDir()
+  filename := filepath.Join(dir, "f.json")
+  for i := 1; i <= 5; i++ {
+    if err := writeFileAtomic(filename, []byte(fmt.Sprint(i)), 3); err != nil {
+      t.Fatal(err)
+    }
+  }
+
+  if got := readFile(t, filename); got != "5" {
+    t.Errorf("got %q, want 5", got)
+  }
+  for n, want := range map[int]string{1: "4", 2: "3", 3: "2"} {
+    if got := readFile(t, backupName(filename, n)); got != want {
+      t.Errorf("backup %d: got %q, want %q", n, got, want)
+    }
+  }
+  entries, err := os.ReadDir(dir)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var names []string
+  for _, e := range entries {
+    names = append(names, e.Name())
+  }
+  // No fourth backup, and no temp files left behind.
+  if got := strings.Join(names, " "); got != "f.json f.json.1 f.json.2 f.json.3" {
+    t.Errorf("got files %s", got)
+  }
+}
+
+func TestWriteFileAtomicNoBackups(t *testing.T) {
+  dir := t.TempDir()
+  filename := filepath.Join(dir, "f.json")
+  for i := 0; i < 2; i++ {
+    if err := writeFileAtomic(filename, []byte("x"), 0); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+    t.Errorf("got %d files, want just f.json", len(entries))
+  }
+}
+
+// A backup is a separate file, not a name for the current one.
+func TestBackupUnchangedByLaterWrite(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "f.json")
+  for _, s := range []string{"old", "new", "newer"} {
+    if err := writeFileAtomic(filename, []byte(s), 2); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if got := readFile(t, backupName(filename, 1)); got != "new" {
+    t.Errorf("backup 1: got %q, want new", got)
+  }
+  if got := readFile(t, backupName(filename, 2)); got != "old" {
+    t.Errorf("backup 2: got %q, want old", got)
+  }
+}
+
+func TestDatabaseLocked(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  db := openTestDB(t, filename)
+  if _, err := NewTextDatabase(filename); err == nil {
+    t.Fatal("opened a database that's already open")
+  }
+  if err := db.Close(); err != nil {
+    t.Fatal(err)
+  }
+  openTestDB(t, filename)
+}
+
+func TestBlobStoreLocked(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "blobs.json")
+  bs, err := NewFileBlobStore(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if err := bs.Set("k", "v"); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := NewFileBlobStore(filename); err == nil {
+    t.Fatal("opened a blob store that's already open")
+  }
+  if err := bs.Close(); err != nil {
+    t.Fatal(err)
+  }
+
+  bs, err = NewFileBlobStore(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  defer bs.Close()
+  if v, err := bs.Get("k"); err != nil || v != "v" {
+    t.Errorf("got %q, %v", v, err)
+  }
+}
+
+func TestCorruptFileMentionsBackups(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  if err := os.WriteFile(filename, []byte("{"), 0644); err != nil {
+    t.Fatal(err)
+  }
+  _, err := NewTextDatabase(filename)
+  if err == nil || !strings.Contains(err.Error(), backupName(filename, 1)) {
+    t.Errorf("got %v, want an error pointing at the backups", err)
+  }
+  // A failed open doesn't keep the lock.
+  if err := os.WriteFile(filename, []byte("{}"), 0644); err != nil {
+    t.Fatal(err)
+  }
+  openTestDB(t, filename)
+}
+
+// Run with -race.
+func TestConcurrentUse(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  db := openTestDB(t, filename)
+  addNotes(t, db, "seed")
+
+  var wg sync.WaitGroup
+  errs := make(chan error, 100)
+  for w := 0; w < 4; w++ {
+    wg.Add(2)
+    go func(w int) {
+      defer wg.Done()
+      for i := 0; i < 10; i++ {
+        if err := db.Add(&Note{Title: fmt.Sprintf("note %d-%d", w, i), Body: "concurrent"}); err != nil {
+          errs <- err
+        }
+        if err := db.Flush(); err != nil {
+          errs <- err
+        }
+      }
+    }(w)
+    go func() {
+      defer wg.Done()
+      for i := 0; i < 10; i++ {
+        if _, err := db.Query(&NotesQuery{Text: "conc*"}); err != nil {
+          errs <- err
+        }
+        if _, err := db.Get(1); err != nil {
+          errs <- err
+        }
+      }
+    }()
+  }
+  wg.Wait()
+  close(errs)
+  for err := range errs {
+    t.Error(err)
+  }
+
+  db = reopen(t, db, filename)
+  if got := search(t, db, "concurrent"); len(got) != 40 {
+    t.Errorf("got %d notes after reload, want 40", len(got))
+  }
+}
@@ -0,0 +1,206 @@
+package data
+
+import (
+  "encoding/json"
+  "hash/fnv"
+  "os"
+  "sort"
+  "strings"
+  "sync"
+  "unicode"
+)
+
+// Bump when the tokenizer or the file format changes, so old index files get rebuilt.
+const indexVersion = 1
+
+// Positions in each field start at a multiple of this, so phrases can't run from one field into the
+// next and the field can be worked out from the position.
+const fieldGap = 1 << 20
+
+const (
+  titleField = iota
+  urlField
+  bodyField
+)
+
+// How much a match in each field counts for in ranking.
+var fieldWeights = []float64{titleField: 3, urlField: 2, bodyField: 1}
+
+// Index is an inverted index over the title, URL and body of notes. It's kept in a file next to the
+// notes, and brought up to date with the notes when it's loaded.
+type Index struct {
+  Version int `json:"version"`
+  // Term -> the notes that have it, sorted by ID.
+  Postings map[string][]Posting `json:"postings"`
+  Docs map[int64]*DocInfo `json:"docs"`
+
+  // Sorted terms, for prefix queries. nil when stale. Searches build it lazily, and may run at the
+  // same time, so it has its own lock.
+  termsMu sync.Mutex
+  terms []string
+  dirty bool
+}
+
+type Posting struct {
+  ID int64 `json:"id"`
+  Pos []int `json:"pos"`
+}
+
+type DocInfo struct {
+  // Hash of the indexed text, to catch notes that changed without the index seeing it.
+  Hash uint64 `json:"hash"`
+  // Weighted token count, for ranking.
+  Length float64 `json:"len"`
+}
+
+func newIndex() *Index {
+  return &Index{
+    Version: indexVersion,
+    Postings: make(map[string][]Posting),
+    Docs: make(map[int64]*DocInfo),
+  }
+}
+
+// Lower-cased runs of letters and digits.
+func tokenize(s string) []string {
+  return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+    return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+  })
+}
+
+func noteFields(note *Note) []string {
+  return []string{titleField: note.Title, urlField: note.URL, bodyField: note.Body}
+}
+
+func noteHash(note *Note) uint64 {
+  h := fnv.New64a()
+  for _, f := range noteFields(note) {
+    h.Write([]byte(f))
+    h.Write([]byte{0})
+  }
+  return h.Sum64()
+}
+
+func indexFilename(notesFilename string) string {
+  return strings.TrimSuffix(notesFilename, ".json") + ".index.json"
+}
+
+// Load an index file. A missing, unreadable or outdated file gives an empty index, which sync fills.
+func loadIndex(filename string) *Index {
+  bs, err := os.ReadFile(filename)
+  if err != nil {
+    return newIndex()
+  }
+  ix := &Index{}
+  if err := json.Unmarshal(bs, ix); err != nil || ix.Version != indexVersion || ix.Postings == nil || ix.Docs == nil {
+    ix = newIndex()
+    ix.dirty = true
+  }
+  return ix
+}
+
+func (ix *Index) save(filename string) error {
+  if !ix.dirty {
+    return nil
+  }
+  bs, err := json.Marshal(ix)
+  if err != nil {
+    return err
+  }
+  // The index can always be rebuilt from the notes, so it doesn't need backups.
+  if err := writeFileAtomic(filename, bs, 0); err != nil {
+    return err
+  }
+  ix.dirty = false
+  return nil
+}
+
+func (ix *Index) add(note *Note) {
+  ix.remove(note.ID)
+  positions := make(map[string][]int)
+  length := 0.0
+  for field, text := range noteFields(note) {
+    tokens := tokenize(text)
+    for i, tok := range tokens {
+      positions[tok] = append(positions[tok], field*fieldGap+i)
+    }
+    length += fieldWeights[field] * float64(len(tokens))
+  }
+  for term, pos := range positions {
+    postings := ix.Postings[term]
+    i := sort.Search(len(postings), func(i int) bool { return postings[i].ID >= note.ID })
+    postings = append(postings, Posting{})
+    copy(postings[i+1:], postings[i:])
+    postings[i] = Posting{ID: note.ID, Pos: pos}
+    if len(postings) == 1 {
+      ix.terms = nil
+    }
+    ix.Postings[term] = postings
+  }
+  ix.Docs[note.ID] = &DocInfo{Hash: noteHash(note), Length: length}
+  ix.dirty = true
+}
+
+func (ix *Index) remove(id int64) {
+  if _, ok := ix.Docs[id]; !ok {
+    return
+  }
+  for term, postings := range ix.Postings {
+    i := sort.Search(len(postings), func(i int) bool { return postings[i].ID >= id })
+    if i == len(postings) || postings[i].ID != id {
+      continue
+    }
+    if len(postings) == 1 {
+      delete(ix.Postings, term)
+      ix.terms = nil
+      continue
+    }
+    ix.Postings[term] = append(postings[:i:i], postings[i+1:]...)
+  }
+  delete(ix.Docs, id)
+  ix.dirty = true
+}
+
+// Bring the index up to date with notes: index new and changed notes, and drop ones that are gone.
+func (ix *Index) sync(notes []Note) {
+  current := make(map[int64]bool)
+  for i := range notes {
+    current[notes[i].ID] = true
+    doc, ok := ix.Docs[notes[i].ID]
+    if !ok || doc.Hash != noteHash(&notes[i]) {
+      ix.add(&notes[i])
+    }
+  }
+  for id := range ix.Docs {
+    if !current[id] {
+      ix.remove(id)
+    }
+  }
+}
+
+func (ix *Index) posting(term string, id int64) *Posting {
+  postings := ix.Postings[term]
+  i := sort.Search(len(postings), func(i int) bool { return postings[i].ID >= id })
+  if i == len(postings) || postings[i].ID != id {
+    return nil
+  }
+  return &postings[i]
+}
+
+// The indexed terms starting with prefix.
+func (ix *Index) expand(prefix string) []string {
+  ix.termsMu.Lock()
+  defer ix.termsMu.Unlock()
+  if ix.terms == nil {
+    ix.terms = make([]string, 0, len(ix.Postings))
+    for term := range ix.Postings {
+      ix.terms = append(ix.terms, term)
+    }
+    sort.Strings(ix.terms)
+  }
+  var out []string
+  for i := sort.SearchStrings(ix.terms, prefix); i < len(ix.terms) && strings.HasPrefix(ix.terms[i], prefix); i++ {
+    out = append(out, ix.terms[i])
+  }
+  return out
+}
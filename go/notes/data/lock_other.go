@@ -0,0 +1,14 @@
+//go:build !unix
+
+package data
+
+import "os"
+
+// No advisory locking here, the in-process mutexes still apply.
+func flock(f *os.File) error {
+  return nil
+}
+
+func funlock(f *os.File) error {
+  return nil
+}
@@ -0,0 +1,17 @@
+//go:build unix
+
+package data
+
+import (
+  "os"
+  "syscall"
+)
+
+// Non-blocking, so a second process fails to open the database instead of hanging.
+func flock(f *os.File) error {
+  return syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+}
+
+func funlock(f *os.File) error {
+  return syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+}
@@ -0,0 +1,153 @@
+package data
+
+import (
+  "errors"
+  "fmt"
+  "strings"
+  "time"
+)
+
+var ErrNoRevision = errors.New("no such revision")
+
+// How many revisions are kept per note. Older ones are dropped as new ones are added, so the numbers
+// of the ones that are left don't start at 1.
+const maxRevisions = 100
+
+// The biggest table DiffLines builds: lines in one text times lines in the other, once their common
+// start and end are left out. Past that it shows the whole differing part as replaced.
+const maxDiffCells = 1 << 22
+
+// Revision is the full content of a note at one point in time. A note's revisions are numbered from
+// 1, oldest first; the last one is what the note has now.
+type Revision struct {
+  Number int `json:"number"`
+  Time time.Time `json:"time"`
+  Title string `json:"title"`
+  Body string `json:"body"`
+  URL string `json:"url"`
+  Tags []string `json:"tags"`
+}
+
+// The note as it is now. Notes edited before revisions were kept start their log with this.
+func revisionOf(note *Note, number int) Revision {
+  t := note.Updated
+  if t.IsZero() {
+    t = note.Created
+  }
+  return Revision{
+    Number: number,
+    Time: t,
+    Title: note.Title,
+    Body: note.Body,
+    URL: note.URL,
+    Tags: note.Tags,
+  }
+}
+
+// Note returns the content of the revision as a note to pass to Update.
+func (r *Revision) Note() *Note {
+  return &Note{
+    Title: r.Title,
+    Body: r.Body,
+    URL: r.URL,
+    Tags: r.Tags,
+  }
+}
+
+// Whether note has the same content as the revision, in which case an update doesn't need a new one.
+func (r *Revision) sameContent(note *Note) bool {
+  if r.Title != note.Title || r.Body != note.Body || r.URL != note.URL || len(r.Tags) != len(note.Tags) {
+    return false
+  }
+  for i := range r.Tags {
+    if r.Tags[i] != note.Tags[i] {
+      return false
+    }
+  }
+  return true
+}
+
+// Text is the revision as plain text, for diffing: a header with the title, URL and tags, then the
+// body.
+func (r *Revision) Text() string {
+  return fmt.Sprintf("Title: %s\nURL: %s\nTags: %s\n\n%s", r.Title, r.URL, strings.Join(r.Tags, ", "), r.Body)
+}
+
+type DiffOp int
+
+const (
+  DiffSame DiffOp = iota
+  DiffAdded
+  DiffRemoved
+)
+
+type DiffLine struct {
+  Op DiffOp
+  Text string
+}
+
+// DiffLines compares two texts line by line, using the longest common subsequence. Removed lines come
+// before the added lines that replace them.
+func DiffLines(a, b string) []DiffLine {
+  x := strings.Split(a, "\n")
+  y := strings.Split(b, "\n")
+  // Lines the texts start and end with in common don't need to go through the table.
+  prefix := 0
+  for prefix < len(x) && prefix < len(y) && x[prefix] == y[prefix] {
+    prefix++
+  }
+  suffix := 0
+  for suffix < len(x)-prefix && suffix < len(y)-prefix && x[len(x)-1-suffix] == y[len(y)-1-suffix] {
+    suffix++
+  }
+
+  var out []DiffLine
+  for _, line := range x[:prefix] {
+    out = append(out, DiffLine{DiffSame, line})
+  }
+  out = diffMiddle(out, x[prefix:len(x)-suffix], y[prefix:len(y)-suffix])
+  for _, line := range x[len(x)-suffix:] {
+    out = append(out, DiffLine{DiffSame, line})
+  }
+  return out
+}
+
+func diffMiddle(out []DiffLine, x, y []string) []DiffLine {
+  i, j := 0, 0
+  if len(x)*len(y) <= maxDiffCells {
+    // lcs[i*width+j] is the length of the longest common subsequence of x[i:] and y[j:].
+    width := len(y)+1
+    lcs := make([]int32, (len(x)+1)*width)
+    for i := len(x)-1; i >= 0; i-- {
+      for j := len(y)-1; j >= 0; j-- {
+        if x[i] == y[j] {
+          lcs[i*width+j] = lcs[(i+1)*width+j+1] + 1
+        } else {
+          lcs[i*width+j] = max(lcs[(i+1)*width+j], lcs[i*width+j+1])
+        }
+      }
+    }
+
+    for i < len(x) && j < len(y) {
+      switch {
+      case x[i] == y[j]:
+        out = append(out, DiffLine{DiffSame, x[i]})
+        i++
+        j++
+      case lcs[(i+1)*width+j] >= lcs[i*width+j+1]:
+        out = append(out, DiffLine{DiffRemoved, x[i]})
+        i++
+      default:
+        out = append(out, DiffLine{DiffAdded, y[j]})
+        j++
+      }
+    }
+  }
+  for ; i < len(x); i++ {
+    out = append(out, DiffLine{DiffRemoved, x[i]})
+  }
+  for ; j < len(y); j++ {
+    out = append(out, DiffLine{DiffAdded, y[j]})
+  }
+  return out
+}
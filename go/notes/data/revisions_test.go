@@ -0,0 +1,259 @@
+package data
+
+import (
+  "errors"
+  "fmt"
+  "path/filepath"
+  "runtime"
+  "strings"
+  "testing"
+)
+
+func TestDiffLines(t *testing.T) {
+  tests := []struct {
+    a, b string
+    want string
+  }{
+    {"a\nb\nc", "a\nb\nc", " a  b  c"},
+    {"a\nb\nc", "a\nx\nc", " a -b +x  c"},
+    {"a\nc", "a\nb\nc", " a +b  c"},
+    {"a\nb\nc", "c", "-a -b  c"},
+    {"", "a", "- +a"},
+    {"a\nb", "b\na", "-a  b +a"},
+  }
+  for _, tt := range tests {
+    var got []string
+    for _, l := range DiffLines(tt.a, tt.b) {
+      got = append(got, map[DiffOp]string{DiffSame: " ", DiffAdded: "+", DiffRemoved: "-"}[l.Op] + l.Text)
+    }
+    if strings.Join(got, " ") != tt.want {
+      t.Errorf("%q -> %q: got %q, want %q", tt.a, tt.b, strings.Join(got, " "), tt.want)
+    }
+  }
+}
+
+func countOps(diff []DiffLine) map[DiffOp]int {
+  counts := map[DiffOp]int{}
+  for _, l := range diff {
+    counts[l.Op]++
+  }
+  return counts
+}
+
+// Big notes mustn't need a table the size of both texts multiplied.
+func TestDiffLinesLarge(t *testing.T) {
+  var x, y, z []string
+  for i := 0; i < 10000; i++ {
+    x = append(x, fmt.Sprintf("old line %d", i))
+    y = append(y, fmt.Sprintf("new line %d", i))
+    z = append(z, fmt.Sprintf("old line %d", i))
+  }
+  z[5000] = "changed"
+  a := "header\n" + strings.Join(x, "\n") + "\nfooter"
+  b := "header\n" + strings.Join(y, "\n") + "\nfooter"
+
+  var before, after runtime.MemStats
+  runtime.ReadMemStats(&before)
+  diff := DiffLines(a, b)
+  runtime.ReadMemStats(&after)
+  if alloc := after.TotalAlloc - before.TotalAlloc; alloc > 100<<20 {
+    t.Errorf("allocated %d MB", alloc>>20)
+  }
+  if got := countOps(diff); got[DiffSame] != 2 || got[DiffRemoved] != 10000 || got[DiffAdded] != 10000 {
+    t.Errorf("got %v for completely different lines", got)
+  }
+  if diff[0].Text != "header" || diff[1].Text != "old line 0" || diff[10001].Text != "new line 0" || diff[20001].Text != "footer" {
+    t.Errorf("got lines %q, %q, %q, %q", diff[0].Text, diff[1].Text, diff[10001].Text, diff[20001].Text)
+  }
+
+  diff = DiffLines(strings.Join(x, "\n"), strings.Join(z, "\n"))
+  if got := countOps(diff); got[DiffSame] != 9999 || got[DiffRemoved] != 1 || got[DiffAdded] != 1 {
+    t.Errorf("got %v for one changed line", got)
+  }
+  if diff[5000] != (DiffLine{DiffRemoved, "old line 5000"}) || diff[5001] != (DiffLine{DiffAdded, "changed"}) {
+    t.Errorf("got %+v around the changed line", diff[4999:5003])
+  }
+}
+
+func revisionTitles(t *testing.T, db NotesDatabase, id int64) string {
+  t.Helper()
+  revisions, err := db.Revisions(id)
+  if err != nil {
+    t.Fatal(err)
+  }
+  var titles []string
+  for i, rev := range revisions {
+    if rev.Number != i+1 || rev.Time.IsZero() {
+      t.Errorf("revision %d: got number %d, time %v", i, rev.Number, rev.Time)
+    }
+    titles = append(titles, rev.Title)
+  }
+  return strings.Join(titles, " ")
+}
+
+func runRevisionTests(t *testing.T, db NotesDatabase) {
+  addNotes(t, db, "a")
+  if got := revisionTitles(t, db, 1); got != "a" {
+    t.Errorf("got revisions %q for a new note", got)
+  }
+  for _, title := range []string{"b", "c"} {
+    if err := db.Update(1, &Note{Title: title, Body: "body " + title, Tags: []string{title}}); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if got := revisionTitles(t, db, 1); got != "a b c" {
+    t.Errorf("got revisions %q, want a b c", got)
+  }
+
+  if err := db.RestoreRevision(1, 2); err != nil {
+    t.Fatal(err)
+  }
+  note, err := db.Get(1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if note.Title != "b" || note.Body != "body b" || fmt.Sprint(note.Tags) != "[b]" {
+    t.Errorf("got %+v after restoring revision 2", note)
+  }
+  // Restoring adds a revision rather than dropping the later ones.
+  if got := revisionTitles(t, db, 1); got != "a b c b" {
+    t.Errorf("got revisions %q, want a b c b", got)
+  }
+
+  // Saving the same content again, or restoring the latest revision, doesn't add one.
+  if err := db.Update(1, &Note{Title: "b", Body: "body b", Tags: []string{"b"}}); err != nil {
+    t.Fatal(err)
+  }
+  if err := db.RestoreRevision(1, 4); err != nil {
+    t.Fatal(err)
+  }
+  if got := revisionTitles(t, db, 1); got != "a b c b" {
+    t.Errorf("got revisions %q after an unchanged update, want a b c b", got)
+  }
+
+  if err := db.RestoreRevision(1, 9); !errors.Is(err, ErrNoRevision) {
+    t.Errorf("restoring a missing revision: got %v, want ErrNoRevision", err)
+  }
+  if _, err := db.Revisions(7); !errors.Is(err, ErrNotFound) {
+    t.Errorf("revisions of a missing note: got %v, want ErrNotFound", err)
+  }
+  if err := db.RestoreRevision(7, 1); !errors.Is(err, ErrNotFound) {
+    t.Errorf("restoring a revision of a missing note: got %v, want ErrNotFound", err)
+  }
+}
+
+func TestRevisions(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  db := openTestDB(t, filename)
+  runRevisionTests(t, db)
+  flush(t, db)
+
+  db = reopen(t, db, filename)
+  if got := revisionTitles(t, db, 1); got != "a b c b" {
+    t.Errorf("got revisions %q after restart", got)
+  }
+}
+
+// Only the last maxRevisions are kept, with their numbers.
+func runRevisionLimitTests(t *testing.T, db NotesDatabase) {
+  addNotes(t, db, "0")
+  for i := 1; i <= maxRevisions+5; i++ {
+    if err := db.Update(1, &Note{Title: fmt.Sprint(i)}); err != nil {
+      t.Fatal(err)
+    }
+  }
+  revisions, err := db.Revisions(1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if len(revisions) != maxRevisions {
+    t.Fatalf("got %d revisions, want %d", len(revisions), maxRevisions)
+  }
+  for i, rev := range revisions {
+    if rev.Number != i+7 || rev.Title != fmt.Sprint(i+6) {
+      t.Errorf("revision %d: got number %d, title %q", i, rev.Number, rev.Title)
+    }
+  }
+  if err := db.RestoreRevision(1, 6); !errors.Is(err, ErrNoRevision) {
+    t.Errorf("restoring a dropped revision: got %v, want ErrNoRevision", err)
+  }
+  if err := db.RestoreRevision(1, 7); err != nil {
+    t.Fatal(err)
+  }
+  if revisions, err := db.Revisions(1); err != nil || len(revisions) != maxRevisions || revisions[maxRevisions-1].Number != maxRevisions+7 {
+    t.Errorf("got %d revisions, %v after a restore", len(revisions), err)
+  }
+}
+
+func TestRevisionLimit(t *testing.T) {
+  runRevisionLimitTests(t, openTestDB(t, filepath.Join(t.TempDir(), "notes.json")))
+}
+
+func TestSQLiteRevisionLimit(t *testing.T) {
+  runRevisionLimitTests(t, openSQLiteTestDB(t, filepath.Join(t.TempDir(), "notes.db")))
+}
+
+func TestSQLiteRevisions(t *testing.T) {
+  db := openSQLiteTestDB(t, filepath.Join(t.TempDir(), "notes.db"))
+  runRevisionTests(t, db)
+
+  // Purging a note drops its revisions.
+  if err := db.Delete(1); err != nil {
+    t.Fatal(err)
+  }
+  if _, err := db.Purge(0); err != nil {
+    t.Fatal(err)
+  }
+  var n int
+  if err := db.db.QueryRow(`SELECT COUNT(*) FROM note_revisions`).Scan(&n); err != nil {
+    t.Fatal(err)
+  }
+  if n != 0 {
+    t.Errorf("%d revisions left after purge", n)
+  }
+}
+
+// Notes edited before revisions were kept get their current content as the first revision.
+func TestRevisionsOfOldNotes(t *testing.T) {
+  dir := t.TempDir()
+  jsonFile := filepath.Join(dir, "notes.json")
+  writeFile(t, jsonFile, map[string]interface{}{
+    "notes": []map[string]interface{}{{"id": 1, "title": "old", "created": "2024-01-02T03:04:05Z"}},
+    "next_id": 2,
+  })
+  db := openTestDB(t, jsonFile)
+  if got := revisionTitles(t, db, 1); got != "old" {
+    t.Errorf("got revisions %q", got)
+  }
+  if err := db.Update(1, &Note{Title: "new"}); err != nil {
+    t.Fatal(err)
+  }
+  if got := revisionTitles(t, db, 1); got != "old new" {
+    t.Errorf("got revisions %q after an edit", got)
+  }
+  flush(t, db)
+  db.Close()
+
+  // Imported with the rest of the file.
+  sqlDB := openSQLiteTestDB(t, filepath.Join(dir, "notes.db"))
+  if _, err := sqlDB.ImportJSON(jsonFile); err != nil {
+    t.Fatal(err)
+  }
+  if got := revisionTitles(t, sqlDB, 1); got != "old new" {
+    t.Errorf("got revisions %q after import", got)
+  }
+
+  // And the same for a note that only ever lived in SQLite without any.
+  if _, err := sqlDB.db.Exec(`DELETE FROM note_revisions`); err != nil {
+    t.Fatal(err)
+  }
+  if got := revisionTitles(t, sqlDB, 1); got != "new" {
+    t.Errorf("got revisions %q without a log", got)
+  }
+  if err := sqlDB.Update(1, &Note{Title: "newer"}); err != nil {
+    t.Fatal(err)
+  }
+  if got := revisionTitles(t, sqlDB, 1); got != "new newer" {
+    t.Errorf("got revisions %q after an edit", got)
+  }
+}
@@ -0,0 +1,332 @@
+package data
+
+import (
+  "errors"
+  "fmt"
+  "math"
+  "sort"
+  "strings"
+  "time"
+)
+
+var ErrBadQuery = errors.New("bad search query")
+
+// SearchQuery is a parsed full-text query. The syntax is:
+//
+//   word        notes with the word in the title, URL or body
+//   wor*        words starting with "wor"
+//   "a phrase"  the words next to each other, in order
+//   -word       notes without the word (also -wor* and -"a phrase")
+//   tag:x       notes tagged x, -tag:x for notes that aren't
+//   after:2024-01-15, before:2024-02, date:2024
+//               notes created on or after the start of the date, before it, or within it
+//
+// Terms are ANDed together. Words that tokenize to several terms, like "github.com", are phrases.
+type SearchQuery struct {
+  Terms []string
+  Prefixes []string
+  Phrases [][]string
+
+  NotTerms []string
+  NotPrefixes []string
+  NotPhrases [][]string
+
+  Tags []string
+  NotTags []string
+  // Zero for no bound. After is inclusive, Before exclusive.
+  After time.Time
+  Before time.Time
+}
+
+// Ranked reports whether the query has words to rank results by. Queries with only tags, dates and
+// negations keep the notes in their usual order.
+func (q *SearchQuery) Ranked() bool {
+  return len(q.Terms) > 0 || len(q.Prefixes) > 0 || len(q.Phrases) > 0
+}
+
+// Split the query on spaces, keeping quoted phrases together.
+func splitQuery(s string) []string {
+  var out []string
+  var cur strings.Builder
+  inQuote := false
+  for _, r := range s {
+    switch {
+    case r == '"':
+      cur.WriteRune(r)
+      inQuote = !inQuote
+    case !inQuote && (r == ' ' || r == '\t' || r == '\n'):
+      if cur.Len() > 0 {
+        out = append(out, cur.String())
+        cur.Reset()
+      }
+    default:
+      cur.WriteRune(r)
+    }
+  }
+  if cur.Len() > 0 {
+    out = append(out, cur.String())
+  }
+  return out
+}
+
+// Parse a date and return the start of it and of the next one, e.g. a whole month for "2024-02".
+func parseDateRange(s string) (time.Time, time.Time, error) {
+  layouts := []struct {
+    layout string
+    years, months, days int
+  }{
+    {"2006-01-02", 0, 0, 1},
+    {"2006-01", 0, 1, 0},
+    {"2006", 1, 0, 0},
+  }
+  for _, l := range layouts {
+    t, err := time.ParseInLocation(l.layout, s, time.Local)
+    if err == nil {
+      return t, t.AddDate(l.years, l.months, l.days), nil
+    }
+  }
+  return time.Time{}, time.Time{}, fmt.Errorf("%w: bad date %q", ErrBadQuery, s)
+}
+
+func ParseSearch(s string) (*SearchQuery, error) {
+  q := &SearchQuery{}
+  for _, word := range splitQuery(s) {
+    neg := false
+    if len(word) > 1 && word[0] == '-' {
+      neg = true
+      word = word[1:]
+    }
+
+    if key, value, ok := strings.Cut(word, ":"); ok && value != "" {
+      switch strings.ToLower(key) {
+      case "tag":
+        if neg {
+          q.NotTags = append(q.NotTags, value)
+        } else {
+          q.Tags = append(q.Tags, value)
+        }
+        continue
+      case "after", "before", "date":
+        if neg {
+          return nil, fmt.Errorf("%w: can't negate %s:", ErrBadQuery, key)
+        }
+        start, end, err := parseDateRange(value)
+        if err != nil {
+          return nil, err
+        }
+        switch strings.ToLower(key) {
+        case "after":
+          q.After = start
+        case "before":
+          q.Before = start
+        default:
+          q.After, q.Before = start, end
+        }
+        continue
+      }
+    }
+
+    quoted := strings.HasPrefix(word, `"`)
+    prefix := !quoted && strings.HasSuffix(word, "*")
+    tokens := tokenize(word)
+    switch {
+    case len(tokens) == 0:
+      continue
+    case prefix && len(tokens) == 1:
+      if neg {
+        q.NotPrefixes = append(q.NotPrefixes, tokens[0])
+      } else {
+        q.Prefixes = append(q.Prefixes, tokens[0])
+      }
+    case len(tokens) == 1:
+      if neg {
+        q.NotTerms = append(q.NotTerms, tokens[0])
+      } else {
+        q.Terms = append(q.Terms, tokens[0])
+      }
+    default:
+      if neg {
+        q.NotPhrases = append(q.NotPhrases, tokens)
+      } else {
+        q.Phrases = append(q.Phrases, tokens)
+      }
+    }
+  }
+  return q, nil
+}
+
+func hasAnyTag(note *Note, tags []string) bool {
+  for _, t := range note.Tags {
+    for _, want := range tags {
+      if strings.EqualFold(t, want) {
+        return true
+      }
+    }
+  }
+  return false
+}
+
+func hasAllTags(note *Note, tags []string) bool {
+  for _, want := range tags {
+    if !hasAnyTag(note, []string{want}) {
+      return false
+    }
+  }
+  return true
+}
+
+func (ix *Index) hasPrefix(prefix string, id int64) bool {
+  for _, term := range ix.expand(prefix) {
+    if ix.posting(term, id) != nil {
+      return true
+    }
+  }
+  return false
+}
+
+func (ix *Index) hasPhrase(phrase []string, id int64) bool {
+  postings := make([]*Posting, len(phrase))
+  for i, term := range phrase {
+    if postings[i] = ix.posting(term, id); postings[i] == nil {
+      return false
+    }
+  }
+  sets := make([]map[int]bool, len(phrase))
+  for i, p := range postings {
+    sets[i] = make(map[int]bool, len(p.Pos))
+    for _, pos := range p.Pos {
+      sets[i][pos] = true
+    }
+  }
+  for _, start := range postings[0].Pos {
+    ok := true
+    for i := 1; i < len(phrase) && ok; i++ {
+      ok = sets[i][start+i]
+    }
+    if ok {
+      return true
+    }
+  }
+  return false
+}
+
+func (ix *Index) matches(q *SearchQuery, note *Note) bool {
+  if !hasAllTags(note, q.Tags) || hasAnyTag(note, q.NotTags) {
+    return false
+  }
+  if (!q.After.IsZero() && note.Created.Before(q.After)) || (!q.Before.IsZero() && !note.Created.Before(q.Before)) {
+    return false
+  }
+  id := note.ID
+  for _, term := range q.Terms {
+    if ix.posting(term, id) == nil {
+      return false
+    }
+  }
+  for _, prefix := range q.Prefixes {
+    if !ix.hasPrefix(prefix, id) {
+      return false
+    }
+  }
+  for _, phrase := range q.Phrases {
+    if !ix.hasPhrase(phrase, id) {
+      return false
+    }
+  }
+  for _, term := range q.NotTerms {
+    if ix.posting(term, id) != nil {
+      return false
+    }
+  }
+  for _, prefix := range q.NotPrefixes {
+    if ix.hasPrefix(prefix, id) {
+      return false
+    }
+  }
+  for _, phrase := range q.NotPhrases {
+    if ix.hasPhrase(phrase, id) {
+      return false
+    }
+  }
+  return true
+}
+
+// BM25 parameters.
+const (
+  bm25K1 = 1.2
+  bm25B = 0.75
+)
+
+// BM25 score of one term in one note, with matches weighted by field.
+func (ix *Index) termScore(term string, id int64, avgLen float64) float64 {
+  p := ix.posting(term, id)
+  if p == nil {
+    return 0
+  }
+  tf := 0.0
+  for _, pos := range p.Pos {
+    tf += fieldWeights[pos/fieldGap]
+  }
+  n := float64(len(ix.Docs))
+  df := float64(len(ix.Postings[term]))
+  idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+  length := 0.0
+  if doc, ok := ix.Docs[id]; ok {
+    length = doc.Length
+  }
+  return idf * tf * (bm25K1 + 1) / (tf + bm25K1*(1-bm25B+bm25B*length/avgLen))
+}
+
+func (ix *Index) score(q *SearchQuery, id int64, avgLen float64) float64 {
+  score := 0.0
+  for _, term := range q.Terms {
+    score += ix.termScore(term, id, avgLen)
+  }
+  for _, prefix := range q.Prefixes {
+    // The best completion, so a prefix with lots of completions doesn't swamp everything else.
+    best := 0.0
+    for _, term := range ix.expand(prefix) {
+      best = math.Max(best, ix.termScore(term, id, avgLen))
+    }
+    score += best
+  }
+  for _, phrase := range q.Phrases {
+    // Count phrase words twice, so an exact phrase beats the same words scattered around.
+    for _, term := range phrase {
+      score += 2 * ix.termScore(term, id, avgLen)
+    }
+  }
+  return score
+}
+
+// Search returns the notes matching q. Ranked queries come back best match first, with newer notes
+// first among equals. Others keep the order of notes.
+func (ix *Index) Search(notes []Note, q *SearchQuery) []Note {
+  var out []Note
+  for i := range notes {
+    if ix.matches(q, &notes[i]) {
+      out = append(out, notes[i])
+    }
+  }
+  if !q.Ranked() || len(out) == 0 {
+    return out
+  }
+
+  avgLen := 0.0
+  for _, doc := range ix.Docs {
+    avgLen += doc.Length
+  }
+  avgLen = math.Max(avgLen/float64(len(ix.Docs)), 1)
+  scores := make(map[int64]float64, len(out))
+  for _, note := range out {
+    scores[note.ID] = ix.score(q, note.ID, avgLen)
+  }
+  sort.SliceStable(out, func(i, j int) bool {
+    si, sj := scores[out[i].ID], scores[out[j].ID]
+    if si != sj {
+      return si > sj
+    }
+    return out[i].Created.After(out[j].Created)
+  })
+  return out
+}
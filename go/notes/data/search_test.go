@@ -0,0 +1,192 @@
+package data
+
+import (
+  "errors"
+  "fmt"
+  "os"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+func searchTestDB(t *testing.T) (*TextNotesDatabase, string) {
+  t.Helper()
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  db := openTestDB(t, filename).(*TextNotesDatabase)
+  addSearchNotes(t, db)
+  // Spread the notes out in time: note i was created i days after 2024-01-01.
+  for i := range db.notes {
+    db.notes[i].Created = searchNoteCreated(i)
+  }
+  return db, filename
+}
+
+func searchNoteCreated(i int) time.Time {
+  return time.Date(2024, 1, 1+i, 12, 0, 0, 0, time.Local)
+}
+
+func addSearchNotes(t *testing.T, db NotesDatabase) {
+  t.Helper()
+  notes := []Note{
+    {Title: "Go concurrency patterns", Body: "Pipelines and cancellation with channels.", Tags: []string{"go", "talk"}},
+    {Title: "Shopping", Body: "milk, eggs, bread", Tags: []string{"todo"}},
+    {Title: "Channels", Body: "The go blog post about channels and concurrency.", URL: "https://go.dev/blog/pipelines", Tags: []string{"go"}},
+    {Title: "Gopher meetup", Body: "Talk about generics", Tags: []string{"talk"}},
+    {Title: "Concurrency is not parallelism", Body: "Rob Pike's talk.", Tags: []string{"go", "talk"}},
+  }
+  for i := range notes {
+    if err := db.Add(&notes[i]); err != nil {
+      t.Fatal(err)
+    }
+  }
+}
+
+func search(t *testing.T, db NotesDatabase, q string) []int64 {
+  t.Helper()
+  notes, err := db.Query(&NotesQuery{Text: q})
+  if err != nil {
+    t.Fatalf("%q: %v", q, err)
+  }
+  ids := []int64{}
+  for _, n := range notes {
+    ids = append(ids, n.ID)
+  }
+  return ids
+}
+
+func sameIds(got, want []int64) bool {
+  return fmt.Sprint(got) == fmt.Sprint(want)
+}
+
+// Queries over the notes from addSearchNotes, and the IDs they return.
+var searchTests = []struct {
+  q string
+  want []int64
+}{
+  // Title matches rank above body matches.
+  {"channels", []int64{3, 1}},
+  {"CHANNELS", []int64{3, 1}},
+  {"concurrency channels", []int64{3, 1}},
+  {"chan*", []int64{3, 1}},
+  {"gen*", []int64{4}},
+  {`"go blog"`, []int64{3}},
+  {`"blog go"`, nil},
+  // Phrases don't run from the title into the body.
+  {`"shopping milk"`, nil},
+  {"go.dev", []int64{3}},
+  {"concurrency -channels", []int64{5}},
+  {"concurrency -chan*", []int64{5}},
+  {`talk -"rob pike"`, []int64{4}},
+  {"talk tag:go", []int64{5}},
+  {"tag:talk -tag:go", []int64{4}},
+  {"tag:talk", []int64{1, 4, 5}},
+  {"after:2024-01-03", []int64{3, 4, 5}},
+  {"before:2024-01-03", []int64{1, 2}},
+  {"date:2024-01-04", []int64{4}},
+  // Shorter notes rank higher for the same matches.
+  {"concurrency date:2024-01", []int64{1, 5, 3}},
+  {"nothing-matches-this", nil},
+}
+
+// Run searchTests, with different results for some queries in overrides.
+func runSearchTests(t *testing.T, db NotesDatabase, overrides map[string][]int64) {
+  t.Helper()
+  for _, tt := range searchTests {
+    if want, ok := overrides[tt.q]; ok {
+      tt.want = want
+    }
+    got := search(t, db, tt.q)
+    if !sameIds(got, tt.want) {
+      t.Errorf("%q: got %v, want %v", tt.q, got, tt.want)
+    }
+  }
+}
+
+func TestSearch(t *testing.T) {
+  db, _ := searchTestDB(t)
+  runSearchTests(t, db, nil)
+}
+
+func TestSearchBadDate(t *testing.T) {
+  db, _ := searchTestDB(t)
+  if _, err := db.Query(&NotesQuery{Text: "after:yesterday"}); !errors.Is(err, ErrBadQuery) {
+    t.Errorf("got %v, want ErrBadQuery", err)
+  }
+}
+
+func TestSearchRanked(t *testing.T) {
+  for q, want := range map[string]bool{
+    "": false,
+    "word": true,
+    "wor*": true,
+    `"a phrase"`: true,
+    "tag:x": false,
+    "-word after:2024": false,
+  } {
+    if got := (&NotesQuery{Text: q}).Ranked(); got != want {
+      t.Errorf("%q: got ranked %v, want %v", q, got, want)
+    }
+  }
+}
+
+func TestSearchFollowsEdits(t *testing.T) {
+  db, filename := searchTestDB(t)
+  if err := db.Update(2, &Note{Title: "Shopping", Body: "oat milk, coffee"}); err != nil {
+    t.Fatal(err)
+  }
+  if err := db.Delete(3); err != nil {
+    t.Fatal(err)
+  }
+  if got := search(t, db, "coffee"); !sameIds(got, []int64{2}) {
+    t.Errorf("coffee: got %v", got)
+  }
+  if got := search(t, db, "eggs"); len(got) != 0 {
+    t.Errorf("eggs: got %v after editing them out", got)
+  }
+  if got := search(t, db, "channels"); !sameIds(got, []int64{1}) {
+    t.Errorf("channels: got %v after deleting note 3", got)
+  }
+  flush(t, db)
+
+  if _, err := os.Stat(indexFilename(filename)); err != nil {
+    t.Fatalf("no index file: %v", err)
+  }
+  db2 := reopen(t, db, filename)
+  if got := search(t, db2, "coffee"); !sameIds(got, []int64{2}) {
+    t.Errorf("coffee after reload: got %v", got)
+  }
+}
+
+// An index that's out of date with notes.json (e.g. the file was edited by hand) is fixed on load.
+func TestStaleIndexResynced(t *testing.T) {
+  db, filename := searchTestDB(t)
+  flush(t, db)
+  stale, err := os.ReadFile(indexFilename(filename))
+  if err != nil {
+    t.Fatal(err)
+  }
+
+  if err := db.Update(2, &Note{Title: "Groceries"}); err != nil {
+    t.Fatal(err)
+  }
+  flush(t, db)
+  if err := os.WriteFile(indexFilename(filename), stale, 0644); err != nil {
+    t.Fatal(err)
+  }
+
+  db2 := reopen(t, db, filename)
+  if got := search(t, db2, "shopping"); len(got) != 0 {
+    t.Errorf("shopping: got %v from the stale index", got)
+  }
+  if got := search(t, db2, "groceries"); !sameIds(got, []int64{2}) {
+    t.Errorf("groceries: got %v", got)
+  }
+
+  if err := os.WriteFile(indexFilename(filename), []byte("not json"), 0644); err != nil {
+    t.Fatal(err)
+  }
+  db3 := reopen(t, db2, filename)
+  if got := search(t, db3, "groceries"); !sameIds(got, []int64{2}) {
+    t.Errorf("groceries with a broken index file: got %v", got)
+  }
+}
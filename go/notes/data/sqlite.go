@@ -0,0 +1,574 @@
+package data
+
+import (
+  "database/sql"
+  "encoding/json"
+  "errors"
+  "fmt"
+  "os"
+  "strings"
+  "time"
+
+  _ "github.com/glebarez/go-sqlite"
+
+  "github.com/davedolben/dev-tools/go/sqlutil"
+)
+
+// Times are stored as fixed width UTC strings so they sort and compare correctly as text.
+const sqliteTimeLayout = "2006-01-02T15:04:05.000000000Z"
+
+var sqliteMigrations = []sqlutil.Migration{
+  {
+    Version: 1,
+    Up: func(db *sql.DB) error {
+      return execAll(db,
+        // AUTOINCREMENT so IDs are never reused, like next_id in the JSON files.
+        `CREATE TABLE notes (
+          id INTEGER PRIMARY KEY AUTOINCREMENT,
+          created TEXT NOT NULL,
+          updated TEXT,
+          title TEXT NOT NULL DEFAULT '',
+          body TEXT NOT NULL DEFAULT '',
+          url TEXT NOT NULL DEFAULT '',
+          trashed INTEGER NOT NULL DEFAULT 0
+        )`,
+        `CREATE TABLE note_tags (
+          note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+          pos INTEGER NOT NULL,
+          tag TEXT NOT NULL,
+          PRIMARY KEY (note_id, pos)
+        )`,
+        `CREATE INDEX note_tags_tag ON note_tags(tag, note_id)`,
+        // Same tokens as tokenize: lower-cased letters and digits, accents kept.
+        `CREATE VIRTUAL TABLE notes_fts USING fts5(
+          title, url, body,
+          content='notes', content_rowid='id',
+          tokenize='unicode61 remove_diacritics 0'
+        )`,
+        `CREATE TRIGGER notes_fts_insert AFTER INSERT ON notes BEGIN
+          INSERT INTO notes_fts(rowid, title, url, body) VALUES (new.id, new.title, new.url, new.body);
+        END`,
+        `CREATE TRIGGER notes_fts_delete AFTER DELETE ON notes BEGIN
+          INSERT INTO notes_fts(notes_fts, rowid, title, url, body) VALUES ('delete', old.id, old.title, old.url, old.body);
+        END`,
+        `CREATE TRIGGER notes_fts_update AFTER UPDATE OF title, url, body ON notes BEGIN
+          INSERT INTO notes_fts(notes_fts, rowid, title, url, body) VALUES ('delete', old.id, old.title, old.url, old.body);
+          INSERT INTO notes_fts(rowid, title, url, body) VALUES (new.id, new.title, new.url, new.body);
+        END`,
+      )
+    },
+  },
+  {
+    Version: 2,
+    Up: func(db *sql.DB) error {
+      return execAll(db, `ALTER TABLE notes ADD COLUMN deleted TEXT`)
+    },
+  },
+  {
+    Version: 3,
+    Up: func(db *sql.DB) error {
+      return execAll(db,
+        // tags is a JSON array.
+        `CREATE TABLE note_revisions (
+          note_id INTEGER NOT NULL REFERENCES notes(id) ON DELETE CASCADE,
+          number INTEGER NOT NULL,
+          time TEXT NOT NULL,
+          title TEXT NOT NULL,
+          body TEXT NOT NULL,
+          url TEXT NOT NULL,
+          tags TEXT NOT NULL,
+          PRIMARY KEY (note_id, number)
+        )`,
+      )
+    },
+  },
+}
+
+func execAll(db *sql.DB, stmts ...string) error {
+  tx, err := db.Begin()
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+  for _, stmt := range stmts {
+    if _, err := tx.Exec(stmt); err != nil {
+      return err
+    }
+  }
+  return tx.Commit()
+}
+
+// SQLiteNotesDatabase keeps notes in a SQLite file. Every change is written right away, so Flush
+// does nothing. Tags are in their own table, and text search uses FTS5.
+type SQLiteNotesDatabase struct {
+  db *sql.DB
+}
+
+func NewSQLiteDatabase(filename string) (*SQLiteNotesDatabase, error) {
+  dsn := filename + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)"
+  db, err := sql.Open("sqlite", dsn)
+  if err != nil {
+    return nil, err
+  }
+  if err := sqlutil.MigrateDB(db, sqliteMigrations); err != nil {
+    db.Close()
+    return nil, fmt.Errorf("%s: %w", filename, err)
+  }
+  return &SQLiteNotesDatabase{db: db}, nil
+}
+
+func formatSQLiteTime(t time.Time) string {
+  return t.UTC().Format(sqliteTimeLayout)
+}
+
+func parseSQLiteTime(s sql.NullString) (time.Time, error) {
+  if !s.Valid {
+    return time.Time{}, nil
+  }
+  t, err := time.Parse(sqliteTimeLayout, s.String)
+  if err != nil {
+    return time.Time{}, err
+  }
+  if t.IsZero() {
+    return t, nil
+  }
+  return t.Local(), nil
+}
+
+func nullSQLiteTime(t time.Time) interface{} {
+  if t.IsZero() {
+    return nil
+  }
+  return formatSQLiteTime(t)
+}
+
+func setTags(tx *sql.Tx, id int64, tags []string) error {
+  if _, err := tx.Exec(`DELETE FROM note_tags WHERE note_id = ?`, id); err != nil {
+    return err
+  }
+  for i, tag := range tags {
+    if _, err := tx.Exec(`INSERT INTO note_tags (note_id, pos, tag) VALUES (?, ?, ?)`, id, i, tag); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+func insertRevision(tx *sql.Tx, id int64, rev *Revision) error {
+  tags, err := json.Marshal(rev.Tags)
+  if err != nil {
+    return err
+  }
+  _, err = tx.Exec(`INSERT INTO note_revisions (note_id, number, time, title, body, url, tags) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+    id, rev.Number, formatSQLiteTime(rev.Time), rev.Title, rev.Body, rev.URL, string(tags))
+  return err
+}
+
+// Insert a note as is, ID and times included.
+func insertNote(tx *sql.Tx, note *Note, trashed bool) error {
+  _, err := tx.Exec(`INSERT INTO notes (id, created, updated, deleted, title, body, url, trashed) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+    note.ID, formatSQLiteTime(note.Created), nullSQLiteTime(note.Updated), nullSQLiteTime(note.Deleted), note.Title, note.Body, note.URL, trashed)
+  if err != nil {
+    return err
+  }
+  return setTags(tx, note.ID, note.Tags)
+}
+
+func (db *SQLiteNotesDatabase) Add(note *Note) error {
+  tx, err := db.db.Begin()
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+  now := time.Now()
+  res, err := tx.Exec(`INSERT INTO notes (created, title, body, url) VALUES (?, ?, ?, ?)`,
+    formatSQLiteTime(now), note.Title, note.Body, note.URL)
+  if err != nil {
+    return err
+  }
+  id, err := res.LastInsertId()
+  if err != nil {
+    return err
+  }
+  if err := setTags(tx, id, note.Tags); err != nil {
+    return err
+  }
+  rev := revisionOf(&Note{Created: now, Title: note.Title, Body: note.Body, URL: note.URL, Tags: note.Tags}, 1)
+  if err := insertRevision(tx, id, &rev); err != nil {
+    return err
+  }
+  return tx.Commit()
+}
+
+func (db *SQLiteNotesDatabase) Get(id int64) (*Note, error) {
+  notes, err := db.queryNotes(`WHERE n.id = ? AND n.trashed = 0`, "", id)
+  if err != nil {
+    return nil, err
+  }
+  if len(notes) == 0 {
+    return nil, ErrNotFound
+  }
+  return &notes[0], nil
+}
+
+func (db *SQLiteNotesDatabase) Update(id int64, note *Note) error {
+  tx, err := db.db.Begin()
+  if err != nil {
+    return err
+  }
+  defer tx.Rollback()
+  // Notes from before revisions were kept start their log with what they have now.
+  _, err = tx.Exec(`
+    INSERT INTO note_revisions (note_id, number, time, title, body, url, tags)
+    SELECT n.id, 1, COALESCE(n.updated, n.created), n.title, n.body, n.url,
+      (SELECT json_group_array(tag) FROM (SELECT tag FROM note_tags WHERE note_id = n.id ORDER BY pos))
+    FROM notes n
+    WHERE n.id = ? AND n.trashed = 0 AND NOT EXISTS (SELECT 1 FROM note_revisions WHERE note_id = n.id)`, id)
+  if err != nil {
+    return err
+  }
+  // Nothing to do if the note already has this content, e.g. when restoring its latest revision.
+  var current Revision
+  var tags string
+  err = tx.QueryRow(`
+    SELECT r.title, r.body, r.url, r.tags FROM note_revisions r JOIN notes n ON n.id = r.note_id
+    WHERE r.note_id = ? AND n.trashed = 0 ORDER BY r.number DESC LIMIT 1`, id).Scan(&current.Title, &current.Body, &current.URL, &tags)
+  if err == nil {
+    if err := json.Unmarshal([]byte(tags), &current.Tags); err != nil {
+      return err
+    }
+    if current.sameContent(note) {
+      return nil
+    }
+  } else if !errors.Is(err, sql.ErrNoRows) {
+    return err
+  }
+
+  now := time.Now()
+  res, err := tx.Exec(`UPDATE notes SET title = ?, body = ?, url = ?, updated = ? WHERE id = ? AND trashed = 0`,
+    note.Title, note.Body, note.URL, formatSQLiteTime(now), id)
+  if err != nil {
+    return err
+  }
+  if n, err := res.RowsAffected(); err != nil {
+    return err
+  } else if n == 0 {
+    return ErrNotFound
+  }
+  if err := setTags(tx, id, note.Tags); err != nil {
+    return err
+  }
+
+  var last int
+  if err := tx.QueryRow(`SELECT MAX(number) FROM note_revisions WHERE note_id = ?`, id).Scan(&last); err != nil {
+    return err
+  }
+  rev := Revision{Number: last + 1, Time: now, Title: note.Title, Body: note.Body, URL: note.URL, Tags: note.Tags}
+  if err := insertRevision(tx, id, &rev); err != nil {
+    return err
+  }
+  if _, err := tx.Exec(`DELETE FROM note_revisions WHERE note_id = ? AND number <= ?`, id, rev.Number-maxRevisions); err != nil {
+    return err
+  }
+  return tx.Commit()
+}
+
+func (db *SQLiteNotesDatabase) Revisions(id int64) ([]Revision, error) {
+  note, err := db.Get(id)
+  if err != nil {
+    return nil, err
+  }
+  rows, err := db.db.Query(`SELECT number, time, title, body, url, tags FROM note_revisions WHERE note_id = ? ORDER BY number`, id)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  var revisions []Revision
+  for rows.Next() {
+    var rev Revision
+    var t sql.NullString
+    var tags string
+    if err := rows.Scan(&rev.Number, &t, &rev.Title, &rev.Body, &rev.URL, &tags); err != nil {
+      return nil, err
+    }
+    if rev.Time, err = parseSQLiteTime(t); err != nil {
+      return nil, err
+    }
+    if err := json.Unmarshal([]byte(tags), &rev.Tags); err != nil {
+      return nil, err
+    }
+    if len(rev.Tags) == 0 {
+      rev.Tags = nil
+    }
+    revisions = append(revisions, rev)
+  }
+  if err := rows.Err(); err != nil {
+    return nil, err
+  }
+  if len(revisions) == 0 {
+    return []Revision{revisionOf(note, 1)}, nil
+  }
+  return revisions, nil
+}
+
+func (db *SQLiteNotesDatabase) RestoreRevision(id int64, number int) error {
+  revisions, err := db.Revisions(id)
+  if err != nil {
+    return err
+  }
+  for _, rev := range revisions {
+    if rev.Number == number {
+      return db.Update(id, rev.Note())
+    }
+  }
+  return ErrNoRevision
+}
+
+func (db *SQLiteNotesDatabase) Delete(id int64) error {
+  res, err := db.db.Exec(`UPDATE notes SET trashed = 1, deleted = ? WHERE id = ? AND trashed = 0`, formatSQLiteTime(time.Now()), id)
+  if err != nil {
+    return err
+  }
+  if n, err := res.RowsAffected(); err != nil {
+    return err
+  } else if n == 0 {
+    return ErrNotFound
+  }
+  return nil
+}
+
+func (db *SQLiteNotesDatabase) ListTrash() ([]Note, error) {
+  // Notes without a deletion time (NULL sorts first) were deleted before the rest.
+  return db.queryNotes(`WHERE n.trashed = 1`, ` ORDER BY n.deleted, n.id`)
+}
+
+func (db *SQLiteNotesDatabase) Restore(id int64) error {
+  res, err := db.db.Exec(`UPDATE notes SET trashed = 0, deleted = NULL WHERE id = ? AND trashed = 1`, id)
+  if err != nil {
+    return err
+  }
+  if n, err := res.RowsAffected(); err != nil {
+    return err
+  } else if n == 0 {
+    return ErrNotFound
+  }
+  return nil
+}
+
+func (db *SQLiteNotesDatabase) Purge(olderThan time.Duration) (int, error) {
+  cutoff := formatSQLiteTime(time.Now().Add(-olderThan))
+  // Tags go with the notes, and the FTS triggers drop them from the search index.
+  res, err := db.db.Exec(`DELETE FROM notes WHERE trashed = 1 AND (deleted IS NULL OR deleted <= ?)`, cutoff)
+  if err != nil {
+    return 0, err
+  }
+  n, err := res.RowsAffected()
+  return int(n), err
+}
+
+// Quote terms for an FTS5 query. tokenize never leaves quotes in them.
+func ftsPhrase(terms []string) string {
+  return `"` + strings.Join(terms, " ") + `"`
+}
+
+// The FTS5 query for the words of q, or "" if it has none. Negations are left to the caller, since
+// FTS5's NOT needs something on its left.
+func ftsMatch(terms, prefixes []string, phrases [][]string) string {
+  var parts []string
+  for _, term := range terms {
+    parts = append(parts, ftsPhrase([]string{term}))
+  }
+  for _, prefix := range prefixes {
+    parts = append(parts, ftsPhrase([]string{prefix}) + "*")
+  }
+  for _, phrase := range phrases {
+    parts = append(parts, ftsPhrase(phrase))
+  }
+  return strings.Join(parts, " AND ")
+}
+
+func (db *SQLiteNotesDatabase) Query(query *NotesQuery) ([]Note, error) {
+  where := []string{"n.trashed = 0"}
+  var args []interface{}
+  join := ""
+  order := "n.id"
+
+  if query != nil {
+    for _, tag := range query.Tags {
+      where = append(where, `EXISTS (SELECT 1 FROM note_tags t WHERE t.note_id = n.id AND t.tag = ?)`)
+      args = append(args, tag)
+    }
+  }
+  if query != nil && query.Text != "" {
+    sq, err := ParseSearch(query.Text)
+    if err != nil {
+      return nil, err
+    }
+    if match := ftsMatch(sq.Terms, sq.Prefixes, sq.Phrases); match != "" {
+      join = `JOIN notes_fts ON notes_fts.rowid = n.id`
+      where = append(where, `notes_fts MATCH ?`)
+      args = append(args, match)
+      // Lower is better. Weighted by field like Index.termScore.
+      order = fmt.Sprintf("bm25(notes_fts, %g, %g, %g), n.created DESC",
+        fieldWeights[titleField], fieldWeights[urlField], fieldWeights[bodyField])
+    }
+    for _, term := range sq.NotTerms {
+      where = append(where, `n.id NOT IN (SELECT rowid FROM notes_fts WHERE notes_fts MATCH ?)`)
+      args = append(args, ftsMatch([]string{term}, nil, nil))
+    }
+    for _, prefix := range sq.NotPrefixes {
+      where = append(where, `n.id NOT IN (SELECT rowid FROM notes_fts WHERE notes_fts MATCH ?)`)
+      args = append(args, ftsMatch(nil, []string{prefix}, nil))
+    }
+    for _, phrase := range sq.NotPhrases {
+      where = append(where, `n.id NOT IN (SELECT rowid FROM notes_fts WHERE notes_fts MATCH ?)`)
+      args = append(args, ftsMatch(nil, nil, [][]string{phrase}))
+    }
+    for _, tag := range sq.Tags {
+      where = append(where, `EXISTS (SELECT 1 FROM note_tags t WHERE t.note_id = n.id AND t.tag = ? COLLATE NOCASE)`)
+      args = append(args, tag)
+    }
+    for _, tag := range sq.NotTags {
+      where = append(where, `NOT EXISTS (SELECT 1 FROM note_tags t WHERE t.note_id = n.id AND t.tag = ? COLLATE NOCASE)`)
+      args = append(args, tag)
+    }
+    if !sq.After.IsZero() {
+      where = append(where, `n.created >= ?`)
+      args = append(args, formatSQLiteTime(sq.After))
+    }
+    if !sq.Before.IsZero() {
+      where = append(where, `n.created < ?`)
+      args = append(args, formatSQLiteTime(sq.Before))
+    }
+  }
+
+  return db.queryNotes(join + " WHERE " + strings.Join(where, " AND "), " ORDER BY " + order, args...)
+}
+
+// Run a query over notes n. rest is the joins and where clause, and order the order by clause.
+func (db *SQLiteNotesDatabase) queryNotes(rest string, order string, args ...interface{}) ([]Note, error) {
+  rows, err := db.db.Query(`
+    SELECT n.id, n.created, n.updated, n.deleted, n.title, n.body, n.url,
+      (SELECT json_group_array(tag) FROM (SELECT tag FROM note_tags WHERE note_id = n.id ORDER BY pos))
+    FROM notes n ` + rest + order, args...)
+  if err != nil {
+    return nil, err
+  }
+  defer rows.Close()
+
+  var notes []Note
+  for rows.Next() {
+    var note Note
+    var created, updated, deleted sql.NullString
+    var tags string
+    if err := rows.Scan(&note.ID, &created, &updated, &deleted, &note.Title, &note.Body, &note.URL, &tags); err != nil {
+      return nil, err
+    }
+    if note.Created, err = parseSQLiteTime(created); err != nil {
+      return nil, err
+    }
+    if note.Updated, err = parseSQLiteTime(updated); err != nil {
+      return nil, err
+    }
+    if note.Deleted, err = parseSQLiteTime(deleted); err != nil {
+      return nil, err
+    }
+    if err := json.Unmarshal([]byte(tags), &note.Tags); err != nil {
+      return nil, err
+    }
+    if len(note.Tags) == 0 {
+      note.Tags = nil
+    }
+    notes = append(notes, note)
+  }
+  return notes, rows.Err()
+}
+
+// Flush does nothing, every change is already saved.
+func (db *SQLiteNotesDatabase) Flush() error {
+  return nil
+}
+
+func (db *SQLiteNotesDatabase) Close() error {
+  return db.db.Close()
+}
+
+// ImportJSON copies the notes and trash from a JSON notes file, keeping their IDs and times. It's
+// meant to be run once, on a new database, and fails if the database already has notes. Returns the
+// number of notes imported, not counting the trash.
+func (db *SQLiteNotesDatabase) ImportJSON(filename string) (int, error) {
+  // Make sure nothing is writing the file while it's read.
+  lock, err := lockFile(filename)
+  if err != nil {
+    return 0, err
+  }
+  defer lock.unlock()
+
+  bs, err := os.ReadFile(filename)
+  if err != nil {
+    return 0, err
+  }
+  var data NotesDB
+  if err := json.Unmarshal(bs, &data); err != nil {
+    return 0, fmt.Errorf("%s: %w", filename, err)
+  }
+  // Fix up the IDs the same way opening the file with NewTextDatabase would.
+  text := &TextNotesDatabase{notes: data.Notes, trash: data.Trash, nextId: data.NextID}
+  fixMissingData(text)
+  initIds(text)
+
+  tx, err := db.db.Begin()
+  if err != nil {
+    return 0, err
+  }
+  defer tx.Rollback()
+  var count int
+  if err := tx.QueryRow(`SELECT COUNT(*) FROM notes`).Scan(&count); err != nil {
+    return 0, err
+  }
+  if count > 0 {
+    return 0, fmt.Errorf("can't import into a database that already has %d notes", count)
+  }
+
+  imported := make(map[int64]bool)
+  for i := range text.notes {
+    if err := insertNote(tx, &text.notes[i], false); err != nil {
+      return 0, err
+    }
+    imported[text.notes[i].ID] = true
+  }
+  for i := range text.trash {
+    if err := insertNote(tx, &text.trash[i], true); err != nil {
+      return 0, err
+    }
+    imported[text.trash[i].ID] = true
+  }
+  for id, revisions := range data.Revisions {
+    // Only for notes that are in the file, in case it was edited by hand.
+    if !imported[id] {
+      continue
+    }
+    for i := range revisions {
+      if err := insertRevision(tx, id, &revisions[i]); err != nil {
+        return 0, err
+      }
+    }
+  }
+  // Keep handing out IDs from where the file left off.
+  res, err := tx.Exec(`UPDATE sqlite_sequence SET seq = ? WHERE name = 'notes'`, text.nextId-1)
+  if err != nil {
+    return 0, err
+  }
+  if n, err := res.RowsAffected(); err != nil {
+    return 0, err
+  } else if n == 0 {
+    if _, err := tx.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES ('notes', ?)`, text.nextId-1); err != nil {
+      return 0, err
+    }
+  }
+  if err := tx.Commit(); err != nil {
+    return 0, err
+  }
+  return len(text.notes), nil
+}
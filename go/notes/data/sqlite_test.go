@@ -0,0 +1,177 @@
+package data
+
+import (
+  "errors"
+  "fmt"
+  "path/filepath"
+  "testing"
+)
+
+func openSQLiteTestDB(t *testing.T, filename string) *SQLiteNotesDatabase {
+  t.Helper()
+  db, err := NewSQLiteDatabase(filename)
+  if err != nil {
+    t.Fatal(err)
+  }
+  t.Cleanup(func() { db.Close() })
+  return db
+}
+
+func TestSQLiteSearch(t *testing.T) {
+  db := openSQLiteTestDB(t, filepath.Join(t.TempDir(), "notes.db"))
+  addSearchNotes(t, db)
+  for i := 0; i < 5; i++ {
+    if _, err := db.db.Exec(`UPDATE notes SET created = ? WHERE id = ?`, formatSQLiteTime(searchNoteCreated(i)), i+1); err != nil {
+      t.Fatal(err)
+    }
+  }
+  runSearchTests(t, db, map[string][]int64{
+    // FTS5 doesn't weight a note's length by field, so note 5 is the shorter one.
+    "concurrency date:2024-01": {5, 1, 3},
+  })
+
+  if _, err := db.Query(&NotesQuery{Text: "after:yesterday"}); !errors.Is(err, ErrBadQuery) {
+    t.Errorf("got %v, want ErrBadQuery", err)
+  }
+}
+
+func TestSQLiteNotes(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.db")
+  db := openSQLiteTestDB(t, filename)
+  addNotes(t, db, "a", "b", "c")
+  if err := db.Update(2, &Note{ID: 99, Title: "edited", URL: "https://example.com", Tags: []string{"z", "a"}}); err != nil {
+    t.Fatal(err)
+  }
+  if err := db.Delete(3); err != nil {
+    t.Fatal(err)
+  }
+  if err := db.Delete(3); !errors.Is(err, ErrNotFound) {
+    t.Errorf("deleting twice: got %v, want ErrNotFound", err)
+  }
+  if _, err := db.Get(3); !errors.Is(err, ErrNotFound) {
+    t.Errorf("get of a deleted note: got %v, want ErrNotFound", err)
+  }
+  if err := db.Update(3, &Note{Title: "x"}); !errors.Is(err, ErrNotFound) {
+    t.Errorf("update of a deleted note: got %v, want ErrNotFound", err)
+  }
+
+  note, err := db.Get(2)
+  if err != nil {
+    t.Fatal(err)
+  }
+  // Tags keep their order.
+  if note.Title != "edited" || note.URL != "https://example.com" || fmt.Sprint(note.Tags) != "[z a]" || note.Created.IsZero() || note.Updated.IsZero() {
+    t.Errorf("got %+v", note)
+  }
+  if note, err := db.Get(1); err != nil || note.Tags != nil || !note.Updated.IsZero() {
+    t.Errorf("got %+v, %v for an unedited note", note, err)
+  }
+
+  // The deleted note's ID isn't handed out again.
+  db.Close()
+  db = openSQLiteTestDB(t, filename)
+  addNotes(t, db, "d")
+  checkIds(t, db, map[string]int64{"a": 1, "edited": 2, "d": 4})
+}
+
+func TestSQLiteTags(t *testing.T) {
+  db := openSQLiteTestDB(t, filepath.Join(t.TempDir(), "notes.db"))
+  for _, tags := range [][]string{{"go"}, {"go", "talk"}, {"talk"}, {"Go"}} {
+    if err := db.Add(&Note{Title: "x", Tags: tags}); err != nil {
+      t.Fatal(err)
+    }
+  }
+  tests := []struct {
+    q *NotesQuery
+    want []int64
+  }{
+    {nil, []int64{1, 2, 3, 4}},
+    {&NotesQuery{Tags: []string{"go"}}, []int64{1, 2}},
+    {&NotesQuery{Tags: []string{"go", "talk"}}, []int64{2}},
+    {&NotesQuery{Tags: []string{"nope"}}, nil},
+    // tag: in a search ignores case, like the JSON storage.
+    {&NotesQuery{Text: "tag:go"}, []int64{1, 2, 4}},
+    {&NotesQuery{Text: "-tag:go"}, []int64{3}},
+  }
+  for _, tt := range tests {
+    notes, err := db.Query(tt.q)
+    if err != nil {
+      t.Fatal(err)
+    }
+    var got []int64
+    for _, n := range notes {
+      got = append(got, n.ID)
+    }
+    if !sameIds(got, tt.want) {
+      t.Errorf("%+v: got %v, want %v", tt.q, got, tt.want)
+    }
+  }
+}
+
+func TestSQLiteImportJSON(t *testing.T) {
+  dir := t.TempDir()
+  jsonFile := filepath.Join(dir, "notes.json")
+  writeFile(t, jsonFile, map[string]interface{}{
+    "notes": []map[string]interface{}{
+      {"id": 1, "title": "a", "tags": []string{"x", "y"}, "created": "2024-01-02T03:04:05Z"},
+      {"id": 4, "title": "b", "body": "searchable"},
+      {"title": "c"},
+    },
+    "trash": []map[string]interface{}{
+      {"id": 7, "title": "deleted"},
+    },
+  })
+
+  db := openSQLiteTestDB(t, filepath.Join(dir, "notes.db"))
+  n, err := db.ImportJSON(jsonFile)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if n != 3 {
+    t.Errorf("imported %d notes, want 3", n)
+  }
+  // Same IDs as opening the file with NewTextDatabase, and the trash stays out of sight.
+  checkIds(t, db, map[string]int64{"a": 1, "b": 4, "c": 8})
+  note, err := db.Get(1)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if fmt.Sprint(note.Tags) != "[x y]" || note.Created.UTC().Format("2006-01-02 15:04:05") != "2024-01-02 03:04:05" {
+    t.Errorf("got %+v", note)
+  }
+  if got := search(t, db, "searchable"); !sameIds(got, []int64{4}) {
+    t.Errorf("search after import: got %v", got)
+  }
+
+  addNotes(t, db, "e")
+  checkIds(t, db, map[string]int64{"a": 1, "b": 4, "c": 8, "e": 9})
+
+  if _, err := db.ImportJSON(jsonFile); err == nil {
+    t.Error("imported into a database that already has notes")
+  }
+}
+
+// The JSON file is locked while it's read, so it can't be imported from under a running server.
+func TestSQLiteImportLockedJSON(t *testing.T) {
+  dir := t.TempDir()
+  jsonFile := filepath.Join(dir, "notes.json")
+  openTestDB(t, jsonFile)
+  db := openSQLiteTestDB(t, filepath.Join(dir, "notes.db"))
+  if _, err := db.ImportJSON(jsonFile); err == nil {
+    t.Error("imported a file that's in use")
+  }
+}
+
+func TestOpenDatabase(t *testing.T) {
+  dir := t.TempDir()
+  for _, storage := range []string{JSONStorage, SQLiteStorage} {
+    db, err := OpenDatabase(storage, dir, "notes")
+    if err != nil {
+      t.Fatalf("%s: %v", storage, err)
+    }
+    db.Close()
+  }
+  if _, err := OpenDatabase("csv", dir, "notes"); err == nil {
+    t.Error("opened unknown storage")
+  }
+}
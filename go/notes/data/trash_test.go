@@ -0,0 +1,136 @@
+package data
+
+import (
+  "errors"
+  "path/filepath"
+  "testing"
+  "time"
+)
+
+func trashIds(t *testing.T, db NotesDatabase) []int64 {
+  t.Helper()
+  trash, err := db.ListTrash()
+  if err != nil {
+    t.Fatal(err)
+  }
+  ids := []int64{}
+  for _, n := range trash {
+    if n.Deleted.IsZero() {
+      t.Errorf("note %d in the trash has no deletion time", n.ID)
+    }
+    ids = append(ids, n.ID)
+  }
+  return ids
+}
+
+// setDeleted backdates the deletion of a note in the trash.
+func runTrashTests(t *testing.T, db NotesDatabase, setDeleted func(id int64, deleted time.Time)) {
+  addNotes(t, db, "a", "b", "c", "d")
+  for _, id := range []int64{3, 1, 4} {
+    if err := db.Delete(id); err != nil {
+      t.Fatal(err)
+    }
+  }
+  if got := trashIds(t, db); !sameIds(got, []int64{3, 1, 4}) {
+    t.Errorf("got trash %v, want [3 1 4]", got)
+  }
+
+  if err := db.Restore(1); err != nil {
+    t.Fatal(err)
+  }
+  if err := db.Restore(1); !errors.Is(err, ErrNotFound) {
+    t.Errorf("restoring twice: got %v, want ErrNotFound", err)
+  }
+  if err := db.Restore(2); !errors.Is(err, ErrNotFound) {
+    t.Errorf("restoring a note that isn't in the trash: got %v, want ErrNotFound", err)
+  }
+  // Back in its old place, and searchable again.
+  checkIds(t, db, map[string]int64{"a": 1, "b": 2})
+  if got := search(t, db, "a"); !sameIds(got, []int64{1}) {
+    t.Errorf("search after restore: got %v", got)
+  }
+  if note, err := db.Get(1); err != nil || !note.Deleted.IsZero() {
+    t.Errorf("got %+v, %v after restore", note, err)
+  }
+
+  setDeleted(3, time.Now().Add(-48*time.Hour))
+  n, err := db.Purge(24 * time.Hour)
+  if err != nil {
+    t.Fatal(err)
+  }
+  if n != 1 {
+    t.Errorf("purged %d notes, want 1", n)
+  }
+  if got := trashIds(t, db); !sameIds(got, []int64{4}) {
+    t.Errorf("got trash %v after purge, want [4]", got)
+  }
+  if err := db.Restore(3); !errors.Is(err, ErrNotFound) {
+    t.Errorf("restoring a purged note: got %v, want ErrNotFound", err)
+  }
+
+  if n, err := db.Purge(0); err != nil || n != 1 {
+    t.Errorf("emptying the trash: got %d, %v", n, err)
+  }
+  if got := trashIds(t, db); len(got) != 0 {
+    t.Errorf("got trash %v after emptying it", got)
+  }
+
+  // Purged IDs aren't reused.
+  addNotes(t, db, "e")
+  checkIds(t, db, map[string]int64{"a": 1, "b": 2, "e": 5})
+}
+
+func TestTrash(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  db := openTestDB(t, filename)
+  text := db.(*TextNotesDatabase)
+  runTrashTests(t, db, func(id int64, deleted time.Time) {
+    for i := range text.trash {
+      if text.trash[i].ID == id {
+        text.trash[i].Deleted = deleted
+      }
+    }
+  })
+}
+
+func TestTrashSurvivesRestart(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  db := openTestDB(t, filename)
+  addNotes(t, db, "a", "b")
+  if err := db.Delete(1); err != nil {
+    t.Fatal(err)
+  }
+  flush(t, db)
+
+  db = reopen(t, db, filename)
+  if got := trashIds(t, db); !sameIds(got, []int64{1}) {
+    t.Errorf("got trash %v after restart", got)
+  }
+  if err := db.Restore(1); err != nil {
+    t.Fatal(err)
+  }
+  checkIds(t, db, map[string]int64{"a": 1, "b": 2})
+}
+
+// Files from before deletion times were recorded can still be purged.
+func TestPurgeOldTrash(t *testing.T) {
+  filename := filepath.Join(t.TempDir(), "notes.json")
+  writeFile(t, filename, map[string]interface{}{
+    "notes": []map[string]interface{}{},
+    "trash": []map[string]interface{}{{"id": 1, "title": "old"}},
+    "next_id": 2,
+  })
+  db := openTestDB(t, filename)
+  if n, err := db.Purge(365 * 24 * time.Hour); err != nil || n != 1 {
+    t.Errorf("got %d, %v", n, err)
+  }
+}
+
+func TestSQLiteTrash(t *testing.T) {
+  db := openSQLiteTestDB(t, filepath.Join(t.TempDir(), "notes.db"))
+  runTrashTests(t, db, func(id int64, deleted time.Time) {
+    if _, err := db.db.Exec(`UPDATE notes SET deleted = ? WHERE id = ?`, formatSQLiteTime(deleted), id); err != nil {
+      t.Fatal(err)
+    }
+  })
+}
@@ -1,11 +1,14 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"html/template"
+	"log"
 	"net/http"
 	"net/url"
+	"os"
 	"path"
 	"regexp"
 	"strconv"
@@ -15,6 +18,7 @@ import (
 	"github.com/davedolben/dev-tools/go/notes/api"
 	"github.com/davedolben/dev-tools/go/notes/bookmarks"
 	"github.com/davedolben/dev-tools/go/notes/data"
+	"github.com/davedolben/dev-tools/go/notes/render"
 )
 
 var tagsSplitRegex = regexp.MustCompile(`[\w-]+`)
@@ -115,6 +119,31 @@ var styleSheetString = `
 .button:hover {
   text-decoration: underline;
 }
+.markdown > :first-child {
+  margin-top: 0;
+}
+.markdown > :last-child {
+  margin-bottom: 0;
+}
+.markdown pre {
+  background-color: #f6f6f6;
+  overflow-x: auto;
+  padding: 3px 5px;
+}
+.markdown li:has(> input[type=checkbox]) {
+  list-style: none;
+}
+.diff {
+  border: solid 1px #ccc;
+  padding: 3px 5px;
+  white-space: pre-wrap;
+}
+.diff-added {
+  background-color: #dfd;
+}
+.diff-removed {
+  background-color: #fdd;
+}
 `
 
 var listTemplate *template.Template
@@ -130,9 +159,14 @@ var listTemplateString = `
 <div class="app-width flex-max-width">
 <div>
   <a href="/">Home</a> |
-  <a href="/bookmarks">Bookmarks</a>
+  <a href="/bookmarks">Bookmarks</a> |
+  <a href="/trash">Trash</a>
 </div>
 <hr />
+<form action="{{.Action}}" method="GET" class="flex-cols-container bottom-padded">
+  <input class="flex-fill padded-input" type="search" name="q" value="{{.Query}}" placeholder="Search: words, wor*, &quot;a phrase&quot;, -word, tag:x, -tag:x, after:2024-01, before:2024-02-15" />
+  <button type="submit" class="button">Search</button>
+</form>
 <form action="/api/add" method="POST">
   <div class="flex-rows-container app-width">
     <div class="flex-cols-container bottom-padded">
@@ -154,7 +188,9 @@ var listTemplateString = `
     <span class="hover-controls">
       ({{.ID}})
       {{formatTime .Created}}
+      {{if not .Updated.IsZero}}(edited {{formatTime .Updated}}){{end}}
       <a class="button" href="/edit?id={{.ID}}">edit</a>
+      {{if not .Updated.IsZero}}<a class="button" href="/history?id={{.ID}}">history</a>{{end}}
       <a class="button" href="/api/delete?id={{.ID}}">X</a>
     </span>
     <span>
@@ -162,7 +198,7 @@ var listTemplateString = `
         <div class="note-line note-title">{{.Title}}</div>
       {{end}}
       {{if .Body}}
-        <div class="note-line">{{htmlify .Body}}</div>
+        <div class="note-line markdown">{{markdown .Body}}</div>
       {{end}}
       <span>{{range .Tags}}<span class="tag"><a href="/?tag={{.}}">{{.}}</a></span>{{end}}</span>
     </span>
@@ -175,6 +211,126 @@ var listTemplateString = `
 </html>
 `
 
+var trashTemplate *template.Template
+var trashTemplateString = `
+<html>
+<head>
+  <title>Trash</title>
+  <link rel="stylesheet" type="text/css" href="/style.css" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+</head>
+<body class="flex-cols-container">
+<div class="flex-fill"></div>
+<div class="app-width flex-max-width">
+<div>
+  <a href="/">Home</a> |
+  <a href="/bookmarks">Bookmarks</a> |
+  <a href="/trash">Trash</a>
+</div>
+<hr />
+<form action="/api/purge" method="POST" class="flex-cols-container bottom-padded">
+  <span class="label padded-input">Permanently delete notes deleted more than</span>
+  <input class="padded-input" type="number" name="days" min="0" value="30" />
+  <span class="label padded-input">days ago</span>
+  <button type="submit" class="button">Purge</button>
+</form>
+<form action="/api/purge" method="POST" class="bottom-padded">
+  <input type="hidden" name="days" value="0" />
+  <button type="submit" class="button">Empty trash</button>
+</form>
+<hr />
+<div>
+{{range .Notes}}
+  <div class="note app-width">
+    <span class="hover-controls">
+      ({{.ID}})
+      deleted {{if .Deleted.IsZero}}a while ago{{else}}{{formatTime .Deleted}}{{end}}
+      <form action="/api/restore" method="POST" style="display: inline">
+        <input type="hidden" name="id" value="{{.ID}}" />
+        <button type="submit" class="button">restore</button>
+      </form>
+    </span>
+    <span>
+      {{if .Title}}
+        <div class="note-line note-title">{{.Title}}</div>
+      {{end}}
+      {{if .Body}}
+        <div class="note-line markdown">{{markdown .Body}}</div>
+      {{end}}
+      <span>{{range .Tags}}<span class="tag">{{.}}</span>{{end}}</span>
+    </span>
+  </div>
+{{else}}
+  <div>The trash is empty.</div>
+{{end}}
+</div>
+</div>
+<div class="flex-fill"></div>
+</body>
+</html>
+`
+
+var historyTemplate *template.Template
+var historyTemplateString = `
+<html>
+<head>
+  <title>History: {{.ID}}</title>
+  <link rel="stylesheet" type="text/css" href="/style.css" />
+  <meta name="viewport" content="width=device-width, initial-scale=1.0">
+</head>
+<body class="flex-cols-container">
+<div class="flex-fill"></div>
+<div class="app-width flex-max-width">
+<div>
+  <a href="/">Home</a> |
+  <a href="/bookmarks">Bookmarks</a> |
+  <a href="/trash">Trash</a>
+</div>
+<hr />
+<form action="/history" method="GET" class="bottom-padded">
+  <input type="hidden" name="id" value="{{.ID}}" />
+  Compare
+  <select name="from">
+    {{range .Revisions}}<option value="{{.Number}}" {{if eq .Number $.From}}selected{{end}}>{{.Number}}: {{formatTime .Time}}</option>{{end}}
+  </select>
+  with
+  <select name="to">
+    {{range .Revisions}}<option value="{{.Number}}" {{if eq .Number $.To}}selected{{end}}>{{.Number}}: {{formatTime .Time}}</option>{{end}}
+  </select>
+  <button type="submit" class="button">Compare</button>
+</form>
+<div class="diff bottom-padded">{{range .Diff}}<div class="{{diffClass .Op}}">{{diffPrefix .Op}} {{.Text}}</div>{{end}}</div>
+<hr />
+<div>
+{{range .Revisions}}
+  <div class="note app-width">
+    <span class="hover-controls">
+      ({{.Number}})
+      {{formatTime .Time}}
+      {{if ne .Number $.Latest}}
+      <form action="/api/restore_revision" method="POST" style="display: inline">
+        <input type="hidden" name="id" value="{{$.ID}}" />
+        <input type="hidden" name="rev" value="{{.Number}}" />
+        <button type="submit" class="button">restore</button>
+      </form>
+      {{end}}
+    </span>
+    <span>
+      <div class="note-line note-title">{{.Number}}. {{.Title}}</div>
+      {{if .Body}}
+        <div class="note-line markdown">{{markdown .Body}}</div>
+      {{end}}
+      <span>{{range .Tags}}<span class="tag">{{.}}</span>{{end}}</span>
+    </span>
+  </div>
+{{end}}
+</div>
+</div>
+<div class="flex-fill"></div>
+</body>
+</html>
+`
+
 var editTemplate *template.Template
 var editTemplateString = `
 <html>
@@ -189,14 +345,14 @@ var editTemplateString = `
   <input type="hidden" name="id" value="{{.ID}}" />
   <div>
     <a class="button" href="/">Cancel</a>
-    <button type="submit" class="button" href="/">Save</a>
+    <button type="submit" class="button">Save</button>
   </div>
   <hr />
   <div>
     <div class="flex-rows-container app-width">
       <div class="flex-cols-container bottom-padded">
         <span class="label padded-input">Title:</span>
-        <input class="flex-fill padded-input" type="text" name="title" value="{{.Body}}"/>
+        <input class="flex-fill padded-input" type="text" name="title" value="{{.Title}}"/>
       </div>
       <div class="flex-cols-container bottom-padded"><textarea name="body" class="flex-fill padded-input" rows="4">{{.Body}}</textarea></div>
       <div class="flex-cols-container bottom-padded">
@@ -214,18 +370,24 @@ var editTemplateString = `
 
 func setupTemplates() {
   tmplFuncs := template.FuncMap{
-    "htmlify": func(s string) template.HTML {
-      return template.HTML(strings.Replace(s, "\n", "<br/>", -1))
-    },
+    "markdown": render.Markdown,
     "formatTime": func(t time.Time) string {
       return t.Format(time.ANSIC)
     },
     "joinStrings": func(ss []string, separator string) string {
       return strings.Join(ss, separator)
     },
+    "diffClass": func(op data.DiffOp) string {
+      return map[data.DiffOp]string{data.DiffAdded: "diff-added", data.DiffRemoved: "diff-removed"}[op]
+    },
+    "diffPrefix": func(op data.DiffOp) string {
+      return map[data.DiffOp]string{data.DiffSame: " ", data.DiffAdded: "+", data.DiffRemoved: "-"}[op]
+    },
   }
   listTemplate = template.Must(template.New("list").Funcs(tmplFuncs).Parse(listTemplateString))
   editTemplate = template.Must(template.New("edit").Funcs(tmplFuncs).Parse(editTemplateString))
+  trashTemplate = template.Must(template.New("trash").Funcs(tmplFuncs).Parse(trashTemplateString))
+  historyTemplate = template.Must(template.New("history").Funcs(tmplFuncs).Parse(historyTemplateString))
 }
 
 func queryFromValues(values url.Values) *data.NotesQuery {
@@ -233,27 +395,32 @@ func queryFromValues(values url.Values) *data.NotesQuery {
   if len(values["tag"]) > 0 {
     q.Tags = values["tag"]
   }
+  q.Text = values.Get("q")
   return q
 }
 
 func serveListNotes(w http.ResponseWriter, r *http.Request) {
-  notes, err := gDb.Query(queryFromValues(r.URL.Query()))
-  if err != nil {
+  q := queryFromValues(r.URL.Query())
+  notes, err := gDb.Query(q)
+  if errors.Is(err, data.ErrBadQuery) {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  } else if err != nil {
     fmt.Printf("Error serving list: %s\n", err)
     http.Error(w, "Error serving list", 500)
     return
   }
 
-  // Reverse
-  var sortedNotes []data.Note
-  for i := len(notes)-1; i >= 0; i-- {
-    sortedNotes = append(sortedNotes, notes[i])
-  }
+  sortedNotes := data.ForDisplay(notes, q)
 
   data := struct {
     Notes []data.Note
+    Action string
+    Query string
   }{
     Notes: sortedNotes,
+    Action: "/",
+    Query: q.Text,
   }
 
   if err := listTemplate.Execute(w, &data); err != nil {
@@ -262,17 +429,17 @@ func serveListNotes(w http.ResponseWriter, r *http.Request) {
   }
 }
 
-func handleAddNote(w http.ResponseWriter, r *http.Request) {
-  title := r.FormValue("title")
-  body := r.FormValue("body")
-  tagsStr := r.FormValue("tags")
-  tags := tagsSplitRegex.FindAllString(tagsStr, -1)
-
-  note := &data.Note{
-    Title: title,
-    Body: body,
-    Tags: tags,
+// The title, body and tags fields shared by the add and edit forms.
+func noteFromForm(r *http.Request) *data.Note {
+  return &data.Note{
+    Title: r.FormValue("title"),
+    Body: r.FormValue("body"),
+    Tags: tagsSplitRegex.FindAllString(r.FormValue("tags"), -1),
   }
+}
+
+func handleAddNote(w http.ResponseWriter, r *http.Request) {
+  note := noteFromForm(r)
 
   if err := gDb.Add(note); err != nil {
     http.Error(w, err.Error(), 500)
@@ -290,16 +457,11 @@ func serveStyleSheet(w http.ResponseWriter, r *http.Request) {
 }
 
 func handleDeleteNote(w http.ResponseWriter, r *http.Request) {
-  idStr := r.FormValue("id")
-  id, err := strconv.ParseInt(idStr, 10, 64)
+  id, err := parseNoteId(r)
   if err != nil {
     http.Error(w, err.Error(), 500)
     return
   }
-  if id <= 0 {
-    http.Error(w, "bad id", 500)
-    return
-  }
   if err := gDb.Delete(id); err != nil {
     http.Error(w, err.Error(), 500)
     return
@@ -311,37 +473,227 @@ func handleDeleteNote(w http.ResponseWriter, r *http.Request) {
   http.Redirect(w, r, "/", http.StatusFound)
 }
 
-func handleUpdateNote(w http.ResponseWriter, r *http.Request) {
-  idStr := r.FormValue("id")
-  id, err := strconv.ParseInt(idStr, 10, 64)
+func serveTrash(w http.ResponseWriter, r *http.Request) {
+  trash, err := gDb.ListTrash()
+  if err != nil {
+    fmt.Printf("Error serving trash: %s\n", err)
+    http.Error(w, "Error serving trash", 500)
+    return
+  }
+
+  // Most recently deleted first.
+  data := struct {
+    Notes []data.Note
+  }{
+    Notes: data.ForDisplay(trash, nil),
+  }
+
+  if err := trashTemplate.Execute(w, &data); err != nil {
+    fmt.Printf("Error serving trash: %s\n", err)
+    http.Error(w, "Error serving trash", 500)
+  }
+}
+
+func handleRestoreNote(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  id, err := parseNoteId(r)
   if err != nil {
     http.Error(w, err.Error(), 500)
     return
   }
-  if id <= 0 {
-    http.Error(w, "bad id", 500)
+  if err := gDb.Restore(id); errors.Is(err, data.ErrNotFound) {
+    http.NotFound(w, r)
+    return
+  } else if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  if err := gDb.Flush(); err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  http.Redirect(w, r, "/trash", http.StatusFound)
+}
+
+func handlePurgeNotes(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    return
+  }
+  days, err := strconv.Atoi(r.FormValue("days"))
+  if err != nil || days < 0 {
+    http.Error(w, "bad number of days", http.StatusBadRequest)
+    return
+  }
+  if _, err := gDb.Purge(time.Duration(days) * 24 * time.Hour); err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  if err := gDb.Flush(); err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  http.Redirect(w, r, "/trash", http.StatusFound)
+}
+
+// The revision numbered by the form value key, or def if it's missing.
+func revisionFromForm(r *http.Request, key string, revisions []data.Revision, def int) (*data.Revision, error) {
+  number := def
+  if s := r.FormValue(key); s != "" {
+    var err error
+    if number, err = strconv.Atoi(s); err != nil {
+      return nil, err
+    }
+  }
+  for i := range revisions {
+    if revisions[i].Number == number {
+      return &revisions[i], nil
+    }
+  }
+  return nil, fmt.Errorf("%w: %d", data.ErrNoRevision, number)
+}
+
+// The revisions of a note, newest first, and a diff between two of them: ?from and ?to, or the last
+// two by default.
+func serveHistory(w http.ResponseWriter, r *http.Request) {
+  id, err := parseNoteId(r)
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  revisions, err := gDb.Revisions(id)
+  if errors.Is(err, data.ErrNotFound) {
+    http.NotFound(w, r)
+    return
+  } else if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+
+  latest := revisions[len(revisions)-1].Number
+  from, err := revisionFromForm(r, "from", revisions, revisions[max(len(revisions)-2, 0)].Number)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  to, err := revisionFromForm(r, "to", revisions, latest)
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+
+  newestFirst := make([]data.Revision, 0, len(revisions))
+  for i := len(revisions)-1; i >= 0; i-- {
+    newestFirst = append(newestFirst, revisions[i])
+  }
+  data := struct {
+    ID int64
+    Revisions []data.Revision
+    Latest int
+    From int
+    To int
+    Diff []data.DiffLine
+  }{
+    ID: id,
+    Revisions: newestFirst,
+    Latest: latest,
+    From: from.Number,
+    To: to.Number,
+    Diff: data.DiffLines(from.Text(), to.Text()),
+  }
+
+  if err := historyTemplate.Execute(w, &data); err != nil {
+    fmt.Printf("Error serving history: %s\n", err)
+    http.Error(w, "Error serving history", 500)
+  }
+}
+
+func handleRestoreRevision(w http.ResponseWriter, r *http.Request) {
+  if r.Method != http.MethodPost {
+    http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
     return
   }
+  id, err := parseNoteId(r)
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  number, err := strconv.Atoi(r.FormValue("rev"))
+  if err != nil {
+    http.Error(w, err.Error(), http.StatusBadRequest)
+    return
+  }
+  if err := gDb.RestoreRevision(id, number); errors.Is(err, data.ErrNotFound) || errors.Is(err, data.ErrNoRevision) {
+    http.NotFound(w, r)
+    return
+  } else if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  if err := gDb.Flush(); err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  http.Redirect(w, r, fmt.Sprintf("/history?id=%d", id), http.StatusFound)
+}
 
-  title := r.FormValue("title")
-  body := r.FormValue("body")
-  tags := r.FormValue("tags")
+func parseNoteId(r *http.Request) (int64, error) {
+  id, err := strconv.ParseInt(r.FormValue("id"), 10, 64)
+  if err != nil {
+    return 0, err
+  }
+  if id <= 0 {
+    return 0, fmt.Errorf("bad id")
+  }
+  return id, nil
+}
+
+func handleUpdateNote(w http.ResponseWriter, r *http.Request) {
+  id, err := parseNoteId(r)
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
 
-  fmt.Printf("Would update: %d\n%s\n%s\n%s", id, title, body, tags)
+  existing, err := gDb.Get(id)
+  if errors.Is(err, data.ErrNotFound) {
+    http.NotFound(w, r)
+    return
+  } else if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
 
-  //if err := gDb.Flush(); err != nil {
-  //  http.Error(w, err.Error(), 500)
-  //  return
-  //}
+  // The form doesn't have the URL, so keep the one the note has.
+  note := noteFromForm(r)
+  note.URL = existing.URL
+  if err := gDb.Update(id, note); err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  if err := gDb.Flush(); err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
   http.Redirect(w, r, "/", http.StatusFound)
 }
 
 func serveEdit(w http.ResponseWriter, r *http.Request) {
-  note := &data.Note{
-    ID: 1234,
-    Title: "title",
-    Body: "body goes here",
-    Tags: []string{"one", "two"},
+  id, err := parseNoteId(r)
+  if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
+  }
+  note, err := gDb.Get(id)
+  if errors.Is(err, data.ErrNotFound) {
+    http.NotFound(w, r)
+    return
+  } else if err != nil {
+    http.Error(w, err.Error(), 500)
+    return
   }
   if err := editTemplate.Execute(w, note); err != nil {
     fmt.Printf("Error serving edit: %s\n", err)
@@ -349,14 +701,47 @@ func serveEdit(w http.ResponseWriter, r *http.Request) {
   }
 }
 
+// Copy <name>.json in root into <name>.db.
+func importJSON(root, name string) error {
+  jsonFile := path.Join(root, name + ".json")
+  if _, err := os.Stat(jsonFile); os.IsNotExist(err) {
+    log.Printf("No %s, skipping", jsonFile)
+    return nil
+  }
+  db, err := data.NewSQLiteDatabase(path.Join(root, name + ".db"))
+  if err != nil {
+    return err
+  }
+  defer db.Close()
+  n, err := db.ImportJSON(jsonFile)
+  if err != nil {
+    return err
+  }
+  log.Printf("Imported %d notes from %s", n, jsonFile)
+  return nil
+}
+
 func main() {
   fHost := flag.String("host", "localhost", "host to serve on")
   fPort := flag.Int("port", 8080, "port to serve on")
   fDataRoot := flag.String("data_root", ".", "root directory for data")
+  fBackups := flag.Int("backups", data.Backups, "number of old copies of each data file to keep")
+  fStorage := flag.String("storage", data.JSONStorage, "where to keep notes and bookmarks: json or sqlite")
+  fImportJSON := flag.Bool("import_json", false, "copy notes.json and bookmarks.json into new SQLite databases, then exit")
   flag.Parse()
+  data.Backups = *fBackups
+
+  if *fImportJSON {
+    for _, name := range []string{"notes", "bookmarks"} {
+      if err := importJSON(*fDataRoot, name); err != nil {
+        log.Fatalf("Error importing %s: %s", name, err)
+      }
+    }
+    return
+  }
 
   var err error
-  gDb, err = data.NewTextDatabase(path.Join(*fDataRoot, "notes.json"))
+  gDb, err = data.OpenDatabase(*fStorage, *fDataRoot, "notes")
   if err != nil {
     panic(err)
   }
@@ -364,11 +749,12 @@ func main() {
   setupTemplates()
 
   mux := http.NewServeMux()
-  bookmarksDb := bookmarks.InitDatabase(*fDataRoot)
+  bookmarksDb := bookmarks.InitDatabase(*fStorage, *fDataRoot)
   bookmarks.RegisterHandlers(mux)
 
   apiMux := http.NewServeMux()
   api.UseDatabase(bookmarksDb)
+  api.UseNotesDatabase(gDb)
   api.InitKVStore(*fDataRoot)
   api.RegisterHandlers(apiMux)
   mux.Handle("/api/v2/", http.StripPrefix("/api/v2", apiMux))
@@ -376,6 +762,11 @@ func main() {
   mux.HandleFunc("/api/add", handleAddNote)
   mux.HandleFunc("/api/delete", handleDeleteNote)
   mux.HandleFunc("/api/update", handleUpdateNote)
+  mux.HandleFunc("/api/restore", handleRestoreNote)
+  mux.HandleFunc("/api/purge", handlePurgeNotes)
+  mux.HandleFunc("/trash", serveTrash)
+  mux.HandleFunc("/history", serveHistory)
+  mux.HandleFunc("/api/restore_revision", handleRestoreRevision)
   mux.HandleFunc("/style.css", serveStyleSheet)
   mux.HandleFunc("/edit", serveEdit)
   mux.HandleFunc("/", serveListNotes)
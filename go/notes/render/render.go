@@ -0,0 +1,43 @@
+// Package render turns note bodies into HTML for the notes and bookmarks pages.
+package render
+
+import (
+  "bytes"
+  "html/template"
+  "regexp"
+
+  "github.com/microcosm-cc/bluemonday"
+  "github.com/yuin/goldmark"
+  "github.com/yuin/goldmark/extension"
+  "github.com/yuin/goldmark/renderer/html"
+)
+
+// GitHub flavored Markdown: tables, strikethrough, task lists and bare URLs become links. Newlines
+// are kept as line breaks, like the old htmlify. Raw HTML in the source is dropped.
+var markdown = goldmark.New(
+  goldmark.WithExtensions(extension.GFM),
+  goldmark.WithRendererOptions(html.WithHardWraps()),
+)
+
+// Anything that gets past goldmark goes through this too.
+var policy = newPolicy()
+
+func newPolicy() *bluemonday.Policy {
+  p := bluemonday.UGCPolicy()
+  // Fenced code blocks get a language-<lang> class for syntax highlighting.
+  p.AllowAttrs("class").Matching(regexp.MustCompile(`^language-[\w+#-]+$`)).OnElements("code")
+  // Task list items, which goldmark renders as disabled checkboxes.
+  p.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+  p.AllowAttrs("checked", "disabled").OnElements("input")
+  return p
+}
+
+// Markdown renders a note body as sanitized HTML. If the Markdown can't be rendered, which goldmark
+// only does for writer errors, the body is shown escaped.
+func Markdown(s string) template.HTML {
+  var buf bytes.Buffer
+  if err := markdown.Convert([]byte(s), &buf); err != nil {
+    return template.HTML(template.HTMLEscapeString(s))
+  }
+  return template.HTML(policy.SanitizeBytes(buf.Bytes()))
+}
@@ -0,0 +1,74 @@
+package render
+
+import (
+  "strings"
+  "testing"
+)
+
+func TestMarkdown(t *testing.T) {
+  tests := []struct {
+    name string
+    in string
+    want []string
+    notWant []string
+  }{
+    {
+      name: "line breaks",
+      in: "one\ntwo",
+      want: []string{"<p>one<br>\ntwo</p>"},
+    },
+    {
+      name: "emphasis",
+      in: "some *text* and `code`",
+      want: []string{"<em>text</em>", "<code>code</code>"},
+    },
+    {
+      name: "code block",
+      in: "```go\nfmt.Println(\"<b>\")\n```",
+      want: []string{`<pre><code class="language-go">fmt.Println(&#34;&lt;b&gt;&#34;)`},
+    },
+    {
+      name: "task list",
+      in: "- [x] done\n- [ ] todo",
+      want: []string{`<input checked="" disabled="" type="checkbox"> done`, `<input disabled="" type="checkbox"> todo`},
+    },
+    {
+      name: "bare url",
+      in: "see https://example.com/a?b=c",
+      want: []string{`<a href="https://example.com/a?b=c" rel="nofollow">https://example.com/a?b=c</a>`},
+    },
+    {
+      name: "raw html",
+      in: "<script>alert(1)</script>\n\nhi <b onclick=\"x()\">there</b> <img src=x onerror=alert(1)>",
+      notWant: []string{"<script", "alert", "onclick", "onerror"},
+    },
+    {
+      name: "javascript link",
+      in: "[click](javascript:alert(1))",
+      notWant: []string{"javascript:"},
+    },
+    {
+      name: "code class injection",
+      in: "```go\" onmouseover=\"x\nbody\n```",
+      notWant: []string{"onmouseover"},
+    },
+    {
+      name: "text input",
+      in: "<input type=\"text\" value=\"x\">",
+      notWant: []string{"<input"},
+    },
+  }
+  for _, tt := range tests {
+    got := string(Markdown(tt.in))
+    for _, want := range tt.want {
+      if !strings.Contains(got, want) {
+        t.Errorf("%s: got %q, want it to contain %q", tt.name, got, want)
+      }
+    }
+    for _, notWant := range tt.notWant {
+      if strings.Contains(got, notWant) {
+        t.Errorf("%s: got %q, which contains %q", tt.name, got, notWant)
+      }
+    }
+  }
+}
@@ -12,7 +12,9 @@ type Migration struct {
 	// TODO: Down
 }
 
-func MigrateDB(db *sql.DB, migrations []Migration) error {
+// AppliedVersion returns the highest migration version applied to db, or 0 if there are none. It
+// creates the migrations table if it doesn't exist yet.
+func AppliedVersion(db *sql.DB) (int, error) {
 	// Add a migrations table
 	_, err := db.Exec(`
 		CREATE TABLE IF NOT EXISTS migrations (
@@ -20,25 +22,32 @@ func MigrateDB(db *sql.DB, migrations []Migration) error {
 			applied_at INTEGER
 		)`)
 	if err != nil {
-		return fmt.Errorf("error creating migrations table: %v", err)
+		return 0, fmt.Errorf("error creating migrations table: %v", err)
 	}
 
 	// Get the highest applied migration version
 	var highestAppliedVersion *int
 	err = db.QueryRow(`SELECT MAX(version) FROM migrations`).Scan(&highestAppliedVersion)
 	if err != nil {
-		return fmt.Errorf("error getting highest applied migration version: %v", err)
+		return 0, fmt.Errorf("error getting highest applied migration version: %v", err)
 	}
 	if highestAppliedVersion == nil {
-		highestAppliedVersion = new(int)
-		*highestAppliedVersion = 0
+		return 0, nil
+	}
+	return *highestAppliedVersion, nil
+}
+
+func MigrateDB(db *sql.DB, migrations []Migration) error {
+	highestAppliedVersion, err := AppliedVersion(db)
+	if err != nil {
+		return err
 	}
 
-	log.Printf("Highest applied migration version: %d", *highestAppliedVersion)
+	log.Printf("Highest applied migration version: %d", highestAppliedVersion)
 
 	for _, migration := range migrations {
 		// Check if migration has already been run
-		if migration.Version <= *highestAppliedVersion {
+		if migration.Version <= highestAppliedVersion {
 			continue
 		}
 